@@ -5,9 +5,12 @@ import (
 )
 
 // InvokeResult is the combined value/error result for async invocations. Used as channel type.
+// Headers are the headers the server has sent with the completion of an invocation, e.g. a trace id, see SetCompletionHeader.
+// They are nil if the completion has no headers and for stream items.
 type InvokeResult struct {
-	Value interface{}
-	Error error
+	Value   interface{}
+	Error   error
+	Headers map[string]string
 }
 
 // newInvokeResultChan combines a value result and an error result channel into one InvokeResult channel
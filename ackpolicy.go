@@ -0,0 +1,86 @@
+package signalr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AckPolicy configures how ClientProxy.SendWithAck waits for the acknowledgement of a message.
+// The client acknowledges a message with the completion of the invocation.
+// If no completion is received in Timeout, the message is sent again up to Retries times.
+// Before a retry, SendWithAck waits for Backoff, which is doubled after each retry.
+type AckPolicy struct {
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}
+
+// ErrNotAcknowledged is returned by ClientProxy.SendWithAck when a client has not acknowledged a message
+// after all attempts allowed by the AckPolicy.
+var ErrNotAcknowledged = errors.New("message not acknowledged")
+
+// ackTracker keeps track of the invocations which are waiting for an acknowledgement.
+// All attempts to send a message use the same invocation id, so a completion of any attempt acknowledges the message.
+// When the message has been acknowledged or given up, the invocation is not pending anymore.
+// Completions of earlier attempts which arrive later are recognized by their id, so they are not mistaken as unknown.
+type ackTracker struct {
+	mx      sync.Mutex
+	lastID  uint64
+	pending map[string]chan struct{}
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{pending: make(map[string]chan struct{})}
+}
+
+// newID returns a new invocation id. The ids do not collide with the ids of the loop, which are plain numbers
+func (a *ackTracker) newID() string {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.lastID++
+	return fmt.Sprintf("%v%v", ackIDPrefix, a.lastID)
+}
+
+const ackIDPrefix = "ack"
+
+// attempt registers an attempt to send the invocation with the id.
+// The returned channel is closed when the invocation has been acknowledged.
+func (a *ackTracker) attempt(id string) <-chan struct{} {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	acked, ok := a.pending[id]
+	if !ok {
+		acked = make(chan struct{})
+		a.pending[id] = acked
+	}
+	return acked
+}
+
+// done ends the tracking of the invocation with the id
+func (a *ackTracker) done(id string) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	delete(a.pending, id)
+}
+
+// receive handles the completion of an attempt. It reports if the id belongs to an invocation sent with ack
+func (a *ackTracker) receive(id string) bool {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	acked, ok := a.pending[id]
+	if !ok {
+		// A late completion of an invocation which is not pending anymore
+		n, err := strconv.ParseUint(strings.TrimPrefix(id, ackIDPrefix), 10, 64)
+		return strings.HasPrefix(id, ackIDPrefix) && err == nil && n > 0 && n <= a.lastID
+	}
+	select {
+	case <-acked:
+	default:
+		close(acked)
+	}
+	return true
+}
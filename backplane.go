@@ -0,0 +1,35 @@
+package signalr
+
+// Backplane connects servers which serve the same hub, e.g. multiple instances of a scaled out hub.
+// Sending to all clients, to a user or to a connection is delivered to the connections of the sending server
+// in-process and published to the backplane, so the other servers can deliver it to their connections.
+// Each server calls Subscribe once with a receive function. Publish has to call the receive functions of
+// all servers which use the backplane. The receiving server ignores messages which it published itself.
+// A backplane for multiple processes would e.g. publish the message via Redis and call receive when
+// it receives the message from its Redis subscription. See also GroupBackplane, which does the same for groups.
+type Backplane interface {
+	Publish(message BackplaneMessage)
+	Subscribe(receive func(message BackplaneMessage))
+}
+
+// BackplaneMessage is an invocation which is sent by a server to the connections of other servers
+type BackplaneMessage struct {
+	// ServerID identifies the server which published the message
+	ServerID string
+	// Kind tells if the invocation is sent to all clients, a user or a single connection
+	Kind BackplaneMessageKind
+	// Recipient is the userID for BackplaneUser and the connectionID for BackplaneClient
+	Recipient string
+	Target    string
+	Args      []interface{}
+}
+
+// BackplaneMessageKind tells which connections receive a BackplaneMessage
+type BackplaneMessageKind int
+
+// BackplaneMessageKind values
+const (
+	BackplaneAll BackplaneMessageKind = iota
+	BackplaneUser
+	BackplaneClient
+)
@@ -0,0 +1,112 @@
+package signalr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBackplane is a Backplane which calls the receive functions of all subscribed servers
+// and records the published messages
+type fakeBackplane struct {
+	mx        sync.Mutex
+	receivers []func(message BackplaneMessage)
+	published []BackplaneMessage
+}
+
+func (f *fakeBackplane) Publish(message BackplaneMessage) {
+	f.mx.Lock()
+	f.published = append(f.published, message)
+	receivers := append([]func(BackplaneMessage){}, f.receivers...)
+	f.mx.Unlock()
+	for _, receive := range receivers {
+		receive(message)
+	}
+}
+
+func (f *fakeBackplane) Subscribe(receive func(message BackplaneMessage)) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.receivers = append(f.receivers, receive)
+}
+
+func (f *fakeBackplane) publishedMessages() []BackplaneMessage {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]BackplaneMessage{}, f.published...)
+}
+
+func userIdentifierOption() func(Party) error {
+	return UserIdentifier(func(ctx context.Context) string {
+		userID, _ := ctx.Value(userContextKey{}).(string)
+		return userID
+	})
+}
+
+var _ = Describe("Backplane", func() {
+	var backplane *fakeBackplane
+	var serverA, serverB Server
+	var clientA1, clientA2, clientB Client
+	var receiverA1, receiverA2, receiverB *simpleReceiver
+	BeforeEach(func() {
+		backplane = &fakeBackplane{}
+		var err error
+		serverA, err = NewServer(context.TODO(), SimpleHubFactory(&backplaneHub{}), UseBackplane(backplane),
+			userIdentifierOption(), testLoggerOption())
+		Expect(err).NotTo(HaveOccurred())
+		serverB, err = NewServer(context.TODO(), SimpleHubFactory(&backplaneHub{}), UseBackplane(backplane),
+			userIdentifierOption(), testLoggerOption())
+		Expect(err).NotTo(HaveOccurred())
+		clientA1, receiverA1 = connectBackplaneClient(serverA, "a1", "alice")
+		clientA2, receiverA2 = connectBackplaneClient(serverA, "a2", "bob")
+		clientB, receiverB = connectBackplaneClient(serverB, "b", "alice")
+	})
+	AfterEach(func() {
+		serverA.cancel()
+		serverB.cancel()
+	})
+	Context("Clients().All()", func() {
+		It("should reach the connections of all servers once", func(done Done) {
+			Expect((<-clientB.Invoke("SendToAll", "all")).Error).NotTo(HaveOccurred())
+			Expect(<-receiverA1.ch).To(Equal("all"))
+			Expect(<-receiverA2.ch).To(Equal("all"))
+			Expect(<-receiverB.ch).To(Equal("all"))
+			Consistently(receiverB.ch, 100*time.Millisecond).ShouldNot(Receive())
+			close(done)
+		}, 3.0)
+	})
+	Context("Clients().User()", func() {
+		It("should reach the connections of the user on all servers", func(done Done) {
+			Expect((<-clientA2.Invoke("SendToUser", "alice", "user")).Error).NotTo(HaveOccurred())
+			Expect(<-receiverA1.ch).To(Equal("user"))
+			Expect(<-receiverB.ch).To(Equal("user"))
+			Consistently(receiverA2.ch, 100*time.Millisecond).ShouldNot(Receive())
+			Consistently(receiverA1.ch, 100*time.Millisecond).ShouldNot(Receive())
+			close(done)
+		}, 3.0)
+	})
+	Context("Clients().Client()", func() {
+		It("should reach a connection of another server via the backplane", func(done Done) {
+			Expect((<-clientB.Invoke("SendToClient", "a2", "client")).Error).NotTo(HaveOccurred())
+			Expect(<-receiverA2.ch).To(Equal("client"))
+			Consistently(receiverA1.ch, 100*time.Millisecond).ShouldNot(Receive())
+			Expect(backplane.publishedMessages()).To(Equal([]BackplaneMessage{{
+				ServerID:  serverB.(*server).lifetimeManager.(*defaultHubLifetimeManager).serverID,
+				Kind:      BackplaneClient,
+				Recipient: "a2",
+				Target:    "OnCallback",
+				Args:      []interface{}{"client"},
+			}}))
+			close(done)
+		}, 3.0)
+		It("should deliver to a connection of the same server without the backplane", func(done Done) {
+			Expect((<-clientA1.Invoke("SendToClient", "a2", "local")).Error).NotTo(HaveOccurred())
+			Expect(<-receiverA2.ch).To(Equal("local"))
+			Expect(backplane.publishedMessages()).To(BeEmpty())
+			close(done)
+		}, 3.0)
+	})
+})
@@ -0,0 +1,121 @@
+package signalr
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// bufferBudget counts the bytes buffered by all connections of a server, see MaxBufferedBytes.
+// Connections wait before they buffer new data while the limit is reached, until other connections release
+// their buffered data. A nil *bufferBudget has no limit.
+type bufferBudget struct {
+	mx       sync.Mutex
+	limit    uint64
+	used     uint64
+	released chan struct{}
+}
+
+func newBufferBudget(limit uint) *bufferBudget {
+	return &bufferBudget{limit: uint64(limit), released: make(chan struct{})}
+}
+
+// bufferBudget returns the budget shared by all connections of the server, nil if MaxBufferedBytes is not set
+func (s *server) bufferBudget() *bufferBudget {
+	return s.budget
+}
+
+// wait waits until less than limit bytes are buffered or ctx is done
+func (b *bufferBudget) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mx.Lock()
+		if b.used < b.limit {
+			b.mx.Unlock()
+			return nil
+		}
+		released := b.released
+		b.mx.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// add counts n bytes as buffered. It does not wait, so the limit can be exceeded by data which is already on its way,
+// e.g. the rest of a frame which has been read partially.
+func (b *bufferBudget) add(n uint64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.used += n
+}
+
+// release counts n bytes as not buffered anymore and wakes the waiting connections
+func (b *bufferBudget) release(n uint64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if n > b.used {
+		n = b.used
+	}
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// buffered returns the number of bytes which are buffered
+func (b *bufferBudget) buffered() uint64 {
+	if b == nil {
+		return 0
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.used
+}
+
+// budgetReader is the reader from which the protocol parses the received messages. When data at the start
+// of a frame has been read, it waits for the budget before it passes the data to the parser, so the parser stops reading
+// from the connection. Data of a partially read frame is passed without waiting,
+// because connections holding partial frames would otherwise wait for each other.
+type budgetReader struct {
+	ctx    context.Context
+	reader io.Reader
+	budget *bufferBudget
+	// held is the number of bytes read but not parsed into messages yet
+	held uint64
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if r.held == 0 && n > 0 {
+		if waitErr := r.budget.wait(r.ctx); waitErr != nil {
+			return 0, waitErr
+		}
+	}
+	r.budget.add(uint64(n))
+	r.held += uint64(n)
+	return n, err
+}
+
+// parsed releases the bytes which have been parsed into messages. remaining bytes are still held by the parser
+func (r *budgetReader) parsed(remaining int) {
+	if uint64(remaining) < r.held {
+		r.budget.release(r.held - uint64(remaining))
+		r.held = uint64(remaining)
+	}
+}
+
+// releaseAll releases all held bytes, when the connection ends
+func (r *budgetReader) releaseAll() {
+	r.budget.release(r.held)
+	r.held = 0
+}
@@ -0,0 +1,97 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("bufferBudget", func() {
+
+	Context("When the limit is reached", func() {
+		It("should let wait block until bytes are released", func(done Done) {
+			budget := newBufferBudget(100)
+			Expect(budget.wait(context.TODO())).NotTo(HaveOccurred())
+			budget.add(150)
+			waited := make(chan error, 1)
+			go func() { waited <- budget.wait(context.TODO()) }()
+			Consistently(waited, 100*time.Millisecond).ShouldNot(Receive())
+			budget.release(60)
+			Eventually(waited).Should(Receive(BeNil()))
+			Expect(budget.buffered()).To(Equal(uint64(90)))
+			close(done)
+		}, 2.0)
+		It("should let wait return the error of the canceled context", func(done Done) {
+			budget := newBufferBudget(100)
+			budget.add(100)
+			ctx, cancel := context.WithCancel(context.Background())
+			waited := make(chan error, 1)
+			go func() { waited <- budget.wait(ctx) }()
+			cancel()
+			Eventually(waited).Should(Receive(MatchError(context.Canceled)))
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When there is no budget", func() {
+		It("should not limit anything", func() {
+			var budget *bufferBudget
+			budget.add(1 << 20)
+			Expect(budget.wait(context.TODO())).NotTo(HaveOccurred())
+			Expect(budget.buffered()).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("MaxBufferedBytes option", func() {
+
+	It("should not be accepted by clients", func() {
+		_, err := NewClient(context.TODO(), WithConnection(newTestingConnection()), MaxBufferedBytes(1000))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("When many connections buffer more than the limit", func() {
+		It("should hold back all connections until the buffered bytes are released", func(done Done) {
+			srv, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+				MaxBufferedBytes(1000))
+			Expect(err).NotTo(HaveOccurred())
+			defer srv.cancel()
+			budget := srv.(*server).budget
+			invokeMe := func(id string) string {
+				return fmt.Sprintf(`{"type":1,"invocationId":"%v","target":"invokeme","arguments":["x",%v]}`, id, len(id))
+			}
+			conns := make([]*testingConnection, 5)
+			for i := range conns {
+				conns[i] = newTestingConnectionForServer()
+				go func(conn *testingConnection) { _ = srv.Serve(conn) }(conns[i])
+				// The connection is up and has nothing buffered when the first invocation is completed
+				conns[i].ClientSend(invokeMe(fmt.Sprintf("first%v", i)))
+				Eventually(conns[i].received).Should(Receive(BeAssignableToTypeOf(completionMessage{})))
+			}
+			// The first connection sends a large message, but only partially
+			large := invokeMe(strings.Repeat("l", 1500))
+			_, err = conns[0].cliWriter.Write([]byte(large[:1200]))
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(budget.buffered).Should(BeNumerically(">=", 1000))
+			// The other connections are not served while the limit is reached
+			for i := 1; i < len(conns); i++ {
+				conns[i].ClientSend(invokeMe(fmt.Sprintf("second%v", i)))
+			}
+			for i := 1; i < len(conns); i++ {
+				Consistently(conns[i].received, 100*time.Millisecond).ShouldNot(Receive())
+			}
+			// When the large message is complete, its bytes are released and all connections continue
+			_, err = conns[0].cliWriter.Write(append([]byte(large[1200:]), 30))
+			Expect(err).NotTo(HaveOccurred())
+			for i := range conns {
+				Eventually(conns[i].received).Should(Receive(BeAssignableToTypeOf(completionMessage{})))
+			}
+			Eventually(budget.buffered).Should(BeZero())
+			close(done)
+		}, 5.0)
+	})
+})
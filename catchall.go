@@ -0,0 +1,42 @@
+package signalr
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RawArgument is an argument of an invocation which has not been unmarshaled,
+// because the invocation is handled by the CatchAllMethod, which can not know the argument types in advance.
+// Hub methods can also declare parameters of type RawArgument to unmarshal large arguments on demand,
+// e.g. only into a struct with the few fields the method needs.
+type RawArgument struct {
+	value    interface{}
+	protocol hubProtocol
+}
+
+// Unmarshal unmarshals the argument into the value pointed to by v, like json.Unmarshal does.
+func (r RawArgument) Unmarshal(v interface{}) error {
+	return r.protocol.UnmarshalArgument(r.value, v)
+}
+
+var (
+	stringType       = reflect.TypeOf("")
+	rawArgumentType  = reflect.TypeOf(RawArgument{})
+	rawArgumentsType = reflect.TypeOf([]RawArgument{})
+)
+
+// buildCatchAllArguments builds the arguments of the catch-all method, which are the target and the raw arguments of the invocation
+func buildCatchAllArguments(method reflect.Value, invocation invocationMessage, protocol hubProtocol) ([]reflect.Value, error) {
+	methodType := method.Type()
+	if methodType.NumIn() != 2 || methodType.In(0) != stringType || methodType.In(1) != rawArgumentsType {
+		return nil, fmt.Errorf("catch-all method for %v has not the signature func(string, []RawArgument)", invocation.Target)
+	}
+	if len(invocation.StreamIds) > 0 {
+		return nil, fmt.Errorf("catch-all method for %v can not receive streams", invocation.Target)
+	}
+	args := make([]RawArgument, len(invocation.Arguments))
+	for i, value := range invocation.Arguments {
+		args[i] = RawArgument{value: value, protocol: protocol}
+	}
+	return []reflect.Value{reflect.ValueOf(invocation.Target), reflect.ValueOf(args)}, nil
+}
@@ -0,0 +1,14 @@
+package signalr
+
+// ChanResultPolicy decides how a non-streaming invocation of a method which returns a chan, an iterator or a producer
+// is completed, see InvokeChanResults. Stream invocations of these methods always receive all items as stream.
+type ChanResultPolicy int
+
+const (
+	// ChanResultFirstItem completes the invocation with the first item of the chan, like the result of an async method.
+	// If the chan is closed without an item, the invocation is completed with an error. This is the default
+	ChanResultFirstItem ChanResultPolicy = iota
+	// ChanResultReject completes the invocation with an error without calling the method,
+	// so methods returning a chan can only be invoked as stream
+	ChanResultReject
+)
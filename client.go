@@ -57,16 +57,35 @@ const (
 // or nil if the ClientState waitFor was reached.
 //  Invoke(method string, arguments ...interface{}) <-chan InvokeResult
 // Invoke invokes a method on the server and returns a channel wich will return the InvokeResult.
-// When failing, InvokeResult.Error contains the client side error.
+// When failing, InvokeResult.Error contains the client side error or a *HubError with the error of the server.
+// Invocations of idempotent methods are retried after client side errors, see InvokeRetries.
+//  InvokeTyped(resultType reflect.Type, method string, arguments ...interface{}) <-chan InvokeResult
+// InvokeTyped invokes a method on the server like Invoke, but the result is unmarshaled directly into a value of resultType.
+// InvokeResult.Value has the type resultType, e.g. an int result is not delivered as float64.
+// The results of methods with multiple return values can be received as slice or as struct with one field per result.
+//  InvokeWithProgress(progress chan<- interface{}, method string, arguments ...interface{}) <-chan InvokeResult
+// InvokeWithProgress invokes a method on the server like Invoke, and delivers the progress the method reports
+// with ReportProgress to progress. The result is delivered to the returned channel as with Invoke.
+// The invocation is sent with the ProgressHeader, without it, the server does not let the method report progress.
+// progress is closed when the invocation has ended. Invocations with progress are not retried.
 //  Send(method string, arguments ...interface{}) <-chan error
 // Send invokes a method on the server but does not return a result from the server but only a channel,
 // which might contain a client side error occurred while sending.
 //   PullStream(method string, arguments ...interface{}) <-chan InvokeResult
 // PullStream invokes a streaming method on the server and returns a channel which delivers the stream items.
 // For more info about Streaming see https://github.com/dotnet/aspnetcore/blob/main/src/SignalR/docs/specs/HubProtocol.md#streaming
+//   PullStreamTyped(itemType reflect.Type, method string, arguments ...interface{}) <-chan InvokeResult
+// PullStreamTyped invokes a streaming method on the server like PullStream, but the items are unmarshaled into values of itemType.
+// E.g. []byte items, which the JSON protocol sends as base64 strings, are delivered as []byte.
 //   PushStreams(method string, arguments ...interface{}) <-chan error
 // PushStreams pushes all items received from its arguments of type channel to the server (Upload Streaming).
 // For more info about Upload Streaming see https://github.com/dotnet/aspnetcore/blob/main/src/SignalR/docs/specs/HubProtocol.md#upload-streaming
+//   SendMessage(message Message) <-chan error
+// SendMessage sends a message built by the application to the server, e.g. to bridge other protocols, see Message.
+// The channel receives the error if the message is invalid or could not be sent, and is closed afterwards.
+//   ReconnectStats() ReconnectStats
+// ReconnectStats returns how often the client has tried to reconnect, how often it succeeded or gave up,
+// and the backoff delays it has waited. The reconnects are also published as ConnectionEvent, see ConnectionEvents.
 type Client interface {
 	Party
 	Start()
@@ -75,9 +94,14 @@ type Client interface {
 	Err() error
 	WaitForState(ctx context.Context, waitFor ClientState) <-chan error
 	Invoke(method string, arguments ...interface{}) <-chan InvokeResult
+	InvokeTyped(resultType reflect.Type, method string, arguments ...interface{}) <-chan InvokeResult
+	InvokeWithProgress(progress chan<- interface{}, method string, arguments ...interface{}) <-chan InvokeResult
 	Send(method string, arguments ...interface{}) <-chan error
 	PullStream(method string, arguments ...interface{}) <-chan InvokeResult
+	PullStreamTyped(itemType reflect.Type, method string, arguments ...interface{}) <-chan InvokeResult
 	PushStreams(method string, arguments ...interface{}) <-chan error
+	SendMessage(message Message) <-chan error
+	ReconnectStats() ReconnectStats
 }
 
 var ErrUnableToConnect = errors.New("neither WithConnection nor WithConnector option was given")
@@ -100,6 +124,7 @@ func NewClient(ctx context.Context, options ...func(Party) error) (Client, error
 			}
 		}
 	}
+	c.limitLoggedPayloads()
 	// Wrap logging with timestamps
 	info, dbg = c.loggers()
 	c.setLoggers(
@@ -124,12 +149,25 @@ type client struct {
 	loop              *loop
 	receiver          interface{}
 	lastID            int64
+	invokeRetryPolicy InvokeRetryPolicy
+	idempotentMethods map[string]bool
+	reconnectStats    ReconnectStats
+	handshakeFields   map[string]interface{}
+	// maxPending and invocationTimeout limit the invocations which are not completed by the server
+	maxPending        int
+	invocationTimeout time.Duration
+	// handshakeRemainder holds the bytes which have been read after the handshake response
+	handshakeRemainder []byte
 }
 
 func (c *client) Start() {
 	c.setState(ClientConnecting)
 	boff := backoff.NewExponentialBackOff()
+	boff.Clock = c.clock()
+	boff.Reset()
 	go func() {
+		// reconnecting is true while the client tries to reconnect after a connection has ended
+		reconnecting := false
 		for {
 			c.setErr(nil)
 			// Listen for state change to ClientConnected and signal backoff Reset then.
@@ -137,10 +175,14 @@ func (c *client) Start() {
 			var connected atomic.Value
 			connected.Store(false)
 			cancelObserve := c.ObserveStateChanged(stateChangeChan)
+			isReconnect := reconnecting
 			go func() {
 				for range stateChangeChan {
 					if c.State() == ClientConnected {
 						connected.Store(true)
+						if isReconnect {
+							c.reconnectSucceeded()
+						}
 						return
 					}
 				}
@@ -153,7 +195,12 @@ func (c *client) Start() {
 			}
 			shouldEnd := c.shouldClientEnd()
 			cancelObserve()
+			// Reconnecting has failed if the last attempt has not connected
+			reconnecting = reconnecting && !connected.Load().(bool)
 			if shouldEnd {
+				if reconnecting {
+					c.reconnectFailed()
+				}
 				return
 			}
 
@@ -162,11 +209,17 @@ func (c *client) Start() {
 				boff.Reset()
 			}
 			// Reconnect after BackOff
+			delay := boff.NextBackOff()
 			select {
-			case <-time.After(boff.NextBackOff()):
+			case <-c.clock().After(delay):
 			case <-c.ctx.Done():
+				if reconnecting {
+					c.reconnectFailed()
+				}
 				return
 			}
+			reconnecting = true
+			c.reconnectAttempted(delay)
 			c.setState(ClientConnecting)
 		}
 	}()
@@ -174,12 +227,19 @@ func (c *client) Start() {
 
 func (c *client) run() error {
 	// negotiate and so on
-	protocol, err := c.setupConnectionAndProtocol()
+	state := newConnectionStateMachine(stateConnecting)
+	protocol, err := c.setupConnectionAndProtocol(state)
 	if err != nil {
+		if closeErr := state.transition(stateClosing); closeErr != nil {
+			_ = c.info.Log(evt, "connect", "error", closeErr)
+		}
 		return err
 	}
 
-	loop := newLoop(c, c.conn, protocol)
+	loop := newLoop(c, c.conn, protocol, state)
+	loop.invokeClient.maxPending = c.maxPending
+	loop.receivedWithHandshake(c.handshakeRemainder)
+	c.handshakeRemainder = nil
 	c.mx.Lock()
 	c.loop = loop
 	c.mx.Unlock()
@@ -192,7 +252,7 @@ func (c *client) run() error {
 	// Run the loop
 	err = loop.Run(isLoopConnected)
 
-	if err == nil {
+	if err == nil && !loop.transportClosed {
 		err = loop.hubConn.Close("", false) // allowReconnect value is ignored as servers never initiate a connection
 	}
 
@@ -204,6 +264,23 @@ func (c *client) run() error {
 	return err
 }
 
+// lastConnectionID returns the ID of the last connection which was used by the client loop
+func (c *client) lastConnectionID() string {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	if c.loop == nil {
+		return ""
+	}
+	return c.loop.hubConn.ConnectionID()
+}
+
+// currentLoop returns the loop of the current connection
+func (c *client) currentLoop() *loop {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.loop
+}
+
 func (c *client) shouldClientEnd() bool {
 	// Canceled?
 	if c.ctx.Err() != nil {
@@ -224,7 +301,8 @@ func (c *client) shouldClientEnd() bool {
 	return false
 }
 
-func (c *client) setupConnectionAndProtocol() (hubProtocol, error) {
+// setupConnectionAndProtocol connects and processes the handshake. state is Connected when it returns without error
+func (c *client) setupConnectionAndProtocol(state *connectionStateMachine) (hubProtocol, error) {
 	return func() (hubProtocol, error) {
 		c.mx.Lock()
 		defer c.mx.Unlock()
@@ -236,13 +314,19 @@ func (c *client) setupConnectionAndProtocol() (hubProtocol, error) {
 			var err error
 			c.conn, err = c.connectionFactory()
 			if err != nil {
+				c.publishConnectionEvent(ConnectionEvent{Type: ConnectionError, Error: err})
 				return nil, err
 			}
 		}
-		protocol, err := c.processHandshake()
+		protocol, err := c.processHandshake(state)
+		if err == nil {
+			err = state.transition(stateConnected)
+		}
 		if err != nil {
+			c.publishConnectionEvent(ConnectionEvent{Type: ConnectionError, ConnectionID: c.conn.ConnectionID(), Error: err})
 			return nil, err
 		}
+		c.publishConnectionEvent(ConnectionEvent{Type: ConnectionHandshook, ConnectionID: c.conn.ConnectionID()})
 
 		return protocol, nil
 	}()
@@ -360,25 +444,74 @@ func (c *client) waitingIsOver(waitFor ClientState, ch chan<- error) bool {
 }
 
 func (c *client) Invoke(method string, arguments ...interface{}) <-chan InvokeResult {
+	return c.invoke(interfaceType, method, arguments)
+}
+
+func (c *client) InvokeTyped(resultType reflect.Type, method string, arguments ...interface{}) <-chan InvokeResult {
+	if resultType == nil {
+		resultType = interfaceType
+	}
+	return c.invoke(resultType, method, arguments)
+}
+
+func (c *client) InvokeWithProgress(progress chan<- interface{}, method string, arguments ...interface{}) <-chan InvokeResult {
+	return c.invokeOnce(interfaceType, method, arguments, progress)
+}
+
+func (c *client) invoke(resultType reflect.Type, method string, arguments []interface{}) <-chan InvokeResult {
+	if c.idempotentMethods[method] {
+		return c.invokeWithRetries(resultType, method, arguments)
+	}
+	return c.invokeOnce(resultType, method, arguments, nil)
+}
+
+// invokeOnce invokes the method. If progress is not nil, it receives the progress of the invocation and is closed
+// when the invocation has ended
+func (c *client) invokeOnce(resultType reflect.Type, method string, arguments []interface{}, progress chan<- interface{}) <-chan InvokeResult {
 	ch := make(chan InvokeResult, 1)
 	go func() {
 
 		if err := <-c.waitForConnected(); err != nil {
+			closeProgress(progress)
+			ch <- InvokeResult{Error: err}
+			close(ch)
+			return
+		}
+		// The loop is replaced when the client reconnects, so all steps of the invocation use the same loop
+		loop := c.currentLoop()
+		id := loop.GetNewID()
+		resultCh, errCh, err := loop.invokeClient.newTypedInvocation(id, resultType)
+		if err != nil {
+			closeProgress(progress)
 			ch <- InvokeResult{Error: err}
 			close(ch)
 			return
 		}
-		id := c.loop.GetNewID()
-		resultCh, errCh := c.loop.invokeClient.newInvocation(id)
+		var headers map[string]string
+		if progress != nil {
+			loop.invokeClient.setProgress(id, progress)
+			// The server lets the method report progress only when the caller asks for it
+			headers = map[string]string{ProgressHeader: "true"}
+		}
+		headersCh := loop.invokeClient.completionHeaders(id)
 		irCh := newInvokeResultChan(c.context(), resultCh, errCh)
-		if err := c.loop.hubConn.SendInvocation(id, method, arguments); err != nil {
-			c.loop.invokeClient.deleteInvocation(id)
+		if err := loop.hubConn.SendInvocationWithHeaders(id, method, arguments, headers); err != nil {
+			loop.invokeClient.deleteInvocation(id)
 			ch <- InvokeResult{Error: err}
 			close(ch)
 			return
 		}
+		if c.invocationTimeout > 0 {
+			loop.invokeClient.evictAfter(id, c.invocationTimeout)
+		}
 		go func() {
+			var headers map[string]string
 			for ir := range irCh {
+				select {
+				case headers = <-headersCh:
+				default:
+				}
+				ir.Headers = headers
 				ch <- ir
 			}
 			close(ch)
@@ -387,6 +520,12 @@ func (c *client) Invoke(method string, arguments ...interface{}) <-chan InvokeRe
 	return ch
 }
 
+func closeProgress(progress chan<- interface{}) {
+	if progress != nil {
+		close(progress)
+	}
+}
+
 func (c *client) Send(method string, arguments ...interface{}) <-chan error {
 	errCh := make(chan error, 1)
 	go func() {
@@ -395,14 +534,23 @@ func (c *client) Send(method string, arguments ...interface{}) <-chan error {
 			close(errCh)
 			return
 		}
-		id := c.loop.GetNewID()
-		_, sendErrCh := c.loop.invokeClient.newInvocation(id)
-		if err := c.loop.hubConn.SendInvocation(id, method, arguments); err != nil {
-			c.loop.invokeClient.deleteInvocation(id)
+		loop := c.currentLoop()
+		id := loop.GetNewID()
+		_, sendErrCh, err := loop.invokeClient.newInvocation(id)
+		if err != nil {
+			errCh <- err
+			close(errCh)
+			return
+		}
+		if err := loop.hubConn.SendInvocation(id, method, arguments); err != nil {
+			loop.invokeClient.deleteInvocation(id)
 			errCh <- err
 			close(errCh)
 			return
 		}
+		if c.invocationTimeout > 0 {
+			loop.invokeClient.evictAfter(id, c.invocationTimeout)
+		}
 		go func() {
 			for ir := range sendErrCh {
 				errCh <- ir
@@ -414,6 +562,17 @@ func (c *client) Send(method string, arguments ...interface{}) <-chan error {
 }
 
 func (c *client) PullStream(method string, arguments ...interface{}) <-chan InvokeResult {
+	return c.pullStream(interfaceType, method, arguments)
+}
+
+func (c *client) PullStreamTyped(itemType reflect.Type, method string, arguments ...interface{}) <-chan InvokeResult {
+	if itemType == nil {
+		itemType = interfaceType
+	}
+	return c.pullStream(itemType, method, arguments)
+}
+
+func (c *client) pullStream(itemType reflect.Type, method string, arguments []interface{}) <-chan InvokeResult {
 	irCh := make(chan InvokeResult, 1)
 	go func() {
 		if err := <-c.waitForConnected(); err != nil {
@@ -421,7 +580,8 @@ func (c *client) PullStream(method string, arguments ...interface{}) <-chan Invo
 			close(irCh)
 			return
 		}
-		pullCh := c.loop.PullStream(method, c.loop.GetNewID(), arguments...)
+		loop := c.currentLoop()
+		pullCh := loop.PullStream(itemType, method, loop.GetNewID(), arguments...)
 		go func() {
 			for ir := range pullCh {
 				irCh <- ir
@@ -443,7 +603,8 @@ func (c *client) PushStreams(method string, arguments ...interface{}) <-chan err
 			close(errCh)
 			return
 		}
-		pushCh, err := c.loop.PushStreams(method, c.loop.GetNewID(), arguments...)
+		loop := c.currentLoop()
+		pushCh, err := loop.PushStreams(method, loop.GetNewID(), arguments...)
 		if err != nil {
 			errCh <- err
 			close(errCh)
@@ -459,6 +620,26 @@ func (c *client) PushStreams(method string, arguments ...interface{}) <-chan err
 	return errCh
 }
 
+func (c *client) SendMessage(message Message) <-chan error {
+	errCh := make(chan error, 1)
+	if _, err := message.hubMessage(); err != nil {
+		errCh <- err
+		close(errCh)
+		return errCh
+	}
+	go func() {
+		defer close(errCh)
+		if err := <-c.waitForConnected(); err != nil {
+			errCh <- err
+			return
+		}
+		if err := c.currentLoop().hubConn.SendMessage(message); err != nil {
+			errCh <- err
+		}
+	}()
+	return errCh
+}
+
 func (c *client) waitForConnected() <-chan error {
 	return c.WaitForState(context.Background(), ClientConnected)
 }
@@ -475,17 +656,28 @@ func createResultChansWithError(ctx context.Context, err error) (<-chan InvokeRe
 
 func (c *client) onConnected(hubConnection) {}
 
-func (c *client) onDisconnected(hubConnection) {}
+func (c *client) onDisconnected(hubConnection, DisconnectReason) {}
 
 func (c *client) invocationTarget(hubConnection) interface{} {
 	return c.receiver
 }
 
-func (c *client) allowReconnect() bool {
-	return false // Servers don't care?
+func (c *client) closeMessageFor(_ DisconnectReason, err error) (string, bool) {
+	return fmt.Sprintf("%v", err), false // Servers don't care?
+}
+
+// authorizeInvocation allows all invocations, because InvocationMiddleware is server only
+func (c *client) authorizeInvocation(hubConnection, string) error {
+	return nil
+}
+
+// bufferBudget returns nil, because MaxBufferedBytes is server only
+func (c *client) bufferBudget() *bufferBudget {
+	return nil
 }
 
 func (c *client) prefixLoggers(connectionID string) (info StructuredLogger, dbg StructuredLogger) {
+	connectionID = c.redactConnectionID(connectionID)
 	if c.receiver == nil {
 		return log.WithPrefix(c.info, "ts", log.DefaultTimestampUTC, "class", "Client", "connection", connectionID),
 			log.WithPrefix(c.dbg, "ts", log.DefaultTimestampUTC, "class", "Client", "connection", connectionID)
@@ -507,19 +699,30 @@ func (c *client) prefixLoggers(connectionID string) (info StructuredLogger, dbg
 			"hub", t)
 }
 
-func (c *client) processHandshake() (hubProtocol, error) {
+func (c *client) processHandshake(state *connectionStateMachine) (hubProtocol, error) {
+	if err := state.transition(stateHandshaking); err != nil {
+		return nil, err
+	}
+	if raw := c.rawProtocol(); raw != "" {
+		// RawMode, the server expects no handshake request
+		return protocolMap[raw](), nil
+	}
 	if err := c.sendHandshakeRequest(); err != nil {
 		return nil, err
 	}
-	return c.receiveHandshakeResponse()
+	return c.receiveHandshakeResponse(state)
 }
 
 func (c *client) sendHandshakeRequest() error {
 	info, dbg := c.prefixLoggers(c.conn.ConnectionID())
-	request := fmt.Sprintf("{\"protocol\":\"%v\",\"version\":1}\u001e", c.format)
-	ctx, cancelWrite := context.WithTimeout(c.context(), c.HandshakeTimeout())
+	request, err := c.handshakeRequest()
+	if err != nil {
+		_ = info.Log(evt, "handshake sent", "error", err)
+		return err
+	}
+	ctx, cancelWrite := withClockTimeout(c.context(), c.clock(), c.HandshakeTimeout())
 	defer cancelWrite()
-	_, err := ReadWriteWithContext(ctx,
+	_, err = ReadWriteWithContext(ctx,
 		func() (int, error) {
 			return c.conn.Write([]byte(request))
 		}, func() {})
@@ -531,15 +734,39 @@ func (c *client) sendHandshakeRequest() error {
 	return nil
 }
 
-func (c *client) receiveHandshakeResponse() (hubProtocol, error) {
+// handshakeRequest builds the handshake request, including the fields set by HandshakeFields
+func (c *client) handshakeRequest() (string, error) {
+	if len(c.handshakeFields) == 0 {
+		return fmt.Sprintf("{\"protocol\":\"%v\",\"version\":1}\u001e", c.format), nil
+	}
+	fields := make(map[string]interface{}, len(c.handshakeFields)+2)
+	for key, value := range c.handshakeFields {
+		fields[key] = value
+	}
+	fields["protocol"] = c.format
+	fields["version"] = protocolVersion
+	request, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(request) + "\u001e", nil
+}
+
+// receiveHandshakeResponse returns the protocol for the connection. The bytes which have been read after the
+// handshake response are kept in handshakeRemainder until the loop for the connection is created.
+func (c *client) receiveHandshakeResponse(state *connectionStateMachine) (hubProtocol, error) {
 	info, dbg := c.prefixLoggers(c.conn.ConnectionID())
-	ctx, cancelRead := context.WithTimeout(c.context(), c.HandshakeTimeout())
+	ctx, cancelRead := withClockTimeout(c.context(), c.clock(), c.HandshakeTimeout())
 	defer cancelRead()
 	readJSONFramesChan := make(chan []interface{}, 1)
 	go func() {
 		var remainBuf bytes.Buffer
 		rawHandshake, err := readJSONFrames(c.conn, &remainBuf)
-		readJSONFramesChan <- []interface{}{rawHandshake, err}
+		var remainder []byte
+		if err == nil {
+			remainder = handshakeRemainder(rawHandshake, &remainBuf)
+		}
+		readJSONFramesChan <- []interface{}{rawHandshake, err, remainder}
 	}()
 	select {
 	case result := <-readJSONFramesChan:
@@ -553,22 +780,25 @@ func (c *client) receiveHandshakeResponse() (hubProtocol, error) {
 			_ = info.Log(evt, "handshake received", "msg", string(rawHandshake[0]), "error", err)
 			return nil, err
 		} else {
+			if err := state.acceptMessage(response.Type); err != nil {
+				// Hub messages are only allowed after the handshake
+				_ = info.Log(evt, "handshake received", "msg", string(rawHandshake[0]), "error", err)
+				return nil, err
+			}
 			if response.Error != "" {
 				_ = info.Log(evt, "handshake received", "error", response.Error)
 				return nil, errors.New(response.Error)
 			}
 			_ = dbg.Log(evt, "handshake received", "msg", fmtMsg(response))
 			var protocol hubProtocol
-			switch c.format {
-			case "json":
-				protocol = &jsonHubProtocol{}
-			case "messagepack":
-				protocol = &messagePackHubProtocol{}
+			if newProtocol, ok := protocolMap[c.format]; ok {
+				protocol = newProtocol()
 			}
 			if protocol != nil {
 				_, pDbg := c.loggers()
 				protocol.setDebugLogger(pDbg)
 			}
+			c.handshakeRemainder = result[2].([]byte)
 			return protocol, nil
 		}
 	case <-ctx.Done():
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -19,6 +20,7 @@ type pipeConnection struct {
 	timeout      time.Duration
 	fail         atomic.Value
 	connectionID string
+	closed       int32
 }
 
 func (pc *pipeConnection) Context() context.Context {
@@ -39,6 +41,15 @@ func (pc *pipeConnection) Write(p []byte) (n int, err error) {
 	return pc.writer.Write(p)
 }
 
+func (pc *pipeConnection) Close() error {
+	atomic.StoreInt32(&pc.closed, 1)
+	return nil
+}
+
+func (pc *pipeConnection) Closed() bool {
+	return atomic.LoadInt32(&pc.closed) == 1
+}
+
 func (pc *pipeConnection) ConnectionID() string {
 	return pc.connectionID
 }
@@ -82,10 +93,49 @@ func (s *simpleHub) InvokeMe(arg1 string, arg2 int) string {
 	return fmt.Sprintf("%v%v", arg1, arg2)
 }
 
+func (s *simpleHub) Progress(ctx context.Context, steps int) (string, error) {
+	for i := 1; i <= steps; i++ {
+		if err := ReportProgress(ctx, fmt.Sprintf("step %v of %v", i, steps)); errors.Is(err, ErrProgressNotSupported) {
+			return "finished without progress", nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "finished", nil
+}
+
+func (s *simpleHub) Traced(ctx context.Context, fail bool) (string, error) {
+	if err := SetCompletionHeader(ctx, "trace-id", "4bf92f35"); err != nil {
+		return "", err
+	}
+	if fail {
+		return "", errors.New("traced failure")
+	}
+	return "traced", nil
+}
+
 func (s *simpleHub) Callback(arg1 string) {
 	s.Hub.Clients().Caller().Send("OnCallback", strings.ToUpper(arg1))
 }
 
+var (
+	onStruct      = NewClientMethod("OnStruct", simpleStruct{})
+	onStructAsMap = NewClientMethod("OnStructAsMap", simpleStruct{})
+)
+
+func (s *simpleHub) PushStruct(asInt int, asString string) {
+	value := simpleStruct{AsInt: asInt, AsString: asString}
+	_ = onStruct.Send(s.Hub.Clients().Caller(), value)
+	_ = onStructAsMap.Send(s.Hub.Clients().Caller(), value)
+}
+
+func (s *simpleHub) PushWrongType() string {
+	if err := onStruct.Send(s.Hub.Clients().Caller(), "no struct"); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
 func (s *simpleHub) ReadStream(i int) chan string {
 	ch := make(chan string)
 	go func() {
@@ -98,6 +148,16 @@ func (s *simpleHub) ReadStream(i int) chan string {
 	return ch
 }
 
+func (s *simpleHub) ReadBytes() chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		ch <- []byte{0, 1, 2}
+		ch <- []byte("binary")
+		close(ch)
+	}()
+	return ch
+}
+
 func (s *simpleHub) ReceiveStream(arg string, ch <-chan int) {
 	s.receiveStreamArg = arg
 	s.receiveStreamChanValues = make([]int, 0)
@@ -113,6 +173,58 @@ func (s *simpleHub) Abort() {
 	s.Hub.Abort()
 }
 
+type primitiveHub struct {
+	Hub
+}
+
+func (p *primitiveHub) Int() int {
+	return -70000
+}
+
+func (p *primitiveHub) IntStream() <-chan int {
+	ch := make(chan int, 1)
+	ch <- -70000
+	close(ch)
+	return ch
+}
+
+func (p *primitiveHub) Uint() uint {
+	return 5
+}
+
+func (p *primitiveHub) UintStream() <-chan uint {
+	ch := make(chan uint, 1)
+	ch <- 5
+	close(ch)
+	return ch
+}
+
+func (p *primitiveHub) Float() float64 {
+	return 3.25
+}
+
+func (p *primitiveHub) FloatStream() <-chan float64 {
+	ch := make(chan float64, 1)
+	ch <- 3.25
+	close(ch)
+	return ch
+}
+
+func (p *primitiveHub) Text() string {
+	return "primitive"
+}
+
+func (p *primitiveHub) TextStream() <-chan string {
+	ch := make(chan string, 1)
+	ch <- "primitive"
+	close(ch)
+	return ch
+}
+
+func (p *primitiveHub) Struct() simpleStruct {
+	return simpleStruct{AsInt: 70000, AsString: "primitive"}
+}
+
 type simpleReceiver struct {
 	result atomic.Value
 	ch     chan string
@@ -122,6 +234,60 @@ func (s *simpleReceiver) OnCallback(result string) {
 	s.ch <- result
 }
 
+type structReceiver struct {
+	structs chan simpleStruct
+	maps    chan map[string]interface{}
+}
+
+func (s *structReceiver) OnStruct(value simpleStruct) {
+	s.structs <- value
+}
+
+func (s *structReceiver) OnStructAsMap(value map[string]interface{}) {
+	s.maps <- value
+}
+
+type retryHub struct {
+	Hub
+	calls int32
+}
+
+func (r *retryHub) Count() int32 {
+	return atomic.AddInt32(&r.calls, 1)
+}
+
+func (r *retryHub) Fail() error {
+	atomic.AddInt32(&r.calls, 1)
+	return errors.New("application error")
+}
+
+// pendingHub does not complete Block until release is closed
+type pendingHub struct {
+	Hub
+	release chan struct{}
+}
+
+func (p *pendingHub) Block() {
+	<-p.release
+}
+
+func (p *pendingHub) Quick() int {
+	return 1
+}
+
+// flakyConnection is a pipeConnection which fails all writes after the first okWrites
+type flakyConnection struct {
+	*pipeConnection
+	okWrites int32
+}
+
+func (f *flakyConnection) Write(p []byte) (n int, err error) {
+	if atomic.AddInt32(&f.okWrites, -1) < 0 {
+		return 0, errors.New("transport failure")
+	}
+	return f.pipeConnection.Write(p)
+}
+
 var _ = Describe("Client", func() {
 	formatOption := TransferFormat("Text")
 	j := 1
@@ -185,6 +351,184 @@ var _ = Describe("Client", func() {
 			close(done)
 		}, 1.0)
 	})
+	Context("InvokeWithProgress", func() {
+		It("should deliver the progress before the result and close the progress channel", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption, CompletionErrors(true))
+			progress := make(chan interface{})
+			resultCh := client.InvokeWithProgress(progress, "Progress", 3)
+			var steps []interface{}
+			for p := range progress {
+				steps = append(steps, p)
+			}
+			Expect(steps).To(Equal([]interface{}{"step 1 of 3", "step 2 of 3", "step 3 of 3"}))
+			r := <-resultCh
+			Expect(r.Error).NotTo(HaveOccurred())
+			Expect(r.Value).To(Equal("finished"))
+			cancelClient()
+			close(done)
+		}, 2.0)
+		It("should not let the method report progress to Invoke", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption, CompletionErrors(true))
+			r := <-client.Invoke("Progress", 3)
+			Expect(r.Error).NotTo(HaveOccurred())
+			Expect(r.Value).To(Equal("finished without progress"))
+			cancelClient()
+			close(done)
+		}, 2.0)
+	})
+	Context("Completion headers", func() {
+		It("should deliver the headers set by the server with the result", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption, CompletionErrors(true))
+			r := <-client.Invoke("Traced", false)
+			Expect(r.Error).NotTo(HaveOccurred())
+			Expect(r.Value).To(Equal("traced"))
+			Expect(r.Headers).To(Equal(map[string]string{"trace-id": "4bf92f35"}))
+			cancelClient()
+			close(done)
+		}, 2.0)
+		It("should deliver the headers set by the server with the error", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption, CompletionErrors(true))
+			r := <-client.Invoke("Traced", true)
+			Expect(r.Error).To(HaveOccurred())
+			Expect(r.Headers).To(Equal(map[string]string{"trace-id": "4bf92f35"}))
+			cancelClient()
+			close(done)
+		}, 2.0)
+		It("should deliver no headers if the server has set none", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption)
+			r := <-client.Invoke("InvokeMe", "A", 1)
+			Expect(r.Error).NotTo(HaveOccurred())
+			Expect(r.Headers).To(BeNil())
+			cancelClient()
+			close(done)
+		}, 2.0)
+	})
+	Context("Invoke retries", func() {
+		var server Server
+		var hub *retryHub
+		var client Client
+		var connections int32
+		var cancelClient context.CancelFunc
+		BeforeEach(func(done Done) {
+			hub = &retryHub{}
+			server, _ = NewServer(context.TODO(), UseHub(hub), CompletionErrors(true), testLoggerOption())
+			connections = 0
+			// The first connection fails after the handshake, the following connections work
+			connector := func() (Connection, error) {
+				cliConn, srvConn := newClientServerConnections()
+				n := atomic.AddInt32(&connections, 1)
+				srvConn.connectionID = fmt.Sprintf("C%v", n)
+				go func() { _ = server.Serve(srvConn) }()
+				if n == 1 {
+					return &flakyConnection{pipeConnection: cliConn, okWrites: 1}, nil
+				}
+				return cliConn, nil
+			}
+			var ctx context.Context
+			ctx, cancelClient = context.WithCancel(context.Background())
+			client, _ = NewClient(ctx, WithConnector(connector),
+				InvokeRetries(InvokeRetryPolicy{MaxRetries: 10, Delay: 200 * time.Millisecond}, "Count", "Fail"),
+				testLoggerOption(), formatOption)
+			client.Start()
+			Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+			close(done)
+		}, 2.0)
+		AfterEach(func() {
+			cancelClient()
+			server.cancel()
+		})
+		It("should retry an idempotent method after a transport failure", func(done Done) {
+			r := <-client.Invoke("Count")
+			Expect(r.Error).NotTo(HaveOccurred())
+			Expect(r.Value).To(BeEquivalentTo(1))
+			Expect(atomic.LoadInt32(&connections)).To(BeNumerically(">", 1))
+			close(done)
+		}, 5.0)
+		It("should not retry an idempotent method which failed with an application error", func(done Done) {
+			// Let the first connection fail, so the invocation reaches the server over the second connection
+			Expect((<-client.Invoke("Count")).Error).NotTo(HaveOccurred())
+			r := <-client.Invoke("Fail")
+			Expect(r.Error).To(MatchError("application error"))
+			var hubErr *HubError
+			Expect(errors.As(r.Error, &hubErr)).To(BeTrue())
+			Consistently(func() int32 { return atomic.LoadInt32(&hub.calls) }, 500*time.Millisecond).Should(Equal(int32(2)))
+			close(done)
+		}, 5.0)
+		It("should not retry methods which are not idempotent", func(done Done) {
+			r := <-client.Invoke("NotIdempotent")
+			Expect(r.Error).To(HaveOccurred())
+			var hubErr *HubError
+			Expect(errors.As(r.Error, &hubErr)).To(BeFalse())
+			close(done)
+		}, 5.0)
+		It("should not retry an idempotent method which failed with an error caused by the invocation", func(done Done) {
+			start := time.Now()
+			r := <-client.Invoke("Count", make(chan int))
+			Expect(errors.Is(r.Error, ErrMarshalFailed)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically("<", 200*time.Millisecond))
+			Expect(isTransportError(ErrTooManyPendingInvocations)).To(BeFalse())
+			Expect(isTransportError(ErrInvocationTimeout)).To(BeFalse())
+			Expect(isTransportError(context.Canceled)).To(BeFalse())
+			close(done)
+		}, 5.0)
+	})
+	Context("Pending invocations", func() {
+		var hub *pendingHub
+		var server Server
+		var cancelClient context.CancelFunc
+		startClient := func(options ...func(Party) error) Client {
+			hub = &pendingHub{release: make(chan struct{})}
+			server, _ = NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			cliConn, srvConn := newClientServerConnections()
+			go func() { _ = server.Serve(srvConn) }()
+			var ctx context.Context
+			ctx, cancelClient = context.WithCancel(context.Background())
+			client, err := NewClient(ctx, append([]func(Party) error{WithConnection(cliConn), testLoggerOption(), formatOption}, options...)...)
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+			return client
+		}
+		AfterEach(func() {
+			cancelClient()
+			server.cancel()
+		})
+		It("should fail fast when MaxPendingInvocations is reached", func(done Done) {
+			client := startClient(MaxPendingInvocations(3))
+			results := make([]<-chan InvokeResult, 10)
+			for i := range results {
+				results[i] = client.Invoke("Block")
+			}
+			failed := 0
+			for _, result := range results {
+				select {
+				case r := <-result:
+					Expect(errors.Is(r.Error, ErrTooManyPendingInvocations)).To(BeTrue())
+					failed++
+				case <-time.After(200 * time.Millisecond):
+				}
+			}
+			Expect(failed).To(Equal(7))
+			// Completed invocations are not pending anymore
+			close(hub.release)
+			Eventually(func() error { return (<-client.Invoke("Quick")).Error }).ShouldNot(HaveOccurred())
+			close(done)
+		}, 5.0)
+		It("should evict invocations which are not completed within InvocationTimeout", func(done Done) {
+			client := startClient(MaxPendingInvocations(1), InvocationTimeout(100*time.Millisecond))
+			r := <-client.Invoke("Block")
+			Expect(errors.Is(r.Error, ErrInvocationTimeout)).To(BeTrue())
+			r = <-client.Invoke("Block")
+			Expect(errors.Is(r.Error, ErrInvocationTimeout)).To(BeTrue())
+			// The late completions are ignored and do not end the connection
+			close(hub.release)
+			r = <-client.Invoke("Quick")
+			Expect(r.Error).NotTo(HaveOccurred())
+			Expect(r.Value).To(BeEquivalentTo(1))
+			Consistently(client.State, 200*time.Millisecond).Should(Equal(ClientConnected))
+			close(done)
+		}, 5.0)
+	})
 	Context("Send", func() {
 		It("should invoke a server method and get the result via callback", func(done Done) {
 			receiver := &simpleReceiver{}
@@ -247,6 +591,23 @@ var _ = Describe("Client", func() {
 			close(done)
 		}, 1.0)
 	})
+	Context("SendMessage", func() {
+		It("should send an invocation built by the application", func(done Done) {
+			receiver := &simpleReceiver{ch: make(chan string, 1)}
+			_, client, _, cancelClient := getTestBed(receiver, formatOption)
+			Expect(<-client.SendMessage(Message{Type: 1, Target: "Callback", Arguments: []interface{}{"low"}})).NotTo(HaveOccurred())
+			Expect(<-receiver.ch).To(Equal("LOW"))
+			cancelClient()
+			close(done)
+		}, 1.0)
+		It("should return ErrInvalidMessage for invalid messages", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption)
+			Expect(<-client.SendMessage(Message{Type: 4, Target: "Callback"})).To(MatchError(ErrInvalidMessage))
+			Expect(<-client.SendMessage(Message{Type: 1, InvocationID: "1", Target: "Callback"})).To(MatchError(ErrInvalidMessage))
+			cancelClient()
+			close(done)
+		}, 1.0)
+	})
 	Context("PullStream", func() {
 		j := 1
 		It("should pull a stream from the server", func(done Done) {
@@ -266,6 +627,28 @@ var _ = Describe("Client", func() {
 			cancelClient()
 			close(done)
 		})
+		It("should pull a stream of []byte items as base64 strings", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption)
+			values := make([]interface{}, 0)
+			for r := range client.PullStream("ReadBytes") {
+				Expect(r.Error).NotTo(HaveOccurred())
+				values = append(values, r.Value)
+			}
+			Expect(values).To(Equal([]interface{}{"AAEC", "YmluYXJ5"}))
+			cancelClient()
+			close(done)
+		}, 2.0)
+		It("should decode []byte items when the stream is pulled with PullStreamTyped", func(done Done) {
+			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption)
+			values := make([]interface{}, 0)
+			for r := range client.PullStreamTyped(reflect.TypeOf([]byte{}), "ReadBytes") {
+				Expect(r.Error).NotTo(HaveOccurred())
+				values = append(values, r.Value)
+			}
+			Expect(values).To(Equal([]interface{}{[]byte{0, 1, 2}, []byte("binary")}))
+			cancelClient()
+			close(done)
+		}, 2.0)
 		It("should return no error when the method returns no stream but a single result", func(done Done) {
 			_, client, _, cancelClient := getTestBed(&simpleReceiver{}, formatOption)
 			r := <-client.PullStream("InvokeMe", "A", 1)
@@ -362,6 +745,168 @@ var _ = Describe("Client", func() {
 		}, 1.0)
 	})
 
+	Context("Primitive results", func() {
+		for _, f := range []string{"Text", "Binary"} {
+			format := f
+			for _, m := range []string{"Int", "Uint", "Float", "Text"} {
+				method := m
+				It(fmt.Sprintf("should return the same value for %v as result and as stream item with format %v", method, format), func(done Done) {
+					server, _ := NewServer(context.TODO(), SimpleHubFactory(&primitiveHub{}), testLoggerOption())
+					cliConn, srvConn := newClientServerConnections()
+					go func() { _ = server.Serve(srvConn) }()
+					ctx, cancelClient := context.WithCancel(context.Background())
+					client, err := NewClient(ctx, WithConnection(cliConn), testLoggerOption(), TransferFormat(format))
+					Expect(err).NotTo(HaveOccurred())
+					client.Start()
+					result := <-client.Invoke(method)
+					Expect(result.Error).NotTo(HaveOccurred())
+					item := <-client.PullStream(method + "Stream")
+					Expect(item.Error).NotTo(HaveOccurred())
+					Expect(item.Value).To(Equal(result.Value))
+					cancelClient()
+					server.cancel()
+					close(done)
+				}, 2.0)
+			}
+		}
+	})
+
+	Context("Typed results", func() {
+		for _, f := range []string{"Text", "Binary"} {
+			format := f
+			for _, w := range []struct {
+				method string
+				value  interface{}
+			}{
+				{"Int", -70000},
+				{"Uint", uint(5)},
+				{"Float", 3.25},
+				{"Struct", simpleStruct{AsInt: 70000, AsString: "primitive"}},
+			} {
+				want := w
+				It(fmt.Sprintf("should return the result of %v with its exact type with format %v", want.method, format), func(done Done) {
+					server, _ := NewServer(context.TODO(), SimpleHubFactory(&primitiveHub{}), testLoggerOption())
+					cliConn, srvConn := newClientServerConnections()
+					go func() { _ = server.Serve(srvConn) }()
+					ctx, cancelClient := context.WithCancel(context.Background())
+					client, err := NewClient(ctx, WithConnection(cliConn), testLoggerOption(), TransferFormat(format))
+					Expect(err).NotTo(HaveOccurred())
+					client.Start()
+					result := <-client.InvokeTyped(reflect.TypeOf(want.value), want.method)
+					Expect(result.Error).NotTo(HaveOccurred())
+					Expect(result.Value).To(Equal(want.value))
+					cancelClient()
+					server.cancel()
+					close(done)
+				}, 2.0)
+			}
+		}
+	})
+
+	Context("Array results", func() {
+		type tenAndHundred struct {
+			Ten     float64
+			Hundred float64
+		}
+		for _, f := range []string{"Text", "Binary"} {
+			format := f
+			for _, w := range []struct {
+				resultType reflect.Type
+				value      interface{}
+			}{
+				{nil, []interface{}{30.0, 300.0}},
+				{reflect.TypeOf([]float64{}), []float64{30, 300}},
+				{reflect.TypeOf(tenAndHundred{}), tenAndHundred{Ten: 30, Hundred: 300}},
+			} {
+				want := w
+				It(fmt.Sprintf("should return the results of SimpleFloat as %T with format %v", want.value, format), func(done Done) {
+					server, _ := NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}), testLoggerOption())
+					cliConn, srvConn := newClientServerConnections()
+					go func() { _ = server.Serve(srvConn) }()
+					ctx, cancelClient := context.WithCancel(context.Background())
+					client, err := NewClient(ctx, WithConnection(cliConn), testLoggerOption(), TransferFormat(format))
+					Expect(err).NotTo(HaveOccurred())
+					client.Start()
+					result := <-client.InvokeTyped(want.resultType, "SimpleFloat", 3.0)
+					Expect(<-invocationQueue).To(Equal("SimpleFloat(3)"))
+					Expect(result.Error).NotTo(HaveOccurred())
+					Expect(result.Value).To(BeEquivalentTo(want.value))
+					cancelClient()
+					server.cancel()
+					close(done)
+				}, 2.0)
+			}
+			It(fmt.Sprintf("should return an error when the struct does not match the results with format %v", format), func(done Done) {
+				server, _ := NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}), testLoggerOption())
+				cliConn, srvConn := newClientServerConnections()
+				go func() { _ = server.Serve(srvConn) }()
+				ctx, cancelClient := context.WithCancel(context.Background())
+				client, err := NewClient(ctx, WithConnection(cliConn), testLoggerOption(), TransferFormat(format))
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				result := <-client.InvokeTyped(reflect.TypeOf(struct{ Ten float64 }{}), "SimpleFloat", 3.0)
+				Expect(<-invocationQueue).To(Equal("SimpleFloat(3)"))
+				Expect(result.Error).To(HaveOccurred())
+				cancelClient()
+				server.cancel()
+				close(done)
+			}, 2.0)
+		}
+	})
+
+	Context("ClientMethod", func() {
+		for _, f := range []string{"Text", "Binary"} {
+			format := f
+			It(fmt.Sprintf("should push a struct argument to the client with format %v", format), func(done Done) {
+				receiver := &structReceiver{structs: make(chan simpleStruct, 1), maps: make(chan map[string]interface{}, 1)}
+				_, client, _, cancelClient := getTestBed(receiver, TransferFormat(format))
+				result := <-client.Invoke("PushStruct", 70000, "pushed")
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(<-receiver.structs).To(Equal(simpleStruct{AsInt: 70000, AsString: "pushed"}))
+				asMap := <-receiver.maps
+				Expect(asMap).To(HaveKey("AI"))
+				Expect(asMap).To(HaveKeyWithValue("AS", "pushed"))
+				cancelClient()
+				close(done)
+			}, 2.0)
+		}
+		It("should not push arguments which do not match the parameter types", func(done Done) {
+			receiver := &structReceiver{structs: make(chan simpleStruct, 1), maps: make(chan map[string]interface{}, 1)}
+			_, client, _, cancelClient := getTestBed(receiver, formatOption)
+			result := <-client.Invoke("PushWrongType")
+			Expect(result.Error).NotTo(HaveOccurred())
+			Expect(result.Value).To(ContainSubstring("not assignable"))
+			Consistently(receiver.structs, 100*time.Millisecond).ShouldNot(Receive())
+			cancelClient()
+			close(done)
+		}, 2.0)
+		It("should panic when a parameter type can not be serialized", func() {
+			Expect(func() { NewClientMethod("OnFunc", func() {}) }).To(Panic())
+		})
+	})
+
+	Context("Named arguments", func() {
+		for _, f := range []string{"Text", "Binary"} {
+			format := f
+			It(fmt.Sprintf("should bind named arguments with format %v", format), func(done Done) {
+				server, _ := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+					ParameterNames("InvokeMe", "arg1", "arg2"))
+				cliConn, srvConn := newClientServerConnections()
+				go func() { _ = server.Serve(srvConn) }()
+				ctx, cancelClient := context.WithCancel(context.Background())
+				client, err := NewClient(ctx, WithConnection(cliConn), testLoggerOption(), TransferFormat(format))
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				result := <-client.Invoke("InvokeMe", map[string]interface{}{"arg2": 5, "arg1": "A"})
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(result.Value).To(Equal("A5"))
+				cancelClient()
+				server.cancel()
+				close(done)
+			}, 2.0)
+		}
+	})
+
 	Context("Reconnect", func() {
 		var cliConn *pipeConnection
 		var srvConn *pipeConnection
@@ -396,13 +941,90 @@ var _ = Describe("Client", func() {
 		}, 2.0)
 		// TODO
 	})
+	Context("Reconnect instrumentation", func() {
+		var server Server
+		var client Client
+		var cancelClient context.CancelFunc
+		var events chan ConnectionEvent
+		var connections int32
+		var connectorErr atomic.Value
+		var firstConn *pipeConnection
+		BeforeEach(func(done Done) {
+			server, _ = NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			connections = 0
+			connectorErr = atomic.Value{}
+			connector := func() (Connection, error) {
+				if err, ok := connectorErr.Load().(error); ok {
+					return nil, err
+				}
+				cliConn, srvConn := newClientServerConnections()
+				if atomic.AddInt32(&connections, 1) == 1 {
+					firstConn = cliConn
+				}
+				go func() { _ = server.Serve(srvConn) }()
+				return cliConn, nil
+			}
+			events = make(chan ConnectionEvent, 20)
+			var ctx context.Context
+			ctx, cancelClient = context.WithCancel(context.Background())
+			client, _ = NewClient(ctx, WithConnector(connector), ConnectionEvents(events), testLoggerOption(), formatOption)
+			client.Start()
+			Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+			close(done)
+		}, 2.0)
+		AfterEach(func() {
+			cancelClient()
+			server.cancel()
+		})
+		waitForEvent := func(eventType ConnectionEventType) ConnectionEvent {
+			for {
+				select {
+				case event := <-events:
+					if event.Type == eventType {
+						return event
+					}
+				case <-time.After(3 * time.Second):
+					Fail(fmt.Sprintf("timeout waiting for %v", eventType))
+					return ConnectionEvent{}
+				}
+			}
+		}
+		It("should count reconnect attempts and successes", func(done Done) {
+			Expect(client.ReconnectStats()).To(Equal(ReconnectStats{}))
+			firstConn.fail.Store(errors.New("fail"))
+			reconnecting := waitForEvent(ConnectionReconnecting)
+			Expect(reconnecting.Delay).To(BeNumerically(">", 0))
+			waitForEvent(ConnectionReconnected)
+			stats := client.ReconnectStats()
+			Expect(stats.Attempts).To(Equal(uint64(1)))
+			Expect(stats.Successes).To(Equal(uint64(1)))
+			Expect(stats.Failures).To(Equal(uint64(0)))
+			Expect(stats.LastDelay).To(Equal(reconnecting.Delay))
+			Expect(stats.TotalDelay).To(Equal(reconnecting.Delay))
+			close(done)
+		}, 5.0)
+		It("should count a failure when the client gives up reconnecting", func(done Done) {
+			connectorErr.Store(errors.New("no connection"))
+			firstConn.fail.Store(errors.New("fail"))
+			waitForEvent(ConnectionReconnecting)
+			cancelClient()
+			failed := waitForEvent(ConnectionReconnectFailed)
+			Expect(failed.Error).To(HaveOccurred())
+			stats := client.ReconnectStats()
+			Expect(stats.Attempts).To(BeNumerically(">=", 1))
+			Expect(stats.Successes).To(Equal(uint64(0)))
+			Expect(stats.Failures).To(Equal(uint64(1)))
+			close(done)
+		}, 5.0)
+	})
 })
 
-func getTestBed(receiver interface{}, formatOption func(Party) error) (Server, Client, *pipeConnection, context.CancelFunc) {
-	server, _ := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+func getTestBed(receiver interface{}, formatOption func(Party) error, serverOptions ...func(Party) error) (Server, Client, *pipeConnection, context.CancelFunc) {
+	options := append([]func(Party) error{SimpleHubFactory(&simpleHub{}),
 		testLoggerOption(),
-		ChanReceiveTimeout(200*time.Millisecond),
-		StreamBufferCapacity(5))
+		ChanReceiveTimeout(200 * time.Millisecond),
+		StreamBufferCapacity(5)}, serverOptions...)
+	server, _ := NewServer(context.TODO(), options...)
 	// Create both ends of the connection
 	cliConn, srvConn := newClientServerConnections()
 	// Start the server
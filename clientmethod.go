@@ -0,0 +1,81 @@
+package signalr
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ClientMethod describes a method of the clients with typed parameters.
+// Arguments sent with ClientMethod.Send are checked against the parameter types before they are sent,
+// so a hub gets an error instead of clients which silently fail to bind the arguments.
+// Struct arguments are serialized with the names given by their json tags, regardless of the hub protocol.
+type ClientMethod struct {
+	target     string
+	paramTypes []reflect.Type
+}
+
+// NewClientMethod creates a ClientMethod for the client method target. The parameter types are taken
+// from the params, e.g.
+//
+//	var onMessage = signalr.NewClientMethod("OnMessage", "", Message{})
+//
+// NewClientMethod panics if a parameter type can not be serialized by the hub protocols,
+// so it should be used to initialize package level variables
+func NewClientMethod(target string, params ...interface{}) *ClientMethod {
+	paramTypes := make([]reflect.Type, len(params))
+	for i, param := range params {
+		if param == nil {
+			panic(fmt.Sprintf("signalr: client method %s: parameter %v has no type", target, i))
+		}
+		paramTypes[i] = reflect.TypeOf(param)
+		if err := checkSerializable(paramTypes[i], map[reflect.Type]bool{}); err != nil {
+			panic(fmt.Sprintf("signalr: client method %s: unsupported parameter type %v: %v", target, paramTypes[i], err))
+		}
+	}
+	return &ClientMethod{target: target, paramTypes: paramTypes}
+}
+
+// Target returns the name of the client method
+func (c *ClientMethod) Target() string {
+	return c.target
+}
+
+// Send sends the args to the client method via the proxy.
+// It returns an error and sends nothing if the args do not match the parameter types of the client method.
+// nil args are allowed for parameters of pointer, slice, map and interface types.
+func (c *ClientMethod) Send(proxy ClientProxy, args ...interface{}) error {
+	if err := c.checkArgs(args); err != nil {
+		return err
+	}
+	proxy.Send(c.target, args...)
+	return nil
+}
+
+// SendWithAck sends the args to the client method via the proxy and waits for the acknowledgement, see ClientProxy.SendWithAck
+func (c *ClientMethod) SendWithAck(proxy ClientProxy, policy AckPolicy, args ...interface{}) error {
+	if err := c.checkArgs(args); err != nil {
+		return err
+	}
+	return proxy.SendWithAck(policy, c.target, args...)
+}
+
+func (c *ClientMethod) checkArgs(args []interface{}) error {
+	if len(args) != len(c.paramTypes) {
+		return fmt.Errorf("client method %s: %v args passed, but %v are expected", c.target, len(args), len(c.paramTypes))
+	}
+	for i, arg := range args {
+		paramType := c.paramTypes[i]
+		if arg == nil {
+			switch paramType.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+				continue
+			default:
+				return fmt.Errorf("client method %s: nil can not be passed as arg %v of type %v", c.target, i, paramType)
+			}
+		}
+		if argType := reflect.TypeOf(arg); !argType.AssignableTo(paramType) {
+			return fmt.Errorf("client method %s: arg %v of type %v is not assignable to %v", c.target, i, argType, paramType)
+		}
+	}
+	return nil
+}
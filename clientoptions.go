@@ -3,6 +3,7 @@ package signalr
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // WithConnection sets the Connection of the Client
@@ -49,6 +50,29 @@ func WithReceiver(receiver interface{}) func(Party) error {
 	}
 }
 
+// InvokeRetries sets the methods which are idempotent, so Invoke can safely retry them
+// when the invocation fails because the connection has failed, was closed or has been lost.
+// Invocations which the server has completed with a HubError are not retried, neither are invocations
+// which failed because of the invocation itself, e.g. with ErrMarshalFailed, ErrTooManyPendingInvocations
+// or ErrInvocationTimeout.
+// Retrying is useful together with WithConnector, which reconnects the client after the connection has failed.
+func InvokeRetries(policy InvokeRetryPolicy, idempotentMethods ...string) func(Party) error {
+	return func(party Party) error {
+		if policy.MaxRetries < 0 || policy.Delay < 0 {
+			return fmt.Errorf("invalid InvokeRetryPolicy %+v", policy)
+		}
+		if client, ok := party.(*client); ok {
+			client.invokeRetryPolicy = policy
+			client.idempotentMethods = make(map[string]bool, len(idempotentMethods))
+			for _, method := range idempotentMethods {
+				client.idempotentMethods[method] = true
+			}
+			return nil
+		}
+		return errors.New("option InvokeRetries is client only")
+	}
+}
+
 // TransferFormat sets the transfer format used on the transport. Allowed values are "Text" and "Binary"
 func TransferFormat(format string) func(Party) error {
 	return func(p Party) error {
@@ -66,3 +90,72 @@ func TransferFormat(format string) func(Party) error {
 		return errors.New("option TransferFormat is client only")
 	}
 }
+
+// UseHubProtocol sets the hub protocol which the client requests with the handshake: "json", "messagepack" or "protobuf".
+// "protobuf" is no standard SignalR protocol and only supported by servers of this package.
+// Default is "json". UseHubProtocol("json") is the same as TransferFormat("Text"),
+// UseHubProtocol("messagepack") the same as TransferFormat("Binary").
+func UseHubProtocol(protocol string) func(Party) error {
+	return func(p Party) error {
+		if c, ok := p.(*client); ok {
+			if _, ok := protocolMap[protocol]; !ok {
+				return fmt.Errorf("unsupported hub protocol %q", protocol)
+			}
+			c.format = protocol
+			return nil
+		}
+		return errors.New("option UseHubProtocol is client only")
+	}
+}
+
+// HandshakeFields sets fields which the client sends with the handshake request in addition to protocol and version,
+// e.g. auth tokens or metadata of the client. The server reads them with the parser set by HandshakeExtension.
+// The fields are marshaled as JSON and must not contain the reserved fields protocol, version and type.
+func HandshakeFields(fields map[string]interface{}) func(Party) error {
+	return func(party Party) error {
+		for _, reserved := range []string{"protocol", "version", "type"} {
+			if _, ok := fields[reserved]; ok {
+				return fmt.Errorf("handshake field %v is reserved", reserved)
+			}
+		}
+		if client, ok := party.(*client); ok {
+			client.handshakeFields = fields
+			return nil
+		}
+		return errors.New("option HandshakeFields is client only")
+	}
+}
+
+// MaxPendingInvocations sets the maximum number of invocations which have been sent to the server but are not completed yet.
+// When the maximum is reached, Invoke, Send and PullStream fail fast with ErrTooManyPendingInvocations
+// until the server has completed some invocations, or they have been evicted by InvocationTimeout.
+// Default is 0, which means unlimited.
+func MaxPendingInvocations(max int) func(Party) error {
+	return func(party Party) error {
+		if max < 0 {
+			return fmt.Errorf("unsupported MaxPendingInvocations %v", max)
+		}
+		if client, ok := party.(*client); ok {
+			client.maxPending = max
+			return nil
+		}
+		return errors.New("option MaxPendingInvocations is client only")
+	}
+}
+
+// InvocationTimeout sets the time in which the server has to complete an invocation sent with Invoke or Send.
+// Invocations which are not completed in time fail with ErrInvocationTimeout and are not pending anymore,
+// see MaxPendingInvocations. A completion which is received after the timeout is ignored.
+// Default is 0, which means the client waits until the connection ends.
+func InvocationTimeout(timeout time.Duration) func(Party) error {
+	return func(party Party) error {
+		if timeout < 0 {
+			return fmt.Errorf("unsupported InvocationTimeout %v", timeout)
+		}
+		if client, ok := party.(*client); ok {
+			client.invocationTimeout = timeout
+			return nil
+		}
+		return errors.New("option InvocationTimeout is client only")
+	}
+}
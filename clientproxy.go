@@ -1,8 +1,14 @@
 package signalr
 
 //ClientProxy allows the hub to send messages to one or more of its clients
+// Send sends the message without waiting for the clients.
+// SendWithAck sends the message and waits until all clients have acknowledged it. Messages which are not acknowledged
+// in time are sent again as configured by policy. So clients might receive a message more than once.
+// The acknowledgement only confirms the delivery, errors of the client method are not reported.
+// SendWithAck returns an error wrapping ErrNotAcknowledged if a client has not acknowledged the message.
 type ClientProxy interface {
 	Send(target string, args ...interface{})
+	SendWithAck(policy AckPolicy, target string, args ...interface{}) error
 }
 
 type allClientProxy struct {
@@ -13,6 +19,10 @@ func (a *allClientProxy) Send(target string, args ...interface{}) {
 	a.lifetimeManager.InvokeAll(target, args)
 }
 
+func (a *allClientProxy) SendWithAck(policy AckPolicy, target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeAllWithAck(target, args, policy)
+}
+
 type singleClientProxy struct {
 	connectionID    string
 	lifetimeManager HubLifetimeManager
@@ -22,6 +32,10 @@ func (a *singleClientProxy) Send(target string, args ...interface{}) {
 	a.lifetimeManager.InvokeClient(a.connectionID, target, args)
 }
 
+func (a *singleClientProxy) SendWithAck(policy AckPolicy, target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeClientWithAck(a.connectionID, target, args, policy)
+}
+
 type groupClientProxy struct {
 	groupName       string
 	lifetimeManager HubLifetimeManager
@@ -30,3 +44,48 @@ type groupClientProxy struct {
 func (g *groupClientProxy) Send(target string, args ...interface{}) {
 	g.lifetimeManager.InvokeGroup(g.groupName, target, args)
 }
+
+func (g *groupClientProxy) SendWithAck(policy AckPolicy, target string, args ...interface{}) error {
+	return g.lifetimeManager.InvokeGroupWithAck(g.groupName, target, args, policy)
+}
+
+type groupExceptClientProxy struct {
+	groupName             string
+	excludedConnectionIDs []string
+	lifetimeManager       HubLifetimeManager
+}
+
+func (g *groupExceptClientProxy) Send(target string, args ...interface{}) {
+	g.lifetimeManager.InvokeGroupExcept(g.groupName, g.excludedConnectionIDs, target, args)
+}
+
+func (g *groupExceptClientProxy) SendWithAck(policy AckPolicy, target string, args ...interface{}) error {
+	return g.lifetimeManager.InvokeGroupExceptWithAck(g.groupName, g.excludedConnectionIDs, target, args, policy)
+}
+
+type taggedClientProxy struct {
+	key             string
+	value           string
+	lifetimeManager HubLifetimeManager
+}
+
+func (t *taggedClientProxy) Send(target string, args ...interface{}) {
+	t.lifetimeManager.InvokeTagged(t.key, t.value, target, args)
+}
+
+func (t *taggedClientProxy) SendWithAck(policy AckPolicy, target string, args ...interface{}) error {
+	return t.lifetimeManager.InvokeTaggedWithAck(t.key, t.value, target, args, policy)
+}
+
+type userClientProxy struct {
+	userID          string
+	lifetimeManager HubLifetimeManager
+}
+
+func (u *userClientProxy) Send(target string, args ...interface{}) {
+	u.lifetimeManager.InvokeUser(u.userID, target, args)
+}
+
+func (u *userClientProxy) SendWithAck(policy AckPolicy, target string, args ...interface{}) error {
+	return u.lifetimeManager.InvokeUserWithAck(u.userID, target, args, policy)
+}
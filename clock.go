@@ -0,0 +1,109 @@
+package signalr
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time for all timers of a Party, e.g. the keep alive, the timeouts and the reconnect backoff.
+// It can be replaced by a fake clock to test these timers without waiting, see WithClock.
+//  Now() time.Time
+// Now returns the current time
+//  After(d time.Duration) <-chan time.Time
+// After waits for d to elapse and then sends the current time on the returned channel, like time.After
+//  NewTimer(d time.Duration) Timer
+// NewTimer returns a Timer which sends the current time after d, like time.NewTimer
+//  AfterFunc(d time.Duration, f func()) Timer
+// AfterFunc calls f in its own goroutine after d, like time.AfterFunc. The C of the returned Timer is nil
+//  NewTicker(d time.Duration) Ticker
+// NewTicker returns a Ticker which sends the current time every d, like time.NewTicker
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a timer created by a Clock
+//  C() <-chan time.Time
+// C returns the channel on which the time is delivered
+//  Stop() bool
+// Stop prevents the timer from firing. It returns false if the timer has already fired or been stopped
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is a ticker created by a Clock
+//  C() <-chan time.Time
+// C returns the channel on which the ticks are delivered
+//  Stop()
+// Stop turns off the ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the default Clock, which uses the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{timer: time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &realTimer{timer: time.AfterFunc(d, f)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (r *realTimer) C() <-chan time.Time {
+	return r.timer.C
+}
+
+func (r *realTimer) Stop() bool {
+	return r.timer.Stop()
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (r *realTicker) C() <-chan time.Time {
+	return r.ticker.C
+}
+
+func (r *realTicker) Stop() {
+	r.ticker.Stop()
+}
+
+// withClockTimeout returns a context which is canceled when timeout has elapsed on clock, like context.WithTimeout
+func withClockTimeout(parent context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(parent, timeout)
+	}
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-clock.After(timeout):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,313 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeClock is a Clock which only advances when Advance is called
+type fakeClock struct {
+	mx      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a pending After, Timer or Ticker of the fakeClock. Tickers have a period, timers of AfterFunc a func
+type fakeWaiter struct {
+	at      time.Time
+	period  time.Duration
+	ch      chan time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	return f.addWaiter(d, 0).ch
+}
+
+func (f *fakeClock) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{clock: f, waiter: f.addWaiter(d, 0)}
+}
+
+func (f *fakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	waiter := f.addWaiter(d, 0)
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	waiter.f = fn
+	return &fakeTimer{clock: f, waiter: waiter}
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker {
+	return &fakeTicker{clock: f, waiter: f.addWaiter(d, d)}
+}
+
+func (f *fakeClock) addWaiter(d time.Duration, period time.Duration) *fakeWaiter {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	waiter := &fakeWaiter{at: f.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, waiter)
+	return waiter
+}
+
+// Advance moves the clock forward and fires all waiters which are due
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.waiters[:0]
+	for _, waiter := range f.waiters {
+		if waiter.stopped {
+			continue
+		}
+		if !waiter.at.After(f.now) {
+			if waiter.f != nil {
+				go waiter.f()
+			} else {
+				select {
+				case waiter.ch <- f.now:
+				default:
+				}
+			}
+			if waiter.period == 0 {
+				waiter.stopped = true
+				continue
+			}
+			for !waiter.at.After(f.now) {
+				waiter.at = waiter.at.Add(waiter.period)
+			}
+		}
+		pending = append(pending, waiter)
+	}
+	f.waiters = pending
+}
+
+type fakeTicker struct {
+	clock  *fakeClock
+	waiter *fakeWaiter
+}
+
+func (f *fakeTicker) C() <-chan time.Time {
+	return f.waiter.ch
+}
+
+func (f *fakeTicker) Stop() {
+	f.clock.mx.Lock()
+	defer f.clock.mx.Unlock()
+	f.waiter.stopped = true
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	waiter *fakeWaiter
+}
+
+func (f *fakeTimer) C() <-chan time.Time {
+	if f.waiter.f != nil {
+		return nil
+	}
+	return f.waiter.ch
+}
+
+func (f *fakeTimer) Stop() bool {
+	f.clock.mx.Lock()
+	defer f.clock.mx.Unlock()
+	stopped := f.waiter.stopped
+	f.waiter.stopped = true
+	return !stopped
+}
+
+var _ = Describe("Clock", func() {
+
+	Context("When the timeout interval elapses on the clock", func() {
+		It("should close the connection without waiting", func(done Done) {
+			clock := newFakeClock()
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+				WithClock(clock), HandshakeTimeout(time.Hour), TimeoutInterval(30*time.Second), KeepAliveInterval(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			Eventually(func() interface{} {
+				clock.Advance(31 * time.Second)
+				select {
+				case message := <-conn.received:
+					return message
+				default:
+					return nil
+				}
+			}, time.Second, 10*time.Millisecond).Should(BeAssignableToTypeOf(closeMessage{}))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When the keep alive ticks while nothing is received", func() {
+		It("should not start the timeout interval again", func(done Done) {
+			clock := newFakeClock()
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+				WithClock(clock), HandshakeTimeout(time.Hour), TimeoutInterval(30*time.Second), KeepAliveInterval(10*time.Second))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			// Each step lets the keep alive tick, but the steps sum up to the timeout interval
+			Eventually(func() interface{} {
+				clock.Advance(5 * time.Second)
+				select {
+				case message := <-conn.received:
+					return message
+				default:
+					return nil
+				}
+			}, time.Second, 10*time.Millisecond).Should(BeAssignableToTypeOf(closeMessage{}))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When the keep alive interval elapses on the clock", func() {
+		It("should send a ping without waiting", func(done Done) {
+			clock := newFakeClock()
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+				WithClock(clock), HandshakeTimeout(time.Hour), TimeoutInterval(time.Hour), KeepAliveInterval(10*time.Second))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnection()
+			go func() { _ = server.Serve(conn) }()
+			conn.ClientSend(`{"protocol": "json","version": 1}`)
+			messages := make(chan string, 10)
+			go func() {
+				for {
+					message, err := conn.ClientReceive()
+					if err != nil {
+						return
+					}
+					messages <- message
+				}
+			}()
+			Expect(<-messages).To(Equal("{}"))
+			Eventually(func() chan string {
+				clock.Advance(5 * time.Second)
+				return messages
+			}, time.Second, 10*time.Millisecond).Should(Receive(Equal(`{"type":6}`)))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When the handshake timeout elapses on the clock", func() {
+		It("should end the connection without waiting", func(done Done) {
+			clock := newFakeClock()
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+				WithClock(clock), HandshakeTimeout(15*time.Second))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnection()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(conn) }()
+			Eventually(func() chan error {
+				clock.Advance(16 * time.Second)
+				return served
+			}, time.Second, 10*time.Millisecond).Should(Receive(HaveOccurred()))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When the invocation timeout elapses on the clock", func() {
+		It("should evict the invocation without waiting", func(done Done) {
+			clock := newFakeClock()
+			hub := &pendingHub{release: make(chan struct{})}
+			server, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			cliConn, srvConn := newClientServerConnections()
+			go func() { _ = server.Serve(srvConn) }()
+			ctx, cancel := context.WithCancel(context.Background())
+			client, err := NewClient(ctx, WithConnection(cliConn), WithClock(clock), testLoggerOption(),
+				TimeoutInterval(time.Hour), InvocationTimeout(time.Minute))
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+			result := client.Invoke("Block")
+			Consistently(result, 100*time.Millisecond).ShouldNot(Receive())
+			var r InvokeResult
+			Eventually(func() <-chan InvokeResult {
+				clock.Advance(time.Minute)
+				return result
+			}, time.Second, 10*time.Millisecond).Should(Receive(&r))
+			Expect(errors.Is(r.Error, ErrInvocationTimeout)).To(BeTrue())
+			close(hub.release)
+			cancel()
+			server.cancel()
+			close(done)
+		}, 3.0)
+	})
+
+	Context("When the ack timeout and the backoff elapse on the clock", func() {
+		It("should send the message again without waiting", func(done Done) {
+			clock := newFakeClock()
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+				WithClock(clock), HandshakeTimeout(time.Hour), TimeoutInterval(time.Hour), KeepAliveInterval(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			// Wait until the connection is known by the server
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["A",1]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("A1"))
+			sent := make(chan error, 1)
+			go func() {
+				sent <- server.HubClients().All().SendWithAck(
+					AckPolicy{Timeout: time.Minute, Retries: 1, Backoff: time.Minute}, "OnCallback", "ack")
+			}()
+			Expect(<-conn.received).To(BeAssignableToTypeOf(invocationMessage{}))
+			Eventually(func() interface{} {
+				clock.Advance(time.Minute)
+				select {
+				case message := <-conn.received:
+					return message
+				default:
+					return nil
+				}
+			}, time.Second, 10*time.Millisecond).Should(BeAssignableToTypeOf(invocationMessage{}))
+			Eventually(func() chan error {
+				clock.Advance(time.Minute)
+				return sent
+			}, time.Second, 10*time.Millisecond).Should(Receive(MatchError(ErrNotAcknowledged)))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When the reconnect backoff elapses on the clock", func() {
+		It("should try to reconnect without waiting", func(done Done) {
+			clock := newFakeClock()
+			ctx, cancel := context.WithCancel(context.Background())
+			client, err := NewClient(ctx, WithClock(clock), testLoggerOption(),
+				WithConnector(func() (Connection, error) {
+					return nil, errors.New("refused")
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			Consistently(func() uint64 {
+				return client.ReconnectStats().Attempts
+			}, 100*time.Millisecond).Should(BeZero())
+			Eventually(func() uint64 {
+				clock.Advance(time.Minute)
+				return client.ReconnectStats().Attempts
+			}, time.Second, 10*time.Millisecond).Should(BeNumerically(">=", 2))
+			cancel()
+			close(done)
+		}, 2.0)
+	})
+})
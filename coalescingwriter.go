@@ -0,0 +1,71 @@
+package signalr
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// coalescingWriter collects the frames written to it and writes them to the underlying writer with one Write call,
+// when delay has elapsed since the first collected frame or when at least size bytes have been collected.
+// Each Write call of the protocols writes one complete frame, so the collected frames are never split.
+// Errors of delayed writes are passed to onError and returned by all following Write calls.
+type coalescingWriter struct {
+	mx      sync.Mutex
+	writer  io.Writer
+	delay   time.Duration
+	size    uint
+	buf     bytes.Buffer
+	clock   Clock
+	timer   Timer
+	err     error
+	onError func(err error)
+}
+
+func newCoalescingWriter(writer io.Writer, delay time.Duration, size uint, clock Clock, onError func(err error)) *coalescingWriter {
+	return &coalescingWriter{writer: writer, delay: delay, size: size, clock: clock, onError: onError}
+}
+
+func (c *coalescingWriter) Write(p []byte) (int, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.buf.Write(p)
+	if uint(c.buf.Len()) >= c.size {
+		return len(p), c.flush()
+	}
+	if c.timer == nil {
+		c.timer = c.clock.AfterFunc(c.delay, func() {
+			c.mx.Lock()
+			defer c.mx.Unlock()
+			if err := c.flush(); err != nil && c.onError != nil {
+				c.onError(err)
+			}
+		})
+	}
+	return len(p), nil
+}
+
+// Flush writes the collected frames at once
+func (c *coalescingWriter) Flush() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.flush()
+}
+
+// flush writes the collected frames. The mutex must be held.
+func (c *coalescingWriter) flush() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.err != nil || c.buf.Len() == 0 {
+		return c.err
+	}
+	_, c.err = c.writer.Write(c.buf.Bytes())
+	c.buf.Reset()
+	return c.err
+}
@@ -0,0 +1,52 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrCompletionHeadersNotSupported is returned by SetCompletionHeader when ctx is not the context of an invocation
+// which is completed with a result
+var ErrCompletionHeadersNotSupported = errors.New("invocation can not send completion headers")
+
+type completionHeadersKey struct{}
+
+// completionHeaders are the headers which are sent with the completion of an invocation
+type completionHeaders struct {
+	mx      sync.Mutex
+	headers map[string]string
+}
+
+func (c *completionHeaders) set(key, value string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.headers == nil {
+		c.headers = make(map[string]string)
+	}
+	c.headers[key] = value
+}
+
+func (c *completionHeaders) get() map[string]string {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.headers
+}
+
+// withCompletionHeaders returns a context which lets the invoked method set the headers of its completion
+func withCompletionHeaders(ctx context.Context, headers *completionHeaders) context.Context {
+	return context.WithValue(ctx, completionHeadersKey{}, headers)
+}
+
+// SetCompletionHeader sets a header of the completion which carries the result of a hub method, e.g. a trace id.
+// ctx must be the context.Context the method has received as first parameter. The header is sent with the completion,
+// whether the method returns a result or an error. Clients receive the headers in InvokeResult.Headers.
+// SetCompletionHeader returns ErrCompletionHeadersNotSupported for stream invocations and invocations which do not expect a result.
+func SetCompletionHeader(ctx context.Context, key, value string) error {
+	headers, ok := ctx.Value(completionHeadersKey{}).(*completionHeaders)
+	if !ok {
+		return ErrCompletionHeadersNotSupported
+	}
+	headers.set(key, value)
+	return nil
+}
@@ -5,7 +5,8 @@ import (
 	"io"
 )
 
-// Connection describes a connection between signalR client and server
+// Connection describes a connection between signalR client and server.
+// If the Connection implements io.Closer, it is closed when the hub connection has ended.
 type Connection interface {
 	io.Reader
 	io.Writer
@@ -14,6 +15,15 @@ type Connection interface {
 	SetConnectionID(id string)
 }
 
+// closeConnection releases the resources of the transport, e.g. the socket, if the Connection implements io.Closer.
+// io.Closer is optional to keep existing Connection implementations working.
+func closeConnection(conn Connection) error {
+	if closer, ok := conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // TransferMode is either TextTransferMode or BinaryTransferMode
 type TransferMode int
 
@@ -1,10 +1,21 @@
 package signalr
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
 	"time"
 
+	"github.com/go-kit/log"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -170,6 +181,65 @@ var _ = Describe("Handshake", func() {
 			close(done)
 		})
 	})
+	Context("When an invocation is sent before the handshake", func() {
+		It("should reject it with a protocol error and be not connected", func(done Done) {
+			conn, cancel := getTestBedHandshake()
+			conn.ClientSend(`{"type":1,"invocationId": "123E","target":"shake"}`)
+			response, err := conn.ClientReceive()
+			Expect(err).To(BeNil())
+			jsonMap := make(map[string]interface{})
+			Expect(json.Unmarshal([]byte(response), &jsonMap)).To(Succeed())
+			Expect(jsonMap["error"]).To(Equal("protocol error: message of type 1 received before the handshake"))
+			conn.ClientSend(`{"protocol": "json","version": 1}`)
+			conn.ClientSend(`{"type":1,"invocationId": "123F","target":"shake"}`)
+			select {
+			case <-shakeQueue:
+				Fail("server invoked a method without handshake")
+			case <-time.After(100 * time.Millisecond):
+			}
+			cancel()
+			close(done)
+		})
+	})
+	Context("When the server sends a hub message instead of the handshake response", func() {
+		It("should end the client with a protocol error and not invoke the receiver", func(done Done) {
+			cliConn, srvConn := newClientServerConnections()
+			go func() {
+				// Read the handshake request, but send an invocation instead of the response
+				buf := make([]byte, 1<<10)
+				_, _ = srvConn.Read(buf)
+				_, _ = srvConn.Write([]byte("{\"type\":1,\"target\":\"OnCallback\",\"arguments\":[\"early\"]}\u001e"))
+			}()
+			receiver := &simpleReceiver{ch: make(chan string, 1)}
+			client, err := NewClient(context.TODO(), WithConnection(cliConn), WithReceiver(receiver), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			Expect(<-client.WaitForState(context.Background(), ClientClosed)).NotTo(HaveOccurred())
+			Expect(errors.Is(client.Err(), ErrProtocolViolation)).To(BeTrue())
+			Consistently(receiver.ch, 100*time.Millisecond).ShouldNot(Receive())
+			close(done)
+		}, 2.0)
+	})
+	Context("When a handshake is sent with an unsupported protocol version", func() {
+		It("should return an error handshake response and be not connected", func(done Done) {
+			conn, cancel := getTestBedHandshake()
+			conn.ClientSend(`{"protocol": "json","version": 2}`)
+			response, err := conn.ClientReceive()
+			Expect(err).To(BeNil())
+			jsonMap := make(map[string]interface{})
+			err = json.Unmarshal([]byte(response), &jsonMap)
+			Expect(err).To(BeNil())
+			Expect(jsonMap["error"]).To(ContainSubstring("version 2"))
+			conn.ClientSend(`{"type":1,"invocationId": "123D","target":"shake"}`)
+			select {
+			case <-shakeQueue:
+				Fail("server connected with invalid handshake")
+			case <-time.After(100 * time.Millisecond):
+			}
+			cancel()
+			close(done)
+		})
+	})
 	Context("When the connection fails before the server can receive handshake request", func() {
 		It("should not be connected", func(done Done) {
 			conn, cancel := getTestBedHandshake()
@@ -232,4 +302,413 @@ var _ = Describe("Handshake", func() {
 			close(done)
 		}, 2.0)
 	})
+
+	Describe("Ping", func() {
+		Context("When Ping is called concurrently and repeatedly", func() {
+			It("should send at most one ping per keep alive interval", func(done Done) {
+				conn := newTestingConnection()
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, 300*time.Millisecond, testLogger(), nil)
+				pings := make(chan string, 20)
+				go func() {
+					for {
+						message, err := conn.ClientReceive()
+						if err != nil {
+							return
+						}
+						pings <- message
+					}
+				}()
+				for i := 0; i < 10; i++ {
+					go func() { _ = hubConn.Ping() }()
+				}
+				Eventually(pings, 200*time.Millisecond).Should(Receive(Equal(`{"type":6}`)))
+				Consistently(pings, 200*time.Millisecond).ShouldNot(Receive())
+				// After the interval, the next ping is sent
+				Eventually(func() int {
+					_ = hubConn.Ping()
+					return len(pings)
+				}, 500*time.Millisecond, 50*time.Millisecond).Should(Equal(1))
+				hubConn.Abort()
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Write coalescing", func() {
+		Context("When messages are sent in quick succession", func() {
+			It("should write them at once and they should be parsed one by one", func(done Done) {
+				conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "coalesce")}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+				hubConn.(*defaultHubConnection).coalesceWrites(50*time.Millisecond, 1<<15)
+				for i := 0; i < 10; i++ {
+					Expect(hubConn.SendInvocation("", "coalesced", []interface{}{i})).To(Succeed())
+				}
+				Expect(conn.writes()).To(Equal(0))
+				Eventually(conn.writes, time.Second).Should(Equal(1))
+				protocol := &jsonHubProtocol{dbg: testLogger()}
+				messages, err := protocol.ParseMessages(bytes.NewReader(conn.written()), &bytes.Buffer{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages).To(HaveLen(10))
+				for i, message := range messages {
+					invocation := message.(invocationMessage)
+					Expect(invocation.Target).To(Equal("coalesced"))
+					var arg int
+					Expect(protocol.UnmarshalArgument(invocation.Arguments[0], &arg)).To(Succeed())
+					Expect(arg).To(Equal(i))
+				}
+				hubConn.Abort()
+				close(done)
+			}, 2.0)
+		})
+		Context("When the collected messages reach the size", func() {
+			It("should write them without waiting for the delay", func(done Done) {
+				conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "coalesce")}
+				hubConn := newHubConnection(conn, &messagePackHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+				hubConn.(*defaultHubConnection).coalesceWrites(time.Minute, 1)
+				for i := 0; i < 3; i++ {
+					Expect(hubConn.SendInvocation("", "coalesced", []interface{}{i})).To(Succeed())
+				}
+				Expect(conn.writes()).To(Equal(3))
+				hubConn.Abort()
+				close(done)
+			}, 2.0)
+		})
+		Context("When the connection is closed", func() {
+			It("should write the collected messages before the close message", func(done Done) {
+				conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "coalesce")}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+				hubConn.(*defaultHubConnection).coalesceWrites(time.Minute, 1<<15)
+				Expect(hubConn.SendInvocation("", "coalesced", nil)).To(Succeed())
+				Expect(hubConn.Close("", false)).To(Succeed())
+				Expect(conn.writes()).To(Equal(1))
+				messages, err := (&jsonHubProtocol{dbg: testLogger()}).ParseMessages(bytes.NewReader(conn.written()), &bytes.Buffer{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages).To(HaveLen(2))
+				Expect(messages[1]).To(BeAssignableToTypeOf(closeMessage{}))
+				close(done)
+			}, 2.0)
+		})
+		Context("When the connection is flushed", func() {
+			It("should write the collected messages and flush the transport", func(done Done) {
+				conn := &flushingConnection{
+					writeRecordingConnection: writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "flush")},
+				}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+				hubConn.(*defaultHubConnection).coalesceWrites(time.Minute, 1<<15)
+				Expect(hubConn.SendInvocation("", "coalesced", nil)).To(Succeed())
+				Expect(conn.writes()).To(Equal(0))
+				Expect(hubConn.Flush()).To(Succeed())
+				Expect(conn.writes()).To(Equal(1))
+				Expect(atomic.LoadInt32(&conn.flushes)).To(Equal(int32(1)))
+				hubConn.Abort()
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("PrioritizeControlMessages", func() {
+		// writeBacklog blocks the connection with a stream item, queues a backlog of stream items and then a completion.
+		// It returns the types of the written messages
+		writeBacklog := func(prioritized bool) []string {
+			conn := &gatedConnection{
+				writeRecordingConnection: writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "gated")},
+				writing:                  make(chan struct{}, 1),
+				gate:                     make(chan struct{}),
+			}
+			hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+			defaultHubConn := hubConn.(*defaultHubConnection)
+			defaultHubConn.prioritized = prioritized
+			waiting := func() int {
+				defaultHubConn.writeMx.mx.Lock()
+				defer defaultHubConn.writeMx.mx.Unlock()
+				return len(defaultHubConn.writeMx.high) + len(defaultHubConn.writeMx.normal)
+			}
+			go func() { _ = hubConn.StreamItem("stream", 0, 0) }()
+			<-conn.writing
+			for i := 1; i <= 5; i++ {
+				go func(i int) { _ = hubConn.StreamItem("stream", i, 0) }(i)
+			}
+			Eventually(waiting, time.Second).Should(Equal(5))
+			go func() { _ = hubConn.Completion("invocation", nil, "") }()
+			Eventually(waiting, time.Second).Should(Equal(6))
+			close(conn.gate)
+			Eventually(conn.writes, time.Second).Should(Equal(7))
+			messages, err := (&jsonHubProtocol{dbg: testLogger()}).ParseMessages(bytes.NewReader(conn.written()), &bytes.Buffer{})
+			Expect(err).NotTo(HaveOccurred())
+			types := make([]string, 0, len(messages))
+			for _, message := range messages {
+				types = append(types, fmt.Sprintf("%T", message))
+			}
+			hubConn.Abort()
+			return types
+		}
+		Context("When a completion is sent while stream items are waiting to be written", func() {
+			It("should write the completion ahead of the stream items", func(done Done) {
+				types := writeBacklog(true)
+				Expect(types).To(HaveLen(7))
+				Expect(types[0]).To(Equal("signalr.streamItemMessage"))
+				Expect(types[1]).To(Equal("signalr.completionMessage"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When the option is not set", func() {
+			It("should write the completion after the waiting stream items", func(done Done) {
+				types := writeBacklog(false)
+				Expect(types).To(HaveLen(7))
+				Expect(types[6]).To(Equal("signalr.completionMessage"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Writing to a closed connection", func() {
+		Context("When messages are sent after Close", func() {
+			It("should not write them and return ErrConnectionClosed", func(done Done) {
+				conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "closed")}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+				Expect(hubConn.Close("", false)).To(Succeed())
+				Expect(conn.writes()).To(Equal(1))
+				Expect(hubConn.StreamItem("1", 1, 0)).To(MatchError(ErrConnectionClosed))
+				Expect(hubConn.Completion("1", nil, "")).To(MatchError(ErrConnectionClosed))
+				Expect(hubConn.SendInvocation("", "closed", nil)).To(MatchError(ErrConnectionClosed))
+				// A second Close sends no second close message
+				Expect(hubConn.Close("", false)).To(Succeed())
+				Expect(conn.writes()).To(Equal(1))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a stream is active while the connection is closed", func() {
+			It("should end the stream without writing the following items", func(done Done) {
+				conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "closed")}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+				s := &streamer{conn: hubConn}
+				items := make(chan int)
+				ended := make(chan struct{})
+				s.Start("stream", reflect.ValueOf(items), func() { close(ended) }, nil)
+				items <- 1
+				Eventually(conn.writes, time.Second).Should(Equal(1))
+				Expect(hubConn.Close("", false)).To(Succeed())
+				items <- 2
+				Eventually(ended, time.Second).Should(BeClosed())
+				// Only the item before Close and the close message have been written
+				Consistently(conn.writes, 100*time.Millisecond).Should(Equal(2))
+				close(done)
+			}, 2.0)
+		})
+		Context("When the connection ends while streams wait for items", func() {
+			It("should end all streams at once without writing completions", func(done Done) {
+				conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "aborted")}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+				s := &streamer{conn: hubConn}
+				ended := make(chan string, 3)
+				for _, id := range []string{"1", "2", "3"} {
+					id := id
+					s.Start(id, reflect.ValueOf(make(chan int)), func() { ended <- id }, nil)
+				}
+				hubConn.Abort()
+				endedIDs := make([]string, 0, 3)
+				for i := 0; i < 3; i++ {
+					endedIDs = append(endedIDs, <-ended)
+				}
+				Expect(endedIDs).To(ConsistOf("1", "2", "3"))
+				Consistently(conn.writes, 100*time.Millisecond).Should(Equal(0))
+				close(done)
+			}, 2.0)
+		})
+	})
+})
+
+// gatedConnection is a writeRecordingConnection whose writes wait until the gate is opened
+type gatedConnection struct {
+	writeRecordingConnection
+	writing chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedConnection) Write(p []byte) (int, error) {
+	select {
+	case g.writing <- struct{}{}:
+	default:
+	}
+	<-g.gate
+	return g.writeRecordingConnection.Write(p)
+}
+
+// writeRecordingConnection is a Connection which records all writes and never receives anything
+type writeRecordingConnection struct {
+	*ConnectionBase
+	mx    sync.Mutex
+	buf   bytes.Buffer
+	count int
+}
+
+func (w *writeRecordingConnection) Read([]byte) (int, error) {
+	<-w.Context().Done()
+	return 0, w.Context().Err()
+}
+
+func (w *writeRecordingConnection) Write(p []byte) (int, error) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	w.count++
+	return w.buf.Write(p)
+}
+
+func (w *writeRecordingConnection) writes() int {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return w.count
+}
+
+func (w *writeRecordingConnection) written() []byte {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return append([]byte{}, w.buf.Bytes()...)
+}
+
+// flushingConnection is a writeRecordingConnection which implements http.Flusher
+type flushingConnection struct {
+	writeRecordingConnection
+	flushes int32
+}
+
+func (f *flushingConnection) Flush() {
+	atomic.AddInt32(&f.flushes, 1)
+}
+
+// readerConnection is a Connection which reads from a bytes.Reader and discards all writes
+type readerConnection struct {
+	*ConnectionBase
+	reader *bytes.Reader
+}
+
+func (r *readerConnection) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *readerConnection) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func BenchmarkReceiveReadChunkSize(b *testing.B) {
+	const messageCount = 100
+	message := fmt.Sprintf(`{"type":1,"target":"echo","arguments":["%s"]}`+"\u001e", strings.Repeat("#", 16000))
+	data := []byte(strings.Repeat(message, messageCount))
+	for _, size := range []uint{1 << 10, 1 << 12, 1 << 15, 1 << 17} {
+		b.Run(fmt.Sprintf("%vB", size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				conn := &readerConnection{ConnectionBase: NewConnectionBase(ctx, "bench"), reader: bytes.NewReader(data)}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: log.NewNopLogger()}, size, 0, time.Minute, log.NewNopLogger(), nil)
+				received := 0
+				for result := range hubConn.Receive() {
+					if result.err != nil {
+						if !errors.Is(result.err, io.EOF) {
+							b.Fatal(result.err)
+						}
+						break
+					}
+					received++
+				}
+				if received != messageCount {
+					b.Fatalf("received %v messages, expected %v", received, messageCount)
+				}
+				cancel()
+			}
+		})
+	}
+}
+
+func BenchmarkWriteCoalescing(b *testing.B) {
+	const messageCount = 100
+	for _, delay := range []time.Duration{0, time.Millisecond} {
+		b.Run(fmt.Sprintf("delay %v", delay), func(b *testing.B) {
+			b.ReportAllocs()
+			writes := 0
+			for i := 0; i < b.N; i++ {
+				conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "bench")}
+				hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: log.NewNopLogger()}, 1<<15, 0, time.Minute, log.NewNopLogger(), nil)
+				if delay > 0 {
+					hubConn.(*defaultHubConnection).coalesceWrites(delay, 1<<15)
+				}
+				for j := 0; j < messageCount; j++ {
+					if err := hubConn.SendInvocation("", "broadcast", []interface{}{j}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := hubConn.Close("", false); err != nil {
+					b.Fatal(err)
+				}
+				writes += conn.writes()
+			}
+			b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+var _ = Describe("Connection activity", func() {
+	It("should stamp reads and writes separately and not change the stamps while idle", func(done Done) {
+		clock := newFakeClock()
+		conn := newTestingConnection()
+		hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), nil)
+		hubConn.(*defaultHubConnection).clock = clock
+		defer hubConn.Abort()
+		Expect(hubConn.LastActivity().IsZero()).To(BeTrue())
+		recv := hubConn.Receive()
+		start := clock.Now()
+		conn.ClientSend(`{"type":6}`)
+		Eventually(recv).Should(Receive())
+		Expect(hubConn.LastReadStamp()).To(Equal(start))
+		Expect(hubConn.LastWriteStamp().IsZero()).To(BeTrue())
+		Expect(hubConn.LastActivity()).To(Equal(start))
+		// Idle
+		clock.Advance(time.Minute)
+		Consistently(hubConn.LastActivity, 100*time.Millisecond).Should(Equal(start))
+		// Write
+		go func() { _, _ = conn.ClientReceive() }()
+		Expect(hubConn.Completion("1", nil, "")).To(Succeed())
+		info := hubConn.ConnectionInfo()
+		Expect(info.LastRead).To(Equal(start))
+		Expect(info.LastWritten).To(Equal(start.Add(time.Minute)))
+		Expect(info.LastActivity).To(Equal(start.Add(time.Minute)))
+		// Read again
+		clock.Advance(time.Minute)
+		conn.ClientSend(`{"type":6}`)
+		Eventually(recv).Should(Receive())
+		Expect(hubConn.LastReadStamp()).To(Equal(start.Add(2 * time.Minute)))
+		Expect(hubConn.LastWriteStamp()).To(Equal(start.Add(time.Minute)))
+		Expect(hubConn.LastActivity()).To(Equal(start.Add(2 * time.Minute)))
+		close(done)
+	}, 2.0)
+})
+
+var _ = Describe("connectionStateMachine", func() {
+	It("should pass the states in order", func() {
+		state := newConnectionStateMachine(stateConnecting)
+		for _, next := range []connectionState{stateHandshaking, stateConnected, stateClosing, stateClosed} {
+			Expect(state.transition(next)).To(Succeed())
+			Expect(state.current()).To(Equal(next))
+		}
+	})
+	It("should go to Closing from all states before", func() {
+		for _, current := range []connectionState{stateConnecting, stateHandshaking, stateConnected} {
+			Expect(newConnectionStateMachine(current).transition(stateClosing)).To(Succeed())
+		}
+	})
+	It("should reject skipped and backward transitions", func() {
+		Expect(newConnectionStateMachine(stateConnecting).transition(stateConnected)).NotTo(Succeed())
+		Expect(newConnectionStateMachine(stateHandshaking).transition(stateClosed)).NotTo(Succeed())
+		Expect(newConnectionStateMachine(stateClosed).transition(stateClosing)).NotTo(Succeed())
+		Expect(newConnectionStateMachine(stateClosing).transition(stateConnected)).To(MatchError(
+			"invalid transition of connection state from Closing to Connected"))
+	})
+	It("should accept hub messages only when Connected", func() {
+		Expect(newConnectionStateMachine(stateHandshaking).acceptMessage(0)).To(Succeed())
+		Expect(newConnectionStateMachine(stateHandshaking).acceptMessage(1)).To(MatchError(ErrProtocolViolation))
+		Expect(newConnectionStateMachine(stateConnected).acceptMessage(1)).To(Succeed())
+		Expect(newConnectionStateMachine(stateClosing).acceptMessage(1)).To(MatchError(
+			"protocol error: message of type 1 received in connection state Closing"))
+	})
 })
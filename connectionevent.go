@@ -0,0 +1,54 @@
+package signalr
+
+import "time"
+
+// ConnectionEventType is the kind of a ConnectionEvent
+type ConnectionEventType int
+
+const (
+	// ConnectionHandshook is published when the handshake with the other party has succeeded
+	ConnectionHandshook ConnectionEventType = iota + 1
+	// ConnectionConnected is published when the connection starts processing messages
+	ConnectionConnected
+	// ConnectionDisconnected is published when the connection has ended
+	ConnectionDisconnected
+	// ConnectionError is published when the connection could not be established or ended with an error
+	ConnectionError
+	// ConnectionReconnecting is published when a client tries to reconnect after the connection has ended
+	ConnectionReconnecting
+	// ConnectionReconnected is published when a client has connected again after ConnectionReconnecting
+	ConnectionReconnected
+	// ConnectionReconnectFailed is published when a client gives up reconnecting
+	ConnectionReconnectFailed
+)
+
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionHandshook:
+		return "Handshook"
+	case ConnectionConnected:
+		return "Connected"
+	case ConnectionDisconnected:
+		return "Disconnected"
+	case ConnectionError:
+		return "Error"
+	case ConnectionReconnecting:
+		return "Reconnecting"
+	case ConnectionReconnected:
+		return "Reconnected"
+	case ConnectionReconnectFailed:
+		return "ReconnectFailed"
+	default:
+		return "Unknown"
+	}
+}
+
+// ConnectionEvent describes a change in the lifecycle of a connection
+type ConnectionEvent struct {
+	Type         ConnectionEventType
+	ConnectionID string
+	// Error is only set for ConnectionError and ConnectionReconnectFailed
+	Error error
+	// Delay is only set for ConnectionReconnecting. It is the backoff delay the client has waited before reconnecting
+	Delay time.Duration
+}
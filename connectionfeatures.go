@@ -0,0 +1,31 @@
+package signalr
+
+// ConnectionFeatures describes what a connection supports, so hubs can adapt to it.
+// The features are known after the negotiation and the handshake and do not change for the connection.
+type ConnectionFeatures struct {
+	// Transport is "WebSockets" or "ServerSentEvents" for HTTP connections and empty for other connections, e.g. net.Conn
+	Transport string
+	// TransferMode is the TransferMode of the hub protocol which has been agreed in the handshake
+	TransferMode TransferMode
+	// Binary is true if the transport can carry binary messages. ServerSentEvents can only carry text
+	Binary bool
+	// StatefulReconnect is true if the ack and sequence messages of stateful reconnect are exchanged, see EnableStatefulReconnect
+	StatefulReconnect bool
+}
+
+// connectionFeatures builds the ConnectionFeatures of conn
+func connectionFeatures(conn Connection, protocol hubProtocol, statefulReconnect bool) ConnectionFeatures {
+	features := ConnectionFeatures{
+		TransferMode:      protocol.transferMode(),
+		Binary:            true,
+		StatefulReconnect: statefulReconnect,
+	}
+	switch conn.(type) {
+	case *webSocketConnection:
+		features.Transport = "WebSockets"
+	case *serverSSEConnection, *clientSSEConnection:
+		features.Transport = "ServerSentEvents"
+		features.Binary = false
+	}
+	return features
+}
@@ -0,0 +1,88 @@
+package signalr
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrProtocolViolation is the error for messages which are not allowed in the current state of the connection,
+// e.g. an invocation which is sent before the handshake
+var ErrProtocolViolation = errors.New("protocol error")
+
+// connectionState is the state of a connection of a server or client. The states are passed in this order:
+// Connecting when the connection is served, Handshaking while the handshake is processed,
+// Connected when hub messages are exchanged, Closing when the message loop ends and Closed when the connection is closed.
+// A connection which fails before it is Connected goes to Closing directly.
+type connectionState int32
+
+const (
+	stateConnecting connectionState = iota
+	stateHandshaking
+	stateConnected
+	stateClosing
+	stateClosed
+)
+
+func (s connectionState) String() string {
+	switch s {
+	case stateConnecting:
+		return "Connecting"
+	case stateHandshaking:
+		return "Handshaking"
+	case stateConnected:
+		return "Connected"
+	case stateClosing:
+		return "Closing"
+	case stateClosed:
+		return "Closed"
+	default:
+		return fmt.Sprintf("connectionState(%d)", int32(s))
+	}
+}
+
+// canTransition reports if next may follow s
+func (s connectionState) canTransition(next connectionState) bool {
+	if next == stateClosing {
+		return s < stateClosing
+	}
+	return next == s+1
+}
+
+// connectionStateMachine holds the connectionState and allows only the transitions described there
+type connectionStateMachine struct {
+	state int32
+}
+
+func newConnectionStateMachine(state connectionState) *connectionStateMachine {
+	return &connectionStateMachine{state: int32(state)}
+}
+
+func (m *connectionStateMachine) current() connectionState {
+	return connectionState(atomic.LoadInt32(&m.state))
+}
+
+// acceptMessage returns an ErrProtocolViolation if a hub message of messageType may not be received in the current state.
+// Before the connection is Connected, only the handshake (messageType 0) is accepted, after it only hub messages
+func (m *connectionStateMachine) acceptMessage(messageType int) error {
+	switch state := m.current(); {
+	case messageType != 0 && state < stateConnected:
+		return fmt.Errorf("%w: message of type %v received before the handshake", ErrProtocolViolation, messageType)
+	case messageType != 0 && state != stateConnected:
+		return fmt.Errorf("%w: message of type %v received in connection state %v", ErrProtocolViolation, messageType, state)
+	}
+	return nil
+}
+
+// transition changes the state to next. It returns an error if next may not follow the current state
+func (m *connectionStateMachine) transition(next connectionState) error {
+	for {
+		current := m.current()
+		if !current.canTransition(next) {
+			return fmt.Errorf("invalid transition of connection state from %v to %v", current, next)
+		}
+		if atomic.CompareAndSwapInt32(&m.state, int32(current), int32(next)) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package signalr
+
+// DisconnectReason describes why a connection has ended
+type DisconnectReason string
+
+const (
+	// DisconnectServerShutdown means the server has been shut down or its context has been canceled
+	DisconnectServerShutdown DisconnectReason = "server shutdown"
+	// DisconnectClient means the client has closed the connection
+	DisconnectClient DisconnectReason = "client"
+	// DisconnectTimeout means nothing has been received from the client within the Timeout
+	DisconnectTimeout DisconnectReason = "timeout"
+	// DisconnectAborted means the connection has been aborted, e.g. by HubContext.Abort or by the transport
+	DisconnectAborted DisconnectReason = "aborted"
+	// DisconnectTransportClosed means the other party has closed the transport without sending a close message,
+	// e.g. a net.Conn which returned io.EOF
+	DisconnectTransportClosed DisconnectReason = "transport closed"
+	// DisconnectError means the connection has ended because of an error, e.g. an invalid message
+	DisconnectError DisconnectReason = "error"
+)
+
+// HubDisconnectReasoner can be implemented by hubs which need to know why a connection has ended.
+// If the hub implements HubDisconnectReasoner, OnDisconnectedWithReason is called instead of OnDisconnected.
+type HubDisconnectReasoner interface {
+	OnDisconnectedWithReason(connectionID string, reason DisconnectReason)
+}
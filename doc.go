@@ -2,6 +2,7 @@
 Package signalr contains a SignalR client and a SignalR server.
 Both support the transport types Websockets and Server-Sent Events
 and the transfer formats Text (JSON) and Binary (MessagePack).
+Parties of this package can also use the non-standard Protobuf protocol, see UseHubProtocol.
 
 Basics
 
@@ -53,6 +54,7 @@ servers context is canceled.
   go server.Serve(NewNetConnection(conn))
 To server a HTTP connection, use server.MapHTTP(), which connects the server with a path in an http.ServeMux.
 The server then automatically negotiates which kind of connection (Websockets, Server-Sent Events) will be used.
+Alternatively, server.HTTPHandler() returns a http.Handler which can be mounted on any path of an existing mux.
   // build a signalr.Server using your hub
   // and any server options you may need
   server, _ := signalr.NewServer(ctx,
@@ -84,6 +86,27 @@ The server then automatically negotiates which kind of connection (Websockets, S
       log.Fatal("ListenAndServe:", err)
   }
 
+Restarts without downtime
+
+To replace a running server process, e.g. on SIGHUP, the old process passes its listener to the new process,
+which accepts new connections on it, and then drains its own connections. The connections of the old process end
+when their clients disconnect, clients which reconnect are accepted by the new process.
+  // Old process, on SIGHUP
+  file, _ := signalr.ListenerFile(listener)
+  cmd := exec.Command(os.Args[0], os.Args[1:]...)
+  cmd.ExtraFiles = []*os.File{file} // the first extra file has the file descriptor 3
+  cmd.Env = append(os.Environ(), signalr.ListenerFDEnv+"=3")
+  _ = cmd.Start()
+  _ = server.Drain(ctx) // refuses new connections and waits for the served connections to end
+  _ = httpServer.Shutdown(ctx)
+
+  // New process
+  listener, _ := signalr.InheritedListener()
+  if listener == nil {
+      listener, _ = net.Listen("tcp", address)
+  }
+  _ = httpServer.Serve(listener)
+
 Supported method signatures
 
 The SignalR protocol constrains the signature of hub or receiver methods that can be used over SignalR.
@@ -94,11 +117,38 @@ Methods with multiple return values are not generally supported, but returning o
   func (ah *AlgoHub) Sort(values []string) []string
   func (ah *AlgoHub) FindKey(value []string, dict map[int][]string) (int, error) // error on not found
   func (receiver *View) DisplayServerValue(value interface{}) // will work for every serializable value
+With the JSON protocol, parameters implementing json.Unmarshaler, like *big.Int, receive the number as sent,
+without conversion to float64. If they also implement encoding.TextUnmarshaler, numbers sent as strings are accepted.
+By default, the error is sent to the caller as second value of the result, e.g. [42, null] for (int, error).
+With the option CompletionErrors(true), a non-nil error completes the invocation with the error text and otherwise
+only the value is sent, e.g. 42. Errors implementing HubErrorDetailer always complete the invocation, also without
+CompletionErrors(true). They are sent as JSON object with the error message and the details,
+so the caller can evaluate e.g. error codes.
+Note that CompletionErrors(true) is a breaking change for callers of methods with an error result,
+as they receive other results than before.
+Methods which take a context.Context as first parameter receive a context which ends when the connection ends.
+If the caller sends an "x-timeout-ms" header with the invocation, it also ends after this time and the invocation
+is completed with the error "context deadline exceeded", see InvocationTimeoutHeader.
+With this context, long running methods can send progress to callers which ask for it with the "x-progress" header
+before they return, see ReportProgress and Client.InvokeWithProgress.
+The progress is sent as stream items with the invocation id, the result follows with the completion as usual.
+They can also attach headers to the completion, e.g. a trace id, see SetCompletionHeader. Clients read them from InvokeResult.Headers.
 Methods which return a single sending channel (<-chan), and optionally an error, are used to initiate callee side streaming.
 The caller will receive the contents of the channel as stream.
 When the returned channel is closed, the stream will be completed.
+When the returned error is not nil, the stream is completed with this error without sending any items.
+When such a method is invoked without streaming, the caller receives the first item as result.
+With InvokeChanResults(ChanResultReject), these invocations are completed with an error instead.
   // Streaming methods
   func (n *Netflix) Stream(show string, season, episode int) (<-chan []byte, error) // error on password shared
+Instead of a channel, a method can return an iterator like iter.Seq[T]. Each value passed to yield is sent as stream item
+and the stream is completed when the iterator returns. When the caller cancels the stream, yield returns false.
+For data sources which push their values by callbacks, a method can return a producer
+func(ctx context.Context, send func(T) error) error. Each value passed to send is sent as stream item and the stream
+is completed when the producer returns, with the returned error if it is not nil. When the caller cancels the stream,
+ctx is done and send returns an error.
+Streams which report recoverable errors between their items use StreamResult as item type.
+StreamError items are sent as items with an error field, a StreamFailure item completes the stream with its error.
 Methods with one or multiple receiving channels (chan<-) as parameters are used as receivers for caller side streaming.
 The caller invokes this method and pushes one or multiple streams to the callee. The method should end when all channels
 are closed. A channel is closed by the server when the assigned stream is completed.
@@ -0,0 +1,99 @@
+package signalr
+
+import "sync"
+
+// GroupBackplane is a registry of client groups which can be shared by multiple servers, e.g. to scale out a hub
+// to multiple instances. With a GroupBackplane, the members of a group can be connected to any server
+// which uses the backplane, and sending to the group reaches all of them.
+// Each server which uses the backplane calls Subscribe once with a deliver function. SendToGroup has to call
+// the deliver functions of all servers for all members of the group, SendToGroupExcept for all members which are not
+// excluded. deliver sends to the connection if it is connected to the server and ignores all other connections.
+// When a connection ends, the server which has served it calls RemoveConnection, which removes the connection
+// from all groups.
+// A backplane for multiple processes would e.g. publish SendToGroup via Redis and call deliver when
+// it receives the message from its Redis subscription.
+type GroupBackplane interface {
+	Subscribe(deliver func(connectionID string, target string, args []interface{}))
+	AddToGroup(groupName string, connectionID string)
+	RemoveFromGroup(groupName string, connectionID string)
+	RemoveConnection(connectionID string)
+	SendToGroup(groupName string, target string, args []interface{})
+	SendToGroupExcept(groupName string, excludedConnectionIDs []string, target string, args []interface{})
+}
+
+// NewInMemoryGroupBackplane creates a GroupBackplane which holds the groups in memory.
+// It can be shared by servers in the same process.
+func NewInMemoryGroupBackplane() GroupBackplane {
+	return &inMemoryGroupBackplane{groups: make(map[string]map[string]struct{})}
+}
+
+type inMemoryGroupBackplane struct {
+	mx          sync.RWMutex
+	groups      map[string]map[string]struct{}
+	subscribers []func(connectionID string, target string, args []interface{})
+}
+
+func (i *inMemoryGroupBackplane) Subscribe(deliver func(connectionID string, target string, args []interface{})) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	i.subscribers = append(i.subscribers, deliver)
+}
+
+func (i *inMemoryGroupBackplane) AddToGroup(groupName string, connectionID string) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	members, ok := i.groups[groupName]
+	if !ok {
+		members = make(map[string]struct{})
+		i.groups[groupName] = members
+	}
+	members[connectionID] = struct{}{}
+}
+
+func (i *inMemoryGroupBackplane) RemoveFromGroup(groupName string, connectionID string) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	if members, ok := i.groups[groupName]; ok {
+		delete(members, connectionID)
+		if len(members) == 0 {
+			delete(i.groups, groupName)
+		}
+	}
+}
+
+func (i *inMemoryGroupBackplane) RemoveConnection(connectionID string) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	for groupName, members := range i.groups {
+		delete(members, connectionID)
+		if len(members) == 0 {
+			delete(i.groups, groupName)
+		}
+	}
+}
+
+func (i *inMemoryGroupBackplane) SendToGroup(groupName string, target string, args []interface{}) {
+	i.SendToGroupExcept(groupName, nil, target, args)
+}
+
+func (i *inMemoryGroupBackplane) SendToGroupExcept(groupName string, excludedConnectionIDs []string, target string, args []interface{}) {
+	excluded := make(map[string]struct{}, len(excludedConnectionIDs))
+	for _, connectionID := range excludedConnectionIDs {
+		excluded[connectionID] = struct{}{}
+	}
+	i.mx.RLock()
+	members := make([]string, 0, len(i.groups[groupName]))
+	for connectionID := range i.groups[groupName] {
+		if _, ok := excluded[connectionID]; !ok {
+			members = append(members, connectionID)
+		}
+	}
+	subscribers := append([]func(string, string, []interface{}){}, i.subscribers...)
+	i.mx.RUnlock()
+	// deliver might block, so the lock is not held while delivering
+	for _, connectionID := range members {
+		for _, deliver := range subscribers {
+			deliver(connectionID, target, args)
+		}
+	}
+}
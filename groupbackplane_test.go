@@ -0,0 +1,243 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type backplaneHub struct {
+	Hub
+}
+
+func (b *backplaneHub) Join(groupName string) {
+	b.Groups().AddToGroup(groupName, b.ConnectionID())
+}
+
+func (b *backplaneHub) Leave(groupName string) {
+	b.Groups().RemoveFromGroup(groupName, b.ConnectionID())
+}
+
+func (b *backplaneHub) SendToGroup(groupName string, text string) {
+	b.Clients().Group(groupName).Send("OnCallback", text)
+}
+
+// fakeGroupBackplane records the calls of the server and delivers group sends to the connections added before
+type fakeGroupBackplane struct {
+	mx      sync.Mutex
+	calls   []string
+	members map[string]bool
+	deliver func(connectionID string, target string, args []interface{})
+}
+
+func (f *fakeGroupBackplane) Subscribe(deliver func(connectionID string, target string, args []interface{})) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.calls = append(f.calls, "Subscribe")
+	f.deliver = deliver
+}
+
+func (f *fakeGroupBackplane) AddToGroup(groupName string, connectionID string) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("AddToGroup(%v, %v)", groupName, connectionID))
+	f.members[connectionID] = true
+}
+
+func (f *fakeGroupBackplane) RemoveFromGroup(groupName string, connectionID string) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("RemoveFromGroup(%v, %v)", groupName, connectionID))
+	delete(f.members, connectionID)
+}
+
+func (f *fakeGroupBackplane) RemoveConnection(connectionID string) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("RemoveConnection(%v)", connectionID))
+	delete(f.members, connectionID)
+}
+
+func (f *fakeGroupBackplane) SendToGroup(groupName string, target string, args []interface{}) {
+	f.send(fmt.Sprintf("SendToGroup(%v, %v)", groupName, target), nil, target, args)
+}
+
+func (f *fakeGroupBackplane) SendToGroupExcept(groupName string, excludedConnectionIDs []string, target string, args []interface{}) {
+	f.send(fmt.Sprintf("SendToGroupExcept(%v, %v, %v)", groupName, excludedConnectionIDs, target), excludedConnectionIDs, target, args)
+}
+
+func (f *fakeGroupBackplane) send(call string, excludedConnectionIDs []string, target string, args []interface{}) {
+	f.mx.Lock()
+	f.calls = append(f.calls, call)
+	members := make([]string, 0)
+	for connectionID := range f.members {
+		members = append(members, connectionID)
+	}
+	f.mx.Unlock()
+members:
+	for _, connectionID := range members {
+		for _, excluded := range excludedConnectionIDs {
+			if connectionID == excluded {
+				continue members
+			}
+		}
+		f.deliver(connectionID, target, args)
+	}
+}
+
+func (f *fakeGroupBackplane) recordedCalls() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+func (b *backplaneHub) SendToOthersInGroup(groupName string, text string) {
+	b.Clients().GroupExcept(groupName, b.ConnectionID()).Send("OnCallback", text)
+}
+
+func (b *backplaneHub) SendToAll(text string) {
+	b.Clients().All().Send("OnCallback", text)
+}
+
+func (b *backplaneHub) SendToClient(connectionID string, text string) {
+	b.Clients().Client(connectionID).Send("OnCallback", text)
+}
+
+func (b *backplaneHub) SendToUser(userID string, text string) {
+	b.Clients().User(userID).Send("OnCallback", text)
+}
+
+// connectBackplaneClient connects a client with the connectionID to the server. The connection context
+// carries userID, see userConnection
+func connectBackplaneClient(server Server, connectionID string, userID string) (Client, *simpleReceiver) {
+	cliConn, srvConn := newClientServerConnections()
+	srvConn.connectionID = connectionID
+	go func() {
+		_ = server.Serve(&userConnection{
+			pipeConnection: srvConn,
+			ctx:            context.WithValue(context.Background(), userContextKey{}, userID),
+		})
+	}()
+	receiver := &simpleReceiver{ch: make(chan string, 1)}
+	client, err := NewClient(context.TODO(), WithConnection(cliConn), WithReceiver(receiver),
+		testLoggerOption(), TransferFormat("Text"))
+	Expect(err).NotTo(HaveOccurred())
+	client.Start()
+	Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+	return client, receiver
+}
+
+var _ = Describe("GroupBackplane", func() {
+	Context("InMemoryGroupBackplane", func() {
+		It("should deliver group sends to all subscribers for all members", func() {
+			backplane := NewInMemoryGroupBackplane()
+			var mx sync.Mutex
+			delivered := make([]string, 0)
+			for _, subscriber := range []string{"A", "B"} {
+				subscriber := subscriber
+				backplane.Subscribe(func(connectionID string, target string, args []interface{}) {
+					mx.Lock()
+					defer mx.Unlock()
+					delivered = append(delivered, fmt.Sprintf("%v:%v:%v%v", subscriber, connectionID, target, args))
+				})
+			}
+			backplane.AddToGroup("g", "1")
+			backplane.AddToGroup("g", "2")
+			backplane.AddToGroup("other", "3")
+			backplane.SendToGroup("g", "t", []interface{}{1})
+			Expect(delivered).To(ConsistOf("A:1:t[1]", "B:1:t[1]", "A:2:t[1]", "B:2:t[1]"))
+			delivered = delivered[:0]
+			backplane.RemoveFromGroup("g", "2")
+			backplane.SendToGroup("g", "t", []interface{}{2})
+			Expect(delivered).To(ConsistOf("A:1:t[2]", "B:1:t[2]"))
+			delivered = delivered[:0]
+			backplane.RemoveFromGroup("g", "1")
+			backplane.SendToGroup("g", "t", []interface{}{3})
+			Expect(delivered).To(BeEmpty())
+		})
+		It("should remove a removed connection from all groups", func() {
+			backplane := NewInMemoryGroupBackplane()
+			backplane.AddToGroup("g", "1")
+			backplane.AddToGroup("other", "1")
+			backplane.AddToGroup("other", "2")
+			backplane.RemoveConnection("1")
+			Expect(backplane.(*inMemoryGroupBackplane).groups).To(Equal(map[string]map[string]struct{}{
+				"other": {"2": {}},
+			}))
+		})
+		It("should not deliver group sends to excluded connections", func() {
+			backplane := NewInMemoryGroupBackplane()
+			delivered := make([]string, 0)
+			backplane.Subscribe(func(connectionID string, target string, args []interface{}) {
+				delivered = append(delivered, connectionID)
+			})
+			backplane.AddToGroup("g", "1")
+			backplane.AddToGroup("g", "2")
+			backplane.AddToGroup("g", "3")
+			backplane.SendToGroupExcept("g", []string{"1", "3"}, "t", nil)
+			Expect(delivered).To(Equal([]string{"2"}))
+		})
+		It("should route group sends across servers which share it", func(done Done) {
+			backplane := NewInMemoryGroupBackplane()
+			serverA, err := NewServer(context.TODO(), SimpleHubFactory(&backplaneHub{}), UseGroupBackplane(backplane), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			serverB, err := NewServer(context.TODO(), SimpleHubFactory(&backplaneHub{}), UseGroupBackplane(backplane), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			clientA, receiverA := connectBackplaneClient(serverA, "a", "")
+			clientB, receiverB := connectBackplaneClient(serverB, "b", "")
+			Expect((<-clientA.Invoke("Join", "g")).Error).NotTo(HaveOccurred())
+			// The sender on server B reaches the member on server A, but is not a member itself
+			Expect((<-clientB.Invoke("SendToGroup", "g", "hello")).Error).NotTo(HaveOccurred())
+			Expect(<-receiverA.ch).To(Equal("hello"))
+			Consistently(receiverB.ch, 100*time.Millisecond).ShouldNot(Receive())
+			// Excluding the sender does not exclude the other members
+			Expect((<-clientB.Invoke("Join", "g")).Error).NotTo(HaveOccurred())
+			Expect((<-clientB.Invoke("SendToOthersInGroup", "g", "others")).Error).NotTo(HaveOccurred())
+			Expect(<-receiverA.ch).To(Equal("others"))
+			Consistently(receiverB.ch, 100*time.Millisecond).ShouldNot(Receive())
+			Expect((<-clientB.Invoke("Leave", "g")).Error).NotTo(HaveOccurred())
+			Expect((<-clientA.Invoke("Leave", "g")).Error).NotTo(HaveOccurred())
+			Expect((<-clientB.Invoke("SendToGroup", "g", "again")).Error).NotTo(HaveOccurred())
+			Consistently(receiverA.ch, 100*time.Millisecond).ShouldNot(Receive())
+			serverA.cancel()
+			serverB.cancel()
+			close(done)
+		}, 3.0)
+	})
+	Context("with a fake backplane", func() {
+		It("should use the backplane for all group operations", func(done Done) {
+			backplane := &fakeGroupBackplane{members: make(map[string]bool)}
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&backplaneHub{}), UseGroupBackplane(backplane), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			client, receiver := connectBackplaneClient(server, "fake", "")
+			Expect((<-client.Invoke("Join", "g")).Error).NotTo(HaveOccurred())
+			Expect((<-client.Invoke("SendToGroup", "g", "hello")).Error).NotTo(HaveOccurred())
+			Expect(<-receiver.ch).To(Equal("hello"))
+			Expect((<-client.Invoke("Leave", "g")).Error).NotTo(HaveOccurred())
+			Expect(backplane.recordedCalls()).To(Equal([]string{
+				"Subscribe",
+				"AddToGroup(g, fake)",
+				"SendToGroup(g, OnCallback)",
+				"RemoveFromGroup(g, fake)",
+			}))
+			Expect(server.HubClients().Group("g").SendWithAck(AckPolicy{Timeout: time.Second}, "OnCallback", "ack")).To(HaveOccurred())
+			server.cancel()
+			close(done)
+		}, 3.0)
+		It("should remove the connection from the backplane when it ends", func(done Done) {
+			backplane := &fakeGroupBackplane{members: make(map[string]bool)}
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&backplaneHub{}), UseGroupBackplane(backplane), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			client, _ := connectBackplaneClient(server, "ending", "")
+			Expect((<-client.Invoke("Join", "g")).Error).NotTo(HaveOccurred())
+			hubConnectionOf(server, "ending").Abort()
+			Eventually(backplane.recordedCalls, time.Second).Should(ContainElement("RemoveConnection(ending)"))
+			server.cancel()
+			close(done)
+		}, 3.0)
+	})
+})
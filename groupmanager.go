@@ -1,9 +1,14 @@
 package signalr
 
-// GroupManager manages the client groups of the hub
+// GroupManager manages the client groups and the connection tags of the hub.
+// Tags are key/value pairs set on a connection, e.g. region=eu. The connections with a tag
+// can be invoked with HubClients.Where(). A connection has at most one value per tag key.
+// Tags are removed when the connection is disconnected.
 type GroupManager interface {
 	AddToGroup(groupName string, connectionID string)
 	RemoveFromGroup(groupName string, connectionID string)
+	SetTag(connectionID string, key string, value string)
+	RemoveTag(connectionID string, key string)
 }
 
 type defaultGroupManager struct {
@@ -17,3 +22,11 @@ func (d *defaultGroupManager) AddToGroup(groupName string, connectionID string)
 func (d *defaultGroupManager) RemoveFromGroup(groupName string, connectionID string) {
 	d.lifetimeManager.RemoveFromGroup(groupName, connectionID)
 }
+
+func (d *defaultGroupManager) SetTag(connectionID string, key string, value string) {
+	d.lifetimeManager.SetTag(connectionID, key, value)
+}
+
+func (d *defaultGroupManager) RemoveTag(connectionID string, key string) {
+	d.lifetimeManager.RemoveTag(connectionID, key)
+}
@@ -0,0 +1,40 @@
+package signalr
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// ListenerFDEnv is the environment variable which passes the file descriptor of the listener
+// from the old to the new server process, see InheritedListener
+const ListenerFDEnv = "SIGNALR_LISTENER_FD"
+
+// ListenerFile returns a duplicate of the file descriptor of listener, which can be passed to a new server process.
+// listener has to be a *net.TCPListener or a *net.UnixListener.
+func ListenerFile(listener net.Listener) (*os.File, error) {
+	filer, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %T has no file descriptor", listener)
+	}
+	return filer.File()
+}
+
+// InheritedListener returns the listener which has been passed by the old server process with ListenerFile
+// and ListenerFDEnv. If ListenerFDEnv is not set, the process has not inherited a listener and InheritedListener
+// returns nil without error.
+func InheritedListener() (net.Listener, error) {
+	fdText := os.Getenv(ListenerFDEnv)
+	if fdText == "" {
+		return nil, nil
+	}
+	fd, err := strconv.Atoi(fdText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %q: %w", ListenerFDEnv, fdText, err)
+	}
+	file := os.NewFile(uintptr(fd), "signalr listener")
+	// net.FileListener duplicates the file descriptor
+	defer func() { _ = file.Close() }()
+	return net.FileListener(file)
+}
@@ -0,0 +1,31 @@
+package signalr
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// HealthHandler returns a http.Handler which reports if the server accepts new connections, e.g. for the liveness and
+// readiness probes of Kubernetes. It responds with 200 OK and "serving" while the server accepts connections and with
+// 503 Service Unavailable and "draining" or "shut down" after Drain or Shutdown.
+// Like StatsHandler, the handler is not mapped by MapHTTP.
+func (s *server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		status, text := http.StatusOK, "serving"
+		switch {
+		case s.context().Err() != nil:
+			status, text = http.StatusServiceUnavailable, "shut down"
+		case atomic.LoadInt32(&s.draining) == 1:
+			status, text = http.StatusServiceUnavailable, "draining"
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(text))
+		}
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"nhooyr.io/websocket"
 )
@@ -17,10 +18,14 @@ type Doer interface {
 }
 
 type httpConnection struct {
-	client  Doer
-	headers func() http.Header
+	client      Doer
+	headers     func() http.Header
+	accessToken string
 }
 
+// maxNegotiateRedirects is the maximum number of redirect negotiate responses a client follows
+const maxNegotiateRedirects = 100
+
 // WithHTTPClient sets the http client used to connect to the signalR server
 func WithHTTPClient(client Doer) func(*httpConnection) error {
 	return func(c *httpConnection) error {
@@ -55,33 +60,21 @@ func NewHTTPConnection(ctx context.Context, address string, options ...func(*htt
 		httpConn.client = &http.Client{}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/negotiate", address), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	if httpConn.headers != nil {
-		req.Header = httpConn.headers()
-	}
-
-	resp, err := httpConn.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%v %v -> %v", req.Method, req.URL.String(), resp.Status)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	nr := negotiateResponse{}
-	if err := json.Unmarshal(body, &nr); err != nil {
-		return nil, err
+	var nr NegotiateResponse
+	var err error
+	// Follow redirects from the negotiate responses, but not endlessly
+	for redirects := 0; ; redirects++ {
+		if nr, err = httpConn.negotiate(ctx, address); err != nil {
+			return nil, err
+		}
+		if !nr.IsRedirect() {
+			break
+		}
+		if redirects == maxNegotiateRedirects {
+			return nil, fmt.Errorf("negotiate redirect limit of %v exceeded", maxNegotiateRedirects)
+		}
+		address = nr.URL
+		httpConn.accessToken = nr.AccessToken
 	}
 
 	reqURL, err := url.Parse(address)
@@ -109,9 +102,8 @@ func NewHTTPConnection(ctx context.Context, address string, options ...func(*htt
 			wsURL.Scheme = "ws"
 		}
 
-		opts := &websocket.DialOptions{}
-		if httpConn.headers != nil {
-			opts.HTTPHeader = httpConn.headers()
+		opts := &websocket.DialOptions{
+			HTTPHeader: httpConn.requestHeader(),
 		}
 
 		ws, _, err := websocket.Dial(ctx, wsURL.String(), opts)
@@ -127,9 +119,7 @@ func NewHTTPConnection(ctx context.Context, address string, options ...func(*htt
 			return nil, err
 		}
 
-		if httpConn.headers != nil {
-			req.Header = httpConn.headers()
-		}
+		req.Header = httpConn.requestHeader()
 		req.Header.Set("Accept", "text/event-stream")
 
 		resp, err := httpConn.client.Do(req)
@@ -145,3 +135,49 @@ func NewHTTPConnection(ctx context.Context, address string, options ...func(*htt
 
 	return conn, nil
 }
+
+func (h *httpConnection) negotiate(ctx context.Context, address string) (NegotiateResponse, error) {
+	nr := NegotiateResponse{}
+	negotiateURL, err := url.Parse(address)
+	if err != nil {
+		return nr, err
+	}
+	negotiateURL.Path = strings.TrimSuffix(negotiateURL.Path, "/") + "/negotiate"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", negotiateURL.String(), nil)
+	if err != nil {
+		return nr, err
+	}
+
+	req.Header = h.requestHeader()
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nr, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != 200 {
+		return nr, fmt.Errorf("%v %v -> %v", req.Method, req.URL.String(), resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nr, err
+	}
+
+	err = json.Unmarshal(body, &nr)
+	return nr, err
+}
+
+// requestHeader builds the header for HTTP and websocket requests
+func (h *httpConnection) requestHeader() http.Header {
+	header := http.Header{}
+	if h.headers != nil {
+		header = h.headers()
+	}
+	if h.accessToken != "" {
+		header.Set("Authorization", fmt.Sprintf("Bearer %s", h.accessToken))
+	}
+	return header
+}
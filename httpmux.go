@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -73,6 +74,9 @@ func (h *httpMux) handlePost(writer http.ResponseWriter, request *http.Request)
 }
 
 func (h *httpMux) handleGet(writer http.ResponseWriter, request *http.Request) {
+	if h.refuseWhenConnectionLimitReached(writer) {
+		return
+	}
 	upgrade := false
 	for _, connHead := range strings.Split(request.Header.Get("Connection"), ",") {
 		if strings.ToLower(strings.TrimSpace(connHead)) == "upgrade" {
@@ -125,7 +129,9 @@ func (h *httpMux) handleServerSentEvent(writer http.ResponseWriter, request *htt
 				// We can't WriteHeader 500 if we get an error as we already wrote the header, so ignore it.
 				_ = h.serveConnection(sseConn)
 			}()
-			// Loop for write jobs from the sseServerConnection
+			// Loop for write jobs from the sseServerConnection.
+			// Each job holds complete messages and is flushed immediately, so stream items
+			// are delivered as soon as they are written. With WriteCoalescing, see StreamResult.Flushed.
 			for buf := range jobChan {
 				n, err := writer.Write(buf)
 				if err == nil {
@@ -149,6 +155,17 @@ func (h *httpMux) handleWebsocket(writer http.ResponseWriter, request *http.Requ
 		InsecureSkipVerify: h.server.insecureSkipVerify(),
 		OriginPatterns:     h.server.originPatterns(),
 	}
+	if allowed := h.server.originAllowed(); allowed != nil {
+		if origin := request.Header.Get("Origin"); origin != "" && !allowed(origin) {
+			_, debug := h.server.loggers()
+			_ = debug.Log(evt, "handleWebsocket", msg, "origin not allowed", "origin", origin)
+			http.Error(writer, fmt.Sprintf("origin %v not allowed", origin), http.StatusForbidden)
+			return
+		}
+		// The origin has been checked already
+		accOptions.InsecureSkipVerify = true
+		accOptions.OriginPatterns = nil
+	}
 	websocketConn, err := websocket.Accept(writer, request, accOptions)
 	if err != nil {
 		_, debug := h.server.loggers()
@@ -191,6 +208,9 @@ func (h *httpMux) negotiate(w http.ResponseWriter, req *http.Request) {
 	if req.Method != "POST" {
 		w.WriteHeader(http.StatusBadRequest)
 	} else {
+		if h.refuseWhenConnectionLimitReached(w) {
+			return
+		}
 		connectionID := newConnectionID()
 		connectionMapKey := connectionID
 		negotiateVersion, err := strconv.Atoi(req.Header.Get("negotiateVersion"))
@@ -207,28 +227,37 @@ func (h *httpMux) negotiate(w http.ResponseWriter, req *http.Request) {
 			ConnectionBase{connectionID: connectionID},
 		}
 		h.mx.Unlock()
-		var availableTransports []availableTransport
+		var availableTransports []AvailableTransport
 		for _, transport := range h.server.availableTransports() {
 			switch transport {
 			case "ServerSentEvents":
 				availableTransports = append(availableTransports,
-					availableTransport{
+					AvailableTransport{
 						Transport:       "ServerSentEvents",
 						TransferFormats: []string{"Text"},
 					})
 			case "WebSockets":
 				availableTransports = append(availableTransports,
-					availableTransport{
+					AvailableTransport{
 						Transport:       "WebSockets",
 						TransferFormats: []string{"Text", "Binary"},
 					})
 			}
 		}
-		response := negotiateResponse{
-			ConnectionToken:     connectionToken,
-			ConnectionID:        connectionID,
-			NegotiateVersion:    negotiateVersion,
-			AvailableTransports: availableTransports,
+		response := NegotiateResponse{
+			ConnectionToken:                 connectionToken,
+			ConnectionID:                    connectionID,
+			NegotiateVersion:                negotiateVersion,
+			AvailableTransports:             availableTransports,
+			KeepAliveIntervalInMilliseconds: h.server.keepAliveInterval().Milliseconds(),
+			TimeoutInMilliseconds:           h.server.timeout().Milliseconds(),
+		}
+		h.server.onNegotiate(req, &response)
+		if response.IsRedirect() {
+			// The client will connect to another server
+			h.mx.Lock()
+			delete(h.connectionMap, connectionMapKey)
+			h.mx.Unlock()
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -236,6 +265,18 @@ func (h *httpMux) negotiate(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// refuseWhenConnectionLimitReached writes 503 Service Unavailable with a Retry-After header
+// when the server already serves the maximum number of connections
+func (h *httpMux) refuseWhenConnectionLimitReached(w http.ResponseWriter) bool {
+	reached, retryAfter := h.server.connectionLimitReached()
+	if !reached {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return true
+}
+
 func (h *httpMux) serveConnection(c Connection) error {
 	h.mx.Lock()
 	h.connectionMap[c.ConnectionID()] = c
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strconv"
 
 	"strings"
@@ -33,6 +34,60 @@ func (w *addHub) Echo(s string) string {
 	return s
 }
 
+func (w *addHub) Features() ConnectionFeatures {
+	return w.ConnectionFeatures()
+}
+
+type numberKindHub struct {
+	Hub
+}
+
+func (n *numberKindHub) Kind(value interface{}) string {
+	return fmt.Sprintf("%T", value)
+}
+
+var tickRelease = make(chan struct{})
+
+type tickHub struct {
+	Hub
+}
+
+func (t *tickHub) Ticks() <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		ch <- 1
+		select {
+		case <-tickRelease:
+			ch <- 2
+		case <-t.Context().Done():
+		}
+	}()
+	return ch
+}
+
+var flushRelease = make(chan struct{})
+
+type flushHub struct {
+	Hub
+}
+
+func (f *flushHub) Results() <-chan StreamResult {
+	ch := make(chan StreamResult)
+	go func() {
+		defer close(ch)
+		ch <- StreamValue(1)
+		select {
+		case <-flushRelease:
+			ch <- StreamValue(2).Flushed()
+		case <-f.Context().Done():
+			return
+		}
+		<-f.Context().Done()
+	}()
+	return ch
+}
+
 var _ = Describe("HTTP server", func() {
 	for _, transport := range [][]string{
 		{"WebSockets", "Text"},
@@ -149,6 +204,355 @@ var _ = Describe("HTTP server", func() {
 			})
 		})
 	}
+	Context("When the HTTPHandler is mounted on a http.ServeMux", func() {
+		for _, transport := range [][]string{
+			{"WebSockets", "Text"},
+			{"ServerSentEvents", "Text"},
+		} {
+			transport := transport
+			It(fmt.Sprintf("should serve the hub relative to the mount path with %v", transport[0]), func(done Done) {
+				ctx, cancel := context.WithCancel(context.Background())
+				server, err := NewServer(ctx, SimpleHubFactory(&addHub{}), HTTPTransports(transport[0]), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				router := http.NewServeMux()
+				router.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte("ok"))
+				})
+				handler := server.HTTPHandler()
+				router.Handle("/hubs/chat", handler)
+				router.Handle("/hubs/chat/", handler)
+				testServer := httptest.NewServer(router)
+				conn, err := NewHTTPConnection(ctx, fmt.Sprintf("%v/hubs/chat", testServer.URL))
+				Expect(err).NotTo(HaveOccurred())
+				client, err := NewClient(ctx, WithConnection(conn), testLoggerOption(), TransferFormat(transport[1]))
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+				result := <-client.Invoke("Add2", 1)
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(result.Value).To(BeEquivalentTo(3))
+				resp, err := http.Get(fmt.Sprintf("%v/api/status", testServer.URL))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				_ = resp.Body.Close()
+				cancel()
+				go testServer.Close()
+				close(done)
+			}, 2.0)
+		}
+		It("should serve the hub when mounted with http.StripPrefix", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			server, err := NewServer(ctx, SimpleHubFactory(&addHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			router.Handle("/hubs/chat/", http.StripPrefix("/hubs/chat", server.HTTPHandler()))
+			testServer := httptest.NewServer(router)
+			resp, err := http.Post(fmt.Sprintf("%v/hubs/chat/negotiate", testServer.URL), "text/plain", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			negResp := make(map[string]interface{})
+			Expect(json.NewDecoder(resp.Body).Decode(&negResp)).To(Succeed())
+			_ = resp.Body.Close()
+			Expect(negResp["connectionId"]).NotTo(BeEmpty())
+			cancel()
+			testServer.Close()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When the hub reads the features of its connection", func() {
+		It("should get different features for WebSockets and ServerSentEvents", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			router := http.NewServeMux()
+			for _, transport := range []string{"WebSockets", "ServerSentEvents"} {
+				server, err := NewServer(ctx, SimpleHubFactory(&addHub{}), HTTPTransports(transport), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				server.MapHTTP(WithHTTPServeMux(router), "/"+transport)
+			}
+			testServer := httptest.NewServer(router)
+			features := make(map[string]ConnectionFeatures)
+			for _, transport := range []string{"WebSockets", "ServerSentEvents"} {
+				conn, err := NewHTTPConnection(ctx, fmt.Sprintf("%v/%v", testServer.URL, transport))
+				Expect(err).NotTo(HaveOccurred())
+				client, err := NewClient(ctx, WithConnection(conn), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				result := <-client.InvokeTyped(reflect.TypeOf(ConnectionFeatures{}), "Features")
+				Expect(result.Error).NotTo(HaveOccurred())
+				features[transport] = result.Value.(ConnectionFeatures)
+			}
+			Expect(features["WebSockets"]).To(Equal(ConnectionFeatures{Transport: "WebSockets", TransferMode: TextTransferMode, Binary: true}))
+			Expect(features["ServerSentEvents"]).To(Equal(ConnectionFeatures{Transport: "ServerSentEvents", TransferMode: TextTransferMode}))
+			cancel()
+			go testServer.Close()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When two hubs with different JSON options are mapped on the same http.ServeMux", func() {
+		It("should decode the arguments of each hub with its own options", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			floatServer, err := NewServer(ctx, SimpleHubFactory(&numberKindHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			numberServer, err := NewServer(ctx, SimpleHubFactory(&numberKindHub{}), testLoggerOption(), UseJSONNumber(true))
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			floatServer.MapHTTP(WithHTTPServeMux(router), "/float")
+			numberServer.MapHTTP(WithHTTPServeMux(router), "/number")
+			testServer := httptest.NewServer(router)
+			for path, kind := range map[string]string{"float": "float64", "number": "json.Number"} {
+				conn, err := NewHTTPConnection(ctx, fmt.Sprintf("%v/%v", testServer.URL, path))
+				Expect(err).NotTo(HaveOccurred())
+				client, err := NewClient(ctx, WithConnection(conn), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				result := <-client.Invoke("Kind", 1)
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(result.Value).To(Equal(kind))
+			}
+			cancel()
+			go testServer.Close()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When KeepAliveInterval and TimeoutInterval are configured", func() {
+		It("should send them in the negotiate response", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&addHub{}), testLoggerOption(),
+				KeepAliveInterval(3*time.Second), TimeoutInterval(7500*time.Millisecond))
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			server.MapHTTP(WithHTTPServeMux(router), "/hub")
+			testServer := httptest.NewServer(router)
+			url, _ := url.Parse(testServer.URL)
+			port, _ := strconv.Atoi(url.Port())
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp["keepAliveIntervalInMilliseconds"]).To(BeEquivalentTo(3000))
+			Expect(negResp["timeoutInMilliseconds"]).To(BeEquivalentTo(7500))
+			testServer.Close()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When OnNegotiate is used", func() {
+		It("should send the custom fields set by the hook", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&addHub{}), testLoggerOption(),
+				OnNegotiate(func(req *http.Request, resp *NegotiateResponse) {
+					resp.Extra = map[string]interface{}{"region": "eu", "connectionId": "overwritten"}
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			server.MapHTTP(WithHTTPServeMux(router), "/hub")
+			testServer := httptest.NewServer(router)
+			url, _ := url.Parse(testServer.URL)
+			port, _ := strconv.Atoi(url.Port())
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp["region"]).To(Equal("eu"))
+			Expect(negResp["connectionId"]).NotTo(Equal("overwritten"))
+			Expect(negResp["availableTransports"]).NotTo(BeNil())
+			testServer.Close()
+			close(done)
+		}, 2.0)
+		It("should redirect the client to another server", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			// The server the client is redirected to
+			authorization := make(chan string, 1)
+			targetServer, err := NewServer(ctx, SimpleHubFactory(&addHub{}), testLoggerOption(),
+				OnNegotiate(func(req *http.Request, resp *NegotiateResponse) {
+					authorization <- req.Header.Get("Authorization")
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			targetRouter := http.NewServeMux()
+			targetServer.MapHTTP(WithHTTPServeMux(targetRouter), "/target")
+			targetTestServer := httptest.NewServer(targetRouter)
+			// The server which redirects
+			redirectServer, err := NewServer(ctx, SimpleHubFactory(&addHub{}), testLoggerOption(),
+				OnNegotiate(func(req *http.Request, resp *NegotiateResponse) {
+					resp.URL = fmt.Sprintf("%s/target", targetTestServer.URL)
+					resp.AccessToken = "secret"
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			redirectRouter := http.NewServeMux()
+			redirectServer.MapHTTP(WithHTTPServeMux(redirectRouter), "/hub")
+			redirectTestServer := httptest.NewServer(redirectRouter)
+			url, _ := url.Parse(redirectTestServer.URL)
+			port, _ := strconv.Atoi(url.Port())
+			// The redirect response contains only url and accessToken
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp).To(Equal(map[string]interface{}{
+				"url":         fmt.Sprintf("%s/target", targetTestServer.URL),
+				"accessToken": "secret",
+			}))
+			conn, err := NewHTTPConnection(ctx, fmt.Sprintf("%s/hub", redirectTestServer.URL))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(<-authorization).To(Equal("Bearer secret"))
+			client, err := NewClient(ctx, WithConnection(conn), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			result := <-client.Invoke("Add2", 1)
+			Expect(result.Error).NotTo(HaveOccurred())
+			Expect(result.Value).To(BeEquivalentTo(3))
+			cancel()
+			go redirectTestServer.Close()
+			go targetTestServer.Close()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When a stream is pulled over ServerSentEvents", func() {
+		It("should deliver each item without waiting for the following items", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			server, err := NewServer(ctx, SimpleHubFactory(&tickHub{}), HTTPTransports("ServerSentEvents"), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			server.MapHTTP(WithHTTPServeMux(router), "/hub")
+			testServer := httptest.NewServer(router)
+			url, _ := url.Parse(testServer.URL)
+			port, _ := strconv.Atoi(url.Port())
+			waitForPort(port)
+			conn, err := NewHTTPConnection(ctx, fmt.Sprintf("http://127.0.0.1:%v/hub", port))
+			Expect(err).NotTo(HaveOccurred())
+			client, err := NewClient(ctx, WithConnection(conn), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			Expect(<-client.WaitForState(ctx, ClientConnected)).NotTo(HaveOccurred())
+			ch := client.PullStream("Ticks")
+			// The second tick is only sent after the first one has been received
+			var result InvokeResult
+			Eventually(ch, 500*time.Millisecond).Should(Receive(&result))
+			Expect(result.Error).NotTo(HaveOccurred())
+			Expect(result.Value).To(BeEquivalentTo(1))
+			tickRelease <- struct{}{}
+			Eventually(ch, 500*time.Millisecond).Should(Receive(&result))
+			Expect(result.Value).To(BeEquivalentTo(2))
+			cancel()
+			go testServer.Close()
+			close(done)
+		}, 3.0)
+		It("should deliver the items collected by WriteCoalescing when a flushed item is sent", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			server, err := NewServer(ctx, SimpleHubFactory(&flushHub{}), HTTPTransports("ServerSentEvents"),
+				WriteCoalescing(time.Minute, 1<<20), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			server.MapHTTP(WithHTTPServeMux(router), "/hub")
+			testServer := httptest.NewServer(router)
+			url, _ := url.Parse(testServer.URL)
+			port, _ := strconv.Atoi(url.Port())
+			waitForPort(port)
+			conn, err := NewHTTPConnection(ctx, fmt.Sprintf("http://127.0.0.1:%v/hub", port))
+			Expect(err).NotTo(HaveOccurred())
+			client, err := NewClient(ctx, WithConnection(conn), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			Expect(<-client.WaitForState(ctx, ClientConnected)).NotTo(HaveOccurred())
+			ch := client.PullStreamTyped(reflect.TypeOf(StreamResult{}), "Results")
+			// The first item is collected, but not written
+			Consistently(ch, 200*time.Millisecond).ShouldNot(Receive())
+			flushRelease <- struct{}{}
+			// The flushed item is written together with the collected one
+			var result InvokeResult
+			Eventually(ch, 500*time.Millisecond).Should(Receive(&result))
+			Expect(result.Value.(StreamResult).Value).To(BeEquivalentTo(1))
+			Eventually(ch, 500*time.Millisecond).Should(Receive(&result))
+			Expect(result.Value.(StreamResult).Value).To(BeEquivalentTo(2))
+			cancel()
+			go testServer.Close()
+			close(done)
+		}, 3.0)
+	})
+
+	Context("When a protocol is negotiated over WebSockets", func() {
+		for _, p := range []struct {
+			name        string
+			protocol    hubProtocol
+			messageType websocket.MessageType
+		}{
+			{"json", &jsonHubProtocol{}, websocket.MessageText},
+			{"messagepack", &messagePackHubProtocol{}, websocket.MessageBinary},
+		} {
+			p := p
+			It(fmt.Sprintf("should send %v messages as %v frames", p.name, p.messageType), func(done Done) {
+				p.protocol.setDebugLogger(testLogger())
+				server, err := NewServer(context.TODO(), SimpleHubFactory(&addHub{}), HTTPTransports("WebSockets"), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				router := http.NewServeMux()
+				server.MapHTTP(WithHTTPServeMux(router), "/hub")
+				testServer := httptest.NewServer(router)
+				url, _ := url.Parse(testServer.URL)
+				port, _ := strconv.Atoi(url.Port())
+				waitForPort(port)
+				ws, _, err := websocket.Dial(context.Background(), fmt.Sprintf("ws://127.0.0.1:%v/hub", port), nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ws.Write(context.Background(), websocket.MessageText,
+					[]byte(fmt.Sprintf(`{"protocol": "%v","version": 1}`+"\u001e", p.name)))).To(Succeed())
+				// The handshake response is always JSON
+				_, handshakeResponse, err := ws.Read(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(handshakeResponse)).To(Equal("{}\u001e"))
+				buf := bytes.Buffer{}
+				Expect(p.protocol.WriteMessage(invocationMessage{
+					Type:         1,
+					InvocationID: "1",
+					Target:       "add2",
+					Arguments:    []interface{}{1},
+				}, &buf)).To(Succeed())
+				Expect(ws.Write(context.Background(), p.messageType, buf.Bytes())).To(Succeed())
+				messageType, data, err := ws.Read(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messageType).To(Equal(p.messageType))
+				messages, err := p.protocol.ParseMessages(bytes.NewReader(data), &bytes.Buffer{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messages).To(HaveLen(1))
+				Expect(messages[0]).To(BeAssignableToTypeOf(completionMessage{}))
+				_ = ws.Close(websocket.StatusNormalClosure, "")
+				server.cancel()
+				testServer.Close()
+				close(done)
+			}, 2.0)
+		}
+	})
+
+	Context("When a WebSocket upgrade request has an Origin header", func() {
+		dialWithOrigin := func(options []func(Party) error, origin func(port int) string) int {
+			server, err := NewServer(context.TODO(), append(options, SimpleHubFactory(&addHub{}), HTTPTransports("WebSockets"), testLoggerOption())...)
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			server.MapHTTP(WithHTTPServeMux(router), "/hub")
+			testServer := httptest.NewServer(router)
+			defer testServer.Close()
+			url, _ := url.Parse(testServer.URL)
+			port, _ := strconv.Atoi(url.Port())
+			waitForPort(port)
+			ws, resp, err := websocket.Dial(context.Background(), fmt.Sprintf("ws://127.0.0.1:%v/hub", port),
+				&websocket.DialOptions{HTTPHeader: http.Header{"Origin": []string{origin(port)}}})
+			if err == nil {
+				_ = ws.Close(websocket.StatusNormalClosure, "")
+			}
+			return resp.StatusCode
+		}
+		sameOrigin := func(port int) string { return fmt.Sprintf("http://127.0.0.1:%v", port) }
+		otherOrigin := func(int) string { return "https://evil.example" }
+		goodOrigin := func(int) string { return "https://app.good.example" }
+		allowGood := AllowedOrigins(func(origin string) bool { return strings.HasSuffix(origin, ".good.example") })
+		It("should allow the same origin by default", func(done Done) {
+			Expect(dialWithOrigin(nil, sameOrigin)).To(Equal(http.StatusSwitchingProtocols))
+			close(done)
+		}, 5.0)
+		It("should reject other origins by default", func(done Done) {
+			Expect(dialWithOrigin(nil, otherOrigin)).To(Equal(http.StatusForbidden))
+			close(done)
+		}, 5.0)
+		It("should allow origins allowed by AllowedOrigins", func(done Done) {
+			Expect(dialWithOrigin([]func(Party) error{allowGood}, goodOrigin)).To(Equal(http.StatusSwitchingProtocols))
+			close(done)
+		}, 5.0)
+		It("should reject origins not allowed by AllowedOrigins with 403", func(done Done) {
+			Expect(dialWithOrigin([]func(Party) error{allowGood}, otherOrigin)).To(Equal(http.StatusForbidden))
+			close(done)
+		}, 5.0)
+	})
+
 	Context("When no negotiation is send", func() {
 		It("should serve websocket requests", func(done Done) {
 			// Start server
@@ -213,7 +617,7 @@ func handShakeAndCallWebSocketTestServer(port int, connectionID string) {
 		_ = ws.Close(websocket.StatusNormalClosure, "")
 	}()
 	wsConn := newWebSocketConnection(context.TODO(), connectionID, ws)
-	cliConn := newHubConnection(wsConn, &protocol, 1<<15, testLogger())
+	cliConn := newHubConnection(wsConn, &protocol, 1<<15, 0, 5*time.Second, testLogger(), nil)
 	_, _ = wsConn.Write(append([]byte(`{"protocol": "json","version": 1}`), 30))
 	_, _ = wsConn.Write(append([]byte(`{"type":1,"invocationId":"666","target":"add2","arguments":[1]}`), 30))
 	result := make(chan interface{})
@@ -53,6 +53,27 @@ func (h *Hub) ConnectionID() string {
 	return h.context.ConnectionID()
 }
 
+// UserID gets the user of the current connection, see UserIdentifier
+func (h *Hub) UserID() string {
+	h.cm.RLock()
+	defer h.cm.RUnlock()
+	return h.context.UserID()
+}
+
+// ConnectionInfo gets the state of the current connection
+func (h *Hub) ConnectionInfo() ConnectionInfo {
+	h.cm.RLock()
+	defer h.cm.RUnlock()
+	return h.context.ConnectionInfo()
+}
+
+// ConnectionFeatures gets what the current connection supports
+func (h *Hub) ConnectionFeatures() ConnectionFeatures {
+	h.cm.RLock()
+	defer h.cm.RUnlock()
+	return h.context.ConnectionFeatures()
+}
+
 // Context is the context.Context of the current connection
 func (h *Hub) Context() context.Context {
 	h.cm.RLock()
@@ -67,6 +88,27 @@ func (h *Hub) Abort() {
 	h.context.Abort()
 }
 
+// SendMessage sends a message built by the application to the current connection, see Message
+func (h *Hub) SendMessage(message Message) error {
+	h.cm.RLock()
+	defer h.cm.RUnlock()
+	return h.context.SendMessage(message)
+}
+
+// PauseReceive stops dispatching the invocations of the current connection until ResumeReceive is called
+func (h *Hub) PauseReceive() {
+	h.cm.RLock()
+	defer h.cm.RUnlock()
+	h.context.PauseReceive()
+}
+
+// ResumeReceive dispatches the invocations queued while paused and resumes dispatching new invocations
+func (h *Hub) ResumeReceive() {
+	h.cm.RLock()
+	defer h.cm.RUnlock()
+	h.context.ResumeReceive()
+}
+
 // Logger returns the loggers used in this server. By this, derived hubs can use the same loggers as the server.
 func (h *Hub) Logger() (info StructuredLogger, dbg StructuredLogger) {
 	h.cm.RLock()
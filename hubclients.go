@@ -5,11 +5,18 @@ package signalr
 // Caller() gets a ClientProxy that can be used to invoke methods of the current calling client
 // Client() gets a ClientProxy that can be used to invoke methods on the specified client connection
 // Group() gets a ClientProxy that can be used to invoke methods on all connections in the specified group
+// GroupExcept() gets a ClientProxy that can be used to invoke methods on all connections in the specified group,
+// except the specified connections, e.g. to notify all members of a group but the caller
+// Where() gets a ClientProxy that can be used to invoke methods on all connections with the specified tag value
+// User() gets a ClientProxy that can be used to invoke methods on all connections of the specified user, see UserIdentifier
 type HubClients interface {
 	All() ClientProxy
 	Caller() ClientProxy
 	Client(connectionID string) ClientProxy
 	Group(groupName string) ClientProxy
+	GroupExcept(groupName string, excludedConnectionIDs ...string) ClientProxy
+	Where(key string, value string) ClientProxy
+	User(userID string) ClientProxy
 }
 
 type defaultHubClients struct {
@@ -29,6 +36,18 @@ func (c *defaultHubClients) Group(groupName string) ClientProxy {
 	return &groupClientProxy{groupName: groupName, lifetimeManager: c.lifetimeManager}
 }
 
+func (c *defaultHubClients) GroupExcept(groupName string, excludedConnectionIDs ...string) ClientProxy {
+	return &groupExceptClientProxy{groupName: groupName, excludedConnectionIDs: excludedConnectionIDs, lifetimeManager: c.lifetimeManager}
+}
+
+func (c *defaultHubClients) Where(key string, value string) ClientProxy {
+	return &taggedClientProxy{key: key, value: value, lifetimeManager: c.lifetimeManager}
+}
+
+func (c *defaultHubClients) User(userID string) ClientProxy {
+	return &userClientProxy{userID: userID, lifetimeManager: c.lifetimeManager}
+}
+
 // Caller is only implemented to fulfill the HubClients interface, so the servers defaultHubClients interface can be
 // used for implementing Server.HubClients.
 func (c *defaultHubClients) Caller() ClientProxy {
@@ -55,3 +74,15 @@ func (c *callerHubClients) Client(connectionID string) ClientProxy {
 func (c *callerHubClients) Group(groupName string) ClientProxy {
 	return c.defaultHubClients.Group(groupName)
 }
+
+func (c *callerHubClients) GroupExcept(groupName string, excludedConnectionIDs ...string) ClientProxy {
+	return c.defaultHubClients.GroupExcept(groupName, excludedConnectionIDs...)
+}
+
+func (c *callerHubClients) Where(key string, value string) ClientProxy {
+	return c.defaultHubClients.Where(key, value)
+}
+
+func (c *callerHubClients) User(userID string) ClientProxy {
+	return c.defaultHubClients.User(userID)
+}
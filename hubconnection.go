@@ -3,10 +3,18 @@ package signalr
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/go-kit/log"
+	"nhooyr.io/websocket"
 )
 
 // hubConnection is used by HubContext, Server and Client to realize the external API.
@@ -15,15 +23,106 @@ type hubConnection interface {
 	ConnectionID() string
 	Receive() <-chan receiveResult
 	SendInvocation(id string, target string, args []interface{}) error
+	SendInvocationWithHeaders(id string, target string, args []interface{}, headers map[string]string) error
+	SendInvocationWithAck(target string, args []interface{}, policy AckPolicy) error
+	Acknowledge(invocationID string) bool
 	SendStreamInvocation(id string, target string, args []interface{}, streamIds []string) error
-	StreamItem(id string, item interface{}) error
+	StreamItem(id string, item interface{}, sequence uint64) error
 	Completion(id string, result interface{}, error string) error
+	CompletionWithHeaders(id string, result interface{}, error string, headers map[string]string) error
 	Close(error string, allowReconnect bool) error
 	Ping() error
+	Flush() error
+	Ack(sequenceID uint64) error
+	SendMessage(message Message) error
 	LastWriteStamp() time.Time
+	LastReadStamp() time.Time
+	LastActivity() time.Time
+	ConnectionInfo() ConnectionInfo
+	Features() ConnectionFeatures
 	Items() *sync.Map
 	Context() context.Context
 	Abort()
+	Capture(w io.Writer)
+	PauseReceive()
+	ResumeReceive()
+	receivePaused() bool
+	receiveResumed() <-chan struct{}
+}
+
+// ConnectionInfo describes the state of a connection
+type ConnectionInfo struct {
+	ConnectionID string
+	// BytesRead is the number of bytes of SignalR messages read from the connection, without the handshake
+	BytesRead uint64
+	// BytesWritten is the number of bytes of SignalR messages written to the connection, without the handshake
+	BytesWritten uint64
+	// LastRead is the time when data has been read from the connection the last time, without the handshake.
+	// It is zero if nothing has been read yet
+	LastRead time.Time
+	// LastWritten is the time when a message has been written to the connection the last time.
+	// It is zero if nothing has been written yet
+	LastWritten time.Time
+	// LastActivity is the later one of LastRead and LastWritten
+	LastActivity time.Time
+}
+
+// connectionReadError is used to close the pipe between the goroutine reading from the connection
+// and the goroutine parsing the messages. It intentionally does not unwrap the error returned by the connection,
+// because the protocols ignore io.EOF when reading from the pipe.
+type connectionReadError struct {
+	err error
+}
+
+func (e *connectionReadError) Error() string {
+	return e.err.Error()
+}
+
+// replaceIn returns the error returned by the connection instead of err, which was caused by e.
+// If the connection ended in the middle of a frame, the result is still an ErrIncompleteFrame.
+func (e *connectionReadError) replaceIn(err error) error {
+	var incomplete *incompleteFrameError
+	if errors.As(err, &incomplete) {
+		return &incompleteFrameError{err: e.err, size: incomplete.size}
+	}
+	return e.err
+}
+
+// isTransportClosed reports if err means that the other party has closed the transport, e.g. io.EOF or net.ErrClosed.
+// A transport which was closed in the middle of a frame has not been closed cleanly.
+func isTransportClosed(err error) bool {
+	if errors.Is(err, ErrIncompleteFrame) {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		websocket.CloseStatus(err) == websocket.StatusNormalClosure
+}
+
+// ErrMessageTooLarge is returned when a message is larger than the MaximumSendMessageSize. The message is not sent then.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// notSendable returns true if a message has not been sent because of its content, i.e. it is too large or could not
+// be marshaled. The other party should get the error instead
+func notSendable(err error) bool {
+	return errors.Is(err, ErrMessageTooLarge) || errors.Is(err, ErrMarshalFailed)
+}
+
+// ErrConnectionClosed is returned when a message should be sent over a connection which has already been closed.
+// The message is not sent then.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// connectionError is returned when a message could not be sent because the connection failed or has ended.
+// Other than errors caused by the message itself, these errors do not depend on the message
+type connectionError struct {
+	err error
+}
+
+func (e *connectionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connectionError) Unwrap() error {
+	return e.err
 }
 
 type receiveResult struct {
@@ -31,18 +130,31 @@ type receiveResult struct {
 	err     error
 }
 
-func newHubConnection(connection Connection, protocol hubProtocol, maximumReceiveMessageSize uint, info StructuredLogger) hubConnection {
+// newHubConnection creates a hubConnection. If buffer is not nil, all sequenced messages written are kept in the buffer
+// until they are acknowledged by the other party.
+// Messages larger than maximumSendMessageSize are not sent, 0 means no limit.
+// Ping sends no ping when a message has been written within keepAliveInterval
+func newHubConnection(connection Connection, protocol hubProtocol, readChunkSize uint, maximumSendMessageSize uint, keepAliveInterval time.Duration,
+	info StructuredLogger, buffer *messageBuffer) hubConnection {
 	ctx, cancelFunc := context.WithCancel(connection.Context())
 	c := &defaultHubConnection{
-		ctx:                       ctx,
-		cancelFunc:                cancelFunc,
-		protocol:                  protocol,
-		mx:                        sync.Mutex{},
-		connection:                connection,
-		maximumReceiveMessageSize: maximumReceiveMessageSize,
-		items:                     &sync.Map{},
-		info:                      info,
+		ctx:                    ctx,
+		cancelFunc:             cancelFunc,
+		protocol:               protocol,
+		mx:                     sync.Mutex{},
+		connection:             connection,
+		readChunkSize:          readChunkSize,
+		maximumSendMessageSize: maximumSendMessageSize,
+		keepAliveInterval:      keepAliveInterval,
+		items:                  &sync.Map{},
+		info:                   info,
+		buffer:                 buffer,
+		acks:                   newAckTracker(),
+		resumed:                make(chan struct{}, 1),
+		features:               connectionFeatures(connection, protocol, buffer != nil),
+		clock:                  realClock{},
 	}
+	c.writer = &countingWriter{connection, &c.bytesWritten}
 	if connectionWithTransferMode, ok := connection.(ConnectionWithTransferMode); ok {
 		connectionWithTransferMode.SetTransferMode(protocol.transferMode())
 	}
@@ -50,28 +162,68 @@ func newHubConnection(connection Connection, protocol hubProtocol, maximumReceiv
 }
 
 type defaultHubConnection struct {
-	ctx                       context.Context
-	cancelFunc                context.CancelFunc
-	protocol                  hubProtocol
-	mx                        sync.Mutex
-	connection                Connection
-	maximumReceiveMessageSize uint
-	items                     *sync.Map
-	lastWriteStamp            time.Time
-	info                      StructuredLogger
+	// Used with atomic: Must be first in struct to ensure 64bit alignment on 32bit architectures
+	bytesRead              uint64
+	bytesWritten           uint64
+	ctx                    context.Context
+	cancelFunc             context.CancelFunc
+	protocol               hubProtocol
+	mx                     sync.Mutex
+	writeMx                priorityLock
+	connection             Connection
+	readChunkSize          uint
+	maximumSendMessageSize uint
+	keepAliveInterval      time.Duration
+	items                  *sync.Map
+	lastWriteStamp         time.Time
+	lastReadStamp          time.Time
+	info                   StructuredLogger
+	buffer                 *messageBuffer
+	acks                   *ackTracker
+	capture                atomic.Value
+	paused                 int32
+	resumed                chan struct{}
+	features               ConnectionFeatures
+	writer                 io.Writer
+	coalescer              *coalescingWriter
+	clock                  Clock
+	targetNaming           NamingConvention
+	// received holds the data which has been read together with the handshake. It is parsed before the data read by Receive
+	received []byte
+	// closed is set to 1 by Close. Used with atomic
+	closed int32
+	// prioritized lets control messages be written before queued invocations and stream items, see PrioritizeControlMessages
+	prioritized bool
+	// budget counts the bytes buffered by all connections of the server, see MaxBufferedBytes
+	budget *bufferBudget
 }
 
 func (c *defaultHubConnection) Items() *sync.Map {
 	return c.items
 }
 
+// Close sends the close message and closes the connection. Messages sent after Close return ErrConnectionClosed.
 func (c *defaultHubConnection) Close(errorText string, allowReconnect bool) error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
 	var closeMessage = closeMessage{
 		Type:           7,
 		Error:          errorText,
 		AllowReconnect: allowReconnect,
 	}
-	return c.protocol.WriteMessage(closeMessage, c.connection)
+	c.writeMx.Lock(c.prioritized)
+	err := c.protocol.WriteMessage(closeMessage, c.writer)
+	if c.coalescer != nil {
+		if flushErr := c.coalescer.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	c.writeMx.Unlock()
+	if closeErr := closeConnection(c.connection); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 func (c *defaultHubConnection) ConnectionID() string {
@@ -86,31 +238,78 @@ func (c *defaultHubConnection) Abort() {
 	c.cancelFunc()
 }
 
+// PauseReceive stops dispatching received invocations until ResumeReceive is called, e.g. when the application
+// is overloaded. The connection is still read, so pings, stream items and completions are processed.
+// The invocations received while paused are queued and dispatched in the order of their arrival after ResumeReceive.
+func (c *defaultHubConnection) PauseReceive() {
+	atomic.StoreInt32(&c.paused, 1)
+}
+
+// ResumeReceive dispatches the invocations queued while paused and all following invocations, see PauseReceive
+func (c *defaultHubConnection) ResumeReceive() {
+	if atomic.CompareAndSwapInt32(&c.paused, 1, 0) {
+		select {
+		case c.resumed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *defaultHubConnection) receivePaused() bool {
+	return atomic.LoadInt32(&c.paused) == 1
+}
+
+// receiveResumed signals when ResumeReceive has been called after PauseReceive
+func (c *defaultHubConnection) receiveResumed() <-chan struct{} {
+	return c.resumed
+}
+
+// readChunkPools holds a *sync.Pool of read chunks per chunk size, so connections do not allocate their own chunk
+var readChunkPools sync.Map
+
+func readChunkPool(size uint) *sync.Pool {
+	if pool, ok := readChunkPools.Load(size); ok {
+		return pool.(*sync.Pool)
+	}
+	pool, _ := readChunkPools.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} { return make([]byte, size) },
+	})
+	return pool.(*sync.Pool)
+}
+
 func (c *defaultHubConnection) Receive() <-chan receiveResult {
 	recvChan := make(chan receiveResult, 20)
 	// Prepare cleanup
 	writerDone := make(chan struct{}, 1)
 	// the pipe connects the goroutine which reads from the connection and the goroutine which parses the read data
 	reader, writer := CtxPipe(c.ctx)
-	p := make([]byte, c.maximumReceiveMessageSize)
-	go func(ctx context.Context, connection io.Reader, writer io.Writer, recvChan chan<- receiveResult, writerDone chan<- struct{}) {
+	chunkPool := readChunkPool(c.readChunkSize)
+	p := chunkPool.Get().([]byte)
+	received := c.received
+	c.received = nil
+	go func(ctx context.Context, connection io.Reader, writer *PipeWriter, recvChan chan<- receiveResult, writerDone chan<- struct{}) {
+		// The pipe copies the data before Write returns, so the chunk is not used by the parser anymore
+		defer chunkPool.Put(p)
+		if len(received) > 0 {
+			c.stampRead()
+			if _, err := writer.Write(received); err != nil {
+				select {
+				case recvChan <- receiveResult{err: err}:
+				case <-ctx.Done():
+				}
+			}
+		}
 	loop:
 		for {
 			select {
 			case <-ctx.Done():
 				break loop
 			default:
-				n, err := connection.Read(p)
-				if err != nil {
-					select {
-					case recvChan <- receiveResult{err: err}:
-					case <-ctx.Done():
-						break loop
-					}
-				}
+				n, readErr := connection.Read(p)
+				atomic.AddUint64(&c.bytesRead, uint64(n))
 				if n > 0 {
-					_, err = writer.Write(p[:n])
-					if err != nil {
+					c.stampRead()
+					if _, err := writer.Write(p[:n]); err != nil {
 						select {
 						case recvChan <- receiveResult{err: err}:
 						case <-ctx.Done():
@@ -118,6 +317,12 @@ func (c *defaultHubConnection) Receive() <-chan receiveResult {
 						}
 					}
 				}
+				if readErr != nil {
+					// The parser reports the error after it has parsed the data read before.
+					// By this, it can detect if the connection ended in the middle of a frame
+					_ = writer.CloseWithError(&connectionReadError{readErr})
+					return
+				}
 			}
 		}
 		// The pipe writer is done
@@ -126,6 +331,12 @@ func (c *defaultHubConnection) Receive() <-chan receiveResult {
 	// parse
 	go func(ctx context.Context, reader io.Reader, recvChan chan<- receiveResult, writerDone <-chan struct{}) {
 		remainBuf := bytes.Buffer{}
+		var budget *budgetReader
+		if c.budget != nil {
+			budget = &budgetReader{ctx: ctx, reader: reader, budget: c.budget}
+			reader = budget
+			defer budget.releaseAll()
+		}
 	loop:
 		for {
 			select {
@@ -135,7 +346,15 @@ func (c *defaultHubConnection) Receive() <-chan receiveResult {
 				break loop
 			default:
 				messages, err := c.protocol.ParseMessages(reader, &remainBuf)
+				if budget != nil {
+					budget.parsed(remainBuf.Len())
+				}
 				if err != nil {
+					var readErr *connectionReadError
+					readEnded := errors.As(err, &readErr)
+					if readEnded {
+						err = readErr.replaceIn(err)
+					}
 					select {
 					case recvChan <- receiveResult{err: err}:
 					case <-ctx.Done():
@@ -143,8 +362,12 @@ func (c *defaultHubConnection) Receive() <-chan receiveResult {
 					case <-writerDone:
 						break loop
 					}
+					if readEnded {
+						break loop
+					}
 				} else {
 					for _, message := range messages {
+						c.captureMessage(msgRecv, message)
 						select {
 						case recvChan <- receiveResult{message: message}:
 						case <-ctx.Done():
@@ -161,83 +384,340 @@ func (c *defaultHubConnection) Receive() <-chan receiveResult {
 }
 
 func (c *defaultHubConnection) SendInvocation(id string, target string, args []interface{}) error {
+	return c.SendInvocationWithHeaders(id, target, args, nil)
+}
+
+func (c *defaultHubConnection) SendInvocationWithHeaders(id string, target string, args []interface{}, headers map[string]string) error {
 	if args == nil {
 		args = make([]interface{}, 0)
 	}
 	var invocationMessage = invocationMessage{
 		Type:         1,
+		Headers:      headers,
 		InvocationID: id,
-		Target:       target,
+		Target:       c.targetNaming.target(target),
 		Arguments:    args,
 	}
 	return c.writeMessage(invocationMessage)
 }
 
+// SendInvocationWithAck sends the invocation until the other party acknowledges it with a completion
+// or the attempts allowed by the policy are exhausted
+func (c *defaultHubConnection) SendInvocationWithAck(target string, args []interface{}, policy AckPolicy) error {
+	if policy.Timeout <= 0 {
+		return fmt.Errorf("invalid AckPolicy: Timeout %v must be greater than 0", policy.Timeout)
+	}
+	id := c.acks.newID()
+	defer c.acks.done(id)
+	backoff := policy.Backoff
+	for attempt := 0; attempt <= policy.Retries; attempt++ {
+		if attempt > 0 {
+			if err := c.wait(backoff); err != nil {
+				return err
+			}
+			backoff *= 2
+		}
+		acked := c.acks.attempt(id)
+		if err := c.SendInvocation(id, target, args); err != nil {
+			return err
+		}
+		timeout := c.clock.NewTimer(policy.Timeout)
+		select {
+		case <-acked:
+			timeout.Stop()
+			return nil
+		case <-timeout.C():
+		case <-c.ctx.Done():
+			timeout.Stop()
+			return fmt.Errorf("hubConnection canceled: %w", c.ctx.Err())
+		}
+	}
+	return fmt.Errorf("%w: %v attempts to invoke %v on connection %v", ErrNotAcknowledged, policy.Retries+1, target, c.ConnectionID())
+}
+
+// wait waits for d to elapse on the clock. It returns an error when the hubConnection is canceled before
+func (c *defaultHubConnection) wait(d time.Duration) error {
+	timer := c.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-c.ctx.Done():
+		return fmt.Errorf("hubConnection canceled: %w", c.ctx.Err())
+	}
+}
+
+// Acknowledge handles the completion of an invocation sent by SendInvocationWithAck.
+// It reports false if the invocationID does not belong to such an invocation
+func (c *defaultHubConnection) Acknowledge(invocationID string) bool {
+	return c.acks.receive(invocationID)
+}
+
 func (c *defaultHubConnection) SendStreamInvocation(id string, target string, args []interface{}, streamIds []string) error {
 	var invocationMessage = invocationMessage{
 		Type:         4,
 		InvocationID: id,
-		Target:       target,
+		Target:       c.targetNaming.target(target),
 		Arguments:    args,
 		StreamIds:    streamIds,
 	}
 	return c.writeMessage(invocationMessage)
 }
 
-func (c *defaultHubConnection) StreamItem(id string, item interface{}) error {
+// StreamItem sends a stream item. If sequence is not 0, it is sent in the sequence header, see StreamItemSequence
+func (c *defaultHubConnection) StreamItem(id string, item interface{}, sequence uint64) error {
 	var streamItemMessage = streamItemMessage{
 		Type:         2,
 		InvocationID: id,
 		Item:         item,
 	}
+	if sequence != 0 {
+		streamItemMessage.Headers = map[string]string{streamSequenceHeader: strconv.FormatUint(sequence, 10)}
+	}
 	return c.writeMessage(streamItemMessage)
 }
 
+// Flush writes the messages which have been collected by WriteCoalescing at once.
+// If the transport Connection implements http.Flusher, it is flushed, too.
+func (c *defaultHubConnection) Flush() error {
+	c.writeMx.Lock(false)
+	defer c.writeMx.Unlock()
+	if c.coalescer != nil {
+		if err := c.coalescer.Flush(); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := c.connection.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
 func (c *defaultHubConnection) Completion(id string, result interface{}, error string) error {
+	return c.CompletionWithHeaders(id, result, error, nil)
+}
+
+func (c *defaultHubConnection) CompletionWithHeaders(id string, result interface{}, error string, headers map[string]string) error {
 	var completionMessage = completionMessage{
 		Type:         3,
+		Headers:      headers,
 		InvocationID: id,
 		Result:       result,
 		Error:        error,
 	}
-	return c.writeMessage(completionMessage)
+	err := c.writeMessage(completionMessage)
+	if notSendable(err) && result != nil {
+		// Let the other party know that the result was not sent
+		completionMessage.Result = nil
+		completionMessage.Error = err.Error()
+		return c.writeMessage(completionMessage)
+	}
+	return err
 }
 
+// Ping sends a ping message, unless a message has been written within the keepAliveInterval.
+// So concurrent or repeated calls send at most one ping per keepAliveInterval
 func (c *defaultHubConnection) Ping() error {
+	c.mx.Lock()
+	if c.clock.Now().Sub(c.lastWriteStamp) < c.keepAliveInterval {
+		c.mx.Unlock()
+		return nil
+	}
+	// Claim the interval before writing, so a concurrent Ping does not send another ping
+	c.lastWriteStamp = c.clock.Now()
+	c.mx.Unlock()
 	var pingMessage = hubMessage{
 		Type: 6,
 	}
 	return c.writeMessage(pingMessage)
 }
 
+func (c *defaultHubConnection) Ack(sequenceID uint64) error {
+	var ackMessage = ackMessage{
+		Type:       8,
+		SequenceID: sequenceID,
+	}
+	return c.writeMessage(ackMessage)
+}
+
+// SendMessage writes a message built by the application, see Message
+func (c *defaultHubConnection) SendMessage(message Message) error {
+	hubMessage, err := message.hubMessage()
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(hubMessage)
+}
+
 func (c *defaultHubConnection) LastWriteStamp() time.Time {
 	defer c.mx.Unlock()
 	c.mx.Lock()
 	return c.lastWriteStamp
 }
 
-func (c *defaultHubConnection) writeMessage(message interface{}) error {
+// LastReadStamp returns the time when data has been read from the connection the last time
+func (c *defaultHubConnection) LastReadStamp() time.Time {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.lastReadStamp
+}
+
+// LastActivity returns the time when data has been read from or written to the connection the last time
+func (c *defaultHubConnection) LastActivity() time.Time {
+	defer c.mx.Unlock()
 	c.mx.Lock()
-	c.lastWriteStamp = time.Now()
+	return latest(c.lastReadStamp, c.lastWriteStamp)
+}
+
+func (c *defaultHubConnection) stampRead() {
+	c.mx.Lock()
+	c.lastReadStamp = c.clock.Now()
 	c.mx.Unlock()
-	err := func() error {
-		if c.ctx.Err() != nil {
-			return fmt.Errorf("hubConnection canceled: %w", c.ctx.Err())
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (c *defaultHubConnection) ConnectionInfo() ConnectionInfo {
+	c.mx.Lock()
+	lastRead, lastWritten := c.lastReadStamp, c.lastWriteStamp
+	c.mx.Unlock()
+	return ConnectionInfo{
+		ConnectionID: c.ConnectionID(),
+		BytesRead:    atomic.LoadUint64(&c.bytesRead),
+		BytesWritten: atomic.LoadUint64(&c.bytesWritten),
+		LastRead:     lastRead,
+		LastWritten:  lastWritten,
+		LastActivity: latest(lastRead, lastWritten),
+	}
+}
+
+// coalesceWrites lets the frames written within delay be written to the connection at once, see WriteCoalescing
+func (c *defaultHubConnection) coalesceWrites(delay time.Duration, size uint) {
+	c.coalescer = newCoalescingWriter(c.writer, delay, size, c.clock, func(error) { c.Abort() })
+	c.writer = c.coalescer
+}
+
+func (c *defaultHubConnection) Features() ConnectionFeatures {
+	return c.features
+}
+
+// countingWriter adds the number of bytes written to count
+type countingWriter struct {
+	writer io.Writer
+	count  *uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	atomic.AddUint64(w.count, uint64(n))
+	return n, err
+}
+
+func (c *defaultHubConnection) writeMessage(message interface{}) error {
+	// Nothing is written to a transport which is known to be dead
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return ErrConnectionClosed
+	}
+	if c.ctx.Err() != nil {
+		return &connectionError{fmt.Errorf("hubConnection canceled: %w", c.ctx.Err())}
+	}
+	write, size, err := c.encodeMessage(message)
+	if err != nil {
+		_ = c.info.Log(evt, msgSend, "message", fmtMsg(message), "error", err)
+		return err
+	}
+	if c.budget != nil {
+		// Control messages are not held back, they are needed to keep the connection alive and to end it
+		if !hasPriority(message) {
+			if err := c.budget.wait(c.ctx); err != nil {
+				return &connectionError{fmt.Errorf("hubConnection canceled: %w", err)}
+			}
 		}
+		c.budget.add(uint64(size))
+		defer c.budget.release(uint64(size))
+	}
+	c.mx.Lock()
+	c.lastWriteStamp = c.clock.Now()
+	c.mx.Unlock()
+	err = func() error {
 		e := make(chan error, 1)
-		go func() { e <- c.protocol.WriteMessage(message, c.connection) }()
+		go func() {
+			// Messages are written one after the other, so concurrent writes can not interleave on the transport
+			c.writeMx.Lock(c.prioritized && hasPriority(message))
+			defer c.writeMx.Unlock()
+			err := write(c.writer)
+			// The sequence ids follow the order on the transport. Messages which have not been sent get no id
+			if err == nil && c.buffer != nil {
+				c.buffer.write(message)
+			}
+			e <- err
+		}()
 		select {
 		case <-c.ctx.Done():
-			return fmt.Errorf("hubConnection canceled: %w", c.ctx.Err())
+			return &connectionError{fmt.Errorf("hubConnection canceled: %w", c.ctx.Err())}
 		case err := <-e:
-			if err != nil {
+			// A message which could not be marshaled has not been written, so the transport is still intact
+			if err != nil && !errors.Is(err, ErrMarshalFailed) {
 				c.Abort()
+				return &connectionError{err}
 			}
 			return err
 		}
 	}()
 	if err != nil {
 		_ = c.info.Log(evt, msgSend, "message", fmtMsg(message), "error", err)
+	} else {
+		c.captureMessage(msgSend, message)
 	}
 	return err
 }
+
+// captureLogger is stored in defaultHubConnection.capture. The logger is nil when nothing is captured
+type captureLogger struct {
+	logger StructuredLogger
+}
+
+// Capture logs all messages sent and received by the connection to w, independent of the debug logging.
+// Capture(nil) stops capturing.
+func (c *defaultHubConnection) Capture(w io.Writer) {
+	if w == nil {
+		c.capture.Store(captureLogger{})
+		return
+	}
+	c.capture.Store(captureLogger{
+		logger: log.With(log.NewSyncLogger(log.NewLogfmtLogger(w)), "ts", log.DefaultTimestampUTC, "connectionId", c.ConnectionID()),
+	})
+}
+
+func (c *defaultHubConnection) captureMessage(event string, message interface{}) {
+	if capture, ok := c.capture.Load().(captureLogger); ok && capture.logger != nil {
+		_ = capture.logger.Log(evt, event, msg, fmtMsg(message))
+	}
+}
+
+// encodeMessage returns a function which writes the message and the size of the encoded message.
+// If the size of outgoing messages is limited or buffered bytes are counted, the message is encoded in advance
+// to know its size, and nothing is written if it is too large. Otherwise, the size is 0.
+func (c *defaultHubConnection) encodeMessage(message interface{}) (func(w io.Writer) error, int, error) {
+	if c.maximumSendMessageSize == 0 && c.budget == nil {
+		return func(w io.Writer) error {
+			return c.protocol.WriteMessage(message, w)
+		}, 0, nil
+	}
+	buf := &bytes.Buffer{}
+	if err := c.protocol.WriteMessage(message, buf); err != nil {
+		return nil, 0, err
+	}
+	if c.maximumSendMessageSize > 0 && uint(buf.Len()) > c.maximumSendMessageSize {
+		return nil, 0, fmt.Errorf("%w: %v bytes exceed the maximum of %v bytes", ErrMessageTooLarge, buf.Len(), c.maximumSendMessageSize)
+	}
+	return func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}, buf.Len(), nil
+}
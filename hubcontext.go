@@ -10,25 +10,39 @@ import (
 // Groups gets a GroupManager that can be used to add and remove connections to named groups
 // Items holds key/value pairs scoped to the hubs connection
 // ConnectionID gets the ID of the current connection
+// UserID gets the user of the current connection, see UserIdentifier
+// ConnectionInfo gets the state of the current connection, e.g. the number of bytes read and written and the time of the last activity
+// ConnectionFeatures gets what the current connection supports, e.g. the transport and if it can carry binary messages
 // Abort aborts the current connection
+// SendMessage sends a message built by the application to the current connection, see Message
+// PauseReceive stops dispatching the invocations of the current connection until ResumeReceive is called.
+// Invocations received in the meantime are queued, pings and other messages are still processed
+// ResumeReceive dispatches the queued invocations and resumes dispatching new invocations
 // Logger returns the logger used in this server
 type HubContext interface {
 	Clients() HubClients
 	Groups() GroupManager
 	Items() *sync.Map
 	ConnectionID() string
+	UserID() string
+	ConnectionInfo() ConnectionInfo
+	ConnectionFeatures() ConnectionFeatures
 	Context() context.Context
 	Abort()
+	SendMessage(message Message) error
+	PauseReceive()
+	ResumeReceive()
 	Logger() (info StructuredLogger, dbg StructuredLogger)
 }
 
 type connectionHubContext struct {
-	abort      context.CancelFunc
-	connection hubConnection
-	clients    HubClients
-	groups     GroupManager
-	info       StructuredLogger
-	dbg        StructuredLogger
+	abort           context.CancelFunc
+	connection      hubConnection
+	lifetimeManager HubLifetimeManager
+	clients         HubClients
+	groups          GroupManager
+	info            StructuredLogger
+	dbg             StructuredLogger
 }
 
 func (c *connectionHubContext) Clients() HubClients {
@@ -47,6 +61,18 @@ func (c *connectionHubContext) ConnectionID() string {
 	return c.connection.ConnectionID()
 }
 
+func (c *connectionHubContext) UserID() string {
+	return c.lifetimeManager.UserID(c.connection.ConnectionID())
+}
+
+func (c *connectionHubContext) ConnectionInfo() ConnectionInfo {
+	return c.connection.ConnectionInfo()
+}
+
+func (c *connectionHubContext) ConnectionFeatures() ConnectionFeatures {
+	return c.connection.Features()
+}
+
 func (c *connectionHubContext) Context() context.Context {
 	return c.connection.Context()
 }
@@ -55,6 +81,18 @@ func (c *connectionHubContext) Abort() {
 	c.abort()
 }
 
+func (c *connectionHubContext) SendMessage(message Message) error {
+	return c.connection.SendMessage(message)
+}
+
+func (c *connectionHubContext) PauseReceive() {
+	c.connection.PauseReceive()
+}
+
+func (c *connectionHubContext) ResumeReceive() {
+	c.connection.ResumeReceive()
+}
+
 func (c *connectionHubContext) Logger() (info StructuredLogger, dbg StructuredLogger) {
 	return c.info, c.dbg
 }
@@ -2,6 +2,7 @@ package signalr
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"sync"
@@ -46,6 +47,22 @@ func (c *contextHub) CallGroup() {
 	c.Clients().Group("local").Send("clientFunc")
 }
 
+func (c *contextHub) CallGroupExceptCaller() {
+	c.Clients().GroupExcept("local", c.ConnectionID()).Send("clientFunc")
+}
+
+func (c *contextHub) SetRegion(region string) {
+	c.Groups().SetTag(c.ConnectionID(), "region", region)
+}
+
+func (c *contextHub) RemoveRegion() {
+	c.Groups().RemoveTag(c.ConnectionID(), "region")
+}
+
+func (c *contextHub) CallRegion(region string) {
+	c.Clients().Where("region", region).Send("clientFunc")
+}
+
 func (c *contextHub) AddItem(key string, value interface{}) {
 	c.Items().Store(key, value)
 }
@@ -60,6 +77,18 @@ func (c *contextHub) GetItem(key string) interface{} {
 func (c *contextHub) TestConnectionID() {
 }
 
+func (c *contextHub) GetConnectionInfo() ConnectionInfo {
+	return c.ConnectionInfo()
+}
+
+func (c *contextHub) SendCompletion(invocationID string, result string) error {
+	return c.SendMessage(Message{Type: 3, InvocationID: invocationID, Result: result})
+}
+
+func (c *contextHub) SendInvalid() error {
+	return c.SendMessage(Message{Type: 3, Result: "no invocationID"})
+}
+
 func (c *contextHub) Abort() {
 	c.Hub.Abort()
 }
@@ -211,6 +240,109 @@ var _ = Describe("HubContext", func() {
 	}
 })
 
+type pauseHub struct {
+	Hub
+	records chan int
+	resume  chan struct{}
+}
+
+// Pause pauses receiving until resume is signaled. The resume can not be triggered by an invocation,
+// because it would not be dispatched while receiving is paused
+func (p *pauseHub) Pause() {
+	p.PauseReceive()
+	go func() {
+		<-p.resume
+		p.ResumeReceive()
+	}()
+}
+
+func (p *pauseHub) Record(i int) {
+	p.records <- i
+}
+
+var _ = Describe("HubContext PauseReceive", func() {
+	It("should dispatch the invocations received while paused after ResumeReceive", func(done Done) {
+		hub := &pauseHub{records: make(chan int, 10), resume: make(chan struct{})}
+		server, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+		Expect(err).NotTo(HaveOccurred())
+		defer server.cancel()
+		conn := newTestingConnectionForServer()
+		go func() { _ = server.Serve(conn) }()
+		conn.ClientSend(`{"type":1,"invocationId":"1","target":"pause"}`)
+		Expect((<-conn.received).(completionMessage).InvocationID).To(Equal("1"))
+		conn.ClientSend(`{"type":1,"invocationId":"2","target":"record","arguments":[1]}`)
+		conn.ClientSend(`{"type":6}`)
+		conn.ClientSend(`{"type":1,"invocationId":"3","target":"record","arguments":[2]}`)
+		Consistently(hub.records, 300*time.Millisecond).ShouldNot(Receive())
+		close(hub.resume)
+		// Invocations are dispatched concurrently, so the order of execution is not defined
+		records := []int{<-hub.records, <-hub.records}
+		Expect(records).To(ConsistOf(1, 2))
+		close(done)
+	}, 2.0)
+})
+
+var _ = Describe("HubContext ConnectionInfo", func() {
+	var server Server
+	var conn *testingConnection
+	BeforeEach(func(done Done) {
+		server, conn = connect(&contextHub{})
+		close(done)
+	})
+	AfterEach(func(done Done) {
+		server.cancel()
+		close(done)
+	})
+	Context("When messages have been sent and received", func() {
+		It("should count the bytes read and written", func(done Done) {
+			first := `{"type":1,"invocationId":"1","target":"testconnectionid"}`
+			conn.ClientSend(first)
+			Expect((<-conn.received).(completionMessage).Error).To(Equal(""))
+			completion, err := json.Marshal(completionMessage{Type: 3, InvocationID: "1"})
+			Expect(err).NotTo(HaveOccurred())
+			second := `{"type":1,"invocationId":"2","target":"getconnectioninfo"}`
+			conn.ClientSend(second)
+			recv := (<-conn.received).(completionMessage)
+			Expect(recv.Error).To(Equal(""))
+			info := recv.Result.(map[string]interface{})
+			Expect(info["ConnectionID"]).To(Equal(conn.ConnectionID()))
+			// Each frame is terminated by the record separator
+			Expect(info["BytesRead"]).To(Equal(float64(len(first) + 1 + len(second) + 1)))
+			Expect(info["BytesWritten"]).To(Equal(float64(len(completion) + 1)))
+			close(done)
+		}, 2.0)
+	})
+})
+
+var _ = Describe("HubContext SendMessage", func() {
+	var server Server
+	var conn *testingConnection
+	BeforeEach(func(done Done) {
+		server, conn = connect(&contextHub{}, CompletionErrors(true))
+		close(done)
+	})
+	AfterEach(func(done Done) {
+		server.cancel()
+		close(done)
+	})
+	Context("When a completion has been built", func() {
+		It("should send it to the client", func(done Done) {
+			conn.ClientSend(`{"type":1,"target":"sendcompletion","arguments":["external","bridged"]}`)
+			Expect(<-conn.received).To(Equal(completionMessage{Type: 3, InvocationID: "external", Result: "bridged"}))
+			close(done)
+		}, 2.0)
+	})
+	Context("When an invalid message has been built", func() {
+		It("should return ErrInvalidMessage and send nothing", func(done Done) {
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"sendinvalid"}`)
+			recv := (<-conn.received).(completionMessage)
+			Expect(recv.InvocationID).To(Equal("1"))
+			Expect(recv.Error).To(ContainSubstring(ErrInvalidMessage.Error()))
+			close(done)
+		}, 2.0)
+	})
+})
+
 func TestGroupShouldInvokeOnlyTheClientsInTheGroup(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -281,6 +413,130 @@ func TestRemoveClientsShouldRemoveClientsFromTheGroup(t *testing.T) {
 	}
 }
 
+func TestGroupExceptShouldNotInvokeTheExcludedClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, client, receiver, srvConn, _, err := makeTCPServerAndClients(ctx, 3)
+	assert.NoError(t, err)
+	select {
+	case ir := <-client[0].Invoke("buildgroup", srvConn[1].ConnectionID(), srvConn[2].ConnectionID()):
+		assert.NoError(t, ir.Error)
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "timeout in invoke")
+	}
+	select {
+	case ir := <-client[1].Invoke("callgroupexceptcaller"):
+		assert.NoError(t, ir.Error)
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "timeout in invoke")
+	}
+	select {
+	case <-receiver[0].ch:
+		assert.Fail(t, "client 1 received message for client 3")
+	case <-receiver[1].ch:
+		assert.Fail(t, "client 2 received message it has sent to its group")
+	case <-receiver[2].ch:
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "timeout without client 3 got called")
+	}
+	select {
+	case <-receiver[1].ch:
+		assert.Fail(t, "client 2 received message it has sent to its group")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWhereShouldInvokeOnlyTheClientsWithTheTag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, client, receiver, _, _, err := makeTCPServerAndClients(ctx, 3)
+	assert.NoError(t, err)
+	for i, region := range []string{"eu", "us", "eu"} {
+		select {
+		case ir := <-client[i].Invoke("setregion", region):
+			assert.NoError(t, ir.Error)
+		case <-time.After(100 * time.Millisecond):
+			assert.Fail(t, "timeout in invoke")
+		}
+	}
+	select {
+	case ir := <-client[1].Invoke("callregion", "eu"):
+		assert.NoError(t, ir.Error)
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "timeout in invoke")
+	}
+	for _, i := range []int{0, 2} {
+		select {
+		case <-receiver[i].ch:
+		case <-time.After(100 * time.Millisecond):
+			assert.Fail(t, fmt.Sprintf("timeout without client %v got called", i+1))
+		}
+	}
+	select {
+	case <-receiver[1].ch:
+		assert.Fail(t, "client 2 received message for region eu")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRemoveTagShouldRemoveClientsFromTheSelection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, client, receiver, _, _, err := makeTCPServerAndClients(ctx, 2)
+	assert.NoError(t, err)
+	for _, i := range []int{0, 1} {
+		select {
+		case ir := <-client[i].Invoke("setregion", "eu"):
+			assert.NoError(t, ir.Error)
+		case <-time.After(100 * time.Millisecond):
+			assert.Fail(t, "timeout in invoke")
+		}
+	}
+	select {
+	case ir := <-client[1].Invoke("removeregion"):
+		assert.NoError(t, ir.Error)
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "timeout in invoke")
+	}
+	select {
+	case ir := <-client[1].Invoke("callregion", "eu"):
+		assert.NoError(t, ir.Error)
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "timeout in invoke")
+	}
+	select {
+	case <-receiver[0].ch:
+	case <-time.After(100 * time.Millisecond):
+		assert.Fail(t, "timeout without client 1 got called")
+	}
+	select {
+	case <-receiver[1].ch:
+		assert.Fail(t, "client 2 received message after its tag was removed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTagsShouldBeClearedOnDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, client, _, _, cliConn, err := makeTCPServerAndClients(ctx, 2)
+	assert.NoError(t, err)
+	for _, i := range []int{0, 1} {
+		select {
+		case ir := <-client[i].Invoke("setregion", "eu"):
+			assert.NoError(t, ir.Error)
+		case <-time.After(100 * time.Millisecond):
+			assert.Fail(t, "timeout in invoke")
+		}
+	}
+	tags := &s.(*server).lifetimeManager.(*defaultHubLifetimeManager).tags
+	assert.Len(t, tags.connections("region", "eu"), 2)
+	_ = cliConn[1].(*netConnection).Close()
+	assert.Eventually(t, func() bool {
+		return len(tags.connections("region", "eu")) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
 func TestItemsShouldHoldItemsConnectionWise(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
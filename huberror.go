@@ -0,0 +1,57 @@
+package signalr
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// HubErrorDetailer is an error with structured details. When a hub method returns a HubErrorDetailer as error,
+// the error of the completion is a JSON object
+//
+//	{"message": <Error()>, "details": <ErrorDetails()>}
+//
+// so clients can parse the details, e.g. error codes. Errors which are no HubErrorDetailer are sent as plain text.
+// A HubErrorDetailer always completes the invocation with the error, also without CompletionErrors(true),
+// which would send other errors as a result value.
+type HubErrorDetailer interface {
+	error
+	ErrorDetails() interface{}
+}
+
+// isHubErrorDetailer reports if the error result of a method is a HubErrorDetailer
+func isHubErrorDetailer(value reflect.Value) bool {
+	if value.IsNil() {
+		return false
+	}
+	_, ok := value.Interface().(HubErrorDetailer)
+	return ok
+}
+
+// HubError is the error of an invocation which the server has completed with an error,
+// e.g. the error returned by the hub method or the error for arguments which do not match the method.
+// Client side errors, e.g. of a failing connection, are no HubErrors.
+type HubError struct {
+	Message string
+}
+
+func (h *HubError) Error() string {
+	return h.Message
+}
+
+type hubErrorObject struct {
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// hubErrorText returns the text for the error field of a completion
+func hubErrorText(err error) (string, error) {
+	detailer, ok := err.(HubErrorDetailer)
+	if !ok {
+		return err.Error(), nil
+	}
+	text, jsonErr := json.Marshal(hubErrorObject{Message: detailer.Error(), Details: detailer.ErrorDetails()})
+	if jsonErr != nil {
+		return err.Error(), jsonErr
+	}
+	return string(text), nil
+}
@@ -1,6 +1,8 @@
 package signalr
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/go-kit/log"
@@ -12,16 +14,45 @@ import (
 // InvokeAll() sends an invocation message to all hub connections
 // InvokeClient() sends an invocation message to a specified hub connection
 // InvokeGroup() sends an invocation message to a specified group of hub connections
+// InvokeAllWithAck(), InvokeClientWithAck() and InvokeGroupWithAck() do the same, but wait until all
+// hub connections have acknowledged the invocation
+// InvokeGroupExcept() sends an invocation message to a specified group of hub connections, except the excluded connections
+// InvokeGroupExceptWithAck() does the same, but waits until all hub connections have acknowledged the invocation
+// ConnectionInfos() returns the ConnectionInfo of all hub connections
 // AddToGroup() adds a connection to the specified group
 // RemoveFromGroup() removes a connection from the specified group
+// SetTag() sets the value of a tag of a connection. A connection has at most one value per tag key
+// RemoveTag() removes a tag from a connection
+// InvokeTagged() sends an invocation message to all hub connections with the specified tag value
+// InvokeTaggedWithAck() does the same, but waits until all hub connections have acknowledged the invocation
+// SetUser() sets the user of a connection, see UserIdentifier
+// UserID() returns the user of a connection or "" if the connection has no user
+// InvokeUser() sends an invocation message to all hub connections of the specified user
+// InvokeUserWithAck() does the same, but waits until all hub connections have acknowledged the invocation
+// Connection() returns the hub connection with the connectionID
 type HubLifetimeManager interface {
 	OnConnected(conn hubConnection)
 	OnDisconnected(conn hubConnection)
 	InvokeAll(target string, args []interface{})
 	InvokeClient(connectionID string, target string, args []interface{})
 	InvokeGroup(groupName string, target string, args []interface{})
+	InvokeAllWithAck(target string, args []interface{}, policy AckPolicy) error
+	InvokeClientWithAck(connectionID string, target string, args []interface{}, policy AckPolicy) error
+	InvokeGroupWithAck(groupName string, target string, args []interface{}, policy AckPolicy) error
+	InvokeGroupExcept(groupName string, excludedConnectionIDs []string, target string, args []interface{})
+	InvokeGroupExceptWithAck(groupName string, excludedConnectionIDs []string, target string, args []interface{}, policy AckPolicy) error
+	ConnectionInfos() []ConnectionInfo
 	AddToGroup(groupName, connectionID string)
 	RemoveFromGroup(groupName, connectionID string)
+	SetTag(connectionID, key, value string)
+	RemoveTag(connectionID, key string)
+	InvokeTagged(key, value string, target string, args []interface{})
+	InvokeTaggedWithAck(key, value string, target string, args []interface{}, policy AckPolicy) error
+	SetUser(connectionID, userID string)
+	UserID(connectionID string) string
+	InvokeUser(userID string, target string, args []interface{})
+	InvokeUserWithAck(userID string, target string, args []interface{}, policy AckPolicy) error
+	Connection(connectionID string) (hubConnection, bool)
 }
 
 func newLifeTimeManager(info StructuredLogger) defaultHubLifetimeManager {
@@ -34,7 +65,16 @@ func newLifeTimeManager(info StructuredLogger) defaultHubLifetimeManager {
 type defaultHubLifetimeManager struct {
 	clients sync.Map
 	groups  sync.Map
+	tags    tagIndex
+	users   tagIndex
 	info    StructuredLogger
+	// groupBackplane replaces groups if it is set
+	groupBackplane GroupBackplane
+	// backplane receives all invocations which might be sent to connections of other servers
+	backplane Backplane
+	serverID  string
+	// sendFailureHandler is called for each connection to which an invocation could not be sent
+	sendFailureHandler func(connectionID string, target string, err error)
 }
 
 func (d *defaultHubLifetimeManager) OnConnected(conn hubConnection) {
@@ -43,30 +83,190 @@ func (d *defaultHubLifetimeManager) OnConnected(conn hubConnection) {
 
 func (d *defaultHubLifetimeManager) OnDisconnected(conn hubConnection) {
 	d.clients.Delete(conn.ConnectionID())
+	d.tags.removeConnection(conn.ConnectionID())
+	d.users.removeConnection(conn.ConnectionID())
+	if d.groupBackplane != nil {
+		d.groupBackplane.RemoveConnection(conn.ConnectionID())
+	}
 }
 
 func (d *defaultHubLifetimeManager) InvokeAll(target string, args []interface{}) {
+	d.invokeAllLocal(target, args)
+	d.publish(BackplaneAll, "", target, args)
+}
+
+func (d *defaultHubLifetimeManager) invokeAllLocal(target string, args []interface{}) {
 	d.clients.Range(func(key, value interface{}) bool {
-		_ = value.(hubConnection).SendInvocation("", target, args)
+		d.sendInvocation(value.(hubConnection), target, args)
 		return true
 	})
 }
 
 func (d *defaultHubLifetimeManager) InvokeClient(connectionID string, target string, args []interface{}) {
+	if !d.invokeClientLocal(connectionID, target, args) {
+		d.publish(BackplaneClient, connectionID, target, args)
+	}
+}
+
+// invokeClientLocal sends to the connection if it is connected to this server. It returns false if not
+func (d *defaultHubLifetimeManager) invokeClientLocal(connectionID string, target string, args []interface{}) bool {
+	if client, ok := d.clients.Load(connectionID); ok {
+		d.sendInvocation(client.(hubConnection), target, args)
+		return true
+	}
+	return false
+}
+
+// publish publishes the invocation to the backplane, if there is any
+func (d *defaultHubLifetimeManager) publish(kind BackplaneMessageKind, recipient string, target string, args []interface{}) {
+	if d.backplane != nil {
+		d.backplane.Publish(BackplaneMessage{
+			ServerID:  d.serverID,
+			Kind:      kind,
+			Recipient: recipient,
+			Target:    target,
+			Args:      args,
+		})
+	}
+}
+
+// receive delivers a message from the backplane to the connections of this server
+func (d *defaultHubLifetimeManager) receive(message BackplaneMessage) {
+	if message.ServerID == d.serverID {
+		return
+	}
+	switch message.Kind {
+	case BackplaneAll:
+		d.invokeAllLocal(message.Target, message.Args)
+	case BackplaneUser:
+		d.invokeUserLocal(message.Recipient, message.Target, message.Args)
+	case BackplaneClient:
+		d.invokeClientLocal(message.Recipient, message.Target, message.Args)
+	}
+}
+
+func (d *defaultHubLifetimeManager) Connection(connectionID string) (hubConnection, bool) {
 	if client, ok := d.clients.Load(connectionID); ok {
-		_ = client.(hubConnection).SendInvocation("", target, args)
+		return client.(hubConnection), true
 	}
+	return nil, false
 }
 
 func (d *defaultHubLifetimeManager) InvokeGroup(groupName string, target string, args []interface{}) {
+	if d.groupBackplane != nil {
+		d.groupBackplane.SendToGroup(groupName, target, args)
+		return
+	}
+	if groups, ok := d.groups.Load(groupName); ok {
+		for _, v := range groups.(map[string]hubConnection) {
+			d.sendInvocation(v, target, args)
+		}
+	}
+}
+
+func (d *defaultHubLifetimeManager) InvokeAllWithAck(target string, args []interface{}, policy AckPolicy) error {
+	conns := make([]hubConnection, 0)
+	d.clients.Range(func(key, value interface{}) bool {
+		conns = append(conns, value.(hubConnection))
+		return true
+	})
+	return invokeWithAck(conns, target, args, policy)
+}
+
+func (d *defaultHubLifetimeManager) InvokeClientWithAck(connectionID string, target string, args []interface{}, policy AckPolicy) error {
+	client, ok := d.clients.Load(connectionID)
+	if !ok {
+		return fmt.Errorf("unknown connection %v", connectionID)
+	}
+	return client.(hubConnection).SendInvocationWithAck(target, args, policy)
+}
+
+func (d *defaultHubLifetimeManager) InvokeGroupWithAck(groupName string, target string, args []interface{}, policy AckPolicy) error {
+	if d.groupBackplane != nil {
+		return errors.New("SendWithAck to a group is not supported with a GroupBackplane")
+	}
+	conns := make([]hubConnection, 0)
 	if groups, ok := d.groups.Load(groupName); ok {
 		for _, v := range groups.(map[string]hubConnection) {
-			_ = v.SendInvocation("", target, args)
+			conns = append(conns, v)
 		}
 	}
+	return invokeWithAck(conns, target, args, policy)
+}
+
+func (d *defaultHubLifetimeManager) InvokeGroupExcept(groupName string, excludedConnectionIDs []string, target string, args []interface{}) {
+	if d.groupBackplane != nil {
+		d.groupBackplane.SendToGroupExcept(groupName, excludedConnectionIDs, target, args)
+		return
+	}
+	for _, conn := range d.groupMembersExcept(groupName, excludedConnectionIDs) {
+		d.sendInvocation(conn, target, args)
+	}
+}
+
+func (d *defaultHubLifetimeManager) InvokeGroupExceptWithAck(groupName string, excludedConnectionIDs []string, target string, args []interface{}, policy AckPolicy) error {
+	if d.groupBackplane != nil {
+		return errors.New("excluding connections is not supported with a GroupBackplane")
+	}
+	return invokeWithAck(d.groupMembersExcept(groupName, excludedConnectionIDs), target, args, policy)
+}
+
+// groupMembersExcept returns the connections in the group which are not excluded
+func (d *defaultHubLifetimeManager) groupMembersExcept(groupName string, excludedConnectionIDs []string) []hubConnection {
+	excluded := make(map[string]struct{}, len(excludedConnectionIDs))
+	for _, connectionID := range excludedConnectionIDs {
+		excluded[connectionID] = struct{}{}
+	}
+	conns := make([]hubConnection, 0)
+	if groups, ok := d.groups.Load(groupName); ok {
+		for connectionID, conn := range groups.(map[string]hubConnection) {
+			if _, ok := excluded[connectionID]; !ok {
+				conns = append(conns, conn)
+			}
+		}
+	}
+	return conns
+}
+
+// sendInvocation sends the invocation to conn. A failed send is reported to the sendFailureHandler
+// and does not stop sending to the other connections of a broadcast
+func (d *defaultHubLifetimeManager) sendInvocation(conn hubConnection, target string, args []interface{}) {
+	if err := conn.SendInvocation("", target, args); err != nil && d.sendFailureHandler != nil {
+		d.sendFailureHandler(conn.ConnectionID(), target, err)
+	}
+}
+
+// invokeWithAck sends the invocation to all conns in parallel and returns the first error
+func invokeWithAck(conns []hubConnection, target string, args []interface{}, policy AckPolicy) error {
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn hubConnection) {
+			errs <- conn.SendInvocationWithAck(target, args, policy)
+		}(conn)
+	}
+	var err error
+	for range conns {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (d *defaultHubLifetimeManager) ConnectionInfos() []ConnectionInfo {
+	infos := make([]ConnectionInfo, 0)
+	d.clients.Range(func(key, value interface{}) bool {
+		infos = append(infos, value.(hubConnection).ConnectionInfo())
+		return true
+	})
+	return infos
 }
 
 func (d *defaultHubLifetimeManager) AddToGroup(groupName string, connectionID string) {
+	if d.groupBackplane != nil {
+		d.groupBackplane.AddToGroup(groupName, connectionID)
+		return
+	}
 	if client, ok := d.clients.Load(connectionID); ok {
 		groups, _ := d.groups.LoadOrStore(groupName, make(map[string]hubConnection))
 		groups.(map[string]hubConnection)[connectionID] = client.(hubConnection)
@@ -74,7 +274,61 @@ func (d *defaultHubLifetimeManager) AddToGroup(groupName string, connectionID st
 }
 
 func (d *defaultHubLifetimeManager) RemoveFromGroup(groupName string, connectionID string) {
+	if d.groupBackplane != nil {
+		d.groupBackplane.RemoveFromGroup(groupName, connectionID)
+		return
+	}
 	if groups, ok := d.groups.Load(groupName); ok {
 		delete(groups.(map[string]hubConnection), connectionID)
 	}
 }
+
+func (d *defaultHubLifetimeManager) SetTag(connectionID, key, value string) {
+	if client, ok := d.clients.Load(connectionID); ok {
+		d.tags.set(client.(hubConnection), key, value)
+	}
+}
+
+func (d *defaultHubLifetimeManager) RemoveTag(connectionID, key string) {
+	d.tags.remove(connectionID, key)
+}
+
+func (d *defaultHubLifetimeManager) InvokeTagged(key, value string, target string, args []interface{}) {
+	for _, conn := range d.tags.connections(key, value) {
+		d.sendInvocation(conn, target, args)
+	}
+}
+
+func (d *defaultHubLifetimeManager) InvokeTaggedWithAck(key, value string, target string, args []interface{}, policy AckPolicy) error {
+	return invokeWithAck(d.tags.connections(key, value), target, args, policy)
+}
+
+// userKey is the key of the users tagIndex. Users are held in their own index,
+// so they can not be overwritten or removed with SetTag and RemoveTag
+const userKey = "user"
+
+func (d *defaultHubLifetimeManager) SetUser(connectionID, userID string) {
+	if client, ok := d.clients.Load(connectionID); ok {
+		d.users.set(client.(hubConnection), userKey, userID)
+	}
+}
+
+func (d *defaultHubLifetimeManager) UserID(connectionID string) string {
+	userID, _ := d.users.value(connectionID, userKey)
+	return userID
+}
+
+func (d *defaultHubLifetimeManager) InvokeUser(userID string, target string, args []interface{}) {
+	d.invokeUserLocal(userID, target, args)
+	d.publish(BackplaneUser, userID, target, args)
+}
+
+func (d *defaultHubLifetimeManager) invokeUserLocal(userID string, target string, args []interface{}) {
+	for _, conn := range d.users.connections(userKey, userID) {
+		d.sendInvocation(conn, target, args)
+	}
+}
+
+func (d *defaultHubLifetimeManager) InvokeUserWithAck(userID string, target string, args []interface{}, policy AckPolicy) error {
+	return invokeWithAck(d.users.connections(userKey, userID), target, args, policy)
+}
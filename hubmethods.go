@@ -0,0 +1,100 @@
+package signalr
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// invokableMethodsCache caches the result of invokableMethods per target type
+var invokableMethodsCache sync.Map
+
+var (
+	hubInterfaceType = reflect.TypeOf((*HubInterface)(nil)).Elem()
+	baseHubType      = reflect.TypeOf(&Hub{})
+)
+
+// reservedMethods can never be invoked by clients, even if the hub declares them itself.
+// These are the lifecycle methods, which are called by the server, and the methods which give access to the HubContext.
+var reservedMethods = map[string]bool{
+	"Initialize":               true,
+	"OnConnected":              true,
+	"OnDisconnected":           true,
+	"OnDisconnectedWithReason": true,
+	"Clients":                  true,
+	"Groups":                   true,
+	"Context":                  true,
+}
+
+// invokableMethods returns the indexes of the methods of targetType by their lower case names.
+// Methods promoted through any level of embedding are invokable, but for hubs
+//   - the reservedMethods are not invokable
+//   - methods of the embedded Hub are only invokable if the hub declares them itself, e.g. an Abort method of the hub
+func invokableMethods(targetType reflect.Type) map[string]int {
+	if methods, ok := invokableMethodsCache.Load(targetType); ok {
+		return methods.(map[string]int)
+	}
+	isHub := targetType.Implements(hubInterfaceType)
+	methods := make(map[string]int)
+	for i := 0; i < targetType.NumMethod(); i++ {
+		m := targetType.Method(i)
+		if isHub && (reservedMethods[m.Name] || declaringType(targetType, m.Name) == baseHubType) {
+			continue
+		}
+		name := strings.ToLower(m.Name)
+		if _, ok := methods[name]; !ok {
+			methods[name] = i
+		}
+	}
+	cached, _ := invokableMethodsCache.LoadOrStore(targetType, methods)
+	return cached.(map[string]int)
+}
+
+// declaringType returns the type which declares the method name of t, following promotions through embedded fields.
+func declaringType(t reflect.Type, name string) reflect.Type {
+	if m, ok := t.MethodByName(name); ok && !isPromotionWrapper(m) {
+		return t
+	}
+	if t.Kind() == reflect.Ptr {
+		// Methods with value receivers are wrapped for the pointer type
+		if m, ok := t.Elem().MethodByName(name); ok && !isPromotionWrapper(m) {
+			return t
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return t
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Interface {
+			if _, ok := fieldType.MethodByName(name); ok {
+				return fieldType
+			}
+			continue
+		}
+		if fieldType.Kind() != reflect.Ptr {
+			fieldType = reflect.PtrTo(fieldType)
+		}
+		if _, ok := fieldType.MethodByName(name); ok {
+			return declaringType(fieldType, name)
+		}
+	}
+	return t
+}
+
+// isPromotionWrapper reports if m is not declared in the source, but generated by the compiler
+// to call a method of an embedded field or a method with value receiver through a pointer.
+func isPromotionWrapper(m reflect.Method) bool {
+	fn := runtime.FuncForPC(m.Func.Pointer())
+	if fn == nil {
+		return false
+	}
+	file, _ := fn.FileLine(fn.Entry())
+	return file == "<autogenerated>"
+}
@@ -2,7 +2,11 @@ package signalr
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"reflect"
 )
 
 // hubProtocol interface
@@ -15,9 +19,128 @@ type hubProtocol interface {
 	WriteMessage(message interface{}, writer io.Writer) error
 	UnmarshalArgument(src interface{}, dst interface{}) error
 	setDebugLogger(dbg StructuredLogger)
+	setMaxArguments(max int)
+	setIdentifierLimits(limits identifierLimits)
 	transferMode() TransferMode
 }
 
+// tooManyArgumentsError is returned by ParseMessages when an invocation has more arguments than allowed by MaxArguments
+func tooManyArgumentsError(max int) error {
+	return fmt.Errorf("invocation has more than %v arguments", max)
+}
+
+// identifierLimits are the maximum lengths in bytes of the targets and the invocation ids of received messages,
+// see MaxTargetLength and MaxInvocationIDLength. 0 means unlimited
+type identifierLimits struct {
+	target       uint
+	invocationID uint
+}
+
+// check returns a protocol error if the target or an invocation or stream id of the parsed message is too long.
+// The error does not contain the value, so oversized values are not logged.
+func (i identifierLimits) check(message interface{}) error {
+	var target string
+	var ids []string
+	switch m := message.(type) {
+	case invocationMessage:
+		target = m.Target
+		ids = append([]string{m.InvocationID}, m.StreamIds...)
+	case streamItemMessage:
+		ids = []string{m.InvocationID}
+	case completionMessage:
+		ids = []string{m.InvocationID}
+	case cancelInvocationMessage:
+		ids = []string{m.InvocationID}
+	}
+	if i.target > 0 && uint(len(target)) > i.target {
+		return fmt.Errorf("%w: target of %v bytes is longer than %v bytes", ErrProtocolViolation, len(target), i.target)
+	}
+	for _, id := range ids {
+		if i.invocationID > 0 && uint(len(id)) > i.invocationID {
+			return fmt.Errorf("%w: id of %v bytes is longer than %v bytes", ErrProtocolViolation, len(id), i.invocationID)
+		}
+	}
+	return nil
+}
+
+// ErrIncompleteFrame is returned when reading from a connection fails while a frame was only partially received,
+// e.g. when the connection was closed in the middle of a frame. The error also wraps the error of the connection.
+var ErrIncompleteFrame = errors.New("incomplete frame")
+
+type incompleteFrameError struct {
+	err  error
+	size int
+}
+
+func (e *incompleteFrameError) Error() string {
+	return fmt.Sprintf("%v (%v bytes received): %v", ErrIncompleteFrame, e.size, e.err)
+}
+
+func (e *incompleteFrameError) Is(target error) bool {
+	return target == ErrIncompleteFrame
+}
+
+func (e *incompleteFrameError) Unwrap() error {
+	return e.err
+}
+
+// unmarshalValue unmarshals the raw value src, which was received by the protocol, into a new value of type t.
+// Hub method arguments, stream items and completion results are all converted by unmarshalValue,
+// so primitive values are converted the same way, regardless in which message they were received.
+// If t is the type of the raw value (e.g. json.RawMessage with the JSON protocol), src is passed through undecoded.
+// If t is RawArgument, src is wrapped undecoded, so it can be unmarshaled on demand.
+func unmarshalValue(protocol hubProtocol, src interface{}, t reflect.Type) (reflect.Value, error) {
+	if t == rawArgumentType {
+		return reflect.ValueOf(RawArgument{value: src, protocol: protocol}), nil
+	}
+	if t.Kind() != reflect.Interface && reflect.TypeOf(src) == t {
+		return reflect.ValueOf(src), nil
+	}
+	value := reflect.New(t)
+	if err := protocol.UnmarshalArgument(src, value.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return value.Elem(), nil
+}
+
+// unmarshalResult unmarshals the result of a completion into a value of type t.
+// Methods with multiple results send them as array. Such a result can be unmarshaled into a slice
+// or into a struct, which receives the items in the order of its serialized fields.
+func unmarshalResult(protocol hubProtocol, src interface{}, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Struct && !isCustomSerialized(t) {
+		// The items of the array are unmarshaled into raw values of the same type as src
+		items := reflect.New(reflect.SliceOf(reflect.TypeOf(src)))
+		if err := protocol.UnmarshalArgument(src, items.Interface()); err == nil {
+			return unmarshalArrayIntoStruct(protocol, items.Elem(), t)
+		}
+	}
+	return unmarshalValue(protocol, src, t)
+}
+
+func unmarshalArrayIntoStruct(protocol hubProtocol, items reflect.Value, t reflect.Type) (reflect.Value, error) {
+	value := reflect.New(t).Elem()
+	fields := make([]int, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.PkgPath == "" && field.Tag.Get("json") != "-" {
+			fields = append(fields, i)
+		}
+	}
+	if items.Len() != len(fields) {
+		return reflect.Value{}, fmt.Errorf("array result with %v items can not be unmarshaled into %v with %v fields", items.Len(), t, len(fields))
+	}
+	for i, field := range fields {
+		item, err := unmarshalValue(protocol, items.Index(i).Interface(), t.Field(field).Type)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("item %v of array result: %w", i, err)
+		}
+		value.Field(field).Set(item)
+	}
+	return value, nil
+}
+
+// interfaceType is the reflect.Type of interface{}, used when no specific type for a value is known
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
 //easyjson:json
 type hubMessage struct {
 	Type int `json:"type"`
@@ -25,28 +148,34 @@ type hubMessage struct {
 
 // easyjson:json
 type invocationMessage struct {
-	Type         int           `json:"type"`
-	Target       string        `json:"target"`
-	InvocationID string        `json:"invocationId,omitempty"`
-	Arguments    []interface{} `json:"arguments"`
-	StreamIds    []string      `json:"streamIds,omitempty"`
+	Type         int               `json:"type"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Target       string            `json:"target"`
+	InvocationID string            `json:"invocationId,omitempty"`
+	Arguments    []interface{}     `json:"arguments"`
+	StreamIds    []string          `json:"streamIds,omitempty"`
 }
 
 //easyjson:json
 type completionMessage struct {
-	Type         int         `json:"type"`
-	InvocationID string      `json:"invocationId"`
-	Result       interface{} `json:"result,omitempty"`
-	Error        string      `json:"error,omitempty"`
+	Type         int               `json:"type"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	InvocationID string            `json:"invocationId"`
+	Result       interface{}       `json:"result,omitempty"`
+	Error        string            `json:"error,omitempty"`
 }
 
 //easyjson:json
 type streamItemMessage struct {
-	Type         int         `json:"type"`
-	InvocationID string      `json:"invocationId"`
-	Item         interface{} `json:"item"`
+	Type         int               `json:"type"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	InvocationID string            `json:"invocationId"`
+	Item         interface{}       `json:"item"`
 }
 
+// streamSequenceHeader is the header of stream items which holds the sequence number of the item, see StreamItemSequence
+const streamSequenceHeader = "sequence"
+
 //easyjson:json
 type cancelInvocationMessage struct {
 	Type         int    `json:"type"`
@@ -60,13 +189,33 @@ type closeMessage struct {
 	AllowReconnect bool   `json:"allowReconnect"`
 }
 
+//easyjson:json
+type ackMessage struct {
+	Type       int    `json:"type"`
+	SequenceID uint64 `json:"sequenceId"`
+}
+
+//easyjson:json
+type sequenceMessage struct {
+	Type       int    `json:"type"`
+	SequenceID uint64 `json:"sequenceId"`
+}
+
 //easyjson:json
 type handshakeRequest struct {
 	Protocol string `json:"protocol"`
 	Version  int    `json:"version"`
+	// Type is only set if the other party has sent a hub message instead of the handshake request
+	Type int `json:"type,omitempty"`
+	// extraFields are the fields besides protocol, version and type. They are only read if needed, see HandshakeExtension
+	extraFields map[string]json.RawMessage
+	// remainder holds the bytes which have been read after the handshake request
+	remainder []byte
 }
 
 //easyjson:json
 type handshakeResponse struct {
 	Error string `json:"error,omitempty"`
+	// Type is only set if the other party has sent a hub message instead of the handshake response
+	Type int `json:"type,omitempty"`
 }
@@ -2,12 +2,16 @@ package signalr
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -20,6 +24,7 @@ var _ = Describe("Protocol", func() {
 	for _, p := range []hubProtocol{
 		&jsonHubProtocol{},
 		&messagePackHubProtocol{},
+		&protobufHubProtocol{},
 	} {
 		protocol := p
 		protocol.setDebugLogger(testLogger())
@@ -80,6 +85,7 @@ var _ = Describe("Protocol", func() {
 					{Type: 2, InvocationID: "6", Item: []int{1, 2, 3}},
 					{Type: 2, InvocationID: "7", Item: map[string]int{"1": 4, "2": 5, "3": 6}},
 					{Type: 2, InvocationID: "9"},
+					{Type: 2, InvocationID: "10", Item: 3, Headers: map[string]string{"sequence": "1"}},
 				} {
 					want := w
 					It(fmt.Sprintf("should be equal after roundtrip of %#v", want), func(done Done) {
@@ -92,6 +98,7 @@ var _ = Describe("Protocol", func() {
 						Expect(got[0]).To(BeAssignableToTypeOf(streamItemMessage{}))
 						gotMsg := got[0].(streamItemMessage)
 						Expect(gotMsg.InvocationID).To(Equal(want.InvocationID))
+						Expect(gotMsg.Headers).To(Equal(want.Headers))
 						if want.Item == nil {
 							var v interface{}
 							Expect(protocol.UnmarshalArgument(gotMsg.Item, &v)).NotTo(HaveOccurred())
@@ -119,6 +126,8 @@ var _ = Describe("Protocol", func() {
 					{Type: 3, InvocationID: "7", Result: map[string]int{"1": 4, "2": 5, "3": 6}},
 					{Type: 3, InvocationID: "8"},
 					{Type: 3, InvocationID: "9", Error: "Failed"},
+					{Type: 3, InvocationID: "10", Result: 3, Headers: map[string]string{"trace-id": "t1"}},
+					{Type: 3, InvocationID: "11", Error: "Failed", Headers: map[string]string{"trace-id": "t2"}},
 				} {
 					want := w
 					It(fmt.Sprintf("should be equal after roundtrip of %#v", want), func(done Done) {
@@ -131,6 +140,7 @@ var _ = Describe("Protocol", func() {
 						Expect(got[0]).To(BeAssignableToTypeOf(completionMessage{}))
 						gotMsg := got[0].(completionMessage)
 						Expect(gotMsg.InvocationID).To(Equal(want.InvocationID))
+						Expect(gotMsg.Headers).To(Equal(want.Headers))
 						if want.Result == nil {
 							// Important: In contrast to StreamItemMessage a nil Result is not transmitted
 							// So if a stream ends with a nil item,
@@ -150,6 +160,26 @@ var _ = Describe("Protocol", func() {
 					})
 				}
 			})
+			Context("AckMessage and SequenceMessage", func() {
+				for _, w := range []interface{}{
+					ackMessage{Type: 8, SequenceID: 1},
+					ackMessage{Type: 8, SequenceID: 0xffffffffff},
+					sequenceMessage{Type: 9, SequenceID: 1},
+					sequenceMessage{Type: 9, SequenceID: 1394},
+				} {
+					want := w
+					It(fmt.Sprintf("should be equal after roundtrip of %#v", want), func(done Done) {
+						buf := bytes.Buffer{}
+						Expect(protocol.WriteMessage(want, &buf)).NotTo(HaveOccurred())
+						var remainBuf bytes.Buffer
+						got, err := protocol.ParseMessages(&buf, &remainBuf)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(len(got)).To(Equal(1))
+						Expect(got[0]).To(Equal(want))
+						close(done)
+					})
+				}
+			})
 			Context("Multiple messages", func() {
 				It("should parse multiple messages sent in one step", func(done Done) {
 					buf := bytes.Buffer{}
@@ -210,10 +240,279 @@ var _ = Describe("Protocol", func() {
 					Expect(err).NotTo(HaveOccurred())
 				}, 2.0)
 			})
+			Context("Incomplete messages", func() {
+				closed := errors.New("closed")
+				It("should return ErrIncompleteFrame when the reader fails in the middle of a frame", func(done Done) {
+					messageBuf := &bytes.Buffer{}
+					streamItem := streamItemMessage{Type: 2, InvocationID: "2", Item: "ABCDEFGHIJKLMNOP"}
+					Expect(protocol.WriteMessage(streamItem, messageBuf)).NotTo(HaveOccurred())
+					reader := &failingReader{data: messageBuf.Bytes()[:messageBuf.Len()/2], err: closed}
+					var remainBuf bytes.Buffer
+					_, err := protocol.ParseMessages(reader, &remainBuf)
+					Expect(errors.Is(err, ErrIncompleteFrame)).To(BeTrue())
+					Expect(errors.Is(err, closed)).To(BeTrue())
+					close(done)
+				})
+				It("should return the error of the reader when the reader fails after a complete frame", func(done Done) {
+					messageBuf := &bytes.Buffer{}
+					streamItem := streamItemMessage{Type: 2, InvocationID: "2", Item: "ABCDEFGHIJKLMNOP"}
+					Expect(protocol.WriteMessage(streamItem, messageBuf)).NotTo(HaveOccurred())
+					reader := &failingReader{data: messageBuf.Bytes(), err: closed}
+					var remainBuf bytes.Buffer
+					got, err := protocol.ParseMessages(reader, &remainBuf)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(len(got)).To(Equal(1))
+					_, err = protocol.ParseMessages(reader, &remainBuf)
+					Expect(err).To(Equal(closed))
+					close(done)
+				})
+			})
+			Context("Too long identifiers", func() {
+				// Each spec limits its own protocol, because the shared one is used by the other specs
+				newProtocol := func(limits identifierLimits) hubProtocol {
+					p := reflect.New(reflect.TypeOf(protocol).Elem()).Interface().(hubProtocol)
+					p.setDebugLogger(testLogger())
+					p.setIdentifierLimits(limits)
+					return p
+				}
+				It("should reject an invocation with a target longer than the limit", func(done Done) {
+					protocol := newProtocol(identifierLimits{target: 100})
+					buf := bytes.Buffer{}
+					Expect(protocol.WriteMessage(invocationMessage{
+						Type:   1,
+						Target: strings.Repeat("A", 101),
+					}, &buf)).NotTo(HaveOccurred())
+					var remainBuf bytes.Buffer
+					_, err := protocol.ParseMessages(&buf, &remainBuf)
+					Expect(errors.Is(err, ErrProtocolViolation)).To(BeTrue())
+					Expect(err.Error()).NotTo(ContainSubstring("AAA"))
+					close(done)
+				})
+				It("should reject a completion with an invocation id longer than the limit", func(done Done) {
+					protocol := newProtocol(identifierLimits{invocationID: 100})
+					buf := bytes.Buffer{}
+					Expect(protocol.WriteMessage(completionMessage{
+						Type:         3,
+						InvocationID: strings.Repeat("1", 101),
+					}, &buf)).NotTo(HaveOccurred())
+					var remainBuf bytes.Buffer
+					_, err := protocol.ParseMessages(&buf, &remainBuf)
+					Expect(errors.Is(err, ErrProtocolViolation)).To(BeTrue())
+					close(done)
+				})
+				It("should accept identifiers with the maximum length", func(done Done) {
+					protocol := newProtocol(identifierLimits{target: 100, invocationID: 100})
+					buf := bytes.Buffer{}
+					Expect(protocol.WriteMessage(invocationMessage{
+						Type:         1,
+						InvocationID: strings.Repeat("1", 100),
+						Target:       strings.Repeat("A", 100),
+					}, &buf)).NotTo(HaveOccurred())
+					var remainBuf bytes.Buffer
+					got, err := protocol.ParseMessages(&buf, &remainBuf)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(got[0].(invocationMessage).Target).To(HaveLen(100))
+					close(done)
+				})
+			})
+			Context("Too many arguments", func() {
+				// Each spec limits its own protocol, because the shared one is used by the other specs
+				newProtocol := func(maxArguments int) hubProtocol {
+					p := reflect.New(reflect.TypeOf(protocol).Elem()).Interface().(hubProtocol)
+					p.setDebugLogger(testLogger())
+					p.setMaxArguments(maxArguments)
+					return p
+				}
+				It("should reject an invocation with more arguments than maxArguments", func(done Done) {
+					protocol := newProtocol(100)
+					buf := bytes.Buffer{}
+					Expect(protocol.WriteMessage(invocationMessage{
+						Type:      1,
+						Target:    "A",
+						Arguments: make([]interface{}, 5000),
+					}, &buf)).NotTo(HaveOccurred())
+					var remainBuf bytes.Buffer
+					_, err := protocol.ParseMessages(&buf, &remainBuf)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("more than 100 arguments"))
+					close(done)
+				})
+				It("should accept an invocation with maxArguments arguments", func(done Done) {
+					protocol := newProtocol(100)
+					buf := bytes.Buffer{}
+					Expect(protocol.WriteMessage(invocationMessage{
+						Type:      1,
+						Target:    "A",
+						Arguments: make([]interface{}, 100),
+					}, &buf)).NotTo(HaveOccurred())
+					var remainBuf bytes.Buffer
+					got, err := protocol.ParseMessages(&buf, &remainBuf)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(got[0].(invocationMessage).Arguments).To(HaveLen(100))
+					close(done)
+				})
+			})
+			Context("Pointer arguments", func() {
+				It("should allocate the value for an object and use nil for null", func(done Done) {
+					buf := bytes.Buffer{}
+					Expect(protocol.WriteMessage(invocationMessage{
+						Type:      1,
+						Target:    "A",
+						Arguments: []interface{}{simpleStruct{AsInt: 3, AsString: "3"}, nil},
+					}, &buf)).NotTo(HaveOccurred())
+					var remainBuf bytes.Buffer
+					got, err := protocol.ParseMessages(&buf, &remainBuf)
+					Expect(err).NotTo(HaveOccurred())
+					arguments := got[0].(invocationMessage).Arguments
+					t := reflect.TypeOf(&simpleStruct{})
+					value, err := unmarshalValue(protocol, arguments[0], t)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(value.Interface()).To(Equal(&simpleStruct{AsInt: 3, AsString: "3"}))
+					value, err = unmarshalValue(protocol, arguments[1], t)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(value.IsNil()).To(BeTrue())
+					close(done)
+				})
+			})
+		})
+	}
+})
+
+var _ = Describe("JSON HTML escaping", func() {
+	message := completionMessage{Type: 3, InvocationID: "1", Result: "<b>&</b>"}
+	newProtocol := func(noHTMLEscaping bool) *jsonHubProtocol {
+		protocol := &jsonHubProtocol{noHTMLEscaping: noHTMLEscaping}
+		protocol.setDebugLogger(testLogger())
+		return protocol
+	}
+	It("should escape <, > and & by default", func() {
+		buf := bytes.Buffer{}
+		Expect(newProtocol(false).WriteMessage(message, &buf)).NotTo(HaveOccurred())
+		Expect(buf.String()).To(ContainSubstring(`"result":"\u003cb\u003e\u0026\u003c/b\u003e"`))
+	})
+	It("should not escape <, > and & when HTML escaping is disabled", func() {
+		buf := bytes.Buffer{}
+		Expect(newProtocol(true).WriteMessage(message, &buf)).NotTo(HaveOccurred())
+		Expect(buf.String()).To(ContainSubstring(`"result":"<b>&</b>"`))
+		Expect(buf.Bytes()[buf.Len()-1]).To(Equal(byte(0x1e)))
+		Expect(buf.String()).NotTo(ContainSubstring("\n"))
+		var remainBuf bytes.Buffer
+		protocol := newProtocol(false)
+		got, err := protocol.ParseMessages(&buf, &remainBuf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(HaveLen(1))
+		var result string
+		Expect(protocol.UnmarshalArgument(got[0].(completionMessage).Result, &result)).NotTo(HaveOccurred())
+		Expect(result).To(Equal("<b>&</b>"))
+	})
+	It("should be disabled by the JSONEscapeHTML option", func() {
+		server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), JSONEscapeHTML(false), testLoggerOption())
+		Expect(err).NotTo(HaveOccurred())
+		l := newLoop(server.(Party), newTestingConnection(), &jsonHubProtocol{}, newConnectionStateMachine(stateConnected))
+		Expect(l.protocol.(*jsonHubProtocol).noHTMLEscaping).To(BeTrue())
+	})
+})
+
+var _ = Describe("JSON unknown fields", func() {
+	invokeSum := func(options ...func(Party) error) completionMessage {
+		server, err := NewServer(context.TODO(), append([]func(Party) error{SimpleHubFactory(&strictHub{}), testLoggerOption()}, options...)...)
+		Expect(err).NotTo(HaveOccurred())
+		conn := newTestingConnectionForServer()
+		go func() { _ = server.Serve(conn) }()
+		conn.ClientSend(`{"type":1,"invocationId":"1","target":"sum","arguments":[{"AI":3,"AS":"3","Unknown":true}]}`)
+		select {
+		case message := <-conn.received:
+			Expect(message).To(BeAssignableToTypeOf(completionMessage{}))
+			return message.(completionMessage)
+		case <-time.After(time.Second):
+			Fail("timed out")
+		}
+		return completionMessage{}
+	}
+	Context("When JSONDisallowUnknownFields is not set", func() {
+		It("should ignore unknown fields of struct arguments", func(done Done) {
+			completion := invokeSum()
+			Expect(completion.Error).To(BeEmpty())
+			Expect(completion.Result).To(BeEquivalentTo(3))
+			close(done)
+		}, 2.0)
+	})
+	Context("When JSONDisallowUnknownFields is set", func() {
+		It("should complete the invocation with a binding error", func(done Done) {
+			completion := invokeSum(JSONDisallowUnknownFields(true))
+			Expect(completion.Error).To(ContainSubstring(`unknown field "Unknown"`))
+			Expect(completion.Result).To(BeNil())
+			close(done)
+		}, 2.0)
+		It("should accept struct arguments without unknown fields", func() {
+			protocol := &jsonHubProtocol{disallowUnknownFields: true}
+			protocol.setDebugLogger(testLogger())
+			var value simpleStruct
+			Expect(protocol.UnmarshalArgument(json.RawMessage(`{"AI":3,"AS":"3"}`), &value)).NotTo(HaveOccurred())
+			Expect(value).To(Equal(simpleStruct{AsInt: 3, AsString: "3"}))
+		})
+	})
+})
+
+// failingMarshaler fails to marshal itself as set by its field: with an error, with invalid JSON or with a panic
+type failingMarshaler struct {
+	fail string
+}
+
+func (f failingMarshaler) MarshalJSON() ([]byte, error) {
+	switch f.fail {
+	case "error":
+		return nil, errors.New("marshaler failed")
+	case "invalid":
+		return []byte(`{"unterminated":`), nil
+	case "panic":
+		panic("marshaler panicked")
+	}
+	return []byte(`"ok"`), nil
+}
+
+var _ = Describe("JSON marshaler errors", func() {
+	for _, fail := range []string{"error", "invalid", "panic"} {
+		fail := fail
+		Context(fmt.Sprintf("When the json.Marshaler of a value fails with %v", fail), func() {
+			protocol := &jsonHubProtocol{}
+			protocol.setDebugLogger(testLogger())
+			It("should return ErrMarshalFailed and write nothing", func() {
+				buf := bytes.Buffer{}
+				err := protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "1", Result: failingMarshaler{fail: fail}}, &buf)
+				Expect(errors.Is(err, ErrMarshalFailed)).To(BeTrue())
+				Expect(buf.Len()).To(BeZero())
+			})
+			It("should write the following messages completely", func() {
+				buf := bytes.Buffer{}
+				Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "1", Result: failingMarshaler{fail: fail}}, &buf)).To(HaveOccurred())
+				Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "2", Result: failingMarshaler{}}, &buf)).NotTo(HaveOccurred())
+				Expect(buf.String()).To(Equal(`{"type":3,"invocationId":"2","result":"ok"}` + "\x1e"))
+			})
+			It("should return ErrMarshalFailed when the message itself fails", func() {
+				buf := bytes.Buffer{}
+				Expect(errors.Is(protocol.WriteMessage(failingMarshaler{fail: fail}, &buf), ErrMarshalFailed)).To(BeTrue())
+				Expect(buf.Len()).To(BeZero())
+			})
 		})
 	}
 })
 
+// failingReader returns data and then fails with err
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
 func TestDevParse(t *testing.T) {
 	if err := devParse(); err != nil {
 		t.Error(err)
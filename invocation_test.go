@@ -1,8 +1,14 @@
 package signalr
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -34,6 +40,30 @@ func (i *invocationHub) SimpleString(value1 string, value2 string) string {
 	return strings.ToLower(value1 + value2)
 }
 
+type codedError struct {
+	Code   int
+	Reason string
+}
+
+func (c *codedError) Error() string {
+	return c.Reason
+}
+
+func (c *codedError) ErrorDetails() interface{} {
+	return map[string]interface{}{"code": c.Code}
+}
+
+func (i *invocationHub) Divide(a, b int, coded bool) (int, error) {
+	invocationQueue <- fmt.Sprintf("Divide(%v, %v)", a, b)
+	if b == 0 {
+		if coded {
+			return 0, &codedError{Code: 4711, Reason: "division by zero"}
+		}
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
 func (i *invocationHub) Async() chan bool {
 	r := make(chan bool)
 	go func() {
@@ -51,11 +81,255 @@ func (i *invocationHub) AsyncClosedChan() chan bool {
 	return r
 }
 
+func (i *invocationHub) NilChan() chan int {
+	invocationQueue <- "NilChan()"
+	return nil
+}
+
+var blockingInvocationRelease = make(chan struct{})
+
+func (i *invocationHub) Blocking() int {
+	invocationQueue <- "Blocking()"
+	<-blockingInvocationRelease
+	return 1
+}
+
 func (i *invocationHub) Panic() {
 	invocationQueue <- "Panic()"
 	panic("Don't panic!")
 }
 
+type boundArguments struct {
+	Name   string
+	Count  int
+	Factor float64
+}
+
+func (i *invocationHub) Bound(args boundArguments) string {
+	invocationQueue <- fmt.Sprintf("Bound(%v, %v, %v)", args.Name, args.Count, args.Factor)
+	return fmt.Sprintf("%v:%v", args.Name, float64(args.Count)*args.Factor)
+}
+
+func (i *invocationHub) Raw(raw json.RawMessage) string {
+	invocationQueue <- "Raw()"
+	return string(raw)
+}
+
+func (i *invocationHub) Lazy(raw RawArgument) (string, error) {
+	var name struct {
+		Name string
+	}
+	if err := raw.Unmarshal(&name); err != nil {
+		return "", err
+	}
+	return name.Name, nil
+}
+
+func (i *invocationHub) BigInt(value *big.Int) string {
+	return value.String()
+}
+
+func (i *invocationHub) Deadline(ctx context.Context, wait bool) string {
+	if !wait {
+		return "no wait"
+	}
+	<-ctx.Done()
+	invocationQueue <- fmt.Sprintf("Deadline(%v)", ctx.Err())
+	return "too late"
+}
+
+func (i *invocationHub) DeadlineStream(ctx context.Context) <-chan int {
+	<-ctx.Done()
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < 3; i++ {
+			ch <- i
+		}
+		close(ch)
+		invocationQueue <- "DeadlineStream()"
+	}()
+	return ch
+}
+
+func (i *invocationHub) Pointer(args *boundArguments) string {
+	if args == nil {
+		invocationQueue <- "Pointer(nil)"
+		return "nil"
+	}
+	invocationQueue <- "Pointer()"
+	return fmt.Sprintf("%v:%v", args.Name, args.Count)
+}
+
+func (i *invocationHub) Slice(values []int) string {
+	invocationQueue <- "Slice()"
+	if values == nil {
+		return "nil"
+	}
+	return fmt.Sprint(values)
+}
+
+func (i *invocationHub) NestedSlice(values [][]int) string {
+	invocationQueue <- "NestedSlice()"
+	return fmt.Sprint(values)
+}
+
+func (i *invocationHub) StructSlice(values []boundArguments) string {
+	invocationQueue <- "StructSlice()"
+	names := make([]string, len(values))
+	for j, value := range values {
+		names[j] = fmt.Sprintf("%v:%v", value.Name, value.Count)
+	}
+	return strings.Join(names, ",")
+}
+
+type sequentialHub struct {
+	Hub
+}
+
+var sequentialMx sync.Mutex
+var sequentialRunning bool
+var sequentialOverlapped bool
+var sequentialOrder []int
+
+func (s *sequentialHub) Sequential(value int) {
+	sequentialMx.Lock()
+	if sequentialRunning {
+		sequentialOverlapped = true
+	}
+	sequentialRunning = true
+	sequentialOrder = append(sequentialOrder, value)
+	sequentialMx.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	sequentialMx.Lock()
+	sequentialRunning = false
+	sequentialMx.Unlock()
+}
+
+type serializedHub struct {
+	Hub
+}
+
+var serializedRunning int32
+var serializedOverlapped int32
+
+func (s *serializedHub) Serialized() {
+	if atomic.AddInt32(&serializedRunning, 1) > 1 {
+		atomic.StoreInt32(&serializedOverlapped, 1)
+	}
+	time.Sleep(5 * time.Millisecond)
+	atomic.AddInt32(&serializedRunning, -1)
+}
+
+func (s *serializedHub) CatchAll(target string, args []RawArgument) {
+	s.Serialized()
+}
+
+type unsupportedResultHub struct {
+	Hub
+}
+
+type hiddenResult struct {
+	value int
+}
+
+type callbackResult struct {
+	Name     string
+	Callback func()
+}
+
+func (u *unsupportedResultHub) ChanOfChan() chan chan int {
+	invocationQueue <- "ChanOfChan()"
+	return make(chan chan int)
+}
+
+func (u *unsupportedResultHub) Func() func() {
+	invocationQueue <- "Func()"
+	return func() {}
+}
+
+func (u *unsupportedResultHub) Hidden() hiddenResult {
+	invocationQueue <- "Hidden()"
+	return hiddenResult{value: 1}
+}
+
+func (u *unsupportedResultHub) Callback() (int, callbackResult) {
+	invocationQueue <- "Callback()"
+	return 1, callbackResult{}
+}
+
+func (u *unsupportedResultHub) SendOnly() chan<- int {
+	invocationQueue <- "SendOnly()"
+	return make(chan int)
+}
+
+// marshalerHub returns results which can not be marshaled
+type marshalerHub struct {
+	Hub
+}
+
+func (m *marshalerHub) Result(fail string) failingMarshaler {
+	return failingMarshaler{fail: fail}
+}
+
+func (m *marshalerHub) Stream(fail string) <-chan failingMarshaler {
+	ch := make(chan failingMarshaler, 2)
+	ch <- failingMarshaler{}
+	ch <- failingMarshaler{fail: fail}
+	close(ch)
+	return ch
+}
+
+type catchAllHub struct {
+	Hub
+}
+
+func (c *catchAllHub) Known() string {
+	return "known"
+}
+
+func (c *catchAllHub) Route(target string, args []RawArgument) (string, error) {
+	sum := 0
+	for _, arg := range args {
+		var value int
+		if err := arg.Unmarshal(&value); err != nil {
+			return "", err
+		}
+		sum += value
+	}
+	return fmt.Sprintf("%v:%v", target, sum), nil
+}
+
+// strictHub is used to test JSONDisallowUnknownFields. It is not declared in hubprotocol_test.go,
+// because devParse generates protocol code for all hubs declared there
+type strictHub struct {
+	Hub
+}
+
+func (s *strictHub) Sum(value simpleStruct) int {
+	return value.AsInt
+}
+
+// baseEmbeddedHub is a shared base hub which is embedded by derivedEmbeddedHub
+type baseEmbeddedHub struct {
+	Hub
+}
+
+func (b *baseEmbeddedHub) Echo(value string) string {
+	return value
+}
+
+func (b *baseEmbeddedHub) ConnectionID() string {
+	return "base"
+}
+
+func (b *baseEmbeddedHub) Clients() HubClients {
+	return b.Hub.Clients()
+}
+
+type derivedEmbeddedHub struct {
+	baseEmbeddedHub
+}
+
 var _ = Describe("Invocation", func() {
 
 	Describe("Simple invocation", func() {
@@ -84,17 +358,62 @@ var _ = Describe("Invocation", func() {
 		Context("When invoked by the client two times in one frame", func() {
 			It("should be invoked and return a completion", func(done Done) {
 				conn.ClientSend(`{"type":1,"invocationId": "123","target":"simple"}`)
-				conn.ClientSend(`{"type":1,"invocationId": "123","target":"simple"}`)
+				conn.ClientSend(`{"type":1,"invocationId": "124","target":"simple"}`)
 				Expect(<-invocationQueue).To(Equal("Simple()"))
 				Expect(<-invocationQueue).To(Equal("Simple()"))
+				ids := make([]string, 0, 2)
+				for i := 0; i < 2; i++ {
+					recv := (<-conn.received).(completionMessage)
+					Expect(recv).NotTo(BeNil())
+					Expect(recv.Result).To(BeNil())
+					Expect(recv.Error).To(Equal(""))
+					ids = append(ids, recv.InvocationID)
+				}
+				Expect(ids).To(ConsistOf("123", "124"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation with duplicate invocationId", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When a second invocation with the id of an active invocation is sent", func() {
+			It("should reject the second invocation and complete the first one", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "dup","target":"blocking"}`)
+				Expect(<-invocationQueue).To(Equal("Blocking()"))
+				conn.ClientSend(`{"type":1,"invocationId": "dup","target":"blocking"}`)
 				recv := (<-conn.received).(completionMessage)
-				Expect(recv).NotTo(BeNil())
-				Expect(recv.InvocationID).To(Equal("123"))
+				Expect(recv.InvocationID).To(Equal("dup"))
 				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).NotTo(Equal(""))
+				blockingInvocationRelease <- struct{}{}
+				recv = (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("dup"))
+				Expect(recv.Result).To(Equal(float64(1)))
 				Expect(recv.Error).To(Equal(""))
 				close(done)
 			}, 2.0)
 		})
+		Context("When the id of a completed invocation is reused", func() {
+			It("should invoke the method again", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "again","target":"simple"}`)
+				Expect(<-invocationQueue).To(Equal("Simple()"))
+				Expect((<-conn.received).(completionMessage).Error).To(Equal(""))
+				conn.ClientSend(`{"type":1,"invocationId": "again","target":"simple"}`)
+				Expect(<-invocationQueue).To(Equal("Simple()"))
+				Expect((<-conn.received).(completionMessage).Error).To(Equal(""))
+				close(done)
+			}, 2.0)
+		})
 	})
 
 	Describe("Non blocking invocation", func() {
@@ -228,6 +547,52 @@ var _ = Describe("Invocation", func() {
 		})
 	})
 
+	Describe("Invocation without arguments", func() {
+		for _, maxArguments := range []int{0, 5} {
+			maxArguments := maxArguments
+			Context(fmt.Sprintf("When MaxArguments is %v", maxArguments), func() {
+				var server Server
+				var conn *testingConnection
+				BeforeEach(func(done Done) {
+					var err error
+					server, err = NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}),
+						MaxArguments(maxArguments), testLoggerOption())
+					Expect(err).NotTo(HaveOccurred())
+					conn = newTestingConnectionForServer()
+					go func() { _ = server.Serve(conn) }()
+					close(done)
+				})
+				AfterEach(func(done Done) {
+					server.cancel()
+					close(done)
+				})
+				for _, arguments := range []string{``, `,"arguments":[]`, `,"arguments":null`} {
+					arguments := arguments
+					Context(fmt.Sprintf("When a method without parameters is invoked with arguments field %#v", arguments), func() {
+						It("should be invoked", func(done Done) {
+							conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "noargs","target":"simple"%v}`, arguments))
+							Expect(<-invocationQueue).To(Equal("Simple()"))
+							recv := (<-conn.received).(completionMessage)
+							Expect(recv.InvocationID).To(Equal("noargs"))
+							Expect(recv.Error).To(Equal(""))
+							close(done)
+						}, 2.0)
+					})
+					Context(fmt.Sprintf("When a method with parameters is invoked with arguments field %#v", arguments), func() {
+						It("should not be invoked and return an arity error", func(done Done) {
+							conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "noargs","target":"simpleint"%v}`, arguments))
+							recv := (<-conn.received).(completionMessage)
+							Expect(recv.InvocationID).To(Equal("noargs"))
+							Expect(recv.Error).To(Equal("parameter mismatch calling method simpleint: 1 arguments expected, but 0 received"))
+							Expect(invocationQueue).To(BeEmpty())
+							close(done)
+						}, 2.0)
+					})
+				}
+			})
+		}
+	})
+
 	Describe("SimpleFloat invocation", func() {
 		var server Server
 		var conn *testingConnection
@@ -255,6 +620,144 @@ var _ = Describe("Invocation", func() {
 		})
 	})
 
+	Describe("Invocation with ResultsAsArray", func() {
+		for _, asArray := range []bool{false, true} {
+			asArray := asArray
+			Context(fmt.Sprintf("When ResultsAsArray is %v", asArray), func() {
+				var server Server
+				var conn *testingConnection
+				BeforeEach(func(done Done) {
+					var err error
+					server, err = NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}),
+						ResultsAsArray(asArray), CompletionErrors(true), testLoggerOption())
+					Expect(err).NotTo(HaveOccurred())
+					conn = newTestingConnectionForServer()
+					go func() { _ = server.Serve(conn) }()
+					close(done)
+				})
+				AfterEach(func(done Done) {
+					server.cancel()
+					close(done)
+				})
+				It("should send single results in the configured shape", func(done Done) {
+					conn.ClientSend(`{"type":1,"invocationId": "int","target":"simpleint","arguments":[1]}`)
+					Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+					recv := (<-conn.received).(completionMessage)
+					if asArray {
+						Expect(recv.Result).To(Equal([]interface{}{2.0}))
+					} else {
+						Expect(recv.Result).To(Equal(2.0))
+					}
+					conn.ClientSend(`{"type":1,"invocationId": "divide","target":"divide","arguments":[6,3,false]}`)
+					Expect(<-invocationQueue).To(Equal("Divide(6, 3)"))
+					recv = (<-conn.received).(completionMessage)
+					Expect(recv.Error).To(Equal(""))
+					if asArray {
+						Expect(recv.Result).To(Equal([]interface{}{2.0}))
+					} else {
+						Expect(recv.Result).To(Equal(2.0))
+					}
+					close(done)
+				}, 2.0)
+				It("should send multiple results as array", func(done Done) {
+					conn.ClientSend(`{"type":1,"invocationId": "float","target":"simplefloat","arguments":[1]}`)
+					Expect(<-invocationQueue).To(Equal("SimpleFloat(1)"))
+					recv := (<-conn.received).(completionMessage)
+					Expect(recv.Result).To(Equal([]interface{}{10.0, 100.0}))
+					close(done)
+				}, 2.0)
+				It("should send no result for methods without results", func(done Done) {
+					conn.ClientSend(`{"type":1,"invocationId": "simple","target":"simple"}`)
+					Expect(<-invocationQueue).To(Equal("Simple()"))
+					recv := (<-conn.received).(completionMessage)
+					Expect(recv.Result).To(BeNil())
+					close(done)
+				}, 2.0)
+			})
+		}
+	})
+
+	Describe("Invocation of a method returning a value and an error", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{}, CompletionErrors(true))
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When the error is nil", func() {
+			It("should return the value as result", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "div1","target":"divide","arguments":[6, 3, false]}`)
+				Expect(<-invocationQueue).To(Equal("Divide(6, 3)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("div1"))
+				Expect(recv.Result).To(BeEquivalentTo(2))
+				Expect(recv.Error).To(Equal(""))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a plain error is returned", func() {
+			It("should return the error text as error", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "div2","target":"divide","arguments":[6, 0, false]}`)
+				Expect(<-invocationQueue).To(Equal("Divide(6, 0)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("div2"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal("division by zero"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a HubErrorDetailer is returned", func() {
+			It("should return a JSON object with message and details as error", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "div3","target":"divide","arguments":[6, 0, true]}`)
+				Expect(<-invocationQueue).To(Equal("Divide(6, 0)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("div3"))
+				Expect(recv.Result).To(BeNil())
+				var hubErr struct {
+					Message string
+					Details struct {
+						Code int
+					}
+				}
+				Expect(json.Unmarshal([]byte(recv.Error), &hubErr)).To(Succeed())
+				Expect(hubErr.Message).To(Equal("division by zero"))
+				Expect(hubErr.Details.Code).To(Equal(4711))
+				close(done)
+			}, 2.0)
+		})
+		Context("When CompletionErrors is not set", func() {
+			It("should return the value and the error as array", func(done Done) {
+				server, conn := connect(&invocationHub{})
+				defer server.cancel()
+				conn.ClientSend(`{"type":1,"invocationId": "div4","target":"divide","arguments":[6, 3, false]}`)
+				Expect(<-invocationQueue).To(Equal("Divide(6, 3)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Result).To(Equal([]interface{}{2.0, nil}))
+				Expect(recv.Error).To(Equal(""))
+				conn.ClientSend(`{"type":1,"invocationId": "div5","target":"divide","arguments":[6, 0, false]}`)
+				Expect(<-invocationQueue).To(Equal("Divide(6, 0)"))
+				recv = (<-conn.received).(completionMessage)
+				Expect(recv.Result).To(Equal([]interface{}{0.0, map[string]interface{}{}}))
+				Expect(recv.Error).To(Equal(""))
+				close(done)
+			}, 2.0)
+			It("should complete the invocation with a HubErrorDetailer error", func(done Done) {
+				server, conn := connect(&invocationHub{})
+				defer server.cancel()
+				conn.ClientSend(`{"type":1,"invocationId": "div6","target":"divide","arguments":[6, 0, true]}`)
+				Expect(<-invocationQueue).To(Equal("Divide(6, 0)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(ContainSubstring(`"code":4711`))
+				close(done)
+			}, 2.0)
+		})
+	})
+
 	Describe("SimpleString invocation", func() {
 		var server Server
 		var conn *testingConnection
@@ -333,6 +836,41 @@ var _ = Describe("Invocation", func() {
 		})
 	})
 
+	Describe("Invocation of a method which returns a nil channel", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client", func() {
+			It("should return a completion without result", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "nil","target":"nilchan"}`)
+				Expect(<-invocationQueue).To(Equal("NilChan()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("nil"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal(""))
+				close(done)
+			}, 2.0)
+		})
+		Context("When stream invoked by the client", func() {
+			It("should return a completion with error", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "nilstream","target":"nilchan"}`)
+				Expect(<-invocationQueue).To(Equal("NilChan()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("nilstream"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal("hub func returned nil chan"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
 	Describe("Panic in invoked func", func() {
 		var server Server
 		var conn *testingConnection
@@ -359,7 +897,7 @@ var _ = Describe("Invocation", func() {
 		})
 	})
 
-	Describe("Missing method invocation", func() {
+	Describe("Invocation with json.RawMessage parameter", func() {
 		var server Server
 		var conn *testingConnection
 		BeforeEach(func(done Done) {
@@ -370,18 +908,683 @@ var _ = Describe("Invocation", func() {
 			server.cancel()
 			close(done)
 		})
-		Context("When a missing server method invoked by the client", func() {
-			It("should return an error", func(done Done) {
-				conn.ClientSend(`{"type":1,"invocationId": "0000","target":"missing"}`)
+		Context("When invoked by the client", func() {
+			It("should pass the argument through unchanged", func(done Done) {
+				raw := `{ "b": [1,  2.50], "a" : null }`
+				conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "raw","target":"raw","arguments":[%v]}`, raw))
+				Expect(<-invocationQueue).To(Equal("Raw()"))
 				recv := (<-conn.received).(completionMessage)
-				Expect(recv).NotTo(BeNil())
-				Expect(recv.InvocationID).To(Equal("0000"))
-				Expect(recv.Result).To(BeNil())
-				Expect(recv.Error).NotTo(BeNil())
-				Expect(len(recv.Error)).To(BeNumerically(">", 0))
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal(raw))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation with RawArgument parameter", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{}, CompletionErrors(true))
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client with a large argument", func() {
+			It("should unmarshal only the fields the method needs", func(done Done) {
+				values := make([]string, 1000)
+				for i := range values {
+					values[i] = fmt.Sprintf(`"value%v"`, i)
+				}
+				conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"lazy","target":"lazy","arguments":[{"Values":[%v],"Name":"large"}]}`,
+					strings.Join(values, ",")))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("lazy"))
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("large"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation with *big.Int parameter", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client with a number which is too large for float64", func() {
+			It("should pass the number without loss of precision", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId":"big","target":"bigint","arguments":[1234567890123456789012345678901234567890]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("1234567890123456789012345678901234567890"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When invoked by the client with the number as string", func() {
+			It("should pass the number without loss of precision", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId":"big","target":"bigint","arguments":["-1234567890123456789012345678901234567890"]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("-1234567890123456789012345678901234567890"))
 				close(done)
 			}, 2.0)
 		})
 	})
 
+	Describe("Invocation with x-timeout-ms header", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When the method does not return before the deadline", func() {
+			It("should cancel the context of the method and complete the invocation with a timeout error", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId":"dl","target":"deadline","arguments":[true],"headers":{"x-timeout-ms":"50"}}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("dl"))
+				Expect(recv.Error).To(Equal("context deadline exceeded"))
+				Expect(<-invocationQueue).To(Equal("Deadline(context deadline exceeded)"))
+				Consistently(conn.received, 100*time.Millisecond).ShouldNot(Receive())
+				close(done)
+			}, 2.0)
+		})
+		Context("When the method returns a stream after the deadline", func() {
+			It("should complete the invocation with a timeout error and drain the stream", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId":"dl","target":"deadlinestream","headers":{"x-timeout-ms":"50"}}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("dl"))
+				Expect(recv.Error).To(Equal("context deadline exceeded"))
+				Expect(<-invocationQueue).To(Equal("DeadlineStream()"))
+				Consistently(conn.received, 100*time.Millisecond).ShouldNot(Receive())
+				close(done)
+			}, 2.0)
+		})
+		Context("When the method returns before the deadline", func() {
+			It("should return the result", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId":"dl","target":"deadline","arguments":[false],"headers":{"x-timeout-ms":"1000"}}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("no wait"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a method with a context parameter is invoked without the header", func() {
+			It("should pass the context and the arguments", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId":"dl","target":"deadline","arguments":[false]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("no wait"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When the header is not a positive number", func() {
+			It("should complete the invocation with an error without invoking the method", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId":"dl","target":"deadline","arguments":[true],"headers":{"x-timeout-ms":"soon"}}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(ContainSubstring("invalid x-timeout-ms header"))
+				Consistently(invocationQueue, 100*time.Millisecond).ShouldNot(Receive())
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation with pointer parameter", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client with an object", func() {
+			It("should allocate the struct and pass a pointer to it", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "ptr","target":"pointer","arguments":[{"Name":"a","Count":2}]}`)
+				Expect(<-invocationQueue).To(Equal("Pointer()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("a:2"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When invoked by the client with null", func() {
+			It("should pass nil", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "ptr","target":"pointer","arguments":[null]}`)
+				Expect(<-invocationQueue).To(Equal("Pointer(nil)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("nil"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation with slice parameters", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		for _, c := range []struct {
+			target   string
+			argument string
+			want     string
+		}{
+			{"slice", `[1,2,3]`, "[1 2 3]"},
+			{"slice", `[]`, "[]"},
+			{"slice", `null`, "nil"},
+			{"nestedslice", `[[1,2],[],[3]]`, "[[1 2] [] [3]]"},
+			{"structslice", `[{"Name":"a","Count":1},{"Name":"b","Count":2}]`, "a:1,b:2"},
+		} {
+			c := c
+			Context(fmt.Sprintf("When %v is invoked with %v", c.target, c.argument), func() {
+				It(fmt.Sprintf("should bind the argument to %v", c.want), func(done Done) {
+					conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "slice","target":"%v","arguments":[%v]}`, c.target, c.argument))
+					<-invocationQueue
+					recv := (<-conn.received).(completionMessage)
+					Expect(recv.Error).To(Equal(""))
+					Expect(recv.Result).To(Equal(c.want))
+					close(done)
+				}, 2.0)
+			})
+		}
+	})
+
+	Describe("Invocation with named arguments", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}),
+				testLoggerOption(),
+				ParameterNames("SimpleInt", "value"),
+				ParameterNames("simplestring", "value1", "value2"))
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client with an object of named arguments", func() {
+			It("should bind the arguments to the parameters by name", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "named","target":"simpleint","arguments":[{"value": 5}]}`)
+				Expect(<-invocationQueue).To(Equal("SimpleInt(5)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("named"))
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(BeEquivalentTo(6))
+				close(done)
+			}, 2.0)
+			It("should bind the arguments independent of their order", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "order","target":"simplestring","arguments":[{"value2": "B", "value1": "A"}]}`)
+				Expect(<-invocationQueue).To(Equal("SimpleString(A, B)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("ab"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When invoked by the client with positional arguments", func() {
+			It("should bind the arguments by position", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "positional","target":"simplestring","arguments":["A", "B"]}`)
+				Expect(<-invocationQueue).To(Equal("SimpleString(A, B)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("ab"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When invoked by the client with a missing named argument", func() {
+			It("should return an error", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "missing","target":"simplestring","arguments":[{"value1": "A"}]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("missing"))
+				Expect(recv.Error).To(ContainSubstring("value2"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation with arguments bound to a struct", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}),
+				testLoggerOption(),
+				BindArgumentsToStruct(true))
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client with positional arguments", func() {
+			It("should bind the arguments to the struct fields in declaration order", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "bind","target":"bound","arguments":["x",3,1.5]}`)
+				Expect(<-invocationQueue).To(Equal("Bound(x, 3, 1.5)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("bind"))
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("x:4.5"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When invoked by the client with the struct as single argument", func() {
+			It("should unmarshal the struct as a whole", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "whole","target":"bound","arguments":[{"Name":"y","Count":2,"Factor":2}]}`)
+				Expect(<-invocationQueue).To(Equal("Bound(y, 2, 2)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("y:4"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When invoked by the client with positional arguments of the wrong type", func() {
+			It("should return an error", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "wrong","target":"bound","arguments":["x","3",1.5]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("wrong"))
+				Expect(recv.Error).NotTo(Equal(""))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Sequential invocation", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&sequentialHub{}),
+				testLoggerOption(),
+				SequentialInvocation(true))
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When the client sends invocations rapidly", func() {
+			It("should execute them in order and one after the other", func(done Done) {
+				sequentialMx.Lock()
+				sequentialOverlapped = false
+				sequentialOrder = nil
+				sequentialMx.Unlock()
+				want := make([]int, 0, 20)
+				for i := 0; i < 20; i++ {
+					conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "%v","target":"sequential","arguments":[%v]}`, i, i))
+					want = append(want, i)
+				}
+				for i := 0; i < 20; i++ {
+					Expect((<-conn.received).(completionMessage).Error).To(Equal(""))
+				}
+				sequentialMx.Lock()
+				defer sequentialMx.Unlock()
+				Expect(sequentialOverlapped).To(BeFalse())
+				Expect(sequentialOrder).To(Equal(want))
+				close(done)
+			}, 3.0)
+		})
+	})
+
+	Describe("Serialized method invocation", func() {
+		var server Server
+		var conn1, conn2 *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&serializedHub{}),
+				testLoggerOption(),
+				CatchAllMethod("CatchAll"),
+				SerializedMethods("Serialized", "CatchAll"))
+			Expect(err).NotTo(HaveOccurred())
+			conn1 = newTestingConnectionForServer()
+			conn2 = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn1) }()
+			go func() { _ = server.Serve(conn2) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When two clients invoke the method at the same time", func() {
+			It("should not run the invocations concurrently", func(done Done) {
+				atomic.StoreInt32(&serializedOverlapped, 0)
+				for i := 0; i < 10; i++ {
+					invocation := fmt.Sprintf(`{"type":1,"invocationId": "%v","target":"serialized"}`, i)
+					conn1.ClientSend(invocation)
+					conn2.ClientSend(invocation)
+				}
+				for i := 0; i < 10; i++ {
+					Expect((<-conn1.received).(completionMessage).Error).To(Equal(""))
+					Expect((<-conn2.received).(completionMessage).Error).To(Equal(""))
+				}
+				Expect(atomic.LoadInt32(&serializedOverlapped)).To(Equal(int32(0)))
+				close(done)
+			}, 3.0)
+		})
+		Context("When two clients invoke different targets routed to the CatchAllMethod at the same time", func() {
+			It("should not run the invocations concurrently", func(done Done) {
+				atomic.StoreInt32(&serializedOverlapped, 0)
+				for i := 0; i < 10; i++ {
+					conn1.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "%v","target":"unknownA"}`, i))
+					conn2.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "%v","target":"unknownB"}`, i))
+				}
+				for i := 0; i < 10; i++ {
+					Expect((<-conn1.received).(completionMessage).Error).To(Equal(""))
+					Expect((<-conn2.received).(completionMessage).Error).To(Equal(""))
+				}
+				Expect(atomic.LoadInt32(&serializedOverlapped)).To(Equal(int32(0)))
+				close(done)
+			}, 3.0)
+		})
+	})
+
+	Describe("Panic in invoked func with PanicHandler", func() {
+		type panicReport struct {
+			connectionID string
+			target       string
+			recovered    interface{}
+			stack        []byte
+		}
+		var server Server
+		var conn *testingConnection
+		var reports chan panicReport
+		BeforeEach(func(done Done) {
+			reports = make(chan panicReport, 1)
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}),
+				testLoggerOption(),
+				PanicHandler(func(connectionID, target string, recovered interface{}, stack []byte) {
+					reports <- panicReport{connectionID, target, recovered, stack}
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When a func is invoked by the client and panics", func() {
+			It("should pass the stack to the handler and return an error without stack", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "???","target":"panic"}`)
+				Expect(<-invocationQueue).To(Equal("Panic()"))
+				report := <-reports
+				Expect(report.connectionID).To(Equal(conn.ConnectionID()))
+				Expect(report.target).To(Equal("panic"))
+				Expect(report.recovered).To(Equal("Don't panic!"))
+				Expect(report.stack).NotTo(BeEmpty())
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("???"))
+				Expect(recv.Error).To(ContainSubstring("Don't panic!"))
+				Expect(recv.Error).NotTo(ContainSubstring("goroutine "))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation of methods with unsupported result types", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&unsupportedResultHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		for _, method := range []struct {
+			name  string
+			cause string
+		}{
+			{"chanofchan", "chan chan int"},
+			{"func", "func()"},
+			{"hidden", "has no exported fields"},
+			{"callback", "field Callback"},
+			{"sendonly", "must be receivable"},
+		} {
+			method := method
+			Context(fmt.Sprintf("When %v is invoked by the client", method.name), func() {
+				It("should not be invoked and return an error describing the result type", func(done Done) {
+					conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "0000","target":"%v"}`, method.name))
+					recv := (<-conn.received).(completionMessage)
+					Expect(recv.InvocationID).To(Equal("0000"))
+					Expect(recv.Result).To(BeNil())
+					Expect(recv.Error).To(ContainSubstring("unsupported result type"))
+					Expect(recv.Error).To(ContainSubstring(method.cause))
+					Expect(invocationQueue).NotTo(Receive())
+					close(done)
+				}, 2.0)
+			})
+		}
+	})
+
+	Describe("Invocation of methods with results which can not be marshaled", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&marshalerHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When the result of an invocation fails to marshal", func() {
+			It("should complete the invocation with an error instead of the result", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId":"0000","target":"result","arguments":["panic"]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("0000"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(ContainSubstring(ErrMarshalFailed.Error()))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a stream item fails to marshal", func() {
+			It("should complete the stream with an error", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId":"0001","target":"stream","arguments":["error"]}`)
+				item := (<-conn.received).(streamItemMessage)
+				Expect(fmt.Sprintf("%s", item.Item)).To(Equal(`"ok"`))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("0001"))
+				Expect(recv.Error).To(ContainSubstring(ErrMarshalFailed.Error()))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation with rewritten targets", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}),
+				testLoggerOption(),
+				RewriteTargets(func(target string) string {
+					return strings.TrimPrefix(target, "v2_")
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When a versioned target is invoked", func() {
+			It("should invoke the method of the rewritten target", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "123","target":"v2_simple"}`)
+				Expect(<-invocationQueue).To(Equal("Simple()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("123"))
+				Expect(recv.Error).To(Equal(""))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a target which is not rewritten is invoked", func() {
+			It("should invoke the method of the target", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "124","target":"simpleint","arguments":[1]}`)
+				Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Result).To(BeEquivalentTo(2))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	It("should not accept a nil RewriteTargets function", func() {
+		_, err := NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}), RewriteTargets(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("Catch-all method invocation", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&catchAllHub{}),
+				testLoggerOption(),
+				CatchAllMethod("Route"),
+				CompletionErrors(true))
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When a missing server method is invoked by the client", func() {
+			It("should invoke the catch-all method with the target and the raw arguments", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "0000","target":"add","arguments":[1,2,3]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("0000"))
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("add:6"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When an existing server method is invoked by the client", func() {
+			It("should invoke the method, not the catch-all method", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "0001","target":"known","arguments":[]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("0001"))
+				Expect(recv.Result).To(Equal("known"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When the raw arguments can not be unmarshaled by the catch-all method", func() {
+			It("should return the error of the catch-all method", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "0002","target":"add","arguments":["one"]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("0002"))
+				Expect(recv.Error).NotTo(Equal(""))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Missing method invocation", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&invocationHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When a missing server method invoked by the client", func() {
+			It("should return an error", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "0000","target":"missing"}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv).NotTo(BeNil())
+				Expect(recv.InvocationID).To(Equal("0000"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).NotTo(BeNil())
+				Expect(len(recv.Error)).To(BeNumerically(">", 0))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Invocation of methods promoted by embedding", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&derivedEmbeddedHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When a method of the embedded base hub is invoked by the client", func() {
+			It("should invoke the method", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "0000","target":"echo","arguments":["hi"]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("0000"))
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(Equal("hi"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a method of Hub which is declared by the base hub is invoked by the client", func() {
+			It("should invoke the method of the base hub", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "0001","target":"connectionid","arguments":[]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("0001"))
+				Expect(recv.Result).To(Equal("base"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When a method of Hub or a reserved method is invoked by the client", func() {
+			It("should return an error", func(done Done) {
+				for i, target := range []string{"items", "abort", "OnConnected", "initialize", "clients", "groups", "context"} {
+					conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "%v","target":"%v","arguments":[]}`, i, target))
+					recv := (<-conn.received).(completionMessage)
+					Expect(recv.Result).To(BeNil())
+					Expect(recv.Error).To(Equal(fmt.Sprintf("Unknown method %v", target)))
+				}
+				close(done)
+			}, 2.0)
+		})
+	})
 })
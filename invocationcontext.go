@@ -0,0 +1,62 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// InvocationTimeoutHeader is the header of an invocation which holds the time in milliseconds the caller waits for the completion.
+// When the time has elapsed, the invocation is completed with the error "context deadline exceeded", even if the method
+// returns a result later. Methods which take a context.Context as first parameter receive a context with this deadline,
+// so they can stop working when the caller does not wait anymore.
+// The deadline only covers the call of the method. The items of a stream or chan which the method has returned before
+// are sent without deadline, unless the method ends the stream when its context is done.
+// Streams which are returned after the deadline are drained and not sent.
+const InvocationTimeoutHeader = "x-timeout-ms"
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// invocationContext returns the context of the invocation. Without InvocationTimeoutHeader, this is the context of the connection.
+// With it, the context ends when the invocation has ended or when the time given in the header has elapsed
+func invocationContext(parent context.Context, invocation invocationMessage) (context.Context, context.CancelFunc, error) {
+	value, ok := invocation.Headers[InvocationTimeoutHeader]
+	if !ok {
+		return parent, func() {}, nil
+	}
+	milliseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || milliseconds <= 0 {
+		return nil, nil, fmt.Errorf("invalid %v header %q: must be a positive number of milliseconds", InvocationTimeoutHeader, value)
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Duration(milliseconds)*time.Millisecond)
+	return ctx, cancel, nil
+}
+
+// takesContext reports if the first parameter of method is a context.Context
+func takesContext(method reflect.Value) bool {
+	return method.Type().NumIn() > 0 && method.Type().In(0) == contextType
+}
+
+// bindContext returns a func which calls method with ctx as first argument, followed by the arguments it is called with.
+// By this, the arguments of the invocation are built for the parameters after the context.
+func bindContext(method reflect.Value, ctx context.Context) reflect.Value {
+	methodType := method.Type()
+	in := make([]reflect.Type, methodType.NumIn()-1)
+	for i := range in {
+		in[i] = methodType.In(i + 1)
+	}
+	out := make([]reflect.Type, methodType.NumOut())
+	for i := range out {
+		out[i] = methodType.Out(i)
+	}
+	ctxValue := reflect.ValueOf(&ctx).Elem()
+	return reflect.MakeFunc(reflect.FuncOf(in, out, methodType.IsVariadic()), func(args []reflect.Value) []reflect.Value {
+		args = append([]reflect.Value{ctxValue}, args...)
+		if methodType.IsVariadic() {
+			return method.CallSlice(args)
+		}
+		return method.Call(args)
+	})
+}
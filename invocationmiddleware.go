@@ -0,0 +1,43 @@
+package signalr
+
+import (
+	"context"
+)
+
+// AuthContext is what an InvocationMiddleware knows about the caller of an invocation
+type AuthContext struct {
+	// Context is the context of the connection. With HTTP connections, it holds the values of the request context,
+	// so the principal and its claims set by an authentication middleware can be taken from it
+	Context context.Context
+	// ConnectionID is the id of the connection which sent the invocation
+	ConnectionID string
+	// UserID is the user of the connection, see UserIdentifier. It is "" if the connection has no user
+	UserID string
+	// Features describes the connection, e.g. its transport
+	Features ConnectionFeatures
+}
+
+// InvocationMiddleware is called before a hub method is invoked, with the AuthContext of the caller and the target of the invocation.
+// If it returns an error, the method is not invoked and the invocation is completed with the error,
+// which is sent like an error returned by a hub method, see HubErrorDetailer.
+// InvocationMiddleware is called for each received invocation before the next one is read, so it should not block.
+type InvocationMiddleware func(auth AuthContext, target string) error
+
+// authorizeInvocation lets the InvocationMiddleware of the server decide if the invocation of target is allowed
+func (s *server) authorizeInvocation(hc hubConnection, target string) error {
+	if len(s.invocationMiddleware) == 0 {
+		return nil
+	}
+	auth := AuthContext{
+		Context:      hc.Context(),
+		ConnectionID: hc.ConnectionID(),
+		UserID:       s.lifetimeManager.UserID(hc.ConnectionID()),
+		Features:     hc.Features(),
+	}
+	for _, middleware := range s.invocationMiddleware {
+		if err := middleware(auth, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,58 +3,206 @@ package signalr
 import (
 	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrTooManyPendingInvocations is returned when an invocation is started while the maximum number of pending
+// invocations is reached, see MaxPendingInvocations
+var ErrTooManyPendingInvocations = errors.New("too many pending invocations")
+
+// ErrInvocationTimeout is returned when the other party has not completed an invocation in time, see InvocationTimeout
+var ErrInvocationTimeout = errors.New("invocation timed out")
+
 type invokeClient struct {
 	mx                 sync.Mutex
 	resultChans        map[string]invocationResultChans
 	protocol           hubProtocol
 	chanReceiveTimeout time.Duration
+	clock              Clock
+	// maxPending is the maximum number of pending invocations. 0 means unlimited
+	maxPending int
+	// evicting is set when an invocation has been evicted after its timeout. Completions of unknown invocations
+	// might be late completions of evicted invocations then
+	evicting int32
 }
 
-func newInvokeClient(protocol hubProtocol, chanReceiveTimeout time.Duration) *invokeClient {
+func newInvokeClient(protocol hubProtocol, chanReceiveTimeout time.Duration, clock Clock) *invokeClient {
 	return &invokeClient{
 		mx:                 sync.Mutex{},
 		resultChans:        make(map[string]invocationResultChans),
 		protocol:           protocol,
 		chanReceiveTimeout: chanReceiveTimeout,
+		clock:              clock,
 	}
 }
 
 type invocationResultChans struct {
 	resultChan chan interface{}
 	errChan    chan error
+	resultType reflect.Type
+	// timeout evicts the invocation if it is not completed in time, see evictAfter
+	timeout Timer
+	// completing is set when the completion has been received, so the invocation is not evicted anymore
+	completing bool
+	// progress receives the progress reported by the invoked method, see InvokeWithProgress. nil if not requested
+	progress *invocationProgress
+	// headers receives the headers of the completion before its result or error is sent
+	headers chan map[string]string
+}
+
+// invocationProgress is the channel which receives the progress of an invocation.
+// It is closed when the invocation has ended, but never while a progress value is sent to it.
+type invocationProgress struct {
+	mx     sync.Mutex
+	ch     chan<- interface{}
+	closed bool
+}
+
+func (p *invocationProgress) send(value interface{}, clock Clock, timeout time.Duration) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.closed {
+		return nil
+	}
+	timer := clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case p.ch <- value:
+		return nil
+	case <-timer.C():
+		return &hubChanTimeoutError{fmt.Sprintf("timeout (%v) waiting for caller to receive progress", timeout)}
+	}
+}
+
+func (p *invocationProgress) close() {
+	if p == nil {
+		return
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.ch)
+	}
+}
+
+func (i *invokeClient) newInvocation(id string) (chan interface{}, chan error, error) {
+	return i.newTypedInvocation(id, interfaceType)
 }
 
-func (i *invokeClient) newInvocation(id string) (chan interface{}, chan error) {
+// newTypedInvocation creates an invocation which result is unmarshaled into a value of resultType.
+// It returns ErrTooManyPendingInvocations if the maximum number of pending invocations is reached
+func (i *invokeClient) newTypedInvocation(id string, resultType reflect.Type) (chan interface{}, chan error, error) {
 	i.mx.Lock()
+	defer i.mx.Unlock()
+	if i.maxPending > 0 && len(i.resultChans) >= i.maxPending {
+		return nil, nil, fmt.Errorf("%w: %v invocations are not completed yet", ErrTooManyPendingInvocations, len(i.resultChans))
+	}
 	r := invocationResultChans{
 		resultChan: make(chan interface{}, 1),
 		errChan:    make(chan error, 1),
+		resultType: resultType,
+		headers:    make(chan map[string]string, 1),
 	}
 	i.resultChans[id] = r
+	return r.resultChan, r.errChan, nil
+}
+
+// completionHeaders returns the channel which receives the headers of the completion of the invocation with id.
+// The headers are received before the result or the error of the completion
+func (i *invokeClient) completionHeaders(id string) <-chan map[string]string {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	return i.resultChans[id].headers
+}
+
+// setProgress lets the invocation with id deliver the progress reported by the invoked method to progress
+func (i *invokeClient) setProgress(id string, progress chan<- interface{}) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	if r, ok := i.resultChans[id]; ok {
+		r.progress = &invocationProgress{ch: progress}
+		i.resultChans[id] = r
+	}
+}
+
+// receiveProgress delivers a stream item which has been sent with the id of a pending invocation as progress.
+// Progress of invocations which have not been started with InvokeWithProgress is dropped
+func (i *invokeClient) receiveProgress(streamItem streamItemMessage) error {
+	i.mx.Lock()
+	ir, ok := i.resultChans[streamItem.InvocationID]
 	i.mx.Unlock()
-	return r.resultChan, r.errChan
+	if !ok || ir.progress == nil {
+		return nil
+	}
+	value, err := unmarshalValue(i.protocol, streamItem.Item, interfaceType)
+	if err != nil {
+		return fmt.Errorf("progress of invocation %v: %w", streamItem.InvocationID, err)
+	}
+	return ir.progress.send(value.Interface(), i.clock, i.chanReceiveTimeout)
 }
 
 func (i *invokeClient) deleteInvocation(id string) {
 	i.mx.Lock()
 	if r, ok := i.resultChans[id]; ok {
 		delete(i.resultChans, id)
+		if r.timeout != nil {
+			r.timeout.Stop()
+		}
+		r.progress.close()
 		close(r.resultChan)
 		close(r.errChan)
 	}
 	i.mx.Unlock()
 }
 
+// evictAfter fails the invocation with ErrInvocationTimeout and removes it if it is not completed within timeout
+func (i *invokeClient) evictAfter(id string, timeout time.Duration) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	if r, ok := i.resultChans[id]; ok {
+		r.timeout = i.clock.AfterFunc(timeout, func() { i.evict(id, timeout) })
+		i.resultChans[id] = r
+	}
+}
+
+func (i *invokeClient) evict(id string, timeout time.Duration) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	if r, ok := i.resultChans[id]; ok && !r.completing {
+		atomic.StoreInt32(&i.evicting, 1)
+		delete(i.resultChans, id)
+		r.progress.close()
+		close(r.resultChan)
+		// errChan has a buffer of 1 and nothing else has been sent to it, because the invocation was still pending
+		r.errChan <- fmt.Errorf("%w after %v", ErrInvocationTimeout, timeout)
+		close(r.errChan)
+	}
+}
+
+// mightBeEvicted reports if the invocation with the id might have been evicted, because it was not completed in time
+func (i *invokeClient) mightBeEvicted(invocationID string, lastID uint64) bool {
+	if atomic.LoadInt32(&i.evicting) == 0 {
+		return false
+	}
+	id, err := strconv.ParseUint(invocationID, 10, 64)
+	return err == nil && id <= lastID
+}
+
 func (i *invokeClient) cancelAllInvokes() {
 	i.mx.Lock()
 	for _, r := range i.resultChans {
+		if r.timeout != nil {
+			r.timeout.Stop()
+		}
+		r.progress.close()
 		close(r.resultChan)
 		go func(errChan chan error) {
-			errChan <- errors.New("message loop ended")
+			errChan <- &connectionError{errors.New("message loop ended")}
 			close(errChan)
 		}(r.errChan)
 	}
@@ -74,40 +222,38 @@ func (i *invokeClient) receiveCompletionItem(completion completionMessage) error
 	defer i.deleteInvocation(completion.InvocationID)
 	i.mx.Lock()
 	ir, ok := i.resultChans[completion.InvocationID]
+	if ok {
+		ir.completing = true
+		i.resultChans[completion.InvocationID] = ir
+	}
 	i.mx.Unlock()
 	if ok {
+		if completion.Headers != nil {
+			ir.headers <- completion.Headers
+		}
 		if completion.Error != "" {
-			done := make(chan struct{})
-			go func() {
-				ir.errChan <- errors.New(completion.Error)
-				done <- struct{}{}
-			}()
-			select {
-			case <-done:
-				return nil
-			case <-time.After(i.chanReceiveTimeout):
-				return &hubChanTimeoutError{fmt.Sprintf("timeout (%v) waiting for hub to receive client sent error", i.chanReceiveTimeout)}
-			}
+			return i.sendError(ir, &HubError{Message: completion.Error})
 		}
 		if completion.Result != nil {
-			var result interface{}
-			if err := i.protocol.UnmarshalArgument(completion.Result, &result); err != nil {
-				return err
+			value, err := unmarshalResult(i.protocol, completion.Result, ir.resultType)
+			if err != nil {
+				// The result does not match the type requested by the caller, which is no reason to close the connection
+				return i.sendError(ir, fmt.Errorf("result of invocation %v: %w", completion.InvocationID, err))
 			}
+			result := value.Interface()
+			timer := i.clock.NewTimer(i.chanReceiveTimeout)
+			defer timer.Stop()
 			done := make(chan struct{})
 			go func() {
+				// The result is delivered alone. Sending an additional nil error would produce
+				// a second InvokeResult which might be received before the one with the result
 				ir.resultChan <- result
-				if completion.Error != "" {
-					ir.errChan <- errors.New(completion.Error)
-				} else {
-					ir.errChan <- nil
-				}
 				close(done)
 			}()
 			select {
 			case <-done:
 				return nil
-			case <-time.After(i.chanReceiveTimeout):
+			case <-timer.C():
 				return &hubChanTimeoutError{fmt.Sprintf("timeout (%v) waiting for hub to receive client sent value", i.chanReceiveTimeout)}
 			}
 		}
@@ -115,3 +261,19 @@ func (i *invokeClient) receiveCompletionItem(completion completionMessage) error
 	}
 	return fmt.Errorf(`unknown completion id "%v"`, completion.InvocationID)
 }
+
+func (i *invokeClient) sendError(ir invocationResultChans, err error) error {
+	timer := i.clock.NewTimer(i.chanReceiveTimeout)
+	defer timer.Stop()
+	done := make(chan struct{})
+	go func() {
+		ir.errChan <- err
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C():
+		return &hubChanTimeoutError{fmt.Sprintf("timeout (%v) waiting for hub to receive client sent error", i.chanReceiveTimeout)}
+	}
+}
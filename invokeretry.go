@@ -0,0 +1,54 @@
+package signalr
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// InvokeRetryPolicy configures how Client.Invoke retries invocations of idempotent methods, see InvokeRetries.
+// After a failed attempt, Invoke waits for Delay and tries again, up to MaxRetries times.
+type InvokeRetryPolicy struct {
+	MaxRetries int
+	Delay      time.Duration
+}
+
+// isTransportError reports if err is caused by a failing or lost connection.
+// Errors sent by the server are no transport errors, because the server has received and handled the invocation.
+// Errors caused by the invocation itself, e.g. ErrMarshalFailed, ErrTooManyPendingInvocations or ErrInvocationTimeout,
+// are no transport errors either, because a retry would fail again or invoke the method twice.
+func isTransportError(err error) bool {
+	var connErr *connectionError
+	return errors.Is(err, ErrConnectionClosed) || errors.As(err, &connErr)
+}
+
+// invokeWithRetries invokes the method until it is not failing with a transport error
+// or the retries allowed by the policy are exhausted
+func (c *client) invokeWithRetries(resultType reflect.Type, method string, arguments []interface{}) <-chan InvokeResult {
+	ch := make(chan InvokeResult, 1)
+	go func() {
+		defer close(ch)
+		for attempt := 0; ; attempt++ {
+			results := make([]InvokeResult, 0, 1)
+			failed := false
+			for ir := range c.invokeOnce(resultType, method, arguments, nil) {
+				results = append(results, ir)
+				failed = failed || isTransportError(ir.Error)
+			}
+			if !failed || attempt == c.invokeRetryPolicy.MaxRetries || c.context().Err() != nil {
+				for _, ir := range results {
+					ch <- ir
+				}
+				return
+			}
+			_ = c.info.Log(evt, "invoke", "method", method, "attempt", attempt+1, "error", results[len(results)-1].Error, react, "retry")
+			select {
+			case <-c.clock().After(c.invokeRetryPolicy.Delay):
+			case <-c.context().Done():
+				ch <- InvokeResult{Error: c.context().Err()}
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -2,6 +2,7 @@ package signalr
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,32 +12,85 @@ import (
 	"github.com/go-kit/log"
 )
 
+// ErrMarshalFailed is returned when a message could not be marshaled, e.g. because the json.Marshaler of a value
+// returned an error, invalid JSON or panicked. Nothing is written then.
+var ErrMarshalFailed = errors.New("message could not be marshaled")
+
 // jsonHubProtocol is the JSON based SignalR protocol
 type jsonHubProtocol struct {
 	dbg log.Logger
+	// useNumber decodes numbers into interface{} values as json.Number instead of float64
+	useNumber bool
+	// maxArguments is the maximum number of arguments of an invocation. 0 means unlimited
+	maxArguments int
+	// limits are the maximum lengths of targets and invocation ids
+	limits identifierLimits
+	// noHTMLEscaping writes <, > and & in strings without escaping them
+	noHTMLEscaping bool
+	// disallowUnknownFields rejects JSON objects with fields which the destination struct does not have
+	disallowUnknownFields bool
 }
 
 // Protocol specific messages for correct unmarshaling of arguments or results.
 // jsonInvocationMessage is only used in ParseMessages, not in WriteMessage
 type jsonInvocationMessage struct {
 	Type         int               `json:"type"`
+	Headers      map[string]string `json:"headers,omitempty"`
 	Target       string            `json:"target"`
 	InvocationID string            `json:"invocationId"`
-	Arguments    []json.RawMessage `json:"arguments"`
+	Arguments    jsonArguments     `json:"arguments"`
 	StreamIds    []string          `json:"streamIds,omitempty"`
 }
 
+// jsonArguments are the raw arguments of an invocation.
+// If max is set, unmarshaling stops with an error as soon as more than max arguments are found
+type jsonArguments struct {
+	max   int
+	items []json.RawMessage
+}
+
+func (a *jsonArguments) UnmarshalJSON(data []byte) error {
+	if a.max == 0 {
+		return json.Unmarshal(data, &a.items)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		a.items = nil
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("arguments must be an array, not %v", token)
+	}
+	for decoder.More() {
+		if len(a.items) == a.max {
+			return tooManyArgumentsError(a.max)
+		}
+		var item json.RawMessage
+		if err := decoder.Decode(&item); err != nil {
+			return err
+		}
+		a.items = append(a.items, item)
+	}
+	return nil
+}
+
 type jsonStreamItemMessage struct {
-	Type         int             `json:"type"`
-	InvocationID string          `json:"invocationId"`
-	Item         json.RawMessage `json:"item"`
+	Type         int               `json:"type"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	InvocationID string            `json:"invocationId"`
+	Item         json.RawMessage   `json:"item"`
 }
 
 type jsonCompletionMessage struct {
-	Type         int             `json:"type"`
-	InvocationID string          `json:"invocationId"`
-	Result       json.RawMessage `json:"result,omitempty"`
-	Error        string          `json:"error,omitempty"`
+	Type         int               `json:"type"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	InvocationID string            `json:"invocationId"`
+	Result       json.RawMessage   `json:"result,omitempty"`
+	Error        string            `json:"error,omitempty"`
 }
 
 type jsonError struct {
@@ -54,7 +108,14 @@ func (j *jsonHubProtocol) UnmarshalArgument(src interface{}, dst interface{}) er
 	if !ok {
 		return fmt.Errorf("invalid source %#v for UnmarshalArgument", src)
 	}
-	if err := json.Unmarshal(rawSrc, dst); err != nil {
+	decoder := json.NewDecoder(bytes.NewReader(rawSrc))
+	if j.useNumber {
+		decoder.UseNumber()
+	}
+	if j.disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(dst); err != nil && !unmarshalQuotedText(rawSrc, dst) {
 		return &jsonError{string(rawSrc), err}
 	}
 	_ = j.dbg.Log(evt, "UnmarshalArgument",
@@ -63,6 +124,29 @@ func (j *jsonHubProtocol) UnmarshalArgument(src interface{}, dst interface{}) er
 	return nil
 }
 
+// unmarshalQuotedText unmarshals a JSON string into dst if dst is or points to an encoding.TextUnmarshaler.
+// Clients send numbers which are too large for float64 as strings, but the json.Unmarshaler of types like *big.Int
+// only accepts numbers. It reports if dst has been unmarshaled.
+func unmarshalQuotedText(rawSrc json.RawMessage, dst interface{}) bool {
+	var text string
+	if err := json.Unmarshal(rawSrc, &text); err != nil {
+		return false
+	}
+	value := reflect.ValueOf(dst).Elem()
+	target := value.Addr()
+	if value.Kind() == reflect.Ptr {
+		target = reflect.New(value.Type().Elem())
+	}
+	unmarshaler, ok := target.Interface().(encoding.TextUnmarshaler)
+	if !ok || unmarshaler.UnmarshalText([]byte(text)) != nil {
+		return false
+	}
+	if value.Kind() == reflect.Ptr {
+		value.Set(target)
+	}
+	return true
+}
+
 // ParseMessages reads all messages from the reader and puts the remaining bytes into remainBuf
 func (j *jsonHubProtocol) ParseMessages(reader io.Reader, remainBuf *bytes.Buffer) (messages []interface{}, err error) {
 	frames, err := readJSONFrames(reader, remainBuf)
@@ -81,6 +165,9 @@ func (j *jsonHubProtocol) ParseMessages(reader io.Reader, remainBuf *bytes.Buffe
 		if err != nil {
 			return nil, err
 		}
+		if err = j.limits.check(typedMessage); err != nil {
+			return nil, err
+		}
 		// No specific type (aka Ping), use hubMessage
 		if typedMessage == nil {
 			typedMessage = message
@@ -93,16 +180,17 @@ func (j *jsonHubProtocol) ParseMessages(reader io.Reader, remainBuf *bytes.Buffe
 func (j *jsonHubProtocol) parseMessage(messageType int, text []byte) (message interface{}, err error) {
 	switch messageType {
 	case 1, 4:
-		jsonInvocation := jsonInvocationMessage{}
+		jsonInvocation := jsonInvocationMessage{Arguments: jsonArguments{max: j.maxArguments}}
 		if err = json.Unmarshal(text, &jsonInvocation); err != nil {
 			err = &jsonError{string(text), err}
 		}
-		arguments := make([]interface{}, len(jsonInvocation.Arguments))
-		for i, a := range jsonInvocation.Arguments {
+		arguments := make([]interface{}, len(jsonInvocation.Arguments.items))
+		for i, a := range jsonInvocation.Arguments.items {
 			arguments[i] = a
 		}
 		return invocationMessage{
 			Type:         jsonInvocation.Type,
+			Headers:      jsonInvocation.Headers,
 			Target:       jsonInvocation.Target,
 			InvocationID: jsonInvocation.InvocationID,
 			Arguments:    arguments,
@@ -115,6 +203,7 @@ func (j *jsonHubProtocol) parseMessage(messageType int, text []byte) (message in
 		}
 		return streamItemMessage{
 			Type:         jsonStreamItem.Type,
+			Headers:      jsonStreamItem.Headers,
 			InvocationID: jsonStreamItem.InvocationID,
 			Item:         jsonStreamItem.Item,
 		}, err
@@ -125,6 +214,7 @@ func (j *jsonHubProtocol) parseMessage(messageType int, text []byte) (message in
 		}
 		completion := completionMessage{
 			Type:         jsonCompletion.Type,
+			Headers:      jsonCompletion.Headers,
 			InvocationID: jsonCompletion.InvocationID,
 			Error:        jsonCompletion.Error,
 		}
@@ -147,6 +237,18 @@ func (j *jsonHubProtocol) parseMessage(messageType int, text []byte) (message in
 			err = &jsonError{string(text), err}
 		}
 		return cm, err
+	case 8:
+		am := ackMessage{}
+		if err = json.Unmarshal(text, &am); err != nil {
+			err = &jsonError{string(text), err}
+		}
+		return am, err
+	case 9:
+		sm := sequenceMessage{}
+		if err = json.Unmarshal(text, &sm); err != nil {
+			err = &jsonError{string(text), err}
+		}
+		return sm, err
 	default:
 		return nil, nil
 	}
@@ -162,6 +264,9 @@ func readJSONFrames(reader io.Reader, remainBuf *bytes.Buffer) ([][]byte, error)
 		n, err := reader.Read(p)
 		// Some reader implementations return io.EOF additionally to n=0 if no data could be read
 		if err != nil && !errors.Is(err, io.EOF) {
+			if buf.Len() > 0 {
+				return nil, &incompleteFrameError{err: err, size: buf.Len()}
+			}
 			return nil, err
 		}
 		if n > 0 {
@@ -178,6 +283,21 @@ func readJSONFrames(reader io.Reader, remainBuf *bytes.Buffer) ([][]byte, error)
 	}
 }
 
+// handshakeRemainder returns the bytes which have been read after the first frame, the handshake.
+// They belong to the messages which the other party has sent directly after the handshake, e.g. in the same packet.
+func handshakeRemainder(frames [][]byte, remainBuf *bytes.Buffer) []byte {
+	var remainder bytes.Buffer
+	for _, frame := range frames[1:] {
+		_, _ = remainder.Write(frame)
+		_ = remainder.WriteByte(0x1e)
+	}
+	_, _ = remainder.ReadFrom(remainBuf)
+	if remainder.Len() == 0 {
+		return nil
+	}
+	return remainder.Bytes()
+}
+
 func parseJSONFrames(buf *bytes.Buffer) ([][]byte, error) {
 	frames := make([][]byte, 0)
 	for {
@@ -197,15 +317,9 @@ func parseJSONFrames(buf *bytes.Buffer) ([][]byte, error) {
 
 // WriteMessage writes a message as JSON to the specified writer
 func (j *jsonHubProtocol) WriteMessage(message interface{}, writer io.Writer) error {
-	var b []byte
-	var err error
-	if marshaler, ok := message.(json.Marshaler); ok {
-		b, err = marshaler.MarshalJSON()
-	} else {
-		b, err = json.Marshal(message)
-	}
+	b, err := j.marshal(message)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrMarshalFailed, err)
 	}
 	b = append(b, 0x1e)
 	_ = j.dbg.Log(evt, "write", msg, string(b))
@@ -213,6 +327,35 @@ func (j *jsonHubProtocol) WriteMessage(message interface{}, writer io.Writer) er
 	return err
 }
 
+// marshal returns the JSON of message. Panics of json.Marshaler implementations are returned as error,
+// so a failing value can not end the connection or leave a partial frame
+func (j *jsonHubProtocol) marshal(message interface{}) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if j.noHTMLEscaping {
+		// json.Marshal always escapes HTML, only the Encoder can be configured
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(message); err != nil {
+			return nil, err
+		}
+		// Encode terminates the value with a newline
+		return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+	}
+	if marshaler, ok := message.(json.Marshaler); ok {
+		// json.Marshal validates the output of nested marshalers, but not of the message itself
+		if b, err = marshaler.MarshalJSON(); err == nil && !json.Valid(b) {
+			return nil, fmt.Errorf("invalid JSON from MarshalJSON of %T", message)
+		}
+		return b, err
+	}
+	return json.Marshal(message)
+}
+
 func (j *jsonHubProtocol) transferMode() TransferMode {
 	return TextTransferMode
 }
@@ -220,3 +363,11 @@ func (j *jsonHubProtocol) transferMode() TransferMode {
 func (j *jsonHubProtocol) setDebugLogger(dbg StructuredLogger) {
 	j.dbg = log.WithPrefix(dbg, "ts", log.DefaultTimestampUTC, "protocol", "JSON")
 }
+
+func (j *jsonHubProtocol) setMaxArguments(max int) {
+	j.maxArguments = max
+}
+
+func (j *jsonHubProtocol) setIdentifierLimits(limits identifierLimits) {
+	j.limits = limits
+}
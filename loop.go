@@ -1,14 +1,18 @@
 package signalr
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/teivah/onecontext"
 )
 
 type loop struct {
@@ -22,32 +26,88 @@ type loop struct {
 	streamer     *streamer
 	streamClient *streamClient
 	closeMessage *closeMessage
+	// transportClosed is true when the other party has closed the transport without sending a close message
+	transportClosed bool
+	// activeInvocations maps the ids of all invocations received from the other party which are not completed yet to the cancel func of their context
+	activeInvocations sync.Map
+	// completionHeaders maps the ids of the invocations which are not completed yet to the headers of their completion, see SetCompletionHeader
+	completionHeaders sync.Map
+	// messageBuffer is only used with stateful reconnect
+	messageBuffer *messageBuffer
+	// lastInvocationDone is closed when the last dispatched invocation has been executed.
+	// It is only used with SequentialInvocation
+	lastInvocationDone chan struct{}
+	// state is the state of the connection, which the party has driven to Connected before the loop runs.
+	// Invocations are only dispatched in this state
+	state *connectionStateMachine
+	// pendingInvocations are the invocations received while receiving is paused, see hubConnection.PauseReceive
+	pendingInvocations []invocationMessage
 }
 
-func newLoop(p Party, conn Connection, protocol hubProtocol) *loop {
+// ackInterval is the interval in which received messages are acknowledged when stateful reconnect is enabled
+const ackInterval = time.Second
+
+func newLoop(p Party, conn Connection, protocol hubProtocol, state *connectionStateMachine) *loop {
 	protocol = reflect.New(reflect.ValueOf(protocol).Elem().Type()).Interface().(hubProtocol)
 	_, dbg := p.loggers()
 	protocol.setDebugLogger(dbg)
+	protocol.setMaxArguments(p.maxArguments())
+	protocol.setIdentifierLimits(p.identifierLimits())
+	jsonProtocol, ok := protocol.(*jsonHubProtocol)
+	if protobufProtocol, isProtobuf := protocol.(*protobufHubProtocol); isProtobuf {
+		// The values of the Protobuf protocol are JSON
+		jsonProtocol, ok = &protobufProtocol.values, true
+	}
+	if ok {
+		jsonProtocol.useNumber = p.useJSONNumber()
+		jsonProtocol.noHTMLEscaping = !p.jsonEscapeHTML()
+		jsonProtocol.disallowUnknownFields = p.jsonDisallowUnknownFields()
+	}
 	pInfo, pDbg := p.prefixLoggers(conn.ConnectionID())
-	hubConn := newHubConnection(conn, protocol, p.maximumReceiveMessageSize(), pInfo)
+	var buffer *messageBuffer
+	if p.enableStatefulReconnect() {
+		buffer = newMessageBuffer()
+	}
+	hubConn := newHubConnection(conn, protocol, p.readChunkSize(), p.maximumSendMessageSize(), p.keepAliveInterval(), pInfo, buffer)
+	defaultHubConn := hubConn.(*defaultHubConnection)
+	defaultHubConn.clock = p.clock()
+	defaultHubConn.targetNaming = p.targetNaming()
+	defaultHubConn.prioritized = p.prioritizeControlMessages()
+	defaultHubConn.budget = p.bufferBudget()
+	if delay := p.writeCoalescingDelay(); delay > 0 {
+		defaultHubConn.coalesceWrites(delay, p.writeCoalescingSize())
+	}
+	// Streams from the other party are given up when the connection or the party ends
+	streamCtx, _ := onecontext.Merge(p.context(), hubConn.Context())
 	return &loop{
-		party:        p,
-		protocol:     protocol,
-		hubConn:      hubConn,
-		invokeClient: newInvokeClient(protocol, p.chanReceiveTimeout()),
-		streamer:     &streamer{conn: hubConn},
-		streamClient: newStreamClient(protocol, p.chanReceiveTimeout(), p.streamBufferCapacity()),
-		info:         pInfo,
-		dbg:          pDbg,
+		party:         p,
+		protocol:      protocol,
+		hubConn:       hubConn,
+		invokeClient:  newInvokeClient(protocol, p.chanReceiveTimeout(), p.clock()),
+		streamer:      &streamer{conn: hubConn, sequenced: p.streamItemSequence(), clock: p.clock()},
+		streamClient:  newStreamClient(streamCtx, protocol, p.chanReceiveTimeout(), p.streamBufferCapacity(), p.clock()),
+		info:          pInfo,
+		dbg:           pDbg,
+		messageBuffer: buffer,
+		state:         state,
 	}
 }
 
 // Run runs the loop. After the startup sequence is done, this is signaled over the started channel.
 // Callers should pass a channel with buffer size 1 to allow the loop to run without waiting for the caller.
 func (l *loop) Run(connected chan struct{}) (err error) {
+	// Hub messages are only exchanged after the handshake
+	if state := l.state.current(); state != stateConnected {
+		l.hubConn.Abort()
+		return fmt.Errorf("%w: message loop started in connection state %v", ErrProtocolViolation, state)
+	}
 	l.party.onConnected(l.hubConn)
+	l.party.publishConnectionEvent(ConnectionEvent{Type: ConnectionConnected, ConnectionID: l.hubConn.ConnectionID()})
 	connected <- struct{}{}
 	close(connected)
+	if l.messageBuffer != nil {
+		go l.acknowledgeReceivedMessages()
+	}
 	// Process messages
 	ch := make(chan receiveResult, 1)
 	go func() {
@@ -69,19 +129,33 @@ func (l *loop) Run(connected chan struct{}) (err error) {
 			}
 		}
 	}()
+	// The keep alive ticker does not depend on received messages, so pings are also sent when the other party
+	// sends messages frequently, e.g. while a sparse stream is sent to it. Ping sends only when nothing was written
+	// within the keepAliveInterval, so ticking twice per interval keeps the idle time below 1.5 intervals
+	keepAlive := l.party.clock().NewTicker(l.party.keepAliveInterval() / 2)
+	defer keepAlive.Stop()
+	reason := DisconnectError
 msgLoop:
 	for {
+		// The timeout starts again with each received message, but not with the keep alive ticks
+		timeout := l.party.clock().After(l.party.timeout())
 	pingLoop:
 		for {
 			select {
 			case evt := <-ch:
 				err = evt.err
+				if err == nil && !l.shouldProcess(evt.message) {
+					break pingLoop
+				}
 				if err == nil {
 					switch message := evt.message.(type) {
 					case invocationMessage:
-						l.handleInvocationMessage(message)
+						// Queue the invocation, so it is not dispatched before invocations which are pending
+						l.pendingInvocations = append(l.pendingInvocations, message)
+						l.dispatchPendingInvocations()
 					case cancelInvocationMessage:
 						_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(message))
+						l.cancelPendingInvocation(message.InvocationID)
 						l.streamer.Stop(message.InvocationID)
 					case streamItemMessage:
 						err = l.handleStreamItemMessage(message)
@@ -90,52 +164,106 @@ msgLoop:
 					case closeMessage:
 						_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(message))
 						l.closeMessage = &message
+						reason = DisconnectClient
 						if message.Error != "" {
 							err = errors.New(message.Error)
 						}
+					case ackMessage:
+						err = l.handleAckMessage(message)
+					case sequenceMessage:
+						err = l.handleSequenceMessage(message)
 					case hubMessage:
 						// Mostly ping
 						err = l.handleOtherMessage(message)
 						// No default case necessary, because the protocol would return either a hubMessage or an error
 					}
+				} else if isTransportClosed(err) {
+					// A closed transport is a normal disconnect, not an error
+					_ = l.dbg.Log(evt, msgRecv, "error", err, react, "end connection")
+					l.transportClosed = true
+					reason = DisconnectTransportClosed
+					err = nil
 				} else {
 					_ = l.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(evt.message), react, "close connection")
 				}
 				break pingLoop
-			case <-time.After(l.party.keepAliveInterval()):
-				// Send ping only when there was no write in the keepAliveInterval before
-				if time.Since(l.hubConn.LastWriteStamp()) > l.party.keepAliveInterval() {
-					_ = l.hubConn.Ping()
-				}
+			case <-l.hubConn.receiveResumed():
+				l.dispatchPendingInvocations()
+			case <-keepAlive.C():
+				// Ping sends a ping only when there was no write in the keepAliveInterval before
+				_ = l.hubConn.Ping()
 				// Don't break the pingLoop when keepAlive is over, it exists for this case
-			case <-time.After(l.party.timeout()):
+			case <-timeout:
 				err = fmt.Errorf("timeout interval elapsed (%v)", l.party.timeout())
+				reason = DisconnectTimeout
 				break pingLoop
 			case <-l.hubConn.Context().Done():
 				err = fmt.Errorf("breaking loop. hubConnection canceled: %w", l.hubConn.Context().Err())
+				reason = DisconnectAborted
+				if l.party.context().Err() != nil {
+					// The hubConnection is canceled because the party is canceled
+					reason = DisconnectServerShutdown
+				}
 				break pingLoop
 			case <-l.party.context().Done():
 				err = fmt.Errorf("breaking loop. Party canceled: %w", l.party.context().Err())
+				reason = DisconnectServerShutdown
 				break pingLoop
 			}
 		}
-		if err != nil || l.closeMessage != nil {
+		if err != nil || l.closeMessage != nil || l.transportClosed {
 			break msgLoop
 		}
 	}
-	l.party.onDisconnected(l.hubConn)
+	if stateErr := l.state.transition(stateClosing); stateErr != nil {
+		_ = l.info.Log(evt, "message loop ended", "error", stateErr)
+	}
+	if err != nil && l.party.flushStreamsTimeout() > 0 {
+		l.streamer.Flush(err.Error(), l.party.flushStreamsTimeout())
+	}
+	l.party.onDisconnected(l.hubConn, reason)
 	if err != nil {
-		_ = l.hubConn.Close(fmt.Sprintf("%v", err), l.party.allowReconnect())
+		errorText, allowReconnect := l.party.closeMessageFor(reason, err)
+		_ = l.hubConn.Close(errorText, allowReconnect)
+		l.party.publishConnectionEvent(ConnectionEvent{Type: ConnectionError, ConnectionID: l.hubConn.ConnectionID(), Error: err})
 	}
+	l.party.publishConnectionEvent(ConnectionEvent{Type: ConnectionDisconnected, ConnectionID: l.hubConn.ConnectionID()})
 	_ = l.dbg.Log(evt, "message loop ended")
 	l.invokeClient.cancelAllInvokes()
+	l.streamClient.abandonAll()
 	l.hubConn.Abort()
+	if stateErr := l.state.transition(stateClosed); stateErr != nil {
+		_ = l.info.Log(evt, "message loop ended", "error", stateErr)
+	}
 	return err
 }
 
-func (l *loop) PullStream(method, id string, arguments ...interface{}) <-chan InvokeResult {
-	_, errChan := l.invokeClient.newInvocation(id)
-	upChan := l.streamClient.newUpstreamChannel(id)
+// dispatchPendingInvocations dispatches the pending invocations until receiving is paused
+func (l *loop) dispatchPendingInvocations() {
+	for len(l.pendingInvocations) > 0 && !l.hubConn.receivePaused() {
+		invocation := l.pendingInvocations[0]
+		l.pendingInvocations = l.pendingInvocations[1:]
+		l.handleInvocationMessage(invocation)
+	}
+}
+
+// cancelPendingInvocation removes a pending invocation, so a stream which has been canceled before it was dispatched is not started
+func (l *loop) cancelPendingInvocation(invocationID string) {
+	for i, invocation := range l.pendingInvocations {
+		if invocation.InvocationID == invocationID {
+			l.pendingInvocations = append(l.pendingInvocations[:i], l.pendingInvocations[i+1:]...)
+			return
+		}
+	}
+}
+
+func (l *loop) PullStream(itemType reflect.Type, method, id string, arguments ...interface{}) <-chan InvokeResult {
+	_, errChan, err := l.invokeClient.newInvocation(id)
+	if err != nil {
+		ch, _ := createResultChansWithError(l.party.context(), err)
+		return ch
+	}
+	upChan := l.streamClient.newUpstreamChannel(id, itemType)
 	ch := newInvokeResultChan(l.party.context(), upChan, errChan)
 	if err := l.hubConn.SendStreamInvocation(id, method, arguments, nil); err != nil {
 		// When we get an error here, the loop is closed and the errChan might be already closed
@@ -148,7 +276,10 @@ func (l *loop) PullStream(method, id string, arguments ...interface{}) <-chan In
 }
 
 func (l *loop) PushStreams(method, id string, arguments ...interface{}) (<-chan error, error) {
-	_, errChan := l.invokeClient.newInvocation(id)
+	_, errChan, err := l.invokeClient.newInvocation(id)
+	if err != nil {
+		return nil, err
+	}
 	invokeArgs := make([]interface{}, 0)
 	reflectedChannels := make([]reflect.Value, 0)
 	streamIds := make([]string, 0)
@@ -168,7 +299,7 @@ func (l *loop) PushStreams(method, id string, arguments ...interface{}) (<-chan
 	}
 	// Start streaming on all channels
 	for i, reflectedChannel := range reflectedChannels {
-		l.streamer.Start(streamIds[i], reflectedChannel)
+		l.streamer.Start(streamIds[i], reflectedChannel, nil, nil)
 	}
 	return errChan, nil
 }
@@ -181,43 +312,297 @@ func (l *loop) GetNewID() string {
 
 func (l *loop) handleInvocationMessage(invocation invocationMessage) {
 	_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(invocation))
+	if err := l.state.acceptMessage(invocation.Type); err != nil {
+		_ = l.info.Log(evt, msgRecv, "error", err, "name", invocation.Target, react, "send completion with error")
+		_ = l.hubConn.Completion(invocation.InvocationID, nil, err.Error())
+		return
+	}
+	if rewrite := l.party.targetRewriter(); rewrite != nil {
+		invocation.Target = rewrite(invocation.Target)
+	}
+	if err := l.party.authorizeInvocation(l.hubConn, invocation.Target); err != nil {
+		errorText, textErr := hubErrorText(err)
+		if textErr != nil {
+			_ = l.info.Log(evt, "hubErrorText", "error", textErr, "name", invocation.Target, react, "send error as plain text")
+		}
+		_ = l.info.Log(evt, msgRecv, "error", err, "name", invocation.Target, react, "deny invocation")
+		_ = l.hubConn.Completion(invocation.InvocationID, nil, errorText)
+		return
+	}
+	ctx, cancel, err := invocationContext(l.hubConn.Context(), invocation)
+	if err != nil {
+		_ = l.info.Log(evt, msgRecv, "error", err, "name", invocation.Target, react, "send completion with error")
+		_ = l.hubConn.Completion(invocation.InvocationID, nil, err.Error())
+		return
+	}
+	// Reject invocations which reuse the id of an invocation which is still in progress
+	if !l.beginInvocation(invocation.InvocationID, cancel) {
+		cancel()
+		_ = l.info.Log(evt, msgRecv, "error", "duplicate invocationId", "name", invocation.Target, react, "send completion with error")
+		_ = l.hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("Duplicate invocationId %s", invocation.InvocationID))
+		return
+	}
+	// end ends the invocation when no result is returned. Invocations without id are not tracked, so their context is canceled here
+	end := func() {
+		l.endInvocation(invocation.InvocationID)
+		cancel()
+	}
 	// Transient hub, dispatch invocation here
-	if method, ok := getMethod(l.party.invocationTarget(l.hubConn), invocation.Target); !ok {
+	target := l.party.invocationTarget(l.hubConn)
+	// methodName is the name of the method which is called, the target or the CatchAllMethod
+	methodName := invocation.Target
+	method, ok := getMethod(target, methodName)
+	catchAll := false
+	if !ok && l.party.catchAllMethod() != "" {
+		method, ok = getMethod(target, l.party.catchAllMethod())
+		catchAll = ok
+		if catchAll {
+			methodName = l.party.catchAllMethod()
+		}
+	}
+	// headers are the headers of the completion set by the method, see SetCompletionHeader
+	var headers *completionHeaders
+	if ok && takesContext(method) {
+		if invocation.Type == 1 && invocation.InvocationID != "" {
+			if invocation.Headers[ProgressHeader] == "true" {
+				ctx = withProgress(ctx, invocation.InvocationID, l.hubConn)
+			}
+			headers = &completionHeaders{}
+			ctx = withCompletionHeaders(ctx, headers)
+		}
+		method = bindContext(method, ctx)
+	}
+	if !ok {
 		// Unable to find the method
 		_ = l.info.Log(evt, "getMethod", "error", "missing method", "name", invocation.Target, react, "send completion with error")
+		end()
 		_ = l.hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("Unknown method %s", invocation.Target))
-	} else if in, clientStreaming, err := buildMethodArguments(method, invocation, l.streamClient, l.protocol); err != nil {
+	} else if err := validateResultTypes(invocation.Target, method.Type()); err != nil {
+		// results can not be sent
+		_ = l.info.Log(evt, "validateResultTypes", "error", err, "name", invocation.Target, react, "send completion with error")
+		end()
+		_ = l.hubConn.Completion(invocation.InvocationID, nil, err.Error())
+	} else if in, clientStreaming, err := l.buildArguments(method, invocation, catchAll); err != nil {
 		// argument build failed
 		_ = l.info.Log(evt, "buildMethodArguments", "error", err, "name", invocation.Target, react, "send completion with error")
+		end()
 		_ = l.hubConn.Completion(invocation.InvocationID, nil, err.Error())
 	} else if clientStreaming {
 		// let the receiving method run independently
-		go func() {
+		l.dispatch(func() {
+			defer end()
 			defer l.recoverInvocationPanic(invocation)
-			method.Call(in)
-		}()
+			l.callMethod(method, methodName, in)
+		})
 	} else {
-		// Stream invocation is only allowed when the method has only one return value
+		// Stream invocation is only allowed when the method has only one return value or returns (chan T, error)
 		// We allow no channel return values, because a client can receive as stream with only one item
-		if invocation.Type == 4 && method.Type().NumOut() != 1 {
+		if invocation.Type == 4 && method.Type().NumOut() != 1 && !returnsStreamAndError(method.Type()) {
+			end()
 			_ = l.hubConn.Completion(invocation.InvocationID, nil,
 				fmt.Sprintf("Stream invocation of method %s which has not return value kind channel", invocation.Target))
+		} else if invocation.Type == 1 && l.party.chanResultPolicy() == ChanResultReject && returnsStream(method.Type()) {
+			end()
+			_ = l.info.Log(evt, msgRecv, "error", "non-streaming invocation of streaming method", "name", invocation.Target, react, "send completion with error")
+			_ = l.hubConn.Completion(invocation.InvocationID, nil,
+				fmt.Sprintf("Method %s returns a stream and can only be invoked as stream invocation", invocation.Target))
 		} else {
+			if headers != nil {
+				l.completionHeaders.Store(invocation.InvocationID, headers)
+			}
 			// hub method might take a long time
-			go func() {
+			l.dispatch(func() {
+				returned := make(chan struct{})
+				watched := make(chan struct{})
+				if _, ok := ctx.Deadline(); ok {
+					go func() {
+						defer close(watched)
+						l.completeOnDeadline(ctx, invocation, returned)
+					}()
+				} else {
+					close(watched)
+				}
 				result := func() []reflect.Value {
 					defer l.recoverInvocationPanic(invocation)
-					return method.Call(in)
+					return l.callMethod(method, methodName, in)
 				}()
+				close(returned)
+				<-watched
+				if invocation.InvocationID == "" {
+					cancel()
+				}
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					l.completeDeadlineExceeded(invocation)
+					discardResult(l.hubConn.Context(), result)
+					return
+				}
 				l.returnInvocationResult(invocation, result)
-			}()
+			})
+		}
+	}
+}
+
+// receivedWithHandshake passes the data which has been read together with the handshake to the hub connection,
+// which parses it before the data read from the connection
+func (l *loop) receivedWithHandshake(data []byte) {
+	if hubConn, ok := l.hubConn.(*defaultHubConnection); ok {
+		hubConn.received = data
+	}
+}
+
+// dispatch runs the invocation of a method in its own goroutine.
+// With SequentialInvocation, the invocation waits until the previously dispatched invocation is done.
+// dispatch is only called by the loop goroutine, so lastInvocationDone needs no synchronization.
+func (l *loop) dispatch(invoke func()) {
+	if !l.party.sequentialInvocation() {
+		go invoke()
+		return
+	}
+	previousDone := l.lastInvocationDone
+	done := make(chan struct{})
+	l.lastInvocationDone = done
+	go func() {
+		defer close(done)
+		if previousDone != nil {
+			<-previousDone
+		}
+		invoke()
+	}()
+}
+
+// callMethod calls the method. Methods marked with SerializedMethods are called only when no other call is running
+func (l *loop) callMethod(method reflect.Value, methodName string, in []reflect.Value) []reflect.Value {
+	if lock := l.party.serializedMethodLock(methodName); lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	return method.Call(in)
+}
+
+// beginInvocation marks the invocation as active.
+// It returns false if an invocation with the same id is already active.
+// Invocations without id can not be completed, so they are not tracked.
+func (l *loop) beginInvocation(invocationID string, cancel context.CancelFunc) bool {
+	if invocationID == "" {
+		return true
+	}
+	_, loaded := l.activeInvocations.LoadOrStore(invocationID, cancel)
+	return !loaded
+}
+
+// endInvocation marks the invocation as completed and ends its context. Its id can be used again for new invocations.
+// It returns false if the invocation has already been completed.
+func (l *loop) endInvocation(invocationID string) bool {
+	cancel, ok := l.activeInvocations.LoadAndDelete(invocationID)
+	if ok {
+		cancel.(context.CancelFunc)()
+	}
+	return ok
+}
+
+// complete sends the completion of an invocation received from the other party with the headers set by the method
+func (l *loop) complete(invocationID string, result interface{}, errorText string) error {
+	var headers map[string]string
+	if value, ok := l.completionHeaders.LoadAndDelete(invocationID); ok {
+		headers = value.(*completionHeaders).get()
+	}
+	return l.hubConn.CompletionWithHeaders(invocationID, result, errorText, headers)
+}
+
+// completeOnDeadline completes the invocation with an error when the deadline of ctx is exceeded before the method has returned
+func (l *loop) completeOnDeadline(ctx context.Context, invocation invocationMessage, returned <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			l.completeDeadlineExceeded(invocation)
+		}
+	case <-returned:
+	}
+}
+
+// discardResult drains the chans in the result of a method which is not sent to the other party,
+// so goroutines of the method which send to them are not blocked forever
+func discardResult(ctx context.Context, result []reflect.Value) {
+	for _, value := range result {
+		if value.Kind() == reflect.Chan && value.Type().ChanDir() != reflect.SendDir && !value.IsNil() {
+			go func(ch reflect.Value) {
+				cases := []reflect.SelectCase{
+					{Dir: reflect.SelectRecv, Chan: ch},
+					{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+				}
+				for {
+					if chosen, _, ok := reflect.Select(cases); chosen == 1 || !ok {
+						return
+					}
+				}
+			}(value)
 		}
 	}
 }
 
+// completeDeadlineExceeded completes the invocation with the deadline error, if it has not been completed yet
+func (l *loop) completeDeadlineExceeded(invocation invocationMessage) {
+	if invocation.InvocationID == "" || !l.endInvocation(invocation.InvocationID) {
+		return
+	}
+	_ = l.info.Log(evt, "invocation", "error", context.DeadlineExceeded, "name", invocation.Target, react, "send completion with error")
+	_ = l.complete(invocation.InvocationID, nil, context.DeadlineExceeded.Error())
+}
+
 func (l *loop) returnInvocationResult(invocation invocationMessage, result []reflect.Value) {
 	// No invocation id, no completion
 	if invocation.InvocationID != "" {
+		// If the last result is an error, a non-nil error completes the invocation, otherwise the other results are returned.
+		// Without CompletionErrors, this applies only to (chan T, error) and the like and to HubErrorDetailer errors
+		if last := len(result) - 1; last >= 0 && result[last].Type() == errorType &&
+			(l.party.completionErrors() || (len(result) == 2 && isStreamType(result[0].Type())) || isHubErrorDetailer(result[last])) {
+			if !result[last].IsNil() {
+				l.endInvocation(invocation.InvocationID)
+				errorText, err := hubErrorText(result[last].Interface().(error))
+				if err != nil {
+					_ = l.info.Log(evt, "hubErrorText", "error", err, "name", invocation.Target, react, "send error as plain text")
+				}
+				_ = l.complete(invocation.InvocationID, nil, errorText)
+				return
+			}
+			result = result[:last]
+		}
+		// Iterators and producers are streamed like a chan. stopSeq ends them when the stream has ended
+		stopSeq := func() {}
+		// closedError returns the error text of the completion after the chan has been closed
+		closedError := func() string { return "" }
+		onPanic := func(recovered interface{}) {
+			_ = l.info.Log(evt, "panic in iterator", "error", recovered, "name", invocation.Target, react, "complete stream")
+			if handler := l.party.panicHandler(); handler != nil {
+				handler(l.hubConn.ConnectionID(), invocation.Target, recovered, debug.Stack())
+			}
+		}
+		if len(result) == 1 && isSeqType(result[0].Type()) {
+			result[0], stopSeq = seqToChan(result[0], onPanic)
+		} else if len(result) == 1 && isProducerType(result[0].Type()) {
+			var producerErr func() error
+			result[0], stopSeq, producerErr = producerToChan(l.hubConn.Context(), result[0], onPanic)
+			closedError = func() string {
+				if err := producerErr(); err != nil {
+					errorText, textErr := hubErrorText(err)
+					if textErr != nil {
+						_ = l.info.Log(evt, "hubErrorText", "error", textErr, "name", invocation.Target, react, "send error as plain text")
+					}
+					return errorText
+				}
+				return ""
+			}
+		}
+		// A nil chan would never deliver an item, so the invocation is completed at once
+		if len(result) == 1 && result[0].Kind() == reflect.Chan && result[0].IsNil() {
+			l.endInvocation(invocation.InvocationID)
+			errorText := ""
+			if invocation.Type == 4 {
+				errorText = "hub func returned nil chan"
+			}
+			_ = l.complete(invocation.InvocationID, nil, errorText)
+			return
+		}
 		// if the hub method returns a chan, it should be considered asynchronous or source for a stream
 		if len(result) == 1 && result[0].Kind() == reflect.Chan {
 			switch invocation.Type {
@@ -225,34 +610,67 @@ func (l *loop) returnInvocationResult(invocation invocationMessage, result []ref
 			case 1:
 				go func() {
 					// Recv might block, so run continue in a goroutine
-					if chanResult, ok := result[0].Recv(); ok {
-						l.sendResult(invocation, completion, []reflect.Value{chanResult})
+					chanResult, ok := result[0].Recv()
+					stopSeq()
+					l.endInvocation(invocation.InvocationID)
+					if errorText, failed := streamFailure(chanResult); failed {
+						_ = l.complete(invocation.InvocationID, nil, errorText)
+					} else if ok {
+						_ = l.sendResult(invocation, completion, []reflect.Value{chanResult})
+					} else if errorText := closedError(); errorText != "" {
+						_ = l.complete(invocation.InvocationID, nil, errorText)
 					} else {
 
-						_ = l.hubConn.Completion(invocation.InvocationID, nil, "hub func returned closed chan")
+						_ = l.complete(invocation.InvocationID, nil, "hub func returned closed chan")
 					}
 				}()
 			// StreamInvocation
 			case 4:
-				l.streamer.Start(invocation.InvocationID, result[0])
+				l.streamer.Start(invocation.InvocationID, result[0], func() {
+					stopSeq()
+					l.endInvocation(invocation.InvocationID)
+				}, closedError)
 			}
 		} else {
+			l.endInvocation(invocation.InvocationID)
 			switch invocation.Type {
 			// Simple invocation
 			case 1:
-				l.sendResult(invocation, completion, result)
+				_ = l.sendResult(invocation, completion, result)
 			case 4:
 				// Stream invocation of method with no stream result.
-				// Return a single StreamItem and an empty Completion
-				l.sendResult(invocation, streamItem, result)
-				_ = l.hubConn.Completion(invocation.InvocationID, nil, "")
+				// Return a single StreamItem and an empty Completion.
+				// Methods which only return a nil error have no item
+				errorText := ""
+				if len(result) > 0 {
+					if err := l.sendResult(invocation, streamItem, result); notSendable(err) {
+						errorText = err.Error()
+					}
+				}
+				_ = l.complete(invocation.InvocationID, nil, errorText)
 			}
 		}
 	}
 }
 
+// buildArguments builds the arguments for the method. The catch-all method gets the target and the raw arguments
+func (l *loop) buildArguments(method reflect.Value, invocation invocationMessage, catchAll bool) ([]reflect.Value, bool, error) {
+	if catchAll {
+		in, err := buildCatchAllArguments(method, invocation, l.protocol)
+		return in, false, err
+	}
+	return buildMethodArguments(method, invocation, l.streamClient, l.protocol, l.party.bindArgumentsToStruct(), l.party.parameterNames(invocation.Target))
+}
+
 func (l *loop) handleStreamItemMessage(streamItemMessage streamItemMessage) error {
 	_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(streamItemMessage))
+	if !l.streamClient.handlesInvocationID(streamItemMessage.InvocationID) && l.invokeClient.handlesInvocationID(streamItemMessage.InvocationID) {
+		// Stream items of non-streaming invocations are progress, see ReportProgress. Progress which can not be delivered is dropped
+		if err := l.invokeClient.receiveProgress(streamItemMessage); err != nil {
+			_ = l.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(streamItemMessage), react, "drop progress")
+		}
+		return nil
+	}
 	if err := l.streamClient.receiveStreamItem(streamItemMessage); err != nil {
 		switch t := err.(type) {
 		case *hubChanTimeoutError:
@@ -272,6 +690,10 @@ func (l *loop) handleCompletionMessage(message completionMessage) error {
 		err = l.streamClient.receiveCompletionItem(message, l.invokeClient)
 	} else if l.invokeClient.handlesInvocationID(message.InvocationID) {
 		err = l.invokeClient.receiveCompletionItem(message)
+	} else if l.hubConn.Acknowledge(message.InvocationID) {
+		// completion of an invocation sent with SendWithAck, the result is not used
+	} else if l.invokeClient.mightBeEvicted(message.InvocationID, atomic.LoadUint64(&l.lastID)) {
+		_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(message), react, "ignore completion of invocation which has timed out")
 	} else {
 		err = fmt.Errorf("unkown invocationID %v", message.InvocationID)
 	}
@@ -281,6 +703,64 @@ func (l *loop) handleCompletionMessage(message completionMessage) error {
 	return err
 }
 
+// shouldProcess reports if a received message should be processed.
+// With stateful reconnect, messages which are sent again by the other party after a sequence message
+// might have been received before. These duplicates are not processed again.
+func (l *loop) shouldProcess(message interface{}) bool {
+	if l.messageBuffer == nil || l.messageBuffer.received(message) {
+		return true
+	}
+	_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(message), react, "ignore duplicate message")
+	return false
+}
+
+func (l *loop) handleAckMessage(message ackMessage) error {
+	_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(message))
+	if l.messageBuffer == nil {
+		// Without stateful reconnect, no messages are buffered which could be dropped
+		return nil
+	}
+	err := l.messageBuffer.ack(message.SequenceID)
+	if err != nil {
+		_ = l.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(message), react, "close connection")
+	}
+	return err
+}
+
+func (l *loop) handleSequenceMessage(message sequenceMessage) error {
+	_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(message))
+	if l.messageBuffer == nil {
+		// Without stateful reconnect, no messages are sent again, so there is nothing to skip
+		return nil
+	}
+	err := l.messageBuffer.sequence(message.SequenceID)
+	if err != nil {
+		_ = l.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(message), react, "close connection")
+	}
+	return err
+}
+
+// acknowledgeReceivedMessages sends an ack message for the received messages every ackInterval,
+// if messages have been received since the last ack message was sent
+func (l *loop) acknowledgeReceivedMessages() {
+	ticker := l.party.clock().NewTicker(ackInterval)
+	defer ticker.Stop()
+	var lastAckedID uint64
+	for {
+		select {
+		case <-ticker.C():
+			if id := l.messageBuffer.lastReceivedID(); id > lastAckedID {
+				if err := l.hubConn.Ack(id); err != nil {
+					return
+				}
+				lastAckedID = id
+			}
+		case <-l.hubConn.Context().Done():
+			return
+		}
+	}
+}
+
 func (l *loop) handleOtherMessage(hubMessage hubMessage) error {
 	_ = l.dbg.Log(evt, msgRecv, msg, fmtMsg(hubMessage))
 	// Not Ping
@@ -292,50 +772,74 @@ func (l *loop) handleOtherMessage(hubMessage hubMessage) error {
 	return nil
 }
 
-func (l *loop) sendResult(invocation invocationMessage, connFunc connFunc, result []reflect.Value) {
+func (l *loop) sendResult(invocation invocationMessage, connFunc connFunc, result []reflect.Value) error {
 	values := make([]interface{}, len(result))
 	for i, rv := range result {
 		values[i] = rv.Interface()
 	}
 	switch len(result) {
 	case 0:
-		_ = l.hubConn.Completion(invocation.InvocationID, nil, "")
+		return l.complete(invocation.InvocationID, nil, "")
 	case 1:
-		connFunc(l, invocation, values[0])
+		// Stream items are not wrapped
+		if l.party.resultsAsArray() && invocation.Type == 1 {
+			return connFunc(l, invocation, values)
+		}
+		return connFunc(l, invocation, values[0])
 	default:
-		connFunc(l, invocation, values)
+		return connFunc(l, invocation, values)
 	}
 }
 
-type connFunc func(sl *loop, invocation invocationMessage, value interface{})
+type connFunc func(sl *loop, invocation invocationMessage, value interface{}) error
 
-func completion(sl *loop, invocation invocationMessage, value interface{}) {
-	_ = sl.hubConn.Completion(invocation.InvocationID, value, "")
+func completion(sl *loop, invocation invocationMessage, value interface{}) error {
+	return sl.complete(invocation.InvocationID, value, "")
 }
 
-func streamItem(sl *loop, invocation invocationMessage, value interface{}) {
-
-	_ = sl.hubConn.StreamItem(invocation.InvocationID, value)
+func streamItem(sl *loop, invocation invocationMessage, value interface{}) error {
+	// A single value is the first and only item of the stream
+	var sequence uint64
+	if sl.party.streamItemSequence() {
+		sequence = 1
+	}
+	return sl.hubConn.StreamItem(invocation.InvocationID, value, sequence)
 }
 
 func (l *loop) recoverInvocationPanic(invocation invocationMessage) {
 	if err := recover(); err != nil {
 		_ = l.info.Log(evt, "panic in target method", "error", err, "name", invocation.Target, react, "send completion with error")
-		stack := string(debug.Stack())
+		rawStack := debug.Stack()
+		if handler := l.party.panicHandler(); handler != nil {
+			handler(l.hubConn.ConnectionID(), invocation.Target, err, rawStack)
+		}
+		stack := string(rawStack)
 		_ = l.dbg.Log(evt, "panic in target method", "error", err, "name", invocation.Target, react, "send completion with error", "stack", stack)
 		if invocation.InvocationID != "" {
 			if !l.party.enableDetailedErrors() {
 				stack = ""
 			}
-			_ = l.hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("%v\n%v", err, stack))
+			_ = l.complete(invocation.InvocationID, nil, fmt.Sprintf("%v\n%v", err, stack))
 		}
 	}
 }
 
 func buildMethodArguments(method reflect.Value, invocation invocationMessage,
-	streamClient *streamClient, protocol hubProtocol) (arguments []reflect.Value, clientStreaming bool, err error) {
+	streamClient *streamClient, protocol hubProtocol, bindToStruct bool, parameterNames []string) (arguments []reflect.Value, clientStreaming bool, err error) {
+	if namedArguments, ok := namedArgumentsOf(invocation, protocol, parameterNames); ok {
+		arguments, err = buildNamedArguments(method, invocation.Target, namedArguments, protocol, parameterNames)
+		return arguments, false, err
+	}
+	if bindToStruct && isStructBinding(method, invocation) {
+		argument, err := buildStructArgument(method.Type().In(0), invocation, protocol)
+		if err != nil {
+			return nil, false, err
+		}
+		return []reflect.Value{argument}, false, nil
+	}
 	if len(invocation.StreamIds)+len(invocation.Arguments) != method.Type().NumIn() {
-		return nil, false, fmt.Errorf("parameter mismatch calling method %v", invocation.Target)
+		return nil, false, fmt.Errorf("parameter mismatch calling method %v: %v arguments expected, but %v received",
+			invocation.Target, method.Type().NumIn(), len(invocation.StreamIds)+len(invocation.Arguments))
 	}
 	arguments = make([]reflect.Value, method.Type().NumIn())
 	chanCount := 0
@@ -351,11 +855,11 @@ func buildMethodArguments(method reflect.Value, invocation invocationMessage,
 			arguments[i] = arg
 		} else {
 			// it is not, so do the normal thing
-			arg := reflect.New(t)
-			if err := protocol.UnmarshalArgument(invocation.Arguments[i-chanCount], arg.Interface()); err != nil {
+			arg, err := unmarshalValue(protocol, invocation.Arguments[i-chanCount], t)
+			if err != nil {
 				return arguments, chanCount > 0, err
 			}
-			arguments[i] = arg.Elem()
+			arguments[i] = arg
 		}
 	}
 	if len(invocation.StreamIds) != chanCount {
@@ -364,16 +868,45 @@ func buildMethodArguments(method reflect.Value, invocation invocationMessage,
 	return arguments, chanCount > 0, nil
 }
 
+// isStructBinding checks if the arguments of the invocation should be bound to the fields of the single struct parameter of method.
+// A single argument is still unmarshaled into the struct as a whole.
+func isStructBinding(method reflect.Value, invocation invocationMessage) bool {
+	if method.Type().NumIn() != 1 || len(invocation.StreamIds) != 0 || len(invocation.Arguments) == 1 {
+		return false
+	}
+	t := method.Type().In(0)
+	return t.Kind() == reflect.Struct && len(exportedFields(t)) == len(invocation.Arguments)
+}
+
+// buildStructArgument assigns the arguments of the invocation to the exported fields of a new value of type t
+func buildStructArgument(t reflect.Type, invocation invocationMessage, protocol hubProtocol) (reflect.Value, error) {
+	argument := reflect.New(t).Elem()
+	for i, field := range exportedFields(t) {
+		value, err := unmarshalValue(protocol, invocation.Arguments[i], field.Type)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("argument %v for field %v of method %v: %w", i, field.Name, invocation.Target, err)
+		}
+		argument.FieldByIndex(field.Index).Set(value)
+	}
+	return argument, nil
+}
+
+func exportedFields(t reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.PkgPath == "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
 func getMethod(target interface{}, name string) (reflect.Value, bool) {
 	hubType := reflect.TypeOf(target)
 	if hubType != nil {
-		hubValue := reflect.ValueOf(target)
-		name = strings.ToLower(name)
-		for i := 0; i < hubType.NumMethod(); i++ {
-			// Search in public methods
-			if m := hubType.Method(i); strings.ToLower(m.Name) == name {
-				return hubValue.Method(i), true
-			}
+		// Search in public methods, see invokableMethods
+		if i, ok := invokableMethods(hubType)[strings.ToLower(name)]; ok {
+			return reflect.ValueOf(target).Method(i), true
 		}
 	}
 	return reflect.Value{}, false
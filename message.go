@@ -0,0 +1,73 @@
+package signalr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Message is a hub message which is built by the application and sent with SendMessage, e.g. to bridge other protocols.
+// Which fields are sent depends on the Type:
+//   - 1 (Invocation): Target and Arguments. The InvocationID must be empty, because the completion could not be handled
+//   - 2 (StreamItem): InvocationID and Item
+//   - 3 (Completion): InvocationID and either Result or Error
+//   - 5 (CancelInvocation): InvocationID
+//   - 6 (Ping): no fields
+//   - 7 (Close): Error and AllowReconnect
+//
+// StreamInvocations and the Ack and Sequence messages of stateful reconnect are managed by the connection,
+// so they can not be sent with SendMessage.
+type Message struct {
+	Type           int
+	InvocationID   string
+	Target         string
+	Arguments      []interface{}
+	Item           interface{}
+	Result         interface{}
+	Error          string
+	AllowReconnect bool
+}
+
+// ErrInvalidMessage is returned by SendMessage when the Message can not be sent
+var ErrInvalidMessage = errors.New("invalid message")
+
+// hubMessage validates the message and converts it to the message type which is written by the protocols
+func (m Message) hubMessage() (interface{}, error) {
+	switch m.Type {
+	case 1:
+		if m.Target == "" {
+			return nil, fmt.Errorf("%w: invocation without Target", ErrInvalidMessage)
+		}
+		if m.InvocationID != "" {
+			return nil, fmt.Errorf("%w: invocation with InvocationID, use Invoke to receive the completion", ErrInvalidMessage)
+		}
+		arguments := m.Arguments
+		if arguments == nil {
+			arguments = make([]interface{}, 0)
+		}
+		return invocationMessage{Type: 1, Target: m.Target, Arguments: arguments}, nil
+	case 2:
+		if m.InvocationID == "" {
+			return nil, fmt.Errorf("%w: stream item without InvocationID", ErrInvalidMessage)
+		}
+		return streamItemMessage{Type: 2, InvocationID: m.InvocationID, Item: m.Item}, nil
+	case 3:
+		if m.InvocationID == "" {
+			return nil, fmt.Errorf("%w: completion without InvocationID", ErrInvalidMessage)
+		}
+		if m.Result != nil && m.Error != "" {
+			return nil, fmt.Errorf("%w: completion with Result and Error", ErrInvalidMessage)
+		}
+		return completionMessage{Type: 3, InvocationID: m.InvocationID, Result: m.Result, Error: m.Error}, nil
+	case 5:
+		if m.InvocationID == "" {
+			return nil, fmt.Errorf("%w: cancel invocation without InvocationID", ErrInvalidMessage)
+		}
+		return cancelInvocationMessage{Type: 5, InvocationID: m.InvocationID}, nil
+	case 6:
+		return hubMessage{Type: 6}, nil
+	case 7:
+		return closeMessage{Type: 7, Error: m.Error, AllowReconnect: m.AllowReconnect}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported type %v", ErrInvalidMessage, m.Type)
+	}
+}
@@ -0,0 +1,106 @@
+package signalr
+
+import (
+	"fmt"
+	"sync"
+)
+
+// messageBuffer realizes the message bookkeeping needed for stateful reconnect.
+// On the sending side, it counts the sent sequenced messages so that ack messages of the other party can be checked.
+// Sent messages are not kept: a reconnect always starts a new connection, so they would never be sent again.
+// On the receiving side, it counts the received sequenced messages so that messages which are sent again
+// after a sequence message can be detected as duplicates.
+// See https://github.com/dotnet/aspnetcore/blob/main/src/SignalR/docs/specs/HubProtocol.md#ack-message-encoding
+type messageBuffer struct {
+	mx sync.Mutex
+	// sending side
+	lastWrittenID uint64
+	lastAckedID   uint64
+	// receiving side
+	nextReceivedID  uint64
+	lastProcessedID uint64
+}
+
+func newMessageBuffer() *messageBuffer {
+	return &messageBuffer{
+		nextReceivedID: 1,
+	}
+}
+
+// isSequenced reports if the message is counted in the sequence of messages.
+// Ping, close, ack and sequence messages are not.
+func isSequenced(message interface{}) bool {
+	switch message.(type) {
+	case invocationMessage, streamItemMessage, completionMessage, cancelInvocationMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+// write assigns the next sequence id to the message.
+// It must be called in the order in which the messages have been sent
+func (b *messageBuffer) write(message interface{}) {
+	if !isSequenced(message) {
+		return
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.lastWrittenID++
+}
+
+// ack records that the other party has received all messages up to and including sequenceID
+func (b *messageBuffer) ack(sequenceID uint64) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if sequenceID > b.lastWrittenID {
+		return fmt.Errorf("ack for sequenceId %v, but only %v messages were sent", sequenceID, b.lastWrittenID)
+	}
+	if sequenceID > b.lastAckedID {
+		b.lastAckedID = sequenceID
+	}
+	return nil
+}
+
+// sequence sets the sequence id of the next message which will be received.
+// The other party sends a sequence message before it sends the unacknowledged messages again.
+func (b *messageBuffer) sequence(sequenceID uint64) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if sequenceID > b.nextReceivedID {
+		return fmt.Errorf("sequenceId %v is greater than the expected sequenceId %v. Messages have been lost", sequenceID, b.nextReceivedID)
+	}
+	b.nextReceivedID = sequenceID
+	return nil
+}
+
+// received counts a received message and reports if it should be processed.
+// Messages which have been received before are duplicates and must not be processed again.
+func (b *messageBuffer) received(message interface{}) bool {
+	if !isSequenced(message) {
+		return true
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	sequenceID := b.nextReceivedID
+	b.nextReceivedID++
+	if sequenceID <= b.lastProcessedID {
+		return false
+	}
+	b.lastProcessedID = sequenceID
+	return true
+}
+
+// unacknowledged is the number of sent messages which have not been acknowledged by the other party
+func (b *messageBuffer) unacknowledged() uint64 {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.lastWrittenID - b.lastAckedID
+}
+
+// lastReceivedID is the sequence id which is sent in an ack message to the other party
+func (b *messageBuffer) lastReceivedID() uint64 {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.lastProcessedID
+}
@@ -0,0 +1,169 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("messageBuffer", func() {
+	Context("When messages are written", func() {
+		It("should count sequenced messages until they are acknowledged", func() {
+			buffer := newMessageBuffer()
+			buffer.write(completionMessage{Type: 3, InvocationID: "1"})
+			buffer.write(hubMessage{Type: 6})
+			buffer.write(streamItemMessage{Type: 2, InvocationID: "2"})
+			buffer.write(completionMessage{Type: 3, InvocationID: "2"})
+			Expect(buffer.unacknowledged()).To(Equal(uint64(3)))
+			Expect(buffer.ack(2)).NotTo(HaveOccurred())
+			Expect(buffer.unacknowledged()).To(Equal(uint64(1)))
+			Expect(buffer.ack(1)).NotTo(HaveOccurred())
+			Expect(buffer.unacknowledged()).To(Equal(uint64(1)))
+			Expect(buffer.ack(3)).NotTo(HaveOccurred())
+			Expect(buffer.unacknowledged()).To(BeZero())
+		})
+		It("should fail when messages are acknowledged which have not been sent", func() {
+			buffer := newMessageBuffer()
+			buffer.write(completionMessage{Type: 3, InvocationID: "1"})
+			Expect(buffer.ack(2)).To(HaveOccurred())
+		})
+	})
+	Context("When messages are sent over a hubConnection", func() {
+		It("should assign a sequence id to each sent message", func(done Done) {
+			conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "sequenced")}
+			buffer := newMessageBuffer()
+			hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), buffer)
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					Expect(hubConn.SendInvocation(fmt.Sprint(i), "sequenced", nil)).To(Succeed())
+				}(i)
+			}
+			wg.Wait()
+			messages, err := (&jsonHubProtocol{dbg: testLogger()}).ParseMessages(bytes.NewReader(conn.written()), &bytes.Buffer{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messages).To(HaveLen(50))
+			Expect(buffer.unacknowledged()).To(Equal(uint64(len(messages))))
+			Expect(buffer.ack(uint64(len(messages)))).NotTo(HaveOccurred())
+			hubConn.Abort()
+			close(done)
+		}, 2.0)
+		It("should not assign sequence ids to messages which could not be sent", func(done Done) {
+			conn := &writeRecordingConnection{ConnectionBase: NewConnectionBase(context.Background(), "sequenced")}
+			buffer := newMessageBuffer()
+			hubConn := newHubConnection(conn, &jsonHubProtocol{dbg: testLogger()}, 1<<15, 0, time.Minute, testLogger(), buffer)
+			Expect(errors.Is(hubConn.SendInvocation("1", "unsendable", []interface{}{make(chan int)}), ErrMarshalFailed)).To(BeTrue())
+			Expect(hubConn.SendInvocation("2", "sent", nil)).To(Succeed())
+			Expect(buffer.unacknowledged()).To(Equal(uint64(1)))
+			Expect(buffer.ack(2)).To(HaveOccurred())
+			hubConn.Abort()
+			close(done)
+		}, 2.0)
+	})
+	Context("When messages are received", func() {
+		It("should not process messages again which are sent again after a sequence message", func() {
+			buffer := newMessageBuffer()
+			Expect(buffer.received(invocationMessage{Type: 1, InvocationID: "1"})).To(BeTrue())
+			Expect(buffer.received(hubMessage{Type: 6})).To(BeTrue())
+			Expect(buffer.received(invocationMessage{Type: 1, InvocationID: "2"})).To(BeTrue())
+			Expect(buffer.lastReceivedID()).To(Equal(uint64(2)))
+			Expect(buffer.sequence(2)).NotTo(HaveOccurred())
+			Expect(buffer.received(invocationMessage{Type: 1, InvocationID: "2"})).To(BeFalse())
+			Expect(buffer.received(invocationMessage{Type: 1, InvocationID: "3"})).To(BeTrue())
+			Expect(buffer.lastReceivedID()).To(Equal(uint64(3)))
+		})
+		It("should fail when the sequence message indicates lost messages", func() {
+			buffer := newMessageBuffer()
+			Expect(buffer.received(invocationMessage{Type: 1, InvocationID: "1"})).To(BeTrue())
+			Expect(buffer.sequence(5)).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Stateful reconnect messages", func() {
+	var server Server
+	var conn *testingConnection
+	BeforeEach(func(done Done) {
+		var err error
+		server, err = NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}),
+			testLoggerOption(),
+			EnableStatefulReconnect(true))
+		Expect(err).NotTo(HaveOccurred())
+		conn = newTestingConnectionForServer()
+		go func() { _ = server.Serve(conn) }()
+		close(done)
+	})
+	AfterEach(func(done Done) {
+		server.cancel()
+		close(done)
+	})
+	Context("When the client sends invocations", func() {
+		It("should acknowledge them with an ack message", func(done Done) {
+			conn.ClientSend(`{"type":1,"invocationId": "1","target":"simple"}`)
+			conn.ClientSend(`{"type":1,"invocationId": "2","target":"simple"}`)
+			Expect(<-invocationQueue).To(Equal("Simple()"))
+			Expect(<-invocationQueue).To(Equal("Simple()"))
+			// Completions and acks might be mixed, but finally both invocations are acknowledged
+			Eventually(conn.received, 2*time.Second).Should(Receive(Equal(ackMessage{Type: 8, SequenceID: 2})))
+			close(done)
+		}, 3.0)
+	})
+	Context("When the client sends a sequence message and sends the invocations again", func() {
+		It("should not invoke the already received invocations again", func(done Done) {
+			conn.ClientSend(`{"type":1,"invocationId": "1","target":"simple"}`)
+			Expect(<-invocationQueue).To(Equal("Simple()"))
+			Expect((<-conn.received).(completionMessage).InvocationID).To(Equal("1"))
+			conn.ClientSend(`{"type":9,"sequenceId":1}`)
+			conn.ClientSend(`{"type":1,"invocationId": "1","target":"simple"}`)
+			conn.ClientSend(`{"type":1,"invocationId": "2","target":"simple"}`)
+			Expect(<-invocationQueue).To(Equal("Simple()"))
+			Expect((<-conn.received).(completionMessage).InvocationID).To(Equal("2"))
+			Consistently(invocationQueue, 100*time.Millisecond).ShouldNot(Receive())
+			close(done)
+		}, 2.0)
+	})
+	Context("When the client acknowledges messages which have not been sent", func() {
+		It("should close the connection with an error", func(done Done) {
+			conn.ClientSend(`{"type":1,"invocationId": "1","target":"simple"}`)
+			Expect(<-invocationQueue).To(Equal("Simple()"))
+			Expect((<-conn.received).(completionMessage).InvocationID).To(Equal("1"))
+			conn.ClientSend(`{"type":8,"sequenceId":5}`)
+			message := <-conn.received
+			Expect(message).To(BeAssignableToTypeOf(closeMessage{}))
+			Expect(message.(closeMessage).Error).NotTo(BeEmpty())
+			close(done)
+		}, 2.0)
+	})
+})
+
+var _ = Describe("Stateful reconnect messages without EnableStatefulReconnect", func() {
+	var server Server
+	var conn *testingConnection
+	BeforeEach(func(done Done) {
+		server, conn = connect(&invocationHub{})
+		close(done)
+	})
+	AfterEach(func(done Done) {
+		server.cancel()
+		close(done)
+	})
+	Context("When the client sends ack and sequence messages", func() {
+		It("should ignore them and keep the connection", func(done Done) {
+			conn.ClientSend(`{"type":8,"sequenceId":5}`)
+			conn.ClientSend(`{"type":9,"sequenceId":7}`)
+			conn.ClientSend(`{"type":1,"invocationId": "1","target":"simple"}`)
+			Expect(<-invocationQueue).To(Equal("Simple()"))
+			Expect((<-conn.received).(completionMessage).InvocationID).To(Equal("1"))
+			Consistently(conn.received, 100*time.Millisecond).ShouldNot(Receive(BeAssignableToTypeOf(closeMessage{})))
+			close(done)
+		}, 2.0)
+	})
+})
@@ -13,10 +13,14 @@ import (
 
 type messagePackHubProtocol struct {
 	dbg log.Logger
+	// maxArguments is the maximum number of arguments of an invocation. 0 means unlimited
+	maxArguments int
+	// limits are the maximum lengths of targets and invocation ids
+	limits identifierLimits
 }
 
 func (m *messagePackHubProtocol) ParseMessages(reader io.Reader, remainBuf *bytes.Buffer) ([]interface{}, error) {
-	frames, err := m.readFrames(reader, remainBuf)
+	frames, err := readVarintFrames(reader, remainBuf)
 	if err != nil {
 		return nil, err
 	}
@@ -26,12 +30,16 @@ func (m *messagePackHubProtocol) ParseMessages(reader io.Reader, remainBuf *byte
 		if err != nil {
 			return nil, err
 		}
+		if err = m.limits.check(message); err != nil {
+			return nil, err
+		}
 		messages = append(messages, message)
 	}
 	return messages, nil
 }
 
-func (m *messagePackHubProtocol) readFrames(reader io.Reader, remainBuf *bytes.Buffer) ([][]byte, error) {
+// readVarintFrames reads the frames of the binary protocols, which are prefixed with their length as varint
+func readVarintFrames(reader io.Reader, remainBuf *bytes.Buffer) ([][]byte, error) {
 	frames := make([][]byte, 0)
 	for {
 		// Try to get the frame length
@@ -43,6 +51,9 @@ func (m *messagePackHubProtocol) readFrames(reader io.Reader, remainBuf *bytes.B
 		}
 		n2, err := reader.Read(frameLenBuf[n1:])
 		if err != nil && !errors.Is(err, io.EOF) {
+			if n1 > 0 {
+				return nil, &incompleteFrameError{err: err, size: n1}
+			}
 			// Some weird other error
 			return nil, err
 		}
@@ -54,21 +65,30 @@ func (m *messagePackHubProtocol) readFrames(reader io.Reader, remainBuf *bytes.B
 			return frames, nil
 		}
 		if lenLen < 0 {
-			return nil, fmt.Errorf("messagepack frame length to large")
+			return nil, fmt.Errorf("frame length too large")
 		}
 		// Still wondering why this happens, but it happens!
 		if frameLen == 0 {
 			// Store the overread bytes for the next iteration
-			_, _ = remainBuf.Write(frameLenBuf[lenLen:])
+			_, _ = remainBuf.Write(frameLenBuf[lenLen : n1+n2])
 			continue
 		}
 		// Try getting data until at least one frame is available
 		readBuf := make([]byte, frameLen)
 		frameBuf := &bytes.Buffer{}
 		// Did we read too many bytes when detecting the frameLen?
-		_, _ = frameBuf.Write(frameLenBuf[lenLen:])
+		_, _ = frameBuf.Write(frameLenBuf[lenLen : n1+n2])
 		// Read the rest of the bytes from the last iteration
 		_, _ = frameBuf.ReadFrom(remainBuf)
+		// Small frames (e.g. ping, ack or sequence) might have been read completely already
+		if frameBuf.Len() >= int(frameLen) {
+			frames = append(frames, frameBuf.Next(int(frameLen)))
+			if frameBuf.Len() == 0 {
+				return frames, nil
+			}
+			_, _ = remainBuf.ReadFrom(frameBuf)
+			continue
+		}
 		for {
 			n, err := reader.Read(readBuf)
 			if errors.Is(err, io.EOF) {
@@ -77,7 +97,7 @@ func (m *messagePackHubProtocol) readFrames(reader io.Reader, remainBuf *bytes.B
 				return frames, nil
 			}
 			if err != nil {
-				return nil, err
+				return nil, &incompleteFrameError{err: err, size: lenLen + frameBuf.Len()}
 			}
 			_, _ = frameBuf.Write(readBuf[:n])
 			if frameBuf.Len() == int(frameLen) {
@@ -109,10 +129,11 @@ func (m *messagePackHubProtocol) parseMessage(buf *bytes.Buffer) (interface{}, e
 	if err != nil {
 		return nil, err
 	}
-	// Ignore Header for all messages, except ping message that has no header
+	// Ignore Header for all messages, except ping, ack and sequence messages that have no header
 	// see message spec at https://github.com/dotnet/aspnetcore/blob/main/src/SignalR/docs/specs/HubProtocol.md#message-headers
-	if msgType != 6 {
-		_, err = decoder.DecodeMap()
+	var headers map[string]interface{}
+	if msgType != 6 && msgType != 8 && msgType != 9 {
+		headers, err = decoder.DecodeMap()
 		if err != nil {
 			return nil, err
 		}
@@ -128,6 +149,7 @@ func (m *messagePackHubProtocol) parseMessage(buf *bytes.Buffer) (interface{}, e
 		}
 		invocationMessage := invocationMessage{
 			Type:         msgType,
+			Headers:      stringHeaders(headers),
 			InvocationID: invocationID,
 		}
 		invocationMessage.Target, err = decoder.DecodeString()
@@ -138,6 +160,10 @@ func (m *messagePackHubProtocol) parseMessage(buf *bytes.Buffer) (interface{}, e
 		if err != nil {
 			return nil, err
 		}
+		// Check the claimed length before any argument is decoded
+		if m.maxArguments > 0 && argLen > m.maxArguments {
+			return nil, tooManyArgumentsError(m.maxArguments)
+		}
 		for i := 0; i < argLen; i++ {
 			argument, err := decoder.DecodeRaw()
 			if err != nil {
@@ -164,7 +190,7 @@ func (m *messagePackHubProtocol) parseMessage(buf *bytes.Buffer) (interface{}, e
 		if msgLen != 4 {
 			return nil, fmt.Errorf("invalid streamItemMessage length %v", msgLen)
 		}
-		streamItemMessage := streamItemMessage{Type: 2}
+		streamItemMessage := streamItemMessage{Type: 2, Headers: stringHeaders(headers)}
 		streamItemMessage.InvocationID, err = decoder.DecodeString()
 		if err != nil {
 			return nil, err
@@ -178,7 +204,7 @@ func (m *messagePackHubProtocol) parseMessage(buf *bytes.Buffer) (interface{}, e
 		if msgLen < 4 {
 			return nil, fmt.Errorf("invalid completionMessage length %v", msgLen)
 		}
-		completionMessage := completionMessage{Type: 3}
+		completionMessage := completionMessage{Type: 3, Headers: stringHeaders(headers)}
 		completionMessage.InvocationID, err = decoder.DecodeString()
 		if err != nil {
 			return nil, err
@@ -240,6 +266,26 @@ func (m *messagePackHubProtocol) parseMessage(buf *bytes.Buffer) (interface{}, e
 			}
 		}
 		return closeMessage, nil
+	case 8:
+		if msgLen != 2 {
+			return nil, fmt.Errorf("invalid ackMessage length %v", msgLen)
+		}
+		ackMessage := ackMessage{Type: 8}
+		ackMessage.SequenceID, err = decoder.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+		return ackMessage, nil
+	case 9:
+		if msgLen != 2 {
+			return nil, fmt.Errorf("invalid sequenceMessage length %v", msgLen)
+		}
+		sequenceMessage := sequenceMessage{Type: 9}
+		sequenceMessage.SequenceID, err = decoder.DecodeUint64()
+		if err != nil {
+			return nil, err
+		}
+		return sequenceMessage, nil
 	}
 	return msg, nil
 }
@@ -269,7 +315,7 @@ func (m *messagePackHubProtocol) WriteMessage(message interface{}, writer io.Wri
 	encoder.SetCustomStructTag("json")
 	switch msg := message.(type) {
 	case invocationMessage:
-		if err := encodeMsgHeader(encoder, 6, msg.Type); err != nil {
+		if err := encodeMsgHeaders(encoder, 6, msg.Type, msg.Headers); err != nil {
 			return err
 		}
 		if msg.InvocationID == "" {
@@ -301,7 +347,7 @@ func (m *messagePackHubProtocol) WriteMessage(message interface{}, writer io.Wri
 			}
 		}
 	case streamItemMessage:
-		if err := encodeMsgHeader(encoder, 4, msg.Type); err != nil {
+		if err := encodeMsgHeaders(encoder, 4, msg.Type, msg.Headers); err != nil {
 			return err
 		}
 		if err := encoder.EncodeString(msg.InvocationID); err != nil {
@@ -315,7 +361,7 @@ func (m *messagePackHubProtocol) WriteMessage(message interface{}, writer io.Wri
 		if msg.Result != nil || msg.Error != "" {
 			msgLen = 5
 		}
-		if err := encodeMsgHeader(encoder, msgLen, msg.Type); err != nil {
+		if err := encodeMsgHeaders(encoder, msgLen, msg.Type, msg.Headers); err != nil {
 			return err
 		}
 		if err := encoder.EncodeString(msg.InvocationID); err != nil {
@@ -364,6 +410,14 @@ func (m *messagePackHubProtocol) WriteMessage(message interface{}, writer io.Wri
 		if err := encoder.EncodeBool(msg.AllowReconnect); err != nil {
 			return err
 		}
+	case ackMessage:
+		if err := encodeSequenceIDMessage(encoder, msg.Type, msg.SequenceID); err != nil {
+			return err
+		}
+	case sequenceMessage:
+		if err := encodeSequenceIDMessage(encoder, msg.Type, msg.SequenceID); err != nil {
+			return err
+		}
 	}
 	// Build frame with length information
 	frameBuf := &bytes.Buffer{}
@@ -379,19 +433,51 @@ func (m *messagePackHubProtocol) WriteMessage(message interface{}, writer io.Wri
 }
 
 func encodeMsgHeader(e *msgpack.Encoder, msgLen int, msgType int) (err error) {
+	return encodeMsgHeaders(e, msgLen, msgType, nil)
+}
+
+// encodeMsgHeaders encodes the message header with headers. nil headers are encoded as empty map
+func encodeMsgHeaders(e *msgpack.Encoder, msgLen int, msgType int, headers map[string]string) (err error) {
 	if err = e.EncodeArrayLen(msgLen); err != nil {
 		return err
 	}
 	if err = e.EncodeInt(int64(msgType)); err != nil {
 		return err
 	}
-	headers := make(map[string]interface{})
-	if err = e.EncodeMap(headers); err != nil {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	if err = e.Encode(headers); err != nil {
 		return err
 	}
 	return nil
 }
 
+// stringHeaders returns the headers with string values, headers with other values are ignored
+func stringHeaders(headers map[string]interface{}) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if s, ok := value.(string); ok {
+			result[key] = s
+		}
+	}
+	return result
+}
+
+// encodeSequenceIDMessage encodes ack and sequence messages, which have no headers
+func encodeSequenceIDMessage(e *msgpack.Encoder, msgType int, sequenceID uint64) (err error) {
+	if err = e.EncodeArrayLen(2); err != nil {
+		return err
+	}
+	if err = e.EncodeInt(int64(msgType)); err != nil {
+		return err
+	}
+	return e.EncodeUint(sequenceID)
+}
+
 func (m *messagePackHubProtocol) transferMode() TransferMode {
 	return BinaryTransferMode
 }
@@ -400,6 +486,14 @@ func (m *messagePackHubProtocol) setDebugLogger(dbg StructuredLogger) {
 	m.dbg = log.WithPrefix(dbg, "ts", log.DefaultTimestampUTC, "protocol", "MSGP")
 }
 
+func (m *messagePackHubProtocol) setMaxArguments(max int) {
+	m.maxArguments = max
+}
+
+func (m *messagePackHubProtocol) setIdentifierLimits(limits identifierLimits) {
+	m.limits = limits
+}
+
 // UnmarshalArgument unmarshals raw bytes to a destination value. dst is the pointer to the destination value.
 func (m *messagePackHubProtocol) UnmarshalArgument(src interface{}, dst interface{}) error {
 	rawSrc, ok := src.(msgpack.RawMessage)
@@ -11,6 +11,18 @@ import (
 var _ = Describe("MessagePackHubProtocol", func() {
 	protocol := messagePackHubProtocol{}
 	protocol.setDebugLogger(testLogger())
+	Context("ParseMessages with maxArguments", func() {
+		It("should reject a message claiming more arguments than maxArguments before decoding them", func() {
+			p := messagePackHubProtocol{maxArguments: 10}
+			p.setDebugLogger(testLogger())
+			// [1, {}, nil, "A", array of 100000 arguments], but without the arguments
+			body := []byte{0x95, 0x01, 0x80, 0xc0, 0xa1, 'A', 0xdd, 0x00, 0x01, 0x86, 0xa0}
+			buf := bytes.NewBuffer(append([]byte{byte(len(body))}, body...))
+			_, err := p.ParseMessages(buf, &bytes.Buffer{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("more than 10 arguments"))
+		})
+	})
 	Context("ParseMessages", func() {
 		It("should encode/decode an InvocationMessage", func() {
 			message := invocationMessage{
@@ -44,5 +56,14 @@ var _ = Describe("MessagePackHubProtocol", func() {
 				Expect(reflect.Indirect(value).Interface()).To(Equal(message.Arguments[i]))
 			}
 		})
+		It("should encode/decode ack and sequence messages", func() {
+			buf := bytes.Buffer{}
+			Expect(protocol.WriteMessage(ackMessage{Type: 8, SequenceID: 3}, &buf)).To(Succeed())
+			Expect(protocol.WriteMessage(sequenceMessage{Type: 9, SequenceID: 4}, &buf)).To(Succeed())
+			remainBuf := bytes.Buffer{}
+			got, err := protocol.ParseMessages(&buf, &remainBuf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal([]interface{}{ackMessage{Type: 8, SequenceID: 3}, sequenceMessage{Type: 9, SequenceID: 4}}))
+		})
 	})
 })
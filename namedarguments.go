@@ -0,0 +1,76 @@
+package signalr
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// namedArgumentsOf returns the named arguments of the invocation. An invocation has named arguments
+// if parameter names are registered for the method, it has a single argument and no streams,
+// and the argument is an object whose properties are all registered parameter names.
+func namedArgumentsOf(invocation invocationMessage, protocol hubProtocol, parameterNames []string) (map[string]interface{}, bool) {
+	if len(parameterNames) == 0 || len(invocation.Arguments) != 1 || len(invocation.StreamIds) != 0 {
+		return nil, false
+	}
+	namedArguments := make(map[string]interface{})
+	switch protocol.(type) {
+	case *jsonHubProtocol:
+		rawArguments := make(map[string]json.RawMessage)
+		if protocol.UnmarshalArgument(invocation.Arguments[0], &rawArguments) != nil {
+			return nil, false
+		}
+		for name, rawArgument := range rawArguments {
+			namedArguments[name] = rawArgument
+		}
+	case *messagePackHubProtocol:
+		rawArguments := make(map[string]msgpack.RawMessage)
+		if protocol.UnmarshalArgument(invocation.Arguments[0], &rawArguments) != nil {
+			return nil, false
+		}
+		for name, rawArgument := range rawArguments {
+			namedArguments[name] = rawArgument
+		}
+	default:
+		return nil, false
+	}
+	for name := range namedArguments {
+		if indexOfName(parameterNames, name) < 0 {
+			return nil, false
+		}
+	}
+	return namedArguments, true
+}
+
+// buildNamedArguments assigns the named arguments to the parameters of the method with the same name
+func buildNamedArguments(method reflect.Value, target string, namedArguments map[string]interface{},
+	protocol hubProtocol, parameterNames []string) ([]reflect.Value, error) {
+	if len(parameterNames) != method.Type().NumIn() {
+		return nil, fmt.Errorf("%v parameter names registered for method %v, which has %v parameters",
+			len(parameterNames), target, method.Type().NumIn())
+	}
+	arguments := make([]reflect.Value, len(parameterNames))
+	for i, name := range parameterNames {
+		rawArgument, ok := namedArguments[name]
+		if !ok {
+			return nil, fmt.Errorf("missing argument %v of method %v", name, target)
+		}
+		argument, err := unmarshalValue(protocol, rawArgument, method.Type().In(i))
+		if err != nil {
+			return nil, fmt.Errorf("argument %v of method %v: %w", name, target, err)
+		}
+		arguments[i] = argument
+	}
+	return arguments, nil
+}
+
+func indexOfName(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,51 @@
+package signalr
+
+import (
+	"unicode"
+)
+
+// NamingConvention is the casing of the targets of the invocations which are sent to the other party, see TargetNamingConvention.
+// Received invocations are matched to the methods of the hub or receiver regardless of the casing of their target.
+type NamingConvention int
+
+const (
+	// NamingAsIs sends the targets as they are given. This is the default
+	NamingAsIs NamingConvention = iota
+	// NamingPascalCase sends the targets in PascalCase, e.g. "ReceiveMessage", like .NET clients expect them
+	NamingPascalCase
+	// NamingCamelCase sends the targets in camelCase, e.g. "receiveMessage", like JavaScript clients expect them.
+	// Leading acronyms are lower cased completely, e.g. "URLChanged" is sent as "urlChanged"
+	NamingCamelCase
+)
+
+// target returns the target in the casing of the NamingConvention
+func (n NamingConvention) target(target string) string {
+	switch n {
+	case NamingPascalCase:
+		return pascalCase(target)
+	case NamingCamelCase:
+		return camelCase(target)
+	default:
+		return target
+	}
+}
+
+func pascalCase(name string) string {
+	runes := []rune(name)
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+func camelCase(name string) string {
+	runes := []rune(name)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		// The last upper case letter of a leading acronym starts the next word
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
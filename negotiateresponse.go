@@ -1,18 +1,65 @@
 package signalr
 
-type availableTransport struct {
+import "encoding/json"
+
+// AvailableTransport describes a transport and its transfer formats offered by the server in the NegotiateResponse
+type AvailableTransport struct {
 	Transport       string   `json:"transport"`
 	TransferFormats []string `json:"transferFormats"`
 }
 
-type negotiateResponse struct {
-	ConnectionToken     string               `json:"connectionToken,omitempty"`
-	ConnectionID        string               `json:"connectionId"`
-	NegotiateVersion    int                  `json:"negotiateVersion,omitempty"`
-	AvailableTransports []availableTransport `json:"availableTransports"`
+// NegotiateResponse is the response the server sends to a negotiate request.
+// When URL is set, the response is a redirect response. The client is told to negotiate again with the server
+// at URL and to use AccessToken as bearer token for the following requests.
+// KeepAliveIntervalInMilliseconds and TimeoutInMilliseconds tell the client the KeepAliveInterval and TimeoutInterval
+// of the server, so it can set its server timeout and its ping interval accordingly.
+// Extra holds additional, application specific fields which are added to the JSON response.
+// Extra fields with the same name as the standard fields are ignored.
+type NegotiateResponse struct {
+	ConnectionToken                 string                 `json:"connectionToken,omitempty"`
+	ConnectionID                    string                 `json:"connectionId"`
+	NegotiateVersion                int                    `json:"negotiateVersion,omitempty"`
+	AvailableTransports             []AvailableTransport   `json:"availableTransports"`
+	URL                             string                 `json:"url,omitempty"`
+	AccessToken                     string                 `json:"accessToken,omitempty"`
+	KeepAliveIntervalInMilliseconds int64                  `json:"keepAliveIntervalInMilliseconds,omitempty"`
+	TimeoutInMilliseconds           int64                  `json:"timeoutInMilliseconds,omitempty"`
+	Extra                           map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON marshals the NegotiateResponse including the Extra fields.
+// A redirect response only contains the URL and the AccessToken.
+func (nr NegotiateResponse) MarshalJSON() ([]byte, error) {
+	type plainNegotiateResponse NegotiateResponse
+	var plain interface{} = plainNegotiateResponse(nr)
+	if nr.IsRedirect() {
+		plain = struct {
+			URL         string `json:"url"`
+			AccessToken string `json:"accessToken,omitempty"`
+		}{URL: nr.URL, AccessToken: nr.AccessToken}
+	}
+	b, err := json.Marshal(plain)
+	if err != nil || len(nr.Extra) == 0 {
+		return b, err
+	}
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range nr.Extra {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// IsRedirect tells if the NegotiateResponse redirects the client to another server
+func (nr *NegotiateResponse) IsRedirect() bool {
+	return nr.URL != ""
 }
 
-func (nr *negotiateResponse) getTransferFormats(transportType string) []string {
+func (nr *NegotiateResponse) getTransferFormats(transportType string) []string {
 	for _, transport := range nr.AvailableTransports {
 		if transport.Transport == transportType {
 			return transport.TransferFormats
@@ -47,6 +47,11 @@ func (nc *netConnection) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// Close closes the wrapped net.Conn
+func (nc *netConnection) Close() error {
+	return nc.conn.Close()
+}
+
 func getConnectionID() string {
 	bytes := make([]byte, 16)
 	_, _ = rand.Read(bytes)
@@ -36,9 +36,14 @@ func HandshakeTimeout(timeout time.Duration) func(Party) error {
 // a ping message is sent automatically to keep the connection open.
 // When changing KeepAliveInterval, change the Timeout setting on the other Party.
 // The recommended Timeout value is double the KeepAliveInterval value.
+// Pings are also sent while the other Party sends messages, so the connection is kept open
+// during sparse streams. Pings are written between the stream items, never inside a message.
 // Default is 15 seconds.
 func KeepAliveInterval(interval time.Duration) func(Party) error {
 	return func(p Party) error {
+		if interval <= 0 {
+			return fmt.Errorf("unsupported keepAliveInterval %v", interval)
+		}
 		p.setKeepAliveInterval(interval)
 		return nil
 	}
@@ -69,6 +74,67 @@ func MaximumReceiveMessageSize(size uint) func(Party) error {
 	}
 }
 
+// MaximumSendMessageSize is the maximum size of a single outgoing hub message.
+// Messages which would be larger are not sent. A stream with an item which is too large is completed with an error,
+// an invocation with a result which is too large is completed with an error instead of the result.
+// Default is 0, which means the size of outgoing messages is not limited.
+func MaximumSendMessageSize(size uint) func(Party) error {
+	return func(p Party) error {
+		p.setMaximumSendMessageSize(size)
+		return nil
+	}
+}
+
+// ReadChunkSize is the size of the chunks which are read from the connection at once.
+// Larger chunks need less reads for large messages, smaller chunks need less memory per connection.
+// Messages larger than a chunk are read in multiple chunks.
+// Default is the MaximumReceiveMessageSize
+func ReadChunkSize(size uint) func(Party) error {
+	return func(p Party) error {
+		if size == 0 {
+			return errors.New("unsupported readChunkSize 0")
+		}
+		p.setReadChunkSize(size)
+		return nil
+	}
+}
+
+// MaxTargetLength is the maximum length in bytes of the target of an invocation received from the other party.
+// Messages with longer targets are rejected while they are parsed, before the target is looked up or logged,
+// and the connection is closed with a protocol error.
+// Default is 0, which means the length is not limited.
+func MaxTargetLength(max uint) func(Party) error {
+	return func(p Party) error {
+		p.setMaxTargetLength(max)
+		return nil
+	}
+}
+
+// MaxInvocationIDLength is the maximum length in bytes of the invocation ids and stream ids of messages received
+// from the other party. Messages with longer ids are rejected while they are parsed, before the ids are used,
+// and the connection is closed with a protocol error.
+// Default is 0, which means the length is not limited.
+func MaxInvocationIDLength(max uint) func(Party) error {
+	return func(p Party) error {
+		p.setMaxInvocationIDLength(max)
+		return nil
+	}
+}
+
+// MaxArguments is the maximum number of arguments of an invocation received from the other party.
+// Messages with more arguments are rejected while they are parsed, before the arguments are decoded,
+// and the connection is closed with an error.
+// Default is 0, which means the number of arguments is not limited.
+func MaxArguments(max int) func(Party) error {
+	return func(p Party) error {
+		if max < 0 {
+			return fmt.Errorf("unsupported maxArguments %v", max)
+		}
+		p.setMaxArguments(max)
+		return nil
+	}
+}
+
 // ChanReceiveTimeout is the timeout for processing stream items from the client, after StreamBufferCapacity was reached
 // If the hub method is not able to process a stream item during the timeout duration,
 // the server will send a completion with error.
@@ -90,6 +156,335 @@ func EnableDetailedErrors(enable bool) func(Party) error {
 	}
 }
 
+// EnableStatefulReconnect If true, the Party acknowledges the messages it receives with ack messages,
+// checks the ack messages of the other Party and ignores messages which are sent again after a sequence message.
+// Sent messages are not buffered: a reconnect always starts a new connection, so they are not sent again.
+// Both Parties must use the ack and sequence messages of stateful reconnect.
+// The default is false.
+func EnableStatefulReconnect(enable bool) func(Party) error {
+	return func(p Party) error {
+		p.setEnableStatefulReconnect(enable)
+		return nil
+	}
+}
+
+// SequentialInvocation If true, the methods invoked by one connection are executed one after the other,
+// in the order the invocations were received. Invocations from different connections still run in parallel.
+// Use it for hubs which are not safe for concurrent use.
+// The default is false, all invocations run concurrently.
+func SequentialInvocation(sequential bool) func(Party) error {
+	return func(p Party) error {
+		p.setSequentialInvocation(sequential)
+		return nil
+	}
+}
+
+// SerializedMethods marks methods which must not run concurrently. Only one invocation of each of these methods runs at a time,
+// even if the invocations come from different connections. Invocations of different methods are not affected.
+// Use it for methods which change shared state and are not safe for concurrent use.
+// If a method returns a chan, only the method call is serialized, not the sending of the results.
+// The method names are not case sensitive. By default, no methods are serialized.
+func SerializedMethods(methods ...string) func(Party) error {
+	return func(p Party) error {
+		p.setSerializedMethods(methods)
+		return nil
+	}
+}
+
+// BindArgumentsToStruct If true, a method with a single struct parameter can be invoked with
+// multiple arguments. The arguments are assigned to the exported fields of the struct in the order of their declaration.
+// This allows porting methods with many parameters without changing the clients.
+// The default is false.
+func BindArgumentsToStruct(bind bool) func(Party) error {
+	return func(p Party) error {
+		p.setBindArgumentsToStruct(bind)
+		return nil
+	}
+}
+
+// ParameterNames registers the names of the parameters of a method, in the order of their declaration.
+// Go does not keep parameter names at runtime, so they have to be registered to allow binding by name.
+// A method with registered names can be invoked with a single argument which is an object with one
+// property per parameter, e.g. {"value": 5} for
+//
+//	func (h *MyHub) Set(value int)
+//
+// Invocations with positional arguments are still supported. The method name is not case sensitive,
+// the parameter names are case sensitive. ParameterNames can be used multiple times for different methods.
+func ParameterNames(method string, names ...string) func(Party) error {
+	return func(p Party) error {
+		if len(names) == 0 {
+			return fmt.Errorf("no parameter names given for method %v", method)
+		}
+		p.setParameterNames(method, names)
+		return nil
+	}
+}
+
+// CatchAllMethod sets the method which is invoked when no method matches the target of an invocation,
+// e.g. to route invocations dynamically or to proxy them to another server.
+// The method must have the signature
+//
+//	func (h *MyHub) CatchAll(target string, args []signalr.RawArgument) ...
+//
+// and can return results like any other method. It can not receive streams from the other party.
+// Without a CatchAllMethod, invocations of unknown targets are completed with an error.
+func CatchAllMethod(method string) func(Party) error {
+	return func(p Party) error {
+		if method == "" {
+			return errors.New("empty CatchAllMethod")
+		}
+		p.setCatchAllMethod(method)
+		return nil
+	}
+}
+
+// ResultsAsArray If true, the result of an invocation is always sent as array, even if the method returns a single value,
+// e.g. [42] instead of 42. Methods with multiple results are always sent as array.
+// Error results are not part of the array, and stream items are not affected.
+// The default is false.
+func ResultsAsArray(asArray bool) func(Party) error {
+	return func(p Party) error {
+		p.setResultsAsArray(asArray)
+		return nil
+	}
+}
+
+// CompletionErrors If true, a method whose last result is an error completes the invocation with the error
+// if it is not nil, see HubErrorDetailer. Otherwise the other results are returned as if the method had no error result,
+// e.g. 42 instead of [42, null] for a method returning (int, error), and methods which only return an error
+// complete without result.
+// If false, the error is returned as a result value like the other results. Only methods which return a chan,
+// an iterator or a producer and an error are always completed with the error, and so are errors implementing
+// HubErrorDetailer, which could not be sent as a result value with their details.
+// The default is false, because true changes the results the callers receive, see "Supported method signatures".
+func CompletionErrors(enable bool) func(Party) error {
+	return func(p Party) error {
+		p.setCompletionErrors(enable)
+		return nil
+	}
+}
+
+// UseJSONNumber If true, the JSON protocol decodes numbers in arguments, stream items and results
+// of type interface{} as json.Number instead of float64, so large integers keep their precision.
+// Numbers decoded into typed values, e.g. int64 parameters or chan int64 stream items, are always exact.
+// The messagepack protocol is not affected, it keeps the type of the encoded number.
+// Like all JSON options, it only applies to the connections of the server or client it is passed to,
+// so hubs of different servers can use different settings, even if they are mapped on the same http.ServeMux.
+// The default is false.
+func UseJSONNumber(use bool) func(Party) error {
+	return func(p Party) error {
+		p.setUseJSONNumber(use)
+		return nil
+	}
+}
+
+// JSONEscapeHTML If false, the JSON protocol does not escape <, > and & in strings as \u003c, \u003e and \u0026,
+// so the messages are smaller and clients receive the strings as they were sent.
+// Escaping is only needed if the messages are embedded into HTML.
+// The messagepack protocol is not affected.
+// The default is true.
+func JSONEscapeHTML(escape bool) func(Party) error {
+	return func(p Party) error {
+		p.setJSONEscapeHTML(escape)
+		return nil
+	}
+}
+
+// JSONDisallowUnknownFields If true, the JSON protocol rejects struct arguments, stream items and results with
+// fields which the struct does not have, so a client and a server which have drifted apart get an error
+// instead of silently losing these fields. The invocation is then completed with the binding error.
+// The messagepack protocol is not affected.
+// The default is false.
+func JSONDisallowUnknownFields(disallow bool) func(Party) error {
+	return func(p Party) error {
+		p.setJSONDisallowUnknownFields(disallow)
+		return nil
+	}
+}
+
+// StreamItemSequence If true, each stream item is sent with its sequence number in the "sequence" header of the message,
+// e.g. {"type":2,"headers":{"sequence":"1"},"invocationId":"1","item":42}.
+// The sequence starts with 1 and is incremented for each item of the stream, so clients which use a transport that
+// might reorder messages can detect gaps and reorder the items. Clients which do not know the header ignore it.
+// The default is false.
+func StreamItemSequence(sequence bool) func(Party) error {
+	return func(p Party) error {
+		p.setStreamItemSequence(sequence)
+		return nil
+	}
+}
+
+// FlushStreamsOnClose If timeout is greater than 0, a connection which is closed with an error first sends
+// the stream items which are already buffered in the channels of its active streams and completes each stream
+// with the error, so the other party gets a clean end of each stream before the close message.
+// Items which are not buffered within timeout are dropped.
+// The default is 0, which closes the connection without completing the active streams.
+func FlushStreamsOnClose(timeout time.Duration) func(Party) error {
+	return func(p Party) error {
+		if timeout < 0 {
+			return errors.New("FlushStreamsOnClose timeout must not be negative")
+		}
+		p.setFlushStreamsTimeout(timeout)
+		return nil
+	}
+}
+
+// WithClock sets the Clock which is used for all timers, e.g. the keep alive, the timeout, the handshake timeout,
+// the invocation timeout, the write coalescing delay and the reconnect backoff.
+// It is meant for tests which need to trigger these timers without waiting for them.
+// The default is a Clock which uses the time package.
+func WithClock(clock Clock) func(Party) error {
+	return func(p Party) error {
+		if clock == nil {
+			return errors.New("unsupported Clock nil")
+		}
+		p.setClock(clock)
+		return nil
+	}
+}
+
+// WriteCoalescing If delay is greater than 0, messages which are sent to a connection in quick succession
+// are collected and written to the transport at once, when delay has elapsed since the first collected message
+// or when at least size bytes have been collected. This reduces the number of writes and syscalls when many small
+// messages are sent to the same connection, e.g. on fan-out, at the cost of up to delay latency.
+// Streams can avoid this latency for single items with StreamResult.Flushed.
+// Errors of the transport are not returned by the send which caused them, but close the connection.
+// The default is 0, which writes each message at once.
+func WriteCoalescing(delay time.Duration, size uint) func(Party) error {
+	return func(p Party) error {
+		if delay < 0 {
+			return errors.New("WriteCoalescing delay must not be negative")
+		}
+		if delay > 0 && size == 0 {
+			return errors.New("unsupported WriteCoalescing size 0")
+		}
+		p.setWriteCoalescing(delay, size)
+		return nil
+	}
+}
+
+// ConnectionEvents sets a channel to which the Party publishes the lifecycle events of its connections,
+// e.g. for building dashboards. Events which can not be sent immediately are dropped,
+// so the channel should be buffered according to the expected event rate.
+func ConnectionEvents(events chan<- ConnectionEvent) func(Party) error {
+	return func(p Party) error {
+		if events == nil {
+			return errors.New("unsupported ConnectionEvents channel nil")
+		}
+		p.setConnectionEvents(events)
+		return nil
+	}
+}
+
+// PanicHandler sets a handler which is called when an invoked method panics.
+// The handler receives the id of the connection, the name of the invoked method,
+// the value recovered from the panic and the stack trace of the panicking goroutine.
+// The other Party still only receives the panic value as error, the stack trace is only sent
+// when EnableDetailedErrors is set.
+func PanicHandler(handler func(connectionID, target string, recovered interface{}, stack []byte)) func(Party) error {
+	return func(p Party) error {
+		p.setPanicHandler(handler)
+		return nil
+	}
+}
+
+// ConnectionIDRedactor sets a func which transforms connection ids before they are logged,
+// e.g. to hash or shorten them. By default, connection ids are logged unchanged.
+// The connection ids passed to hub methods, ConnectionEvents or the PanicHandler are not affected.
+func ConnectionIDRedactor(redactor func(connectionID string) string) func(Party) error {
+	return func(p Party) error {
+		if redactor == nil {
+			return errors.New("unsupported ConnectionIDRedactor nil")
+		}
+		p.setConnectionIDRedactor(redactor)
+		return nil
+	}
+}
+
+// LogPayloadLimit sets the maximum number of bytes of message payloads which are logged.
+// Longer payloads are truncated and end with a marker which tells how many bytes have been elided,
+// so huge messages do not flood the log and sensitive data in them is logged only partially.
+// Default is 0, which logs the payloads completely.
+func LogPayloadLimit(limit uint) func(Party) error {
+	return func(p Party) error {
+		p.setLogPayloadLimit(limit)
+		return nil
+	}
+}
+
+// TargetNamingConvention sets the casing of the targets of the invocations which are sent to the other party,
+// e.g. NamingCamelCase when the server invokes methods of JavaScript clients which are named in camelCase.
+// Received invocations are matched to the methods regardless of the casing of their target.
+// Default is NamingAsIs, which sends the targets as they are given.
+func TargetNamingConvention(convention NamingConvention) func(Party) error {
+	return func(p Party) error {
+		if convention < NamingAsIs || convention > NamingCamelCase {
+			return fmt.Errorf("unsupported NamingConvention %v", convention)
+		}
+		p.setTargetNaming(convention)
+		return nil
+	}
+}
+
+// PrioritizeControlMessages lets control messages be written before the invocations and stream items which are waiting
+// to be written, e.g. when many streams congest a slow connection. Control messages are completions, cancel invocations,
+// pings, close, ack and sequence messages.
+// Default is false, which writes all messages in the order in which they are waiting.
+func PrioritizeControlMessages(prioritize bool) func(Party) error {
+	return func(p Party) error {
+		p.setPrioritizeControlMessages(prioritize)
+		return nil
+	}
+}
+
+// RawMode lets the party skip the handshake and exchange messages with protocol, "json", "messagepack" or "protobuf", from the start.
+// It is meant for links between trusted internal services over a Connection like NewNetConnection, where both
+// ends are configured alike. Connections over HTTP still negotiate, only the handshake is skipped.
+// RawMode is not part of the SignalR protocol: A party in RawMode can only talk to another party in RawMode with the
+// same protocol, not to standard SignalR servers or clients. Options which rely on the handshake, like HandshakeFields
+// and HandshakeExtension, have no effect.
+// Default is "", which means the handshake is done.
+func RawMode(protocol string) func(Party) error {
+	return func(p Party) error {
+		if _, ok := protocolMap[protocol]; !ok {
+			return fmt.Errorf("RawMode: unsupported protocol %q", protocol)
+		}
+		p.setRawProtocol(protocol)
+		return nil
+	}
+}
+
+// InvokeChanResults sets how a non-streaming invocation of a method which returns a chan, an iterator or a producer
+// is completed. With ChanResultFirstItem, the first item is the result of the invocation, like with an async method.
+// With ChanResultReject, the invocation is completed with an error and the method is not called,
+// because the caller would not receive the following items.
+// Default is ChanResultFirstItem.
+func InvokeChanResults(policy ChanResultPolicy) func(Party) error {
+	return func(p Party) error {
+		if policy < ChanResultFirstItem || policy > ChanResultReject {
+			return fmt.Errorf("unsupported ChanResultPolicy %v", policy)
+		}
+		p.setChanResultPolicy(policy)
+		return nil
+	}
+}
+
+// RewriteTargets sets a function which maps the target of each invocation received from the other party to the name
+// of the method which is invoked, e.g. to strip a version prefix from the targets of older clients
+// or to route them to methods with a new name. It is applied before the invocation is authorized and dispatched,
+// so InvocationMiddleware, CatchAllMethod and the logs see the rewritten target.
+// Default is to invoke the methods with the names of the targets.
+func RewriteTargets(rewrite func(target string) string) func(Party) error {
+	return func(p Party) error {
+		if rewrite == nil {
+			return errors.New("RewriteTargets function nil")
+		}
+		p.setTargetRewriter(rewrite)
+		return nil
+	}
+}
+
 // StructuredLogger is the simplest logging interface for structured logging.
 // See github.com/go-kit/log
 type StructuredLogger interface {
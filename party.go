@@ -2,6 +2,8 @@ package signalr
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
@@ -14,7 +16,7 @@ type Party interface {
 	cancel()
 
 	onConnected(hc hubConnection)
-	onDisconnected(hc hubConnection)
+	onDisconnected(hc hubConnection, reason DisconnectReason)
 
 	invocationTarget(hc hubConnection) interface{}
 
@@ -38,11 +40,88 @@ type Party interface {
 	streamBufferCapacity() uint
 	setStreamBufferCapacity(capacity uint)
 
-	allowReconnect() bool
+	closeMessageFor(reason DisconnectReason, err error) (errorText string, allowReconnect bool)
+
+	authorizeInvocation(hc hubConnection, target string) error
+
+	bufferBudget() *bufferBudget
 
 	enableDetailedErrors() bool
 	setEnableDetailedErrors(enable bool)
 
+	enableStatefulReconnect() bool
+	setEnableStatefulReconnect(enable bool)
+
+	sequentialInvocation() bool
+	setSequentialInvocation(sequential bool)
+
+	serializedMethodLock(method string) sync.Locker
+	setSerializedMethods(methods []string)
+
+	useJSONNumber() bool
+	setUseJSONNumber(use bool)
+
+	jsonEscapeHTML() bool
+	setJSONEscapeHTML(escape bool)
+
+	jsonDisallowUnknownFields() bool
+	setJSONDisallowUnknownFields(disallow bool)
+
+	streamItemSequence() bool
+	setStreamItemSequence(sequence bool)
+
+	flushStreamsTimeout() time.Duration
+	setFlushStreamsTimeout(timeout time.Duration)
+
+	clock() Clock
+	setClock(clock Clock)
+
+	writeCoalescingDelay() time.Duration
+	writeCoalescingSize() uint
+	setWriteCoalescing(delay time.Duration, size uint)
+
+	bindArgumentsToStruct() bool
+	setBindArgumentsToStruct(bind bool)
+
+	parameterNames(method string) []string
+	setParameterNames(method string, names []string)
+
+	catchAllMethod() string
+	setCatchAllMethod(method string)
+
+	resultsAsArray() bool
+	setResultsAsArray(asArray bool)
+
+	completionErrors() bool
+	setCompletionErrors(enable bool)
+
+	publishConnectionEvent(event ConnectionEvent)
+	setConnectionEvents(events chan<- ConnectionEvent)
+
+	panicHandler() func(connectionID, target string, recovered interface{}, stack []byte)
+	setPanicHandler(handler func(connectionID, target string, recovered interface{}, stack []byte))
+
+	redactConnectionID(connectionID string) string
+	setConnectionIDRedactor(redactor func(connectionID string) string)
+
+	logPayloadLimit() uint
+	setLogPayloadLimit(limit uint)
+
+	targetNaming() NamingConvention
+	setTargetNaming(convention NamingConvention)
+
+	prioritizeControlMessages() bool
+	setPrioritizeControlMessages(prioritize bool)
+
+	rawProtocol() string
+	setRawProtocol(protocol string)
+
+	chanResultPolicy() ChanResultPolicy
+	setChanResultPolicy(policy ChanResultPolicy)
+
+	targetRewriter() func(target string) string
+	setTargetRewriter(rewrite func(target string) string)
+
 	loggers() (info StructuredLogger, dbg StructuredLogger)
 	setLoggers(info StructuredLogger, dbg StructuredLogger)
 
@@ -50,6 +129,19 @@ type Party interface {
 
 	maximumReceiveMessageSize() uint
 	setMaximumReceiveMessageSize(size uint)
+
+	maximumSendMessageSize() uint
+	setMaximumSendMessageSize(size uint)
+
+	readChunkSize() uint
+	setReadChunkSize(size uint)
+
+	maxArguments() int
+	setMaxArguments(max int)
+
+	identifierLimits() identifierLimits
+	setMaxTargetLength(max uint)
+	setMaxInvocationIDLength(max uint)
 }
 
 func newPartyBase(parentContext context.Context, info log.Logger, dbg log.Logger) partyBase {
@@ -64,6 +156,10 @@ func newPartyBase(parentContext context.Context, info log.Logger, dbg log.Logger
 		_streamBufferCapacity:      10,
 		_maximumReceiveMessageSize: 1 << 15, // 32KB
 		_enableDetailedErrors:      false,
+		_enableStatefulReconnect:   false,
+		_sequentialInvocation:      false,
+		_jsonEscapeHTML:            true,
+		_clock:                     realClock{},
 		_insecureSkipVerify:        false,
 		_originPatterns:            nil,
 		info:                       info,
@@ -80,7 +176,36 @@ type partyBase struct {
 	_chanReceiveTimeout        time.Duration
 	_streamBufferCapacity      uint
 	_maximumReceiveMessageSize uint
+	_maximumSendMessageSize    uint
+	_readChunkSize             uint
+	_maxArguments              int
+	_identifierLimits          identifierLimits
 	_enableDetailedErrors      bool
+	_enableStatefulReconnect   bool
+	_sequentialInvocation      bool
+	_serializedMethods         map[string]*sync.Mutex
+	_bindArgumentsToStruct     bool
+	_parameterNames            map[string][]string
+	_catchAllMethod            string
+	_resultsAsArray            bool
+	_completionErrors          bool
+	_useJSONNumber             bool
+	_jsonEscapeHTML            bool
+	_jsonDisallowUnknownFields bool
+	_streamItemSequence        bool
+	_flushStreamsTimeout       time.Duration
+	_writeCoalescingDelay      time.Duration
+	_clock                     Clock
+	_writeCoalescingSize       uint
+	_connectionEvents          chan<- ConnectionEvent
+	_panicHandler              func(connectionID, target string, recovered interface{}, stack []byte)
+	_connectionIDRedactor      func(connectionID string) string
+	_logPayloadLimit           uint
+	_targetNaming              NamingConvention
+	_prioritizeControlMessages bool
+	_rawProtocol               string
+	_chanResultPolicy          ChanResultPolicy
+	_targetRewriter            func(target string) string
 	_insecureSkipVerify		   bool
 	_originPatterns             []string
 	info                       StructuredLogger
@@ -157,6 +282,46 @@ func (p *partyBase) setMaximumReceiveMessageSize(size uint) {
 	p._maximumReceiveMessageSize = size
 }
 
+func (p *partyBase) maximumSendMessageSize() uint {
+	return p._maximumSendMessageSize
+}
+
+func (p *partyBase) setMaximumSendMessageSize(size uint) {
+	p._maximumSendMessageSize = size
+}
+
+// readChunkSize is the size of the chunks read from the connection. If not set, it is the maximumReceiveMessageSize
+func (p *partyBase) readChunkSize() uint {
+	if p._readChunkSize == 0 {
+		return p._maximumReceiveMessageSize
+	}
+	return p._readChunkSize
+}
+
+func (p *partyBase) setReadChunkSize(size uint) {
+	p._readChunkSize = size
+}
+
+func (p *partyBase) maxArguments() int {
+	return p._maxArguments
+}
+
+func (p *partyBase) setMaxArguments(max int) {
+	p._maxArguments = max
+}
+
+func (p *partyBase) identifierLimits() identifierLimits {
+	return p._identifierLimits
+}
+
+func (p *partyBase) setMaxTargetLength(max uint) {
+	p._identifierLimits.target = max
+}
+
+func (p *partyBase) setMaxInvocationIDLength(max uint) {
+	p._identifierLimits.invocationID = max
+}
+
 func (p *partyBase) enableDetailedErrors() bool {
 	return p._enableDetailedErrors
 }
@@ -165,6 +330,227 @@ func (p *partyBase) setEnableDetailedErrors(enable bool) {
 	p._enableDetailedErrors = enable
 }
 
+func (p *partyBase) enableStatefulReconnect() bool {
+	return p._enableStatefulReconnect
+}
+
+func (p *partyBase) setEnableStatefulReconnect(enable bool) {
+	p._enableStatefulReconnect = enable
+}
+
+func (p *partyBase) sequentialInvocation() bool {
+	return p._sequentialInvocation
+}
+
+func (p *partyBase) setSequentialInvocation(sequential bool) {
+	p._sequentialInvocation = sequential
+}
+
+// serializedMethodLock returns the lock which has to be held while the method runs,
+// or nil if the method is not serialized
+func (p *partyBase) serializedMethodLock(method string) sync.Locker {
+	if lock, ok := p._serializedMethods[strings.ToLower(method)]; ok {
+		return lock
+	}
+	return nil
+}
+
+func (p *partyBase) setSerializedMethods(methods []string) {
+	p._serializedMethods = make(map[string]*sync.Mutex, len(methods))
+	for _, method := range methods {
+		p._serializedMethods[strings.ToLower(method)] = &sync.Mutex{}
+	}
+}
+
+func (p *partyBase) bindArgumentsToStruct() bool {
+	return p._bindArgumentsToStruct
+}
+
+func (p *partyBase) setBindArgumentsToStruct(bind bool) {
+	p._bindArgumentsToStruct = bind
+}
+
+// parameterNames returns the names registered for the parameters of the method, or nil if no names are registered
+func (p *partyBase) parameterNames(method string) []string {
+	return p._parameterNames[strings.ToLower(method)]
+}
+
+func (p *partyBase) setParameterNames(method string, names []string) {
+	if p._parameterNames == nil {
+		p._parameterNames = make(map[string][]string)
+	}
+	p._parameterNames[strings.ToLower(method)] = names
+}
+
+func (p *partyBase) catchAllMethod() string {
+	return p._catchAllMethod
+}
+
+func (p *partyBase) setCatchAllMethod(method string) {
+	p._catchAllMethod = method
+}
+
+func (p *partyBase) resultsAsArray() bool {
+	return p._resultsAsArray
+}
+
+func (p *partyBase) setResultsAsArray(asArray bool) {
+	p._resultsAsArray = asArray
+}
+
+func (p *partyBase) completionErrors() bool {
+	return p._completionErrors
+}
+
+func (p *partyBase) setCompletionErrors(enable bool) {
+	p._completionErrors = enable
+}
+
+func (p *partyBase) useJSONNumber() bool {
+	return p._useJSONNumber
+}
+
+func (p *partyBase) setUseJSONNumber(use bool) {
+	p._useJSONNumber = use
+}
+
+func (p *partyBase) jsonEscapeHTML() bool {
+	return p._jsonEscapeHTML
+}
+
+func (p *partyBase) setJSONEscapeHTML(escape bool) {
+	p._jsonEscapeHTML = escape
+}
+
+func (p *partyBase) jsonDisallowUnknownFields() bool {
+	return p._jsonDisallowUnknownFields
+}
+
+func (p *partyBase) setJSONDisallowUnknownFields(disallow bool) {
+	p._jsonDisallowUnknownFields = disallow
+}
+
+func (p *partyBase) streamItemSequence() bool {
+	return p._streamItemSequence
+}
+
+func (p *partyBase) setStreamItemSequence(sequence bool) {
+	p._streamItemSequence = sequence
+}
+
+func (p *partyBase) flushStreamsTimeout() time.Duration {
+	return p._flushStreamsTimeout
+}
+
+func (p *partyBase) setFlushStreamsTimeout(timeout time.Duration) {
+	p._flushStreamsTimeout = timeout
+}
+
+func (p *partyBase) clock() Clock {
+	return p._clock
+}
+
+func (p *partyBase) setClock(clock Clock) {
+	p._clock = clock
+}
+
+func (p *partyBase) writeCoalescingDelay() time.Duration {
+	return p._writeCoalescingDelay
+}
+
+func (p *partyBase) writeCoalescingSize() uint {
+	return p._writeCoalescingSize
+}
+
+func (p *partyBase) setWriteCoalescing(delay time.Duration, size uint) {
+	p._writeCoalescingDelay = delay
+	p._writeCoalescingSize = size
+}
+
+// publishConnectionEvent sends the event to the ConnectionEvents channel.
+// When the channel is not ready to receive, the event is dropped, so slow receivers can not block the connection
+func (p *partyBase) publishConnectionEvent(event ConnectionEvent) {
+	if p._connectionEvents == nil {
+		return
+	}
+	select {
+	case p._connectionEvents <- event:
+	default:
+	}
+}
+
+func (p *partyBase) setConnectionEvents(events chan<- ConnectionEvent) {
+	p._connectionEvents = events
+}
+
+func (p *partyBase) panicHandler() func(connectionID, target string, recovered interface{}, stack []byte) {
+	return p._panicHandler
+}
+
+func (p *partyBase) setPanicHandler(handler func(connectionID, target string, recovered interface{}, stack []byte)) {
+	p._panicHandler = handler
+}
+
+// redactConnectionID transforms the connection id before it is logged
+func (p *partyBase) redactConnectionID(connectionID string) string {
+	if p._connectionIDRedactor == nil || connectionID == "" {
+		return connectionID
+	}
+	return p._connectionIDRedactor(connectionID)
+}
+
+func (p *partyBase) setConnectionIDRedactor(redactor func(connectionID string) string) {
+	p._connectionIDRedactor = redactor
+}
+
+func (p *partyBase) logPayloadLimit() uint {
+	return p._logPayloadLimit
+}
+
+func (p *partyBase) setLogPayloadLimit(limit uint) {
+	p._logPayloadLimit = limit
+}
+
+func (p *partyBase) targetNaming() NamingConvention {
+	return p._targetNaming
+}
+
+func (p *partyBase) setTargetNaming(convention NamingConvention) {
+	p._targetNaming = convention
+}
+
+func (p *partyBase) prioritizeControlMessages() bool {
+	return p._prioritizeControlMessages
+}
+
+func (p *partyBase) setPrioritizeControlMessages(prioritize bool) {
+	p._prioritizeControlMessages = prioritize
+}
+
+func (p *partyBase) rawProtocol() string {
+	return p._rawProtocol
+}
+
+func (p *partyBase) setRawProtocol(protocol string) {
+	p._rawProtocol = protocol
+}
+
+func (p *partyBase) chanResultPolicy() ChanResultPolicy {
+	return p._chanResultPolicy
+}
+
+func (p *partyBase) setChanResultPolicy(policy ChanResultPolicy) {
+	p._chanResultPolicy = policy
+}
+
+func (p *partyBase) targetRewriter() func(target string) string {
+	return p._targetRewriter
+}
+
+func (p *partyBase) setTargetRewriter(rewrite func(target string) string) {
+	p._targetRewriter = rewrite
+}
+
 func (p *partyBase) setLoggers(info StructuredLogger, dbg StructuredLogger) {
 	p.info = info
 	p.dbg = dbg
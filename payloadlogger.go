@@ -0,0 +1,48 @@
+package signalr
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// payloadLogger truncates the logged message payloads, which are logged with the key "message", to limit bytes
+type payloadLogger struct {
+	logger StructuredLogger
+	limit  uint
+}
+
+func (p *payloadLogger) Log(keyVals ...interface{}) error {
+	truncated, copied := keyVals, false
+	for i := 0; i+1 < len(keyVals); i += 2 {
+		if keyVals[i] != msg {
+			continue
+		}
+		if text, ok := keyVals[i+1].(string); ok && uint(len(text)) > p.limit {
+			if !copied {
+				// Do not change the key values of the caller
+				truncated, copied = append([]interface{}{}, keyVals...), true
+			}
+			truncated[i+1] = truncatePayload(text, p.limit)
+		}
+	}
+	return p.logger.Log(truncated...)
+}
+
+// truncatePayload returns the first limit bytes of text, without splitting a UTF-8 character,
+// followed by a marker which tells how many bytes have been elided
+func truncatePayload(text string, limit uint) string {
+	end := int(limit)
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return fmt.Sprintf("%s...(%d bytes elided)", text[:end], len(text)-end)
+}
+
+// limitLoggedPayloads wraps the loggers of the party, so they truncate the logged message payloads, see LogPayloadLimit
+func (p *partyBase) limitLoggedPayloads() {
+	if p._logPayloadLimit == 0 {
+		return
+	}
+	p.info = &payloadLogger{logger: p.info, limit: p._logPayloadLimit}
+	p.dbg = &payloadLogger{logger: p.dbg, limit: p._logPayloadLimit}
+}
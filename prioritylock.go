@@ -0,0 +1,60 @@
+package signalr
+
+import "sync"
+
+// priorityLock is a mutex which is handed over to the waiting holders in the order of their arrival,
+// except that holders which lock with high priority are served before all waiting holders with normal priority.
+type priorityLock struct {
+	mx     sync.Mutex
+	locked bool
+	high   []chan struct{}
+	normal []chan struct{}
+}
+
+// Lock locks the priorityLock. If it is already locked, Lock waits until it is handed over
+func (p *priorityLock) Lock(high bool) {
+	p.mx.Lock()
+	if !p.locked {
+		p.locked = true
+		p.mx.Unlock()
+		return
+	}
+	handover := make(chan struct{})
+	if high {
+		p.high = append(p.high, handover)
+	} else {
+		p.normal = append(p.normal, handover)
+	}
+	p.mx.Unlock()
+	<-handover
+}
+
+// Unlock hands the priorityLock over to the next waiting holder or unlocks it if no holder is waiting
+func (p *priorityLock) Unlock() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	var next chan struct{}
+	switch {
+	case len(p.high) > 0:
+		next, p.high = p.high[0], p.high[1:]
+	case len(p.normal) > 0:
+		next, p.normal = p.normal[0], p.normal[1:]
+	default:
+		p.locked = false
+		return
+	}
+	close(next)
+}
+
+// hasPriority reports if message is a control message which is written before queued invocations and stream items,
+// see PrioritizeControlMessages
+func hasPriority(message interface{}) bool {
+	switch message.(type) {
+	case completionMessage, cancelInvocationMessage, closeMessage, ackMessage, sequenceMessage:
+		return true
+	case hubMessage:
+		// Ping
+		return true
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrProgressNotSupported is returned by ReportProgress when ctx is not the context of an invocation which can report progress
+var ErrProgressNotSupported = errors.New("invocation can not report progress")
+
+// ProgressHeader is the header of an invocation which lets the invoked method report progress with ReportProgress.
+// Client.InvokeWithProgress sets it to "true". Other SignalR clients do not expect stream items for non-streaming
+// invocations, so progress is only sent when the caller has set this header.
+const ProgressHeader = "x-progress"
+
+type progressKey struct{}
+
+// progressReporter sends progress as stream items with the id of the invocation
+type progressReporter struct {
+	invocationID string
+	hubConn      hubConnection
+}
+
+// withProgress returns a context which lets the invoked method report progress to the caller of the invocation
+func withProgress(ctx context.Context, invocationID string, hubConn hubConnection) context.Context {
+	return context.WithValue(ctx, progressKey{}, progressReporter{invocationID: invocationID, hubConn: hubConn})
+}
+
+// ReportProgress sends progress to the caller of a hub method while the method is running.
+// ctx must be the context.Context the method has received as first parameter. The method returns its result as usual,
+// which is sent to the caller with the completion after all progress values.
+// Progress is sent as stream item with the id of the invocation. Callers which use Client.InvokeWithProgress receive
+// the progress values on their progress channel.
+// ReportProgress returns ErrProgressNotSupported when the caller has not asked for progress with ProgressHeader,
+// e.g. when it has used Client.Invoke or another SignalR client, and for stream invocations and invocations
+// which do not expect a result.
+// It must not be called after the method has returned.
+func ReportProgress(ctx context.Context, progress interface{}) error {
+	reporter, ok := ctx.Value(progressKey{}).(progressReporter)
+	if !ok {
+		return ErrProgressNotSupported
+	}
+	return reporter.hubConn.StreamItem(reporter.invocationID, progress, 0)
+}
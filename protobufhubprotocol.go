@@ -0,0 +1,392 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-kit/log"
+)
+
+// protobufHubProtocol is the Protobuf based hub protocol "protobuf". It is no standard SignalR protocol,
+// so it can only be used between parties of this package or clients which implement it, see UseHubProtocol.
+// Like with MessagePack, each frame is prefixed with its length as varint, which is the usual framing of
+// delimited Protobuf messages. Each frame holds one HubMessage of the following proto3 schema:
+//
+//	message HubMessage {
+//	  int32 type = 1;
+//	  map<string, string> headers = 2;
+//	  string invocation_id = 3;
+//	  string target = 4;
+//	  repeated bytes arguments = 5;
+//	  repeated string stream_ids = 6;
+//	  bytes item = 7;
+//	  bytes result = 8;
+//	  string error = 9;
+//	  bool allow_reconnect = 10;
+//	  uint64 sequence_id = 11;
+//	}
+//
+// The fields are used like the fields with the same name in the JSON protocol.
+// Arguments, items and results are JSON, because hub methods take and return arbitrary Go values which have
+// no Protobuf schema. A completion without result has no result field.
+type protobufHubProtocol struct {
+	dbg log.Logger
+	// values encodes and decodes arguments, items and results
+	values jsonHubProtocol
+	// maxArguments is the maximum number of arguments of an invocation. 0 means unlimited
+	maxArguments int
+	// limits are the maximum lengths of targets and invocation ids
+	limits identifierLimits
+}
+
+// Field numbers of HubMessage
+const (
+	pbType           = 1
+	pbHeaders        = 2
+	pbInvocationID   = 3
+	pbTarget         = 4
+	pbArguments      = 5
+	pbStreamIds      = 6
+	pbItem           = 7
+	pbResult         = 8
+	pbError          = 9
+	pbAllowReconnect = 10
+	pbSequenceID     = 11
+)
+
+// Protobuf wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+func (p *protobufHubProtocol) ParseMessages(reader io.Reader, remainBuf *bytes.Buffer) ([]interface{}, error) {
+	frames, err := readVarintFrames(reader, remainBuf)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]interface{}, 0)
+	for _, frame := range frames {
+		message, err := p.parseMessage(frame)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.limits.check(message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// protobufFields are the fields of a parsed HubMessage
+type protobufFields struct {
+	msgType        int
+	headers        map[string]string
+	invocationID   string
+	target         string
+	arguments      []interface{}
+	streamIds      []string
+	item           json.RawMessage
+	result         json.RawMessage
+	error          string
+	allowReconnect bool
+	sequenceID     uint64
+}
+
+func (p *protobufHubProtocol) parseMessage(frame []byte) (interface{}, error) {
+	var f protobufFields
+	err := readProtobufFields(frame, func(field int, wireType int, varint uint64, data []byte) error {
+		switch {
+		case field == pbType && wireType == pbVarint:
+			f.msgType = int(int32(varint))
+		case field == pbHeaders && wireType == pbBytes:
+			if f.headers == nil {
+				f.headers = make(map[string]string)
+			}
+			var key, value string
+			if err := readProtobufFields(data, func(field int, wireType int, _ uint64, data []byte) error {
+				if wireType == pbBytes {
+					switch field {
+					case 1:
+						key = string(data)
+					case 2:
+						value = string(data)
+					}
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			f.headers[key] = value
+		case field == pbInvocationID && wireType == pbBytes:
+			f.invocationID = string(data)
+		case field == pbTarget && wireType == pbBytes:
+			f.target = string(data)
+		case field == pbArguments && wireType == pbBytes:
+			if p.maxArguments > 0 && len(f.arguments) == p.maxArguments {
+				return tooManyArgumentsError(p.maxArguments)
+			}
+			f.arguments = append(f.arguments, json.RawMessage(data))
+		case field == pbStreamIds && wireType == pbBytes:
+			f.streamIds = append(f.streamIds, string(data))
+		case field == pbItem && wireType == pbBytes:
+			f.item = data
+		case field == pbResult && wireType == pbBytes:
+			f.result = data
+		case field == pbError && wireType == pbBytes:
+			f.error = string(data)
+		case field == pbAllowReconnect && wireType == pbVarint:
+			f.allowReconnect = varint != 0
+		case field == pbSequenceID && wireType == pbVarint:
+			f.sequenceID = varint
+		}
+		// Unknown fields are skipped, so newer parties can add fields
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch f.msgType {
+	case 1, 4:
+		arguments := f.arguments
+		if arguments == nil {
+			arguments = make([]interface{}, 0)
+		}
+		return invocationMessage{
+			Type:         f.msgType,
+			Headers:      f.headers,
+			Target:       f.target,
+			InvocationID: f.invocationID,
+			Arguments:    arguments,
+			StreamIds:    f.streamIds,
+		}, nil
+	case 2:
+		item := f.item
+		if len(item) == 0 {
+			item = json.RawMessage("null")
+		}
+		return streamItemMessage{Type: 2, Headers: f.headers, InvocationID: f.invocationID, Item: item}, nil
+	case 3:
+		completion := completionMessage{Type: 3, Headers: f.headers, InvocationID: f.invocationID, Error: f.error}
+		// Like with JSON, Result must stay a nil interface{} if there is no result
+		if len(f.result) > 0 {
+			completion.Result = f.result
+		}
+		return completion, nil
+	case 5:
+		return cancelInvocationMessage{Type: 5, InvocationID: f.invocationID}, nil
+	case 7:
+		return closeMessage{Type: 7, Error: f.error, AllowReconnect: f.allowReconnect}, nil
+	case 8:
+		return ackMessage{Type: 8, SequenceID: f.sequenceID}, nil
+	case 9:
+		return sequenceMessage{Type: 9, SequenceID: f.sequenceID}, nil
+	default:
+		return hubMessage{Type: f.msgType}, nil
+	}
+}
+
+// readProtobufFields calls onField for each field of the encoded Protobuf message in data.
+// varint is set for varint fields, data for length delimited fields. Fixed size fields are skipped
+func readProtobufFields(data []byte, onField func(field int, wireType int, varint uint64, data []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		data = data[n:]
+		field, wireType := int(key>>3), int(key&7)
+		var varint uint64
+		var value []byte
+		switch wireType {
+		case pbVarint:
+			if varint, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("invalid protobuf varint in field %v", field)
+			}
+			data = data[n:]
+		case pbBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return fmt.Errorf("invalid protobuf length in field %v", field)
+			}
+			value = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case pbFixed64, pbFixed32:
+			size := 8
+			if wireType == pbFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return fmt.Errorf("invalid protobuf fixed size value in field %v", field)
+			}
+			data = data[size:]
+			continue
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %v in field %v", wireType, field)
+		}
+		if err := onField(field, wireType, varint, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *protobufHubProtocol) WriteMessage(message interface{}, writer io.Writer) error {
+	w := &protobufWriter{}
+	switch msg := message.(type) {
+	case invocationMessage:
+		w.varint(pbType, uint64(msg.Type))
+		w.headers(msg.Headers)
+		w.string(pbInvocationID, msg.InvocationID)
+		w.string(pbTarget, msg.Target)
+		for _, argument := range msg.Arguments {
+			value, err := p.marshalValue(argument)
+			if err != nil {
+				return err
+			}
+			// Arguments are repeated, so they are written even if they are empty
+			w.bytes(pbArguments, value, true)
+		}
+		for _, streamID := range msg.StreamIds {
+			w.bytes(pbStreamIds, []byte(streamID), true)
+		}
+	case streamItemMessage:
+		w.varint(pbType, uint64(msg.Type))
+		w.headers(msg.Headers)
+		w.string(pbInvocationID, msg.InvocationID)
+		item, err := p.marshalValue(msg.Item)
+		if err != nil {
+			return err
+		}
+		w.bytes(pbItem, item, false)
+	case completionMessage:
+		w.varint(pbType, uint64(msg.Type))
+		w.headers(msg.Headers)
+		w.string(pbInvocationID, msg.InvocationID)
+		if msg.Result != nil {
+			result, err := p.marshalValue(msg.Result)
+			if err != nil {
+				return err
+			}
+			w.bytes(pbResult, result, false)
+		}
+		w.string(pbError, msg.Error)
+	case cancelInvocationMessage:
+		w.varint(pbType, uint64(msg.Type))
+		w.string(pbInvocationID, msg.InvocationID)
+	case hubMessage:
+		w.varint(pbType, uint64(msg.Type))
+	case closeMessage:
+		w.varint(pbType, uint64(msg.Type))
+		w.string(pbError, msg.Error)
+		if msg.AllowReconnect {
+			w.varint(pbAllowReconnect, 1)
+		}
+	case ackMessage:
+		w.varint(pbType, uint64(msg.Type))
+		w.varint(pbSequenceID, msg.SequenceID)
+	case sequenceMessage:
+		w.varint(pbType, uint64(msg.Type))
+		w.varint(pbSequenceID, msg.SequenceID)
+	default:
+		return fmt.Errorf("%w: unsupported message %T", ErrMarshalFailed, message)
+	}
+	// Build frame with length information
+	frameBuf := &bytes.Buffer{}
+	lenBuf := make([]byte, binary.MaxVarintLen32)
+	lenLen := binary.PutUvarint(lenBuf, uint64(w.buf.Len()))
+	_, _ = frameBuf.Write(lenBuf[:lenLen])
+	_ = p.dbg.Log(evt, "Write", msg, fmtMsg(message))
+	_, _ = frameBuf.ReadFrom(&w.buf)
+	_, err := frameBuf.WriteTo(writer)
+	return err
+}
+
+// marshalValue returns the JSON of an argument, item or result
+func (p *protobufHubProtocol) marshalValue(value interface{}) ([]byte, error) {
+	b, err := p.values.marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMarshalFailed, err)
+	}
+	return b, nil
+}
+
+// protobufWriter encodes the fields of a Protobuf message. Fields with default values are omitted, like in proto3
+type protobufWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *protobufWriter) key(field int, wireType int) {
+	w.uvarint(uint64(field<<3 | wireType))
+}
+
+func (w *protobufWriter) uvarint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	_, _ = w.buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func (w *protobufWriter) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.key(field, pbVarint)
+	w.uvarint(v)
+}
+
+func (w *protobufWriter) bytes(field int, b []byte, always bool) {
+	if len(b) == 0 && !always {
+		return
+	}
+	w.key(field, pbBytes)
+	w.uvarint(uint64(len(b)))
+	_, _ = w.buf.Write(b)
+}
+
+func (w *protobufWriter) string(field int, s string) {
+	w.bytes(field, []byte(s), false)
+}
+
+// headers writes the headers as map entries, sorted by key so equal messages are encoded equally
+func (w *protobufWriter) headers(headers map[string]string) {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := &protobufWriter{}
+		entry.string(1, key)
+		entry.string(2, headers[key])
+		w.bytes(pbHeaders, entry.buf.Bytes(), true)
+	}
+}
+
+// UnmarshalArgument unmarshals the JSON of an argument, item or result into dst
+func (p *protobufHubProtocol) UnmarshalArgument(src interface{}, dst interface{}) error {
+	return p.values.UnmarshalArgument(src, dst)
+}
+
+func (p *protobufHubProtocol) transferMode() TransferMode {
+	return BinaryTransferMode
+}
+
+func (p *protobufHubProtocol) setDebugLogger(dbg StructuredLogger) {
+	p.dbg = log.WithPrefix(dbg, "ts", log.DefaultTimestampUTC, "protocol", "PROTOBUF")
+	p.values.setDebugLogger(dbg)
+}
+
+func (p *protobufHubProtocol) setMaxArguments(max int) {
+	p.maxArguments = max
+}
+
+func (p *protobufHubProtocol) setIdentifierLimits(limits identifierLimits) {
+	p.limits = limits
+}
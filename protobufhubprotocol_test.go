@@ -0,0 +1,166 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// protobufFixture is a client of the "protobuf" hub protocol which is not built with this package.
+// It sends frames encoded by hand and reads the frames of the server
+type protobufFixture struct {
+	conn   *pipeConnection
+	reader *bufio.Reader
+}
+
+func newProtobufFixture(conn *pipeConnection) *protobufFixture {
+	return &protobufFixture{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (f *protobufFixture) handshake() {
+	_, err := f.conn.Write([]byte("{\"protocol\":\"protobuf\",\"version\":1}\u001e"))
+	Expect(err).NotTo(HaveOccurred())
+	response, err := f.reader.ReadString(0x1e)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(response).To(Equal("{}\u001e"))
+}
+
+func (f *protobufFixture) send(body []byte) {
+	_, err := f.conn.Write(append([]byte{byte(len(body))}, body...))
+	Expect(err).NotTo(HaveOccurred())
+}
+
+func (f *protobufFixture) receive() []byte {
+	length, err := binary.ReadUvarint(f.reader)
+	Expect(err).NotTo(HaveOccurred())
+	body := make([]byte, length)
+	_, err = io.ReadFull(f.reader, body)
+	Expect(err).NotTo(HaveOccurred())
+	return body
+}
+
+var _ = Describe("ProtobufHubProtocol", func() {
+	protocol := &protobufHubProtocol{}
+	protocol.setDebugLogger(testLogger())
+
+	Context("ParseMessages", func() {
+		It("should skip unknown fields", func() {
+			// type: 6, field 15 (varint): 7, field 16 (bytes): "x", field 17 (fixed32): 0
+			body := []byte{0x08, 0x06, 0x78, 0x07, 0x82, 0x01, 0x01, 'x', 0x8d, 0x01, 0, 0, 0, 0}
+			got, err := protocol.ParseMessages(bytes.NewBuffer(append([]byte{byte(len(body))}, body...)), &bytes.Buffer{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal([]interface{}{hubMessage{Type: 6}}))
+		})
+		It("should reject a field which exceeds the frame", func() {
+			// type: 3, invocation_id with length 10, but only one byte
+			body := []byte{0x08, 0x03, 0x1a, 0x0a, '1'}
+			_, err := protocol.ParseMessages(bytes.NewBuffer(append([]byte{byte(len(body))}, body...)), &bytes.Buffer{})
+			Expect(err).To(HaveOccurred())
+		})
+		It("should decode headers", func() {
+			buf := bytes.Buffer{}
+			headers := map[string]string{"a": "1", "b": "2"}
+			Expect(protocol.WriteMessage(invocationMessage{Type: 1, Target: "t", Headers: headers}, &buf)).To(Succeed())
+			got, err := protocol.ParseMessages(&buf, &bytes.Buffer{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got[0].(invocationMessage).Headers).To(Equal(headers))
+		})
+		It("should round trip close messages", func() {
+			buf := bytes.Buffer{}
+			Expect(protocol.WriteMessage(closeMessage{Type: 7, Error: "bye", AllowReconnect: true}, &buf)).To(Succeed())
+			got, err := protocol.ParseMessages(&buf, &bytes.Buffer{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal([]interface{}{closeMessage{Type: 7, Error: "bye", AllowReconnect: true}}))
+		})
+	})
+
+	Context("When a client which is not built with this package uses the protocol", func() {
+		var server Server
+		var fixture *protobufFixture
+		BeforeEach(func() {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			cliConn, srvConn := newClientServerConnections()
+			go func() { _ = server.Serve(srvConn) }()
+			fixture = newProtobufFixture(cliConn)
+			fixture.handshake()
+		})
+		AfterEach(func() {
+			server.cancel()
+		})
+		It("should answer an invocation with a completion", func(done Done) {
+			// type: 1, invocation_id: "1", target: "InvokeMe", arguments: ["A", 1]
+			fixture.send([]byte{0x08, 0x01, 0x1a, 0x01, '1', 0x22, 0x08, 'I', 'n', 'v', 'o', 'k', 'e', 'M', 'e',
+				0x2a, 0x03, '"', 'A', '"', 0x2a, 0x01, '1'})
+			// type: 3, invocation_id: "1", result: "A1"
+			Expect(fixture.receive()).To(Equal([]byte{0x08, 0x03, 0x1a, 0x01, '1', 0x42, 0x04, '"', 'A', '1', '"'}))
+			close(done)
+		}, 2.0)
+		It("should answer a stream invocation with stream items and a completion", func(done Done) {
+			// type: 4, invocation_id: "2", target: "ReadStream", arguments: [1]
+			fixture.send([]byte{0x08, 0x04, 0x1a, 0x01, '2', 0x22, 0x0a, 'R', 'e', 'a', 'd', 'S', 't', 'r', 'e', 'a', 'm',
+				0x2a, 0x01, '1'})
+			for _, item := range []string{"A1", "B1", "C1", "D1"} {
+				// type: 2, invocation_id: "2", item: <item>
+				Expect(fixture.receive()).To(Equal(append([]byte{0x08, 0x02, 0x1a, 0x01, '2', 0x3a, 0x04, '"'}, item[0], item[1], '"')))
+			}
+			// type: 3, invocation_id: "2"
+			Expect(fixture.receive()).To(Equal([]byte{0x08, 0x03, 0x1a, 0x01, '2'}))
+			close(done)
+		}, 2.0)
+	})
+
+	Context("When a client of this package uses the protocol", func() {
+		It("should invoke, pull streams and push streams", func(done Done) {
+			hub := &simpleHub{receiveStreamDone: make(chan struct{}, 1)}
+			server, err := NewServer(context.TODO(), HubFactory(func() HubInterface { return hub }), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			defer server.cancel()
+			cliConn, srvConn := newClientServerConnections()
+			go func() { _ = server.Serve(srvConn) }()
+			ctx, cancelClient := context.WithCancel(context.Background())
+			defer cancelClient()
+			client, err := NewClient(ctx, WithConnection(cliConn), testLoggerOption(), UseHubProtocol("protobuf"))
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+			result := <-client.Invoke("InvokeMe", "A", 1)
+			Expect(result.Error).NotTo(HaveOccurred())
+			Expect(result.Value).To(Equal("A1"))
+			values := make([]interface{}, 0)
+			for r := range client.PullStream("ReadStream", 2) {
+				Expect(r.Error).NotTo(HaveOccurred())
+				values = append(values, r.Value)
+			}
+			Expect(values).To(Equal([]interface{}{"A2", "B2", "C2", "D2"}))
+			ch := make(chan int, 4)
+			for i := 1; i <= 4; i++ {
+				ch <- i
+			}
+			close(ch)
+			_ = client.PushStreams("ReceiveStream", "pushed", ch)
+			Eventually(hub.receiveStreamDone, time.Second).Should(Receive())
+			Expect(hub.receiveStreamArg).To(Equal("pushed"))
+			Expect(hub.receiveStreamChanValues).To(Equal([]int{1, 2, 3, 4}))
+			close(done)
+		}, 3.0)
+	})
+
+	Context("UseHubProtocol", func() {
+		It("should reject unknown protocols", func() {
+			_, err := NewClient(context.TODO(), WithConnection(newTestingConnection()), UseHubProtocol("xml"))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should be client only", func() {
+			_, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), UseHubProtocol("protobuf"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,51 @@
+package signalr
+
+import "time"
+
+// ReconnectStats describes the reconnects of a Client which has been created with WithConnector
+type ReconnectStats struct {
+	// Attempts is the number of reconnects the client has tried
+	Attempts uint64 `json:"attempts"`
+	// Successes is the number of attempts after which the client has been connected again
+	Successes uint64 `json:"successes"`
+	// Failures is the number of times the client has given up reconnecting,
+	// because it was canceled or the server did not allow to reconnect before an attempt succeeded
+	Failures uint64 `json:"failures"`
+	// LastDelay is the backoff delay the client has waited before the last attempt
+	LastDelay time.Duration `json:"lastDelay"`
+	// TotalDelay is the sum of all backoff delays
+	TotalDelay time.Duration `json:"totalDelay"`
+}
+
+// ReconnectStats returns the ReconnectStats of the client
+func (c *client) ReconnectStats() ReconnectStats {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.reconnectStats
+}
+
+// reconnectAttempted counts a reconnect attempt after delay and publishes ConnectionReconnecting
+func (c *client) reconnectAttempted(delay time.Duration) {
+	c.mx.Lock()
+	c.reconnectStats.Attempts++
+	c.reconnectStats.LastDelay = delay
+	c.reconnectStats.TotalDelay += delay
+	c.mx.Unlock()
+	c.publishConnectionEvent(ConnectionEvent{Type: ConnectionReconnecting, ConnectionID: c.lastConnectionID(), Delay: delay})
+}
+
+// reconnectSucceeded counts a successful reconnect and publishes ConnectionReconnected
+func (c *client) reconnectSucceeded() {
+	c.mx.Lock()
+	c.reconnectStats.Successes++
+	c.mx.Unlock()
+	c.publishConnectionEvent(ConnectionEvent{Type: ConnectionReconnected, ConnectionID: c.lastConnectionID()})
+}
+
+// reconnectFailed counts that the client has given up reconnecting and publishes ConnectionReconnectFailed
+func (c *client) reconnectFailed() {
+	c.mx.Lock()
+	c.reconnectStats.Failures++
+	c.mx.Unlock()
+	c.publishConnectionEvent(ConnectionEvent{Type: ConnectionReconnectFailed, ConnectionID: c.lastConnectionID(), Error: c.Err()})
+}
@@ -0,0 +1,127 @@
+package signalr
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// validatedResultTypes caches the result of validateResultTypes per method type
+var validatedResultTypes sync.Map
+
+// validateResultTypes checks if the results of a hub method can be sent to the client.
+// Supported are methods
+//   - without results
+//   - with one or more results which can be serialized by the hub protocol
+//   - with one result of kind chan, which is received as single result or as stream. The chan element has to be serializable
+//   - with one result which is an iterator like iter.Seq[T], which is handled like a chan T, see isSeqType
+//   - with one result which is a producer func(ctx context.Context, send func(T) error) error, see isProducerType
+//   - with a result of kind chan or an iterator and a result of type error, see returnsStreamAndError
+//
+// Serializable means the type does not contain funcs, chans, complex numbers or unsafe pointers.
+// Unexported struct fields are not serialized, so they are not checked. But structs which have only
+// unexported fields and no custom serialization are rejected, because the client would only receive an empty object.
+func validateResultTypes(methodName string, methodType reflect.Type) error {
+	result, ok := validatedResultTypes.Load(methodType)
+	if !ok {
+		result, _ = validatedResultTypes.LoadOrStore(methodType, resultTypesCheck{err: checkResultTypes(methodType)})
+	}
+	if err := result.(resultTypesCheck).err; err != nil {
+		return fmt.Errorf("method %s: %w", methodName, err)
+	}
+	return nil
+}
+
+type resultTypesCheck struct {
+	err error
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// returnsStream reports if the method returns a chan, an iterator or a producer, optionally followed by an error
+func returnsStream(methodType reflect.Type) bool {
+	return (methodType.NumOut() == 1 && isStreamType(methodType.Out(0))) || returnsStreamAndError(methodType)
+}
+
+// returnsStreamAndError reports if the method returns (chan T, error) or an iterator and an error.
+// A non-nil error means the method could not start the stream, the chan is ignored then.
+func returnsStreamAndError(methodType reflect.Type) bool {
+	return methodType.NumOut() == 2 && isStreamType(methodType.Out(0)) && methodType.Out(1) == errorType
+}
+
+func checkResultTypes(methodType reflect.Type) error {
+	if returnsStream(methodType) {
+		chanType := methodType.Out(0)
+		if chanType.Kind() == reflect.Chan && chanType.ChanDir() == reflect.SendDir {
+			return fmt.Errorf("unsupported result type %v. Results of kind chan must be receivable", chanType)
+		}
+		if err := checkSerializable(streamElem(chanType), map[reflect.Type]bool{}); err != nil {
+			return fmt.Errorf("unsupported result type %v: %w", chanType, err)
+		}
+		return nil
+	}
+	for i := 0; i < methodType.NumOut(); i++ {
+		if err := checkSerializable(methodType.Out(i), map[reflect.Type]bool{}); err != nil {
+			return fmt.Errorf("unsupported result type %v: %w", methodType.Out(i), err)
+		}
+	}
+	return nil
+}
+
+func checkSerializable(t reflect.Type, visited map[reflect.Type]bool) error {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return fmt.Errorf("values of kind %v can not be serialized", t.Kind())
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return checkSerializable(t.Elem(), visited)
+	case reflect.Map:
+		if err := checkSerializable(t.Key(), visited); err != nil {
+			return err
+		}
+		return checkSerializable(t.Elem(), visited)
+	case reflect.Struct:
+		if isCustomSerialized(t) {
+			return nil
+		}
+		serializedFields := 0
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			// Fields of embedded structs are promoted, even if the embedded struct is unexported
+			if (field.PkgPath != "" && !field.Anonymous) || field.Tag.Get("json") == "-" || field.Tag.Get("msgpack") == "-" {
+				continue
+			}
+			serializedFields++
+			if err := checkSerializable(field.Type, visited); err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+		if t.NumField() > 0 && serializedFields == 0 {
+			return fmt.Errorf("%v has no exported fields", t)
+		}
+	}
+	return nil
+}
+
+var (
+	jsonMarshalerType    = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType    = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	msgpackMarshalerType = reflect.TypeOf((*msgpack.Marshaler)(nil)).Elem()
+	msgpackEncoderType   = reflect.TypeOf((*msgpack.CustomEncoder)(nil)).Elem()
+)
+
+func isCustomSerialized(t reflect.Type) bool {
+	for _, m := range []reflect.Type{jsonMarshalerType, textMarshalerType, msgpackMarshalerType, msgpackEncoderType} {
+		if t.Implements(m) || reflect.PtrTo(t).Implements(m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package signalr
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// isSeqType reports if t is an iterator function like iter.Seq[T], func(yield func(T) bool).
+// A hub method can return an iterator instead of a chan T. Each value passed to yield is sent as stream item
+// and the stream is completed when the iterator returns.
+func isSeqType(t reflect.Type) bool {
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 0 {
+		return false
+	}
+	yield := t.In(0)
+	return yield.Kind() == reflect.Func && yield.NumIn() == 1 && yield.NumOut() == 1 && yield.Out(0).Kind() == reflect.Bool
+}
+
+// isProducerType reports if t is a producer function, func(ctx context.Context, send func(T) error) error.
+// A hub method can return a producer for data sources which push their values by callbacks. Each value passed to send
+// is sent as stream item. The stream is completed when the producer returns, with the returned error if it is not nil.
+// When the stream has been canceled by the caller or the connection has ended, ctx is done and send returns ctx.Err().
+func isProducerType(t reflect.Type) bool {
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 1 || t.In(0) != contextType || t.Out(0) != errorType {
+		return false
+	}
+	send := t.In(1)
+	return send.Kind() == reflect.Func && send.NumIn() == 1 && send.NumOut() == 1 && send.Out(0) == errorType
+}
+
+// isStreamType reports if t can be the result of a streaming hub method, a chan T, an iterator or a producer
+func isStreamType(t reflect.Type) bool {
+	return t.Kind() == reflect.Chan || isSeqType(t) || isProducerType(t)
+}
+
+// streamElem returns the type of the items of the stream type t
+func streamElem(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Chan {
+		return t.Elem()
+	}
+	if isProducerType(t) {
+		return t.In(1).In(0)
+	}
+	return t.In(0).In(0)
+}
+
+// seqToChan ranges over the iterator seq in its own goroutine and sends the values to the returned chan.
+// The chan is closed when the iterator returns. Calling stop lets yield return false, so the iterator can end early.
+// A panic in the iterator is passed to onPanic and ends the stream.
+func seqToChan(seq reflect.Value, onPanic func(recovered interface{})) (ch reflect.Value, stop func()) {
+	ch = reflect.MakeChan(reflect.ChanOf(reflect.BothDir, streamElem(seq.Type())), 0)
+	stopped := make(chan struct{})
+	var once sync.Once
+	stop = func() { once.Do(func() { close(stopped) }) }
+	yield := reflect.MakeFunc(seq.Type().In(0), func(args []reflect.Value) []reflect.Value {
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: ch, Send: args[0]},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stopped)},
+		})
+		return []reflect.Value{reflect.ValueOf(chosen == 0)}
+	})
+	go func() {
+		defer ch.Close()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				onPanic(recovered)
+			}
+		}()
+		if !seq.IsNil() {
+			seq.Call([]reflect.Value{yield})
+		}
+	}()
+	return ch, stop
+}
+
+// producerToChan calls the producer in its own goroutine and sends the values passed to send to the returned chan.
+// The chan is closed when the producer returns. Calling stop ends the context of the producer.
+// After the chan has been closed, producerErr returns the error returned by the producer.
+// A panic in the producer is passed to onPanic and ends the stream.
+func producerToChan(ctx context.Context, producer reflect.Value, onPanic func(recovered interface{})) (ch reflect.Value, stop func(), producerErr func() error) {
+	ch = reflect.MakeChan(reflect.ChanOf(reflect.BothDir, streamElem(producer.Type())), 0)
+	ctx, stop = context.WithCancel(ctx)
+	send := reflect.MakeFunc(producer.Type().In(1), func(args []reflect.Value) []reflect.Value {
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: ch, Send: args[0]},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		})
+		var err error
+		if chosen == 1 {
+			err = ctx.Err()
+		}
+		return []reflect.Value{reflect.ValueOf(&err).Elem()}
+	})
+	// err is written before the chan is closed, so it can be read after the chan has been closed
+	var err error
+	go func() {
+		defer ch.Close()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				onPanic(recovered)
+			}
+		}()
+		if !producer.IsNil() {
+			if result := producer.Call([]reflect.Value{reflect.ValueOf(&ctx).Elem(), send})[0]; !result.IsNil() {
+				err = result.Interface().(error)
+			}
+		}
+	}()
+	return ch, stop, func() error { return err }
+}
@@ -6,10 +6,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path"
 	"reflect"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-kit/log"
 )
@@ -19,30 +24,100 @@ import (
 // 	MapHTTP(mux *http.ServeMux, path string)
 // maps the servers' hub to a path on a http.ServeMux.
 //
+// 	HTTPHandler() http.Handler
+// returns a http.Handler which serves the hub relative to the path it is mounted on.
+//
+// 	StatsHandler() http.Handler
+// returns a http.Handler which serves the ServerStats as JSON.
+//
+// 	HealthHandler() http.Handler
+// returns a http.Handler which responds with 200 while the server accepts connections and with 503 while it is
+// draining or after it has been shut down.
+//
 // 	Serve(conn Connection)
 // serves the hub of the server on one connection.
 // The same server might serve different connections in parallel. Serve does not return until the connection is closed
 // or the servers' context is canceled.
 //
+// 	ReplaceHub(factory func() HubInterface) error
+// replaces the hub factory of the running server, e.g. to add or remove hub methods.
+//
+// 	Shutdown(ctx context.Context) error
+// ends all connections and waits until the hubs' OnDisconnected has returned for each of them.
+//
+// 	Drain(ctx context.Context) error
+// refuses new connections and waits until the served connections have ended, e.g. for a restart without downtime.
+//
+// 	CaptureConnection(connectionID string, w io.Writer) error
+// logs all messages of one connection to w, e.g. to troubleshoot a single client without enabling the debug logging.
+//
 // HubClients()
 // allows to call all HubClients of the server from server-side, non-hub code.
 // Note that HubClients.Caller() returns nil, because there is no real caller which can be reached over a HubConnection.
 type Server interface {
 	Party
 	MapHTTP(routerFactory func() MappableRouter, path string)
+	HTTPHandler() http.Handler
+	StatsHandler() http.Handler
+	HealthHandler() http.Handler
 	Serve(conn Connection) error
 	HubClients() HubClients
 	availableTransports() []string
+	onNegotiate(req *http.Request, resp *NegotiateResponse)
+	connectionLimitReached() (reached bool, retryAfter time.Duration)
+	originAllowed() func(origin string) bool
+	ReplaceHub(factory func() HubInterface) error
+	Shutdown(ctx context.Context) error
+	Drain(ctx context.Context) error
+	CaptureConnection(connectionID string, w io.Writer) error
 }
 
+// ErrTooManyConnections is returned by Server.Serve when the server already serves the number of connections
+// set by the MaxConnections option
+var ErrTooManyConnections = errors.New("maximum number of connections reached")
+
+// ErrServerShutdown is returned by Server.Serve when the server has been shut down
+var ErrServerShutdown = errors.New("server shut down")
+
+// ErrServerDraining is returned by Server.Serve when the server is draining, see Server.Drain
+var ErrServerDraining = errors.New("server draining")
+
 type server struct {
+	// Used with atomic: Must be first in struct to ensure 64bit alignment on 32bit architectures
+	connectionCount    int64
+	closedBytesRead    uint64
+	closedBytesWritten uint64
 	partyBase
-	newHub            func() HubInterface
+	startTime         time.Time
+	hubMx             sync.RWMutex
+	hubFactory        func() HubInterface
+	hubType           string
 	lifetimeManager   HubLifetimeManager
 	defaultHubClients *defaultHubClients
 	groupManager      GroupManager
 	reconnectAllowed  bool
 	transports        []string
+	negotiateHook     func(req *http.Request, resp *NegotiateResponse)
+	maxConnections    int64
+	retryAfter        time.Duration
+	userIdentifier    func(ctx context.Context) string
+	servingMx         sync.Mutex
+	serving           sync.WaitGroup
+	allowedOrigins    func(origin string) bool
+	groupBackplane    GroupBackplane
+	backplane         Backplane
+	sendFailed        func(connectionID string, target string, err error)
+	draining          int32
+	handshakeParser   func(fields map[string]json.RawMessage) (map[string]interface{}, error)
+
+	// shutdownErrorText and shutdownAllowReconnect are sent with the close message on shutdown, see ShutdownCloseMessage
+	shutdownErrorText      string
+	shutdownAllowReconnect bool
+
+	invocationMiddleware []InvocationMiddleware
+
+	// budget counts the bytes buffered by all connections, see MaxBufferedBytes
+	budget *bufferBudget
 }
 
 // NewServer creates a new server for one type of hub. The hub type is set by one of the
@@ -59,8 +134,10 @@ func NewServer(ctx context.Context, options ...func(Party) error) (Server, error
 		groupManager: &defaultGroupManager{
 			lifetimeManager: &lifetimeManager,
 		},
-		partyBase:        newPartyBase(ctx, info, dbg),
-		reconnectAllowed: true,
+		partyBase:              newPartyBase(ctx, info, dbg),
+		startTime:              time.Now(),
+		reconnectAllowed:       true,
+		shutdownAllowReconnect: true,
 	}
 	for _, option := range options {
 		if option != nil {
@@ -69,15 +146,54 @@ func NewServer(ctx context.Context, options ...func(Party) error) (Server, error
 			}
 		}
 	}
+	server.limitLoggedPayloads()
 	if server.transports == nil {
 		server.transports = []string{"WebSockets", "ServerSentEvents"}
 	}
-	if server.newHub == nil {
+	if server.hubFactory == nil {
 		return server, errors.New("cannot determine hub type. Neither UseHub, HubFactory or SimpleHubFactory given as option")
 	}
+	server.hubType = fmt.Sprintf("%T", server.hubFactory())
+	lifetimeManager.sendFailureHandler = server.sendFailed
+	if server.groupBackplane != nil {
+		lifetimeManager.groupBackplane = server.groupBackplane
+		// Each server delivers only to its own connections, the other servers are called by the GroupBackplane
+		server.groupBackplane.Subscribe(func(connectionID string, target string, args []interface{}) {
+			lifetimeManager.invokeClientLocal(connectionID, target, args)
+		})
+	}
+	if server.backplane != nil {
+		lifetimeManager.backplane = server.backplane
+		lifetimeManager.serverID = newConnectionID()
+		server.backplane.Subscribe(lifetimeManager.receive)
+	}
 	return server, nil
 }
 
+// ReplaceHub replaces the factory which creates the hub instances. The hub instances created by factory
+// might be of another type than the previous ones, so methods can be added or removed while the server is running.
+// Invocations which have already started are completed by the previous hub, all following invocations
+// and the OnConnected/OnDisconnected calls use the hubs created by factory.
+func (s *server) ReplaceHub(factory func() HubInterface) error {
+	if factory == nil {
+		return errors.New("cannot replace hub with factory nil")
+	}
+	hubType := fmt.Sprintf("%T", factory())
+	s.hubMx.Lock()
+	defer s.hubMx.Unlock()
+	s.hubFactory = factory
+	s.hubType = hubType
+	return nil
+}
+
+// newHub creates a hub instance with the current hub factory
+func (s *server) newHub() HubInterface {
+	s.hubMx.RLock()
+	factory := s.hubFactory
+	s.hubMx.RUnlock()
+	return factory()
+}
+
 // MappableRouter encapsulates the methods used by server.MapHTTP to configure the
 // handlers required by the signalr protocol. this abstraction removes the explicit
 // binding to http.ServerMux and allows use of any mux which implements those basic
@@ -104,19 +220,154 @@ func (s *server) MapHTTP(routerFactory func() MappableRouter, path string) {
 	router.Handle(path, httpMux)
 }
 
+// HTTPHandler returns a http.Handler which serves the hub relative to the path it is mounted on.
+// Requests to <mount path>/negotiate are negotiate requests, all other requests are handled
+// as connect requests of the transports or as messages sent by the ServerSentEvents transport.
+// To mount the handler on a http.ServeMux, register it for both the path and its subtree:
+//  handler := server.HTTPHandler()
+//  mux.Handle("/hubs/chat", handler)
+//  mux.Handle("/hubs/chat/", handler)
+// The handler might also be used with http.StripPrefix.
+func (s *server) HTTPHandler() http.Handler {
+	httpMux := newHTTPMux(s)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) == "negotiate" {
+			httpMux.negotiate(w, r)
+		} else {
+			httpMux.ServeHTTP(w, r)
+		}
+	})
+}
+
 // Serve serves the hub of the server on one connection.
 // The same server might serve different connections in parallel. Serve does not return until the connection is closed
 // or the servers' context is canceled.
 func (s *server) Serve(conn Connection) error {
+	if err := s.startServing(); err != nil {
+		return err
+	}
+	defer s.serving.Done()
+	if !s.acquireConnection() {
+		info, _ := s.prefixLoggers("")
+		_ = info.Log(evt, "Serve", "connectionId", s.redactConnectionID(conn.ConnectionID()), "error", ErrTooManyConnections, react, "do not connect")
+		return ErrTooManyConnections
+	}
+	defer atomic.AddInt64(&s.connectionCount, -1)
 
-	protocol, err := s.processHandshake(conn)
+	state := newConnectionStateMachine(stateConnecting)
+	protocol, items, remainder, err := s.processHandshake(conn, state)
+	if err == nil {
+		err = state.transition(stateConnected)
+	}
 	if err != nil {
 		info, _ := s.prefixLoggers("")
-		_ = info.Log(evt, "processHandshake", "connectionId", conn.ConnectionID(), "error", err, react, "do not connect")
+		if closeErr := state.transition(stateClosing); closeErr != nil {
+			_ = info.Log(evt, "processHandshake", "connectionId", s.redactConnectionID(conn.ConnectionID()), "error", closeErr)
+		}
+		_ = info.Log(evt, "processHandshake", "connectionId", s.redactConnectionID(conn.ConnectionID()), "error", err, react, "do not connect")
+		s.publishConnectionEvent(ConnectionEvent{Type: ConnectionError, ConnectionID: conn.ConnectionID(), Error: err})
 		return err
 	}
+	s.publishConnectionEvent(ConnectionEvent{Type: ConnectionHandshook, ConnectionID: conn.ConnectionID()})
+
+	defer func() { _ = closeConnection(conn) }()
+	l := newLoop(s, conn, protocol, state)
+	l.receivedWithHandshake(remainder)
+	for key, value := range items {
+		l.hubConn.Items().Store(key, value)
+	}
+	return l.Run(make(chan struct{}, 1))
+}
+
+// Shutdown cancels the server, which ends all connections, and waits until Serve has returned for all connections and
+// OnDisconnected has returned for all hubs. Hubs which implement HubDisconnectReasoner get DisconnectServerShutdown as reason.
+// The clients receive the close message set by ShutdownCloseMessage.
+// If ctx is done before, Shutdown returns the error of ctx. After Shutdown, Serve returns ErrServerShutdown.
+func (s *server) Shutdown(ctx context.Context) error {
+	s.servingMx.Lock()
+	s.cancel()
+	s.servingMx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain refuses new connections and waits until all served connections have ended, so the server can be replaced
+// without ending the connections, e.g. by a new process which accepts on the same listener, see InheritedListener.
+// While draining, Serve returns ErrServerDraining and negotiate requests are refused with 503 Service Unavailable.
+// If ctx is done before all connections have ended, the remaining connections are ended as with Shutdown
+// and Drain returns the error of ctx.
+func (s *server) Drain(ctx context.Context) error {
+	s.servingMx.Lock()
+	atomic.StoreInt32(&s.draining, 1)
+	s.servingMx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+// CaptureConnection logs all messages sent and received by the connection with connectionID to w,
+// until the connection ends or CaptureConnection is called with a nil w.
+// It returns an error if the server has no connection with connectionID.
+func (s *server) CaptureConnection(connectionID string, w io.Writer) error {
+	hc, ok := s.lifetimeManager.Connection(connectionID)
+	if !ok {
+		return fmt.Errorf("unknown connection %v", connectionID)
+	}
+	hc.Capture(w)
+	return nil
+}
+
+// startServing counts a connection as served, unless the server has been shut down or is draining.
+// Shutdown and Drain change the state under servingMx, so no connection is counted after they have started to wait.
+func (s *server) startServing() error {
+	s.servingMx.Lock()
+	defer s.servingMx.Unlock()
+	if s.context().Err() != nil {
+		return ErrServerShutdown
+	}
+	if atomic.LoadInt32(&s.draining) == 1 {
+		return ErrServerDraining
+	}
+	s.serving.Add(1)
+	return nil
+}
+
+// acquireConnection counts a new connection. It returns false if the connection would exceed MaxConnections
+func (s *server) acquireConnection() bool {
+	if count := atomic.AddInt64(&s.connectionCount, 1); s.maxConnections > 0 && count > s.maxConnections {
+		atomic.AddInt64(&s.connectionCount, -1)
+		return false
+	}
+	return true
+}
+
+func (s *server) connectionLimitReached() (reached bool, retryAfter time.Duration) {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		return true, s.retryAfter
+	}
+	return s.maxConnections > 0 && atomic.LoadInt64(&s.connectionCount) >= s.maxConnections, s.retryAfter
+}
 
-	return newLoop(s, conn, protocol).Run(make(chan struct{}, 1))
+func (s *server) originAllowed() func(origin string) bool {
+	return s.allowedOrigins
 }
 
 func (s *server) HubClients() HubClients {
@@ -127,19 +378,43 @@ func (s *server) availableTransports() []string {
 	return s.transports
 }
 
+func (s *server) onNegotiate(req *http.Request, resp *NegotiateResponse) {
+	if s.negotiateHook != nil {
+		s.negotiateHook(req, resp)
+	}
+}
+
 func (s *server) onConnected(hc hubConnection) {
 	s.lifetimeManager.OnConnected(hc)
+	if s.userIdentifier != nil {
+		if userID := s.userIdentifier(hc.Context()); userID != "" {
+			s.lifetimeManager.SetUser(hc.ConnectionID(), userID)
+		}
+	}
 	go func() {
 		defer s.recoverHubLifeCyclePanic()
 		s.invocationTarget(hc).(HubInterface).OnConnected(hc.ConnectionID())
 	}()
 }
 
-func (s *server) onDisconnected(hc hubConnection) {
+func (s *server) onDisconnected(hc hubConnection, reason DisconnectReason) {
+	hc.Capture(nil)
+	s.serving.Add(1)
 	go func() {
+		defer s.serving.Done()
 		defer s.recoverHubLifeCyclePanic()
-		s.invocationTarget(hc).(HubInterface).OnDisconnected(hc.ConnectionID())
+		hub := s.invocationTarget(hc)
+		if reasoner, ok := hub.(HubDisconnectReasoner); ok {
+			reasoner.OnDisconnectedWithReason(hc.ConnectionID(), reason)
+		} else {
+			hub.(HubInterface).OnDisconnected(hc.ConnectionID())
+		}
 	}()
+	// Keep the traffic of closed connections for the stats. It is added before the connection is removed,
+	// so the stats never miss the traffic of a connection
+	connectionInfo := hc.ConnectionInfo()
+	atomic.AddUint64(&s.closedBytesRead, connectionInfo.BytesRead)
+	atomic.AddUint64(&s.closedBytesWritten, connectionInfo.BytesWritten)
 	s.lifetimeManager.OnDisconnected(hc)
 
 }
@@ -150,8 +425,17 @@ func (s *server) invocationTarget(conn hubConnection) interface{} {
 	return hub
 }
 
-func (s *server) allowReconnect() bool {
-	return s.reconnectAllowed
+// closeMessageFor returns the error text and the AllowReconnect flag of the close message for a connection which ends
+// with err for reason. On shutdown, these are taken from ShutdownCloseMessage
+func (s *server) closeMessageFor(reason DisconnectReason, err error) (string, bool) {
+	if reason == DisconnectServerShutdown {
+		errorText := s.shutdownErrorText
+		if errorText == "" {
+			errorText = fmt.Sprintf("%v", err)
+		}
+		return errorText, s.reconnectAllowed && s.shutdownAllowReconnect
+	}
+	return fmt.Sprintf("%v", err), s.reconnectAllowed
 }
 
 func (s *server) recoverHubLifeCyclePanic() {
@@ -165,6 +449,7 @@ func (s *server) recoverHubLifeCyclePanic() {
 }
 
 func (s *server) prefixLoggers(connectionID string) (info StructuredLogger, dbg StructuredLogger) {
+	connectionID = s.redactConnectionID(connectionID)
 	return log.WithPrefix(s.info, "ts", log.DefaultTimestampUTC,
 			"class", "Server",
 			"connection", connectionID,
@@ -182,30 +467,46 @@ func (s *server) newConnectionHubContext(hubConn hubConnection) HubContext {
 			defaultHubClients: s.defaultHubClients,
 			connectionID:      hubConn.ConnectionID(),
 		},
-		groups:     s.groupManager,
-		connection: hubConn,
-		info:       s.info,
-		dbg:        s.dbg,
+		groups:          s.groupManager,
+		connection:      hubConn,
+		lifetimeManager: s.lifetimeManager,
+		info:            s.info,
+		dbg:             s.dbg,
 	}
 }
 
-func (s *server) processHandshake(conn Connection) (hubProtocol, error) {
+// processHandshake returns the protocol, the items from the HandshakeExtension and the bytes which have been read
+// after the handshake request. These have to be parsed before the data which is read later from conn.
+// state is Handshaking when processHandshake returns without error.
+func (s *server) processHandshake(conn Connection, state *connectionStateMachine) (hubProtocol, map[string]interface{}, []byte, error) {
+	if err := state.transition(stateHandshaking); err != nil {
+		return nil, nil, nil, err
+	}
+	if raw := s.rawProtocol(); raw != "" {
+		// RawMode, the client starts with the first message
+		return protocolMap[raw](), nil, nil, nil
+	}
 	if request, err := s.receiveHandshakeRequest(conn); err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	} else {
-		return s.sendHandshakeResponse(conn, request)
+		protocol, items, err := s.sendHandshakeResponse(conn, request, state)
+		return protocol, items, request.remainder, err
 	}
 }
 
 func (s *server) receiveHandshakeRequest(conn Connection) (handshakeRequest, error) {
 	_, dbg := s.prefixLoggers(conn.ConnectionID())
-	ctx, cancelRead := context.WithTimeout(s.context(), s.HandshakeTimeout())
+	ctx, cancelRead := withClockTimeout(s.context(), s.clock(), s.HandshakeTimeout())
 	defer cancelRead()
 	readJSONFramesChan := make(chan []interface{}, 1)
 	go func() {
 		var remainBuf bytes.Buffer
 		rawHandshake, err := readJSONFrames(conn, &remainBuf)
-		readJSONFramesChan <- []interface{}{rawHandshake, err}
+		var remainder []byte
+		if err == nil {
+			remainder = handshakeRemainder(rawHandshake, &remainBuf)
+		}
+		readJSONFramesChan <- []interface{}{rawHandshake, err, remainder}
 	}()
 	request := handshakeRequest{}
 	select {
@@ -214,19 +515,43 @@ func (s *server) receiveHandshakeRequest(conn Connection) (handshakeRequest, err
 			return request, result[1].(error)
 		}
 		rawHandshake := result[0].([][]byte)
+		request.remainder = result[2].([]byte)
 		_ = dbg.Log(evt, "handshake received", "msg", string(rawHandshake[0]))
-		return request, json.Unmarshal(rawHandshake[0], &request)
+		if err := json.Unmarshal(rawHandshake[0], &request); err != nil || s.handshakeParser == nil {
+			return request, err
+		}
+		if err := json.Unmarshal(rawHandshake[0], &request.extraFields); err != nil {
+			return request, err
+		}
+		for _, field := range []string{"protocol", "version", "type"} {
+			delete(request.extraFields, field)
+		}
+		return request, nil
 	case <-ctx.Done():
 		return request, ctx.Err()
 	}
 }
 
-func (s *server) sendHandshakeResponse(conn Connection, request handshakeRequest) (protocol hubProtocol, err error) {
+func (s *server) sendHandshakeResponse(conn Connection, request handshakeRequest, state *connectionStateMachine) (protocol hubProtocol, items map[string]interface{}, err error) {
 	info, dbg := s.prefixLoggers(conn.ConnectionID())
-	ctx, cancelWrite := context.WithTimeout(s.context(), s.HandshakeTimeout())
+	ctx, cancelWrite := withClockTimeout(s.context(), s.clock(), s.HandshakeTimeout())
 	defer cancelWrite()
-	var ok bool
-	if protocol, ok = protocolMap[request.Protocol]; ok {
+	newProtocol, ok := protocolMap[request.Protocol]
+	if acceptErr := state.acceptMessage(request.Type); acceptErr != nil {
+		// Hub messages are only allowed after the handshake
+		err = acceptErr
+	} else if !ok {
+		err = fmt.Errorf("protocol %v not supported", request.Protocol)
+	} else if request.Version != protocolVersion {
+		err = fmt.Errorf("version %v of protocol %v not supported, supported version is %v", request.Version, request.Protocol, protocolVersion)
+	} else if s.handshakeParser != nil {
+		if items, err = s.handshakeParser(request.extraFields); err != nil {
+			err = fmt.Errorf("invalid handshake: %w", err)
+		}
+	}
+	if err == nil {
+		// Each connection gets its own protocol instance, which is configured by the loop
+		protocol = newProtocol()
 		// Send the handshake response
 		const handshakeResponse = "{}\u001e"
 		if _, err = ReadWriteWithContext(ctx,
@@ -238,7 +563,6 @@ func (s *server) sendHandshakeResponse(conn Connection, request handshakeRequest
 			_ = dbg.Log(evt, "handshake sent", "msg", handshakeResponse)
 		}
 	} else {
-		err = fmt.Errorf("protocol %v not supported", request.Protocol)
 		_ = info.Log(evt, "protocol requested", "error", err)
 		if _, respErr := ReadWriteWithContext(ctx,
 			func() (int, error) {
@@ -249,12 +573,16 @@ func (s *server) sendHandshakeResponse(conn Connection, request handshakeRequest
 			err = respErr
 		}
 	}
-	return protocol, err
+	return protocol, items, err
 }
 
-var protocolMap = map[string]hubProtocol{
-	"json":        &jsonHubProtocol{},
-	"messagepack": &messagePackHubProtocol{},
+// protocolVersion is the version of the hub protocols supported by the server
+const protocolVersion = 1
+
+var protocolMap = map[string]func() hubProtocol{
+	"json":        func() hubProtocol { return &jsonHubProtocol{} },
+	"messagepack": func() hubProtocol { return &messagePackHubProtocol{} },
+	"protobuf":    func() hubProtocol { return &protobufHubProtocol{} },
 }
 
 // const for logging
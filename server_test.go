@@ -1,8 +1,20 @@
 package signalr
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -50,6 +62,876 @@ var _ = Describe("Server.HubClients", func() {
 		}, 1.0)
 	})
 
+	Context("ConnectionEvents", func() {
+		It("should publish the events of a connect/disconnect cycle in order", func(done Done) {
+			events := make(chan ConnectionEvent, 10)
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				testLoggerOption(),
+				ConnectionEvents(events))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			conn.ClientSend(`{"type":7}`)
+			for _, want := range []ConnectionEventType{ConnectionHandshook, ConnectionConnected, ConnectionDisconnected} {
+				event := <-events
+				Expect(event.Type).To(Equal(want))
+				Expect(event.ConnectionID).To(Equal(conn.ConnectionID()))
+				Expect(event.Error).NotTo(HaveOccurred())
+			}
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should publish an error event when the connection ends with an error", func(done Done) {
+			events := make(chan ConnectionEvent, 10)
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				testLoggerOption(),
+				ConnectionEvents(events))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			conn.ClientSend(`{"type":7,"error":"client error"}`)
+			Expect((<-events).Type).To(Equal(ConnectionHandshook))
+			Expect((<-events).Type).To(Equal(ConnectionConnected))
+			event := <-events
+			Expect(event.Type).To(Equal(ConnectionError))
+			Expect(event.Error).To(MatchError("client error"))
+			Expect((<-events).Type).To(Equal(ConnectionDisconnected))
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should publish ErrIncompleteFrame when the connection ends in the middle of a frame", func(done Done) {
+			events := make(chan ConnectionEvent, 10)
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				testLoggerOption(),
+				ConnectionEvents(events))
+			Expect(err).NotTo(HaveOccurred())
+			cliConn, srvConn := newClientServerConnections()
+			go func() { _, _ = io.Copy(io.Discard, cliConn.reader) }()
+			go func() { _ = server.Serve(srvConn) }()
+			_, err = cliConn.Write([]byte("{\"protocol\":\"json\",\"version\":1}\u001e"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect((<-events).Type).To(Equal(ConnectionHandshook))
+			Expect((<-events).Type).To(Equal(ConnectionConnected))
+			// Close the connection after half a frame
+			_, err = cliConn.Write([]byte(`{"type":1,"invocationId":"1","tar`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cliConn.writer.(*io.PipeWriter).Close()).NotTo(HaveOccurred())
+			event := <-events
+			Expect(event.Type).To(Equal(ConnectionError))
+			Expect(errors.Is(event.Error, ErrIncompleteFrame)).To(BeTrue())
+			Expect(errors.Is(event.Error, io.EOF)).To(BeTrue())
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should not block the connection when nobody receives the events", func(done Done) {
+			events := make(chan ConnectionEvent)
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				testLoggerOption(),
+				ConnectionEvents(events))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["A",1]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("A1"))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("MaxConnections", func() {
+		It("should refuse connections over the limit until a connection has ended", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				testLoggerOption(),
+				MaxConnections(1, 2*time.Second))
+			Expect(err).NotTo(HaveOccurred())
+			router := http.NewServeMux()
+			server.MapHTTP(WithHTTPServeMux(router), "/hub")
+			testServer := httptest.NewServer(router)
+			defer testServer.Close()
+			negotiate := func() *http.Response {
+				resp, err := http.Post(testServer.URL+"/hub/negotiate", "text/plain", nil)
+				Expect(err).NotTo(HaveOccurred())
+				_ = resp.Body.Close()
+				return resp
+			}
+			// First connection
+			conn := newTestingConnectionForServer()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(conn) }()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["A",1]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("A1"))
+			// Second connection is refused
+			Expect(server.Serve(newTestingConnectionForServer())).To(MatchError(ErrTooManyConnections))
+			resp := negotiate()
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header.Get("Retry-After")).To(Equal("2"))
+			// The first connection still works
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"invokeme","arguments":["B",2]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("B2"))
+			// End the first connection
+			conn.ClientSend(`{"type":7}`)
+			Expect(<-served).NotTo(HaveOccurred())
+			Expect(negotiate().StatusCode).To(Equal(http.StatusOK))
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			conn.ClientSend(`{"type":1,"invocationId":"3","target":"invokeme","arguments":["C",3]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("C3"))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("ConnectionIDRedactor", func() {
+		It("should log only redacted connection ids", func(done Done) {
+			logger := &connectionIDLogger{}
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				Logger(logger, true),
+				ConnectionIDRedactor(func(connectionID string) string {
+					return "redacted"
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(conn) }()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["A",1]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("A1"))
+			conn.ClientSend(`{"type":7}`)
+			Expect(<-served).NotTo(HaveOccurred())
+			loggedIDs := logger.connectionIDs()
+			Expect(loggedIDs).NotTo(BeEmpty())
+			Expect(loggedIDs).To(ContainElement("redacted"))
+			Expect(loggedIDs).NotTo(ContainElement(conn.ConnectionID()))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("LogPayloadLimit", func() {
+		It("should log only the first bytes of large payloads", func(done Done) {
+			logger := &payloadCollectingLogger{}
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				Logger(logger, true),
+				LogPayloadLimit(40))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(conn) }()
+			large := strings.Repeat("x", 1000)
+			conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["%s",1]}`, large))
+			Expect((<-conn.received).(completionMessage).Result).To(Equal(large + "1"))
+			conn.ClientSend(`{"type":7}`)
+			Expect(<-served).NotTo(HaveOccurred())
+			payloads := logger.payloads()
+			Expect(payloads).To(ContainElement(ContainSubstring("bytes elided")))
+			for _, payload := range payloads {
+				Expect(payload).NotTo(ContainSubstring(strings.Repeat("x", 41)))
+			}
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("TargetNamingConvention", func() {
+		serve := func(convention NamingConvention) (Server, *testingConnection) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&contextHub{}), testLoggerOption(),
+				TargetNamingConvention(convention))
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			return server, conn
+		}
+		It("should accept PascalCase and lower case targets and send PascalCase targets", func(done Done) {
+			server, conn := serve(NamingPascalCase)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"AddItem","arguments":["key","value"]}`)
+			Expect((<-conn.received).(completionMessage).Error).To(Equal(""))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"getitem","arguments":["key"]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("value"))
+			conn.ClientSend(`{"type":1,"target":"CallCaller"}`)
+			Expect((<-conn.received).(invocationMessage).Target).To(Equal("ClientFunc"))
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should send camelCase targets", func(done Done) {
+			server, conn := serve(NamingCamelCase)
+			conn.ClientSend(`{"type":1,"target":"CallCaller"}`)
+			Expect((<-conn.received).(invocationMessage).Target).To(Equal("clientFunc"))
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should convert names to the NamingConvention", func() {
+			Expect(NamingAsIs.target("sendMessage")).To(Equal("sendMessage"))
+			Expect(NamingPascalCase.target("sendMessage")).To(Equal("SendMessage"))
+			Expect(NamingCamelCase.target("SendMessage")).To(Equal("sendMessage"))
+			Expect(NamingCamelCase.target("URLChanged")).To(Equal("urlChanged"))
+			Expect(NamingCamelCase.target("ID")).To(Equal("id"))
+		})
+		It("should reject unknown NamingConventions", func() {
+			_, err := NewServer(context.TODO(), SimpleHubFactory(&contextHub{}), TargetNamingConvention(NamingConvention(7)))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Closing the transport", func() {
+		It("should close the transport when the client has closed the connection", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(conn) }()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["A",1]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("A1"))
+			Expect(conn.Closed()).To(BeFalse())
+			conn.ClientSend(`{"type":7}`)
+			Expect(<-served).NotTo(HaveOccurred())
+			Expect(conn.Closed()).To(BeTrue())
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should close the transport after sending the close message when the connection ends with an error", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(conn) }()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"abort"}`)
+			message := <-conn.received
+			Expect(message).To(BeAssignableToTypeOf(closeMessage{}))
+			Expect(<-served).To(HaveOccurred())
+			Expect(conn.Closed()).To(BeTrue())
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("SendWithAck", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			// Wait until the connection is known by the server
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["A",1]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("A1"))
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		It("should return when the client acknowledges the message", func(done Done) {
+			sent := make(chan error, 1)
+			go func() {
+				sent <- server.HubClients().Client(conn.ConnectionID()).SendWithAck(
+					AckPolicy{Timeout: time.Second}, "OnCallback", "ack")
+			}()
+			invocation := (<-conn.received).(invocationMessage)
+			Expect(invocation.Target).To(Equal("OnCallback"))
+			Expect(invocation.InvocationID).NotTo(BeEmpty())
+			conn.ClientSend(fmt.Sprintf(`{"type":3,"invocationId":"%v"}`, invocation.InvocationID))
+			Expect(<-sent).NotTo(HaveOccurred())
+			close(done)
+		}, 2.0)
+		It("should send the message again and fail when the client does not acknowledge it", func(done Done) {
+			sent := make(chan error, 1)
+			go func() {
+				sent <- server.HubClients().All().SendWithAck(
+					AckPolicy{Timeout: 50 * time.Millisecond, Retries: 2, Backoff: 10 * time.Millisecond}, "OnCallback", "ack")
+			}()
+			ids := make([]string, 0)
+			for i := 0; i < 3; i++ {
+				invocation := (<-conn.received).(invocationMessage)
+				Expect(invocation.Target).To(Equal("OnCallback"))
+				ids = append(ids, invocation.InvocationID)
+			}
+			err := <-sent
+			Expect(errors.Is(err, ErrNotAcknowledged)).To(BeTrue())
+			// The given up message is not tracked anymore
+			acks := hubConnectionOf(server, conn.ConnectionID()).acks
+			acks.mx.Lock()
+			Expect(acks.pending).To(BeEmpty())
+			acks.mx.Unlock()
+			// All attempts are sent with the same id
+			Expect(ids[1]).To(Equal(ids[0]))
+			Expect(ids[2]).To(Equal(ids[0]))
+			// Late acknowledgements do not end the connection
+			conn.ClientSend(fmt.Sprintf(`{"type":3,"invocationId":"%v"}`, ids[0]))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"invokeme","arguments":["B",2]}`)
+			Expect((<-conn.received).(completionMessage).Result).To(Equal("B2"))
+			close(done)
+		}, 2.0)
+	})
+
+	Context("ReplaceHub", func() {
+		It("should invoke the methods of the new hub and complete invocations already started by the old hub", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&oldPluginHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"old"}`)
+			Expect(<-oldPluginStarted).To(Equal("Old()"))
+			Expect(server.ReplaceHub(func() HubInterface { return &newPluginHub{} })).NotTo(HaveOccurred())
+			// The new method is callable
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"new"}`)
+			completion := (<-conn.received).(completionMessage)
+			Expect(completion.InvocationID).To(Equal("2"))
+			Expect(completion.Result).To(Equal("New()"))
+			// The removed method is not found
+			conn.ClientSend(`{"type":1,"invocationId":"3","target":"old"}`)
+			completion = (<-conn.received).(completionMessage)
+			Expect(completion.InvocationID).To(Equal("3"))
+			Expect(completion.Error).To(ContainSubstring("Unknown method"))
+			// The invocation which has been started before still completes
+			close(oldPluginRelease)
+			completion = (<-conn.received).(completionMessage)
+			Expect(completion.InvocationID).To(Equal("1"))
+			Expect(completion.Result).To(Equal("Old()"))
+			Expect(server.ReplaceHub(nil)).To(HaveOccurred())
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("StatsHandler", func() {
+		It("should serve the stats as JSON with the count of the active connections", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			getStats := func() map[string]interface{} {
+				recorder := httptest.NewRecorder()
+				server.StatsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/stats", nil))
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+				stats := make(map[string]interface{})
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &stats)).To(Succeed())
+				return stats
+			}
+			srvConns := make([]*pipeConnection, 0)
+			for i := 0; i < 2; i++ {
+				cliConn, srvConn := newClientServerConnections()
+				srvConn.connectionID = fmt.Sprintf("stats%v", i)
+				srvConns = append(srvConns, srvConn)
+				go func() { _ = server.Serve(srvConn) }()
+				client, err := NewClient(context.TODO(), WithConnection(cliConn), testLoggerOption(), TransferFormat("Text"))
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				result := <-client.Invoke("InvokeMe", "A", 1)
+				Expect(result.Error).NotTo(HaveOccurred())
+			}
+			stats := getStats()
+			Expect(stats).To(HaveKey("uptime"))
+			Expect(stats).To(HaveKeyWithValue("connections", BeEquivalentTo(2)))
+			Expect(stats).To(HaveKeyWithValue("hubs", HaveKeyWithValue("*signalr.simpleHub", BeEquivalentTo(2))))
+			Expect(stats["bytesRead"]).To(BeNumerically(">", 0))
+			Expect(stats["bytesWritten"]).To(BeNumerically(">", 0))
+			Expect(stats).To(HaveKey("bytesReadPerSecond"))
+			Expect(stats).To(HaveKey("bytesWrittenPerSecond"))
+			bytesRead := stats["bytesRead"]
+			// End one connection
+			_ = srvConns[0].reader.(*io.PipeReader).Close()
+			Eventually(func() interface{} { return getStats()["connections"] }, time.Second).Should(BeEquivalentTo(1))
+			Expect(getStats()["bytesRead"]).To(BeNumerically(">=", bytesRead))
+			server.cancel()
+			close(done)
+		}, 3.0)
+		It("should not create hubs and report the type of the replaced hub", func() {
+			var created int32
+			server, err := NewServer(context.TODO(), HubFactory(func() HubInterface {
+				atomic.AddInt32(&created, 1)
+				return &simpleHub{}
+			}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			defer server.cancel()
+			getHubs := func() interface{} {
+				recorder := httptest.NewRecorder()
+				server.StatsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/stats", nil))
+				stats := make(map[string]interface{})
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &stats)).To(Succeed())
+				return stats["hubs"]
+			}
+			createdBefore := atomic.LoadInt32(&created)
+			Expect(getHubs()).To(HaveKey("*signalr.simpleHub"))
+			Expect(getHubs()).To(HaveKey("*signalr.simpleHub"))
+			Expect(atomic.LoadInt32(&created)).To(Equal(createdBefore))
+			Expect(server.ReplaceHub(func() HubInterface { return &invocationHub{} })).To(Succeed())
+			Expect(getHubs()).To(HaveKey("*signalr.invocationHub"))
+		})
+	})
+
+	Context("HealthHandler", func() {
+		It("should report serving, draining and shut down", func(done Done) {
+			server, _ := connect(&simpleHub{})
+			getHealth := func() (int, string) {
+				recorder := httptest.NewRecorder()
+				server.HealthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+				return recorder.Code, recorder.Body.String()
+			}
+			code, text := getHealth()
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(text).To(Equal("serving"))
+			// The served connection keeps the server draining
+			drained := make(chan error, 1)
+			go func() { drained <- server.Drain(context.Background()) }()
+			Eventually(func() int { code, _ := getHealth(); return code }, time.Second).Should(Equal(http.StatusServiceUnavailable))
+			_, text = getHealth()
+			Expect(text).To(Equal("draining"))
+			Expect(server.Shutdown(context.Background())).To(Succeed())
+			Expect(<-drained).NotTo(HaveOccurred())
+			code, text = getHealth()
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(text).To(Equal("shut down"))
+			close(done)
+		}, 2.0)
+		It("should answer HEAD without body and reject other methods", func() {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			recorder := httptest.NewRecorder()
+			server.HealthHandler().ServeHTTP(recorder, httptest.NewRequest("HEAD", "/healthz", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.Len()).To(BeZero())
+			recorder = httptest.NewRecorder()
+			server.HealthHandler().ServeHTTP(recorder, httptest.NewRequest("POST", "/healthz", nil))
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+			server.cancel()
+		})
+	})
+
+	Context("User()", func() {
+		It("should send to all connections of the user only", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&whisperHub{}),
+				testLoggerOption(),
+				UserIdentifier(func(ctx context.Context) string {
+					userID, _ := ctx.Value(userContextKey{}).(string)
+					return userID
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			clients := make([]Client, 0)
+			receivers := make([]*simpleReceiver, 0)
+			for i, userID := range []string{"alice", "bob", "bob", "carol"} {
+				cliConn, srvConn := newClientServerConnections()
+				srvConn.connectionID = fmt.Sprintf("user%v", i)
+				go func(userID string) {
+					_ = server.Serve(&userConnection{
+						pipeConnection: srvConn,
+						ctx:            context.WithValue(context.Background(), userContextKey{}, userID),
+					})
+				}(userID)
+				receiver := &simpleReceiver{ch: make(chan string, 1)}
+				client, err := NewClient(context.TODO(), WithConnection(cliConn), WithReceiver(receiver),
+					testLoggerOption(), TransferFormat("Text"))
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+				clients = append(clients, client)
+				receivers = append(receivers, receiver)
+			}
+			// alice whispers to bob
+			result := <-clients[0].Invoke("Whisper", "bob", "hi")
+			Expect(result.Error).NotTo(HaveOccurred())
+			Expect(<-receivers[1].ch).To(Equal("alice: hi"))
+			Expect(<-receivers[2].ch).To(Equal("alice: hi"))
+			Consistently(receivers[0].ch, 100*time.Millisecond).ShouldNot(Receive())
+			Consistently(receivers[3].ch, 100*time.Millisecond).ShouldNot(Receive())
+			server.cancel()
+			close(done)
+		}, 3.0)
+	})
+
+	Context("InvocationMiddleware", func() {
+		It("should deny invocations by the claims of the principal in the connection context", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
+				testLoggerOption(),
+				UserIdentifier(func(ctx context.Context) string {
+					principal, _ := ctx.Value(principalContextKey{}).(testPrincipal)
+					return principal.name
+				}),
+				UseInvocationMiddleware(func(auth AuthContext, target string) error {
+					principal, ok := auth.Context.Value(principalContextKey{}).(testPrincipal)
+					if !ok || principal.claims["role"] != "admin" {
+						return fmt.Errorf("%v is not allowed to invoke %v", auth.UserID, target)
+					}
+					return nil
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			clients := make([]Client, 0)
+			for i, principal := range []testPrincipal{
+				{name: "alice", claims: map[string]string{"role": "admin"}},
+				{name: "bob", claims: map[string]string{"role": "guest"}},
+			} {
+				cliConn, srvConn := newClientServerConnections()
+				srvConn.connectionID = fmt.Sprintf("principal%v", i)
+				go func(principal testPrincipal) {
+					_ = server.Serve(&userConnection{
+						pipeConnection: srvConn,
+						ctx:            context.WithValue(context.Background(), principalContextKey{}, principal),
+					})
+				}(principal)
+				client, err := NewClient(context.TODO(), WithConnection(cliConn), testLoggerOption(), TransferFormat("Text"))
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+				clients = append(clients, client)
+			}
+			result := <-clients[0].Invoke("InvokeMe", "A", 1)
+			Expect(result.Error).NotTo(HaveOccurred())
+			Expect(result.Value).To(Equal("A1"))
+			result = <-clients[1].Invoke("InvokeMe", "A", 1)
+			Expect(result.Error).To(HaveOccurred())
+			Expect(result.Error.Error()).To(ContainSubstring("bob is not allowed to invoke InvokeMe"))
+			// A denied invocation does not end the connection
+			result = <-clients[1].Invoke("InvokeMe", "B", 2)
+			Expect(result.Error).To(HaveOccurred())
+			Expect(result.Error.Error()).To(ContainSubstring("bob is not allowed to invoke InvokeMe"))
+			server.cancel()
+			close(done)
+		}, 3.0)
+		It("should be server only", func() {
+			_, err := NewClient(context.TODO(), WithConnection(newTestingConnection()), testLoggerOption(),
+				UseInvocationMiddleware(func(AuthContext, string) error { return nil }))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("SendFailureHandler", func() {
+		It("should report the connections which failed and send to all others", func(done Done) {
+			failed := make(chan string, 3)
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption(),
+				SendFailureHandler(func(connectionID string, target string, err error) {
+					Expect(target).To(Equal("OnCallback"))
+					Expect(err).To(HaveOccurred())
+					failed <- connectionID
+				}))
+			Expect(err).NotTo(HaveOccurred())
+			srvConns := make([]*pipeConnection, 3)
+			receivers := make([]*simpleReceiver, 3)
+			for i := range srvConns {
+				var cliConn *pipeConnection
+				cliConn, srvConns[i] = newClientServerConnections()
+				srvConns[i].connectionID = fmt.Sprintf("c%v", i)
+				go func(conn Connection) { _ = server.Serve(conn) }(srvConns[i])
+				receivers[i] = &simpleReceiver{ch: make(chan string, 1)}
+				client, err := NewClient(context.TODO(), WithConnection(cliConn), WithReceiver(receivers[i]),
+					testLoggerOption(), TransferFormat("Text"))
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				Expect(<-client.WaitForState(context.Background(), ClientConnected)).NotTo(HaveOccurred())
+			}
+			srvConns[1].fail.Store(errors.New("dead connection"))
+			server.HubClients().All().Send("OnCallback", "broadcast")
+			Expect(<-failed).To(Equal("c1"))
+			Expect(<-receivers[0].ch).To(Equal("broadcast"))
+			Expect(<-receivers[2].ch).To(Equal("broadcast"))
+			Consistently(failed, 100*time.Millisecond).ShouldNot(Receive())
+			server.cancel()
+			close(done)
+		}, 3.0)
+	})
+
+	Context("Shutdown", func() {
+		It("should call OnDisconnected for all connections with the server shutdown reason before it returns", func(done Done) {
+			hub := &reasonHub{connected: make(chan string, 2), reasons: make(chan DisconnectReason, 2)}
+			server, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			for i := 0; i < 2; i++ {
+				conn := newTestingConnectionForServer()
+				go func() { _ = server.Serve(conn) }()
+				<-hub.connected
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			Expect(server.Shutdown(ctx)).To(Succeed())
+			Expect(hub.reasons).To(HaveLen(2))
+			Expect(<-hub.reasons).To(Equal(DisconnectServerShutdown))
+			Expect(<-hub.reasons).To(Equal(DisconnectServerShutdown))
+			Expect(server.Serve(newTestingConnectionForServer())).To(MatchError(ErrServerShutdown))
+			close(done)
+		}, 3.0)
+		It("should call OnDisconnected with the client reason when the client closes the connection", func(done Done) {
+			hub := &reasonHub{connected: make(chan string, 1), reasons: make(chan DisconnectReason, 1)}
+			server, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			<-hub.connected
+			conn.ClientSend(`{"type":7}`)
+			Expect(<-hub.reasons).To(Equal(DisconnectClient))
+			Expect(server.Shutdown(context.Background())).To(Succeed())
+			close(done)
+		}, 3.0)
+		It("should either refuse or wait for connections which are served while it shuts down", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			served := make(chan error, 20)
+			for i := 0; i < cap(served); i++ {
+				go func() { served <- server.Serve(newTestingConnectionForServer()) }()
+			}
+			Expect(server.Shutdown(context.Background())).To(Succeed())
+			for i := 0; i < cap(served); i++ {
+				Eventually(served).Should(Receive())
+			}
+			close(done)
+		}, 3.0)
+	})
+
+	Context("Messages sent in the same write as the handshake", func() {
+		It("should process the messages after the handshake with the json protocol", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			clientSide, serverSide := net.Pipe()
+			go func() { _ = server.Serve(NewNetConnection(context.TODO(), serverSide)) }()
+			second := `{"type":1,"invocationId":"b","target":"invokeme","arguments":["B",2]}` + "\u001e"
+			// The handshake, the first invocation and the beginning of the second invocation in one write
+			_, err = clientSide.Write([]byte(`{"protocol":"json","version":1}` + "\u001e" +
+				`{"type":1,"invocationId":"a","target":"invokeme","arguments":["A",1]}` + "\u001e" + second[:20]))
+			Expect(err).NotTo(HaveOccurred())
+			// net.Pipe is synchronous, the server reads the rest after it has sent the handshake response
+			go func() { _, _ = clientSide.Write([]byte(second[20:])) }()
+			handshake := make([]byte, 3)
+			_, err = io.ReadFull(clientSide, handshake)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(handshake)).To(Equal("{}\u001e"))
+			protocol := &jsonHubProtocol{}
+			protocol.setDebugLogger(testLogger())
+			remainBuf := bytes.Buffer{}
+			results := make(map[string]string)
+			for len(results) < 2 {
+				messages, err := protocol.ParseMessages(clientSide, &remainBuf)
+				Expect(err).NotTo(HaveOccurred())
+				for _, message := range messages {
+					if completion, ok := message.(completionMessage); ok {
+						results[completion.InvocationID] = fmt.Sprintf("%s", completion.Result)
+					}
+				}
+			}
+			Expect(results).To(Equal(map[string]string{"a": `"A1"`, "b": `"B2"`}))
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should process the messages after the handshake with the messagepack protocol", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			clientSide, serverSide := net.Pipe()
+			go func() { _ = server.Serve(NewNetConnection(context.TODO(), serverSide)) }()
+			protocol := &messagePackHubProtocol{}
+			protocol.setDebugLogger(testLogger())
+			buf := bytes.NewBufferString(`{"protocol":"messagepack","version":1}` + "\u001e")
+			Expect(protocol.WriteMessage(invocationMessage{Type: 1, InvocationID: "a", Target: "invokeme",
+				Arguments: []interface{}{"A", 1}}, buf)).To(Succeed())
+			_, err = clientSide.Write(buf.Bytes())
+			Expect(err).NotTo(HaveOccurred())
+			handshake := make([]byte, 3)
+			_, err = io.ReadFull(clientSide, handshake)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(handshake)).To(Equal("{}\u001e"))
+			remainBuf := bytes.Buffer{}
+			for {
+				messages, err := protocol.ParseMessages(clientSide, &remainBuf)
+				Expect(err).NotTo(HaveOccurred())
+				if len(messages) > 0 {
+					Expect(messages[0]).To(BeAssignableToTypeOf(completionMessage{}))
+					completion := messages[0].(completionMessage)
+					Expect(completion.InvocationID).To(Equal("a"))
+					Expect(completion.Error).To(BeEmpty())
+					break
+				}
+			}
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("RawMode", func() {
+		for _, protocol := range []string{"json", "messagepack"} {
+			protocol := protocol
+			It(fmt.Sprintf("should connect a client and a server without handshake with the %v protocol", protocol), func(done Done) {
+				server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), RawMode(protocol), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				clientSide, serverSide := net.Pipe()
+				go func() { _ = server.Serve(NewNetConnection(context.TODO(), serverSide)) }()
+				ctx, cancel := context.WithCancel(context.Background())
+				client, err := NewClient(ctx, WithConnection(NewNetConnection(ctx, clientSide)), RawMode(protocol), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				result := <-client.Invoke("InvokeMe", "A", 1)
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(result.Value).To(Equal("A1"))
+				cancel()
+				server.cancel()
+				close(done)
+			}, 2.0)
+		}
+		It("should not send a handshake response", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), RawMode("json"), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			clientSide, serverSide := net.Pipe()
+			go func() { _ = server.Serve(NewNetConnection(context.TODO(), serverSide)) }()
+			_, err = clientSide.Write([]byte(`{"type":1,"invocationId":"raw","target":"invokeme","arguments":["A",1]}` + "\u001e"))
+			Expect(err).NotTo(HaveOccurred())
+			protocol := &jsonHubProtocol{}
+			protocol.setDebugLogger(testLogger())
+			messages, err := protocol.ParseMessages(clientSide, &bytes.Buffer{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(messages).NotTo(BeEmpty())
+			Expect(messages[0]).To(BeAssignableToTypeOf(completionMessage{}))
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should reject unsupported protocols", func() {
+			_, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), RawMode("xml"), testLoggerOption())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Closed transport", func() {
+		It("should end the connection without error when the client closes the transport", func(done Done) {
+			hub := &reasonHub{connected: make(chan string, 1), reasons: make(chan DisconnectReason, 1)}
+			events := make(chan ConnectionEvent, 10)
+			server, err := NewServer(context.TODO(), UseHub(hub), ConnectionEvents(events), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			clientSide, serverSide := net.Pipe()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(NewNetConnection(context.TODO(), serverSide)) }()
+			_, err = clientSide.Write([]byte(`{"protocol":"json","version":1}` + "\u001e"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = clientSide.Read(make([]byte, 1024))
+			Expect(err).NotTo(HaveOccurred())
+			<-hub.connected
+			Expect(clientSide.Close()).To(Succeed())
+			Expect(<-served).NotTo(HaveOccurred())
+			Expect(<-hub.reasons).To(Equal(DisconnectTransportClosed))
+			for len(events) > 0 {
+				Expect((<-events).Type).NotTo(Equal(ConnectionError))
+			}
+			server.cancel()
+			close(done)
+		}, 2.0)
+		It("should end the connection with the error when reading from the transport fails", func(done Done) {
+			hub := &reasonHub{connected: make(chan string, 1), reasons: make(chan DisconnectReason, 1)}
+			server, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			served := make(chan error, 1)
+			go func() { served <- server.Serve(conn) }()
+			<-hub.connected
+			conn.SetFailRead("connection reset")
+			// The ping ends the pending read, the next read fails
+			conn.ClientSend(`{"type":6}`)
+			Expect(<-served).To(MatchError("connection reset"))
+			Expect(<-hub.reasons).To(Equal(DisconnectError))
+			server.cancel()
+			close(done)
+		}, 2.0)
+	})
+
+	Context("Drain", func() {
+		It("should refuse new connections, which are served by the new server, and wait for the served connections", func(done Done) {
+			hub := &reasonHub{connected: make(chan string, 2), reasons: make(chan DisconnectReason, 2)}
+			oldServer, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			newServer, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			servedConn := newTestingConnectionForServer()
+			go func() { _ = oldServer.Serve(servedConn) }()
+			<-hub.connected
+			drained := make(chan error, 1)
+			go func() { drained <- oldServer.Drain(context.Background()) }()
+			// Negotiate requests are refused like with MaxConnections
+			Eventually(func() bool {
+				reached, _ := oldServer.connectionLimitReached()
+				return reached
+			}).Should(BeTrue())
+			Expect(oldServer.Serve(newTestingConnectionForServer())).To(MatchError(ErrServerDraining))
+			go func() { _ = newServer.Serve(newTestingConnectionForServer()) }()
+			<-hub.connected
+			// The served connection is not ended by draining
+			Consistently(drained, 100*time.Millisecond).ShouldNot(Receive())
+			Expect(hub.reasons).To(BeEmpty())
+			servedConn.ClientSend(`{"type":7}`)
+			Expect(<-drained).NotTo(HaveOccurred())
+			Expect(<-hub.reasons).To(Equal(DisconnectClient))
+			Expect(newServer.Shutdown(context.Background())).To(Succeed())
+			close(done)
+		}, 3.0)
+		It("should end the served connections when ctx is done", func(done Done) {
+			hub := &reasonHub{connected: make(chan string, 1), reasons: make(chan DisconnectReason, 1)}
+			server, err := NewServer(context.TODO(), UseHub(hub), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			go func() { _ = server.Serve(newTestingConnectionForServer()) }()
+			<-hub.connected
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			Expect(server.Drain(ctx)).To(MatchError(context.DeadlineExceeded))
+			Expect(<-hub.reasons).To(Equal(DisconnectServerShutdown))
+			close(done)
+		}, 3.0)
+		It("should pass the listener to the new server", func(done Done) {
+			oldListener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			file, err := ListenerFile(oldListener)
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { _ = file.Close() }()
+			// The old server stops accepting
+			Expect(oldListener.Close()).To(Succeed())
+			Expect(os.Setenv(ListenerFDEnv, strconv.Itoa(int(file.Fd())))).To(Succeed())
+			defer func() { _ = os.Unsetenv(ListenerFDEnv) }()
+			newListener, err := InheritedListener()
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { _ = newListener.Close() }()
+			Expect(newListener.Addr().String()).To(Equal(oldListener.Addr().String()))
+			go func() {
+				conn, err := net.Dial("tcp", oldListener.Addr().String())
+				if err == nil {
+					_ = conn.Close()
+				}
+			}()
+			conn, err := newListener.Accept()
+			Expect(err).NotTo(HaveOccurred())
+			_ = conn.Close()
+			close(done)
+		}, 3.0)
+		It("should not inherit a listener without the environment variable", func() {
+			Expect(os.Unsetenv(ListenerFDEnv)).To(Succeed())
+			listener, err := InheritedListener()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listener).To(BeNil())
+		})
+	})
+
+	Context("CaptureConnection", func() {
+		It("should capture the messages of the captured connection only", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			captured := newTestingConnectionForServer()
+			quiet := newTestingConnectionForServer()
+			go func() { _ = server.Serve(captured) }()
+			go func() { _ = server.Serve(quiet) }()
+			capture := &lockedBuffer{}
+			Eventually(func() error {
+				return server.CaptureConnection(captured.ConnectionID(), capture)
+			}, time.Second).Should(Succeed())
+			Eventually(func() error {
+				return server.CaptureConnection(quiet.ConnectionID(), nil)
+			}, time.Second).Should(Succeed())
+			captured.ClientSend(`{"type":1,"invocationId":"captured","target":"invokeme","arguments":["A",1]}`)
+			Expect((<-captured.received).(completionMessage).InvocationID).To(Equal("captured"))
+			quiet.ClientSend(`{"type":1,"invocationId":"quiet","target":"invokeme","arguments":["B",2]}`)
+			Expect((<-quiet.received).(completionMessage).InvocationID).To(Equal("quiet"))
+			Eventually(capture.String, time.Second).Should(ContainSubstring("A1"))
+			text := capture.String()
+			Expect(text).To(ContainSubstring("connectionId=" + captured.ConnectionID()))
+			Expect(text).To(ContainSubstring("invokeme"))
+			Expect(text).NotTo(ContainSubstring("quiet"))
+			// Capturing ends with the connection
+			captured.ClientSend(`{"type":7}`)
+			Eventually(func() error {
+				return server.CaptureConnection(captured.ConnectionID(), capture)
+			}, time.Second).Should(HaveOccurred())
+			server.cancel()
+			close(done)
+		}, 3.0)
+	})
+
 	Context("Caller()", func() {
 		It("should return nil", func() {
 			server, _ := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}),
@@ -60,3 +942,149 @@ var _ = Describe("Server.HubClients", func() {
 		})
 	})
 })
+
+// lockedBuffer is a bytes.Buffer which can be written and read concurrently
+type lockedBuffer struct {
+	mx  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.buf.String()
+}
+
+type reasonHub struct {
+	Hub
+	connected chan string
+	reasons   chan DisconnectReason
+}
+
+func (r *reasonHub) OnConnected(connectionID string) {
+	r.connected <- connectionID
+}
+
+func (r *reasonHub) OnDisconnectedWithReason(_ string, reason DisconnectReason) {
+	r.reasons <- reason
+}
+
+type whisperHub struct {
+	Hub
+}
+
+func (w *whisperHub) Whisper(userID string, text string) {
+	w.Clients().User(userID).Send("OnCallback", fmt.Sprintf("%v: %v", w.UserID(), text))
+}
+
+type userContextKey struct{}
+
+type principalContextKey struct{}
+
+// testPrincipal is a principal with claims, like an authentication middleware would put it into the request context
+type testPrincipal struct {
+	name   string
+	claims map[string]string
+}
+
+// userConnection is a pipeConnection with a context, e.g. the context of an authenticated request
+type userConnection struct {
+	*pipeConnection
+	ctx context.Context
+}
+
+func (u *userConnection) Context() context.Context {
+	return u.ctx
+}
+
+// connectionIDLogger collects the logged connection ids
+type connectionIDLogger struct {
+	mx  sync.Mutex
+	ids []interface{}
+}
+
+func (c *connectionIDLogger) Log(keyVals ...interface{}) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	// The logger created by the Logger option passes the key values as one nested slice
+	for _, kv := range keyVals {
+		if nested, ok := kv.([]interface{}); ok {
+			keyVals = nested
+		}
+	}
+	for i := 0; i+1 < len(keyVals); i += 2 {
+		if keyVals[i] == "connection" || keyVals[i] == "connectionId" {
+			c.ids = append(c.ids, keyVals[i+1])
+		}
+	}
+	return nil
+}
+
+func (c *connectionIDLogger) connectionIDs() []interface{} {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return append([]interface{}{}, c.ids...)
+}
+
+// payloadCollectingLogger collects the logged message payloads
+type payloadCollectingLogger struct {
+	mx       sync.Mutex
+	messages []string
+}
+
+func (p *payloadCollectingLogger) Log(keyVals ...interface{}) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	// The logger created by the Logger option passes the key values as one nested slice
+	for _, kv := range keyVals {
+		if nested, ok := kv.([]interface{}); ok {
+			keyVals = nested
+		}
+	}
+	for i := 0; i+1 < len(keyVals); i += 2 {
+		if text, ok := keyVals[i+1].(string); ok && keyVals[i] == msg {
+			p.messages = append(p.messages, text)
+		}
+	}
+	return nil
+}
+
+func (p *payloadCollectingLogger) payloads() []string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return append([]string{}, p.messages...)
+}
+
+var oldPluginStarted = make(chan string, 1)
+var oldPluginRelease = make(chan struct{})
+
+type oldPluginHub struct {
+	Hub
+}
+
+func (o *oldPluginHub) Old() string {
+	oldPluginStarted <- "Old()"
+	<-oldPluginRelease
+	return "Old()"
+}
+
+type newPluginHub struct {
+	Hub
+}
+
+func (n *newPluginHub) New() string {
+	return "New()"
+}
+
+// hubConnectionOf returns the connection with the connectionID which is served by s
+func hubConnectionOf(s Server, connectionID string) *defaultHubConnection {
+	hubConn, ok := s.(*server).lifetimeManager.(*defaultHubLifetimeManager).clients.Load(connectionID)
+	Expect(ok).To(BeTrue())
+	return hubConn.(*defaultHubConnection)
+}
@@ -1,16 +1,20 @@
 package signalr
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
+	"time"
 )
 
 // UseHub sets the hub instance used by the server
 func UseHub(hub HubInterface) func(Party) error {
 	return func(p Party) error {
 		if s, ok := p.(*server); ok {
-			s.newHub = func() HubInterface { return hub }
+			s.hubFactory = func() HubInterface { return hub }
 			return nil
 		}
 		return errors.New("option UseHub is server only")
@@ -24,7 +28,7 @@ func UseHub(hub HubInterface) func(Party) error {
 func HubFactory(factory func() HubInterface) func(Party) error {
 	return func(p Party) error {
 		if s, ok := p.(*server); ok {
-			s.newHub = factory
+			s.hubFactory = factory
 			return nil
 		}
 		return errors.New("option HubFactory is server only")
@@ -59,6 +63,150 @@ func HTTPTransports(transports ...string) func(Party) error {
 	}
 }
 
+// OnNegotiate sets a function which can inspect and modify the NegotiateResponse before it is sent to the client.
+// It can be used to add custom fields to the response by setting NegotiateResponse.Extra
+// or to redirect the client to another server by setting NegotiateResponse.URL and NegotiateResponse.AccessToken.
+func OnNegotiate(hook func(req *http.Request, resp *NegotiateResponse)) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			s.negotiateHook = hook
+			return nil
+		}
+		return errors.New("option OnNegotiate is server only")
+	}
+}
+
+// UserIdentifier sets a function which returns the user of a connection. It is called with the context of the
+// connection when the connection is connected. With HTTP connections, this context holds the values of the
+// request context, so the user can be taken from the principal set by an authentication middleware.
+// All connections of a user can be invoked with HubClients.User(). Connections for which identify
+// returns "" have no user.
+func UserIdentifier(identify func(ctx context.Context) string) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			s.userIdentifier = identify
+			return nil
+		}
+		return errors.New("option UserIdentifier is server only")
+	}
+}
+
+// UseInvocationMiddleware adds InvocationMiddleware which is called before each invocation of a hub method,
+// e.g. to authorize the invocation by the claims of the principal in the context of the connection.
+// The middleware is called in the order it has been added. The first one returning an error denies the invocation.
+func UseInvocationMiddleware(middleware ...InvocationMiddleware) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			for _, m := range middleware {
+				if m == nil {
+					return errors.New("InvocationMiddleware nil")
+				}
+			}
+			s.invocationMiddleware = append(s.invocationMiddleware, middleware...)
+			return nil
+		}
+		return errors.New("option UseInvocationMiddleware is server only")
+	}
+}
+
+// MaxBufferedBytes limits the bytes buffered by all connections of the server together: received data which
+// has not been parsed into messages yet and encoded messages which have not been written to the transport yet.
+// While the limit is reached, connections stop reading new messages from their transport, which applies backpressure
+// to the clients, and writing invocations and stream items waits until other connections have released their buffers.
+// Partially received messages are completed and control messages like completions and pings are written anyway,
+// so the limit can be exceeded by them. The messages kept for stateful reconnect are not counted.
+// Default is 0, which means the buffered bytes are not limited.
+func MaxBufferedBytes(limit uint) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			if limit == 0 {
+				s.budget = nil
+				return nil
+			}
+			s.budget = newBufferBudget(limit)
+			return nil
+		}
+		return errors.New("option MaxBufferedBytes is server only")
+	}
+}
+
+// UseGroupBackplane sets the GroupBackplane which holds the client groups of the server, e.g. to share the groups
+// with other servers. Sending to a group with SendWithAck is not supported with a GroupBackplane.
+// Default is to hold the groups in the server.
+func UseGroupBackplane(backplane GroupBackplane) func(Party) error {
+	return func(p Party) error {
+		if backplane == nil {
+			return errors.New("GroupBackplane nil")
+		}
+		if s, ok := p.(*server); ok {
+			s.groupBackplane = backplane
+			return nil
+		}
+		return errors.New("option UseGroupBackplane is server only")
+	}
+}
+
+// UseBackplane sets the Backplane which connects the server with other servers for the same hub.
+// Sending to all clients, a user or a connection then also reaches the connections of the other servers.
+// SendWithAck only reaches the connections of the server itself.
+func UseBackplane(backplane Backplane) func(Party) error {
+	return func(p Party) error {
+		if backplane == nil {
+			return errors.New("Backplane nil")
+		}
+		if s, ok := p.(*server); ok {
+			s.backplane = backplane
+			return nil
+		}
+		return errors.New("option UseBackplane is server only")
+	}
+}
+
+// SendFailureHandler sets a handler which is called for each connection to which the server could not send
+// an invocation, e.g. because the connection is dead. A failed connection does not stop sending to the other
+// connections of Clients().All(), Group(), User() or Tagged(), so the handler can e.g. clean up the failed connections
+// after a broadcast. Failures of SendWithAck are returned by SendWithAck and are not passed to the handler.
+func SendFailureHandler(handler func(connectionID string, target string, err error)) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			s.sendFailed = handler
+			return nil
+		}
+		return errors.New("option SendFailureHandler is server only")
+	}
+}
+
+// MaxConnections limits the number of connections the server serves at the same time.
+// When the limit is reached, negotiate and connect requests are refused with 503 Service Unavailable
+// and a Retry-After header with retryAfter, and Serve returns ErrTooManyConnections.
+// Default is 0, which means no limit.
+func MaxConnections(max uint, retryAfter time.Duration) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			s.maxConnections = int64(max)
+			s.retryAfter = retryAfter
+			return nil
+		}
+		return errors.New("option MaxConnections is server only")
+	}
+}
+
+// ShutdownCloseMessage sets the close message which is sent to the clients when the server is shut down by Shutdown
+// or by canceling its context. With allowReconnect true, e.g. on a rolling deploy, clients reconnect, possibly to
+// another instance. With allowReconnect false, e.g. on a permanent shutdown, clients do not try to reconnect.
+// errorText is sent as error of the close message. If it is "", the error which has ended the connection is sent.
+// Default is to allow reconnects.
+func ShutdownCloseMessage(errorText string, allowReconnect bool) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			s.shutdownErrorText = errorText
+			s.shutdownAllowReconnect = allowReconnect
+			return nil
+		}
+		return errors.New("option ShutdownCloseMessage is server only")
+	}
+}
+
 // InsecureSkipVerify disables Accepts origin verification behaviour which is used to avoid same origin strategy.
 // See https://pkg.go.dev/nhooyr.io/websocket#AcceptOptions
 func InsecureSkipVerify(skip bool) func(Party) error {
@@ -68,6 +216,22 @@ func InsecureSkipVerify(skip bool) func(Party) error {
 	}
 }
 
+// AllowedOrigins sets a function which decides if a WebSocket upgrade request with the Origin header origin is allowed,
+// e.g. to check origins against a configuration which can change at runtime.
+// The origin is the complete value of the Origin header, e.g. "https://example.com".
+// Requests with a disallowed origin are rejected with 403 Forbidden. Requests without Origin header, which are not
+// sent by browsers, are always allowed. If allowed is set, InsecureSkipVerify and AllowOriginPatterns are not used.
+// Default is to allow only same origin requests or the origins set with AllowOriginPatterns.
+func AllowedOrigins(allowed func(origin string) bool) func(Party) error {
+	return func(p Party) error {
+		if s, ok := p.(*server); ok {
+			s.allowedOrigins = allowed
+			return nil
+		}
+		return errors.New("option AllowedOrigins is server only")
+	}
+}
+
 // AllowOriginPatterns lists the host patterns for authorized origins which is used for avoid same origin strategy.
 // See https://pkg.go.dev/nhooyr.io/websocket#AcceptOptions
 func AllowOriginPatterns(origins []string) func(Party) error {
@@ -76,3 +240,21 @@ func AllowOriginPatterns(origins []string) func(Party) error {
 		return nil
 	}
 }
+
+// HandshakeExtension sets a parser for fields of the handshake request which are sent in addition to protocol and version,
+// e.g. to pass auth tokens or metadata of the client, see HandshakeFields.
+// parse receives the raw additional fields and returns items which are stored in the Items of the connection,
+// so the hub can access them with HubContext.Items. If parse returns an error, the handshake is rejected with this error.
+// parse is called for each handshake, also when the client has sent no additional fields.
+func HandshakeExtension(parse func(fields map[string]json.RawMessage) (map[string]interface{}, error)) func(Party) error {
+	return func(p Party) error {
+		if parse == nil {
+			return errors.New("unsupported HandshakeExtension parser nil")
+		}
+		if s, ok := p.(*server); ok {
+			s.handshakeParser = parse
+			return nil
+		}
+		return errors.New("option HandshakeExtension is server only")
+	}
+}
@@ -2,6 +2,7 @@ package signalr
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -37,6 +38,29 @@ func (s *singleHub) initUUID() {
 
 var singleHubMsg = make(chan string, 100)
 
+type handshakeFieldsHub struct {
+	Hub
+}
+
+func (h *handshakeFieldsHub) Token() string {
+	if token, ok := h.Items().Load("token"); ok {
+		return token.(string)
+	}
+	return ""
+}
+
+// parseTokenHandshake stores the token field of the handshake request in the connection items
+func parseTokenHandshake(fields map[string]json.RawMessage) (map[string]interface{}, error) {
+	var token string
+	if err := json.Unmarshal(fields["token"], &token); err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, errors.New("missing token")
+	}
+	return map[string]interface{}{"token": token}, nil
+}
+
 var _ = Describe("Server options", func() {
 
 	Describe("UseHub option", func() {
@@ -325,6 +349,63 @@ var _ = Describe("Server options", func() {
 		})
 	})
 
+	Describe("KeepAliveInterval option with streams", func() {
+		Context("When the KeepAliveInterval is 0", func() {
+			It("should return an error", func(done Done) {
+				_, err := NewServer(context.TODO(), UseHub(&singleHub{}), KeepAliveInterval(0), testLoggerOption())
+				Expect(err).NotTo(BeNil())
+				close(done)
+			})
+		})
+		Context("When a sparse stream is sent while the client sends messages frequently", func() {
+			It("should send pings between the stream items", func(done Done) {
+				server, err := NewServer(context.TODO(), UseHub(&streamHub{}), KeepAliveInterval(100*time.Millisecond), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				conn := newTestingConnection()
+				go func() { _ = server.Serve(conn) }()
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal("{}"))
+				stopPings := make(chan struct{})
+				go func() {
+					for {
+						select {
+						case <-time.After(30 * time.Millisecond):
+							conn.ClientSend(`{"type":6}`)
+						case <-stopPings:
+							return
+						}
+					}
+				}()
+				conn.ClientSend(`{"type":4,"invocationId": "sparse","target":"sparsestream"}`)
+				messages := make([]string, 0)
+				for {
+					message, err := conn.ClientReceive()
+					Expect(err).NotTo(HaveOccurred())
+					messages = append(messages, message)
+					if strings.Contains(message, `"type":3`) {
+						break
+					}
+				}
+				close(stopPings)
+				pingsBetweenItems := 0
+				items := 0
+				for _, message := range messages {
+					switch {
+					case strings.Contains(message, `"type":2`):
+						items++
+					case message == `{"type":6}` && items == 1:
+						pingsBetweenItems++
+					}
+				}
+				Expect(items).To(Equal(2))
+				Expect(pingsBetweenItems).To(BeNumerically(">=", 2))
+				server.cancel()
+				close(done)
+			}, 3.0)
+		})
+	})
+
 	Describe("StreamBufferCapacity option", func() {
 		Context("When the StreamBufferCapacity is 0", func() {
 			It("should return an error", func(done Done) {
@@ -344,6 +425,162 @@ var _ = Describe("Server options", func() {
 			})
 		})
 	})
+	Describe("ReadChunkSize option", func() {
+		Context("When the ReadChunkSize is 0", func() {
+			It("should return an error", func(done Done) {
+				_, err := NewServer(context.TODO(), UseHub(&singleHub{}), ReadChunkSize(0), testLoggerOption())
+				Expect(err).NotTo(BeNil())
+				close(done)
+			})
+		})
+		Context("When the ReadChunkSize is smaller than the messages", func() {
+			It("should read the messages in multiple chunks", func(done Done) {
+				server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), ReadChunkSize(8), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				cliConn, srvConn := newClientServerConnections()
+				go func() { _ = server.Serve(srvConn) }()
+				ctx, cancelClient := context.WithCancel(context.Background())
+				client, err := NewClient(ctx, WithConnection(cliConn), ReadChunkSize(8), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				client.Start()
+				arg := strings.Repeat("#", 100)
+				result := <-client.Invoke("InvokeMe", arg, 1)
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(result.Value).To(Equal(arg + "1"))
+				cancelClient()
+				server.cancel()
+				close(done)
+			}, 2.0)
+		})
+	})
+	Describe("MaxArguments option", func() {
+		Context("When MaxArguments is negative", func() {
+			It("should return an error", func(done Done) {
+				_, err := NewServer(context.TODO(), UseHub(&singleHub{}), MaxArguments(-1), testLoggerOption())
+				Expect(err).NotTo(BeNil())
+				close(done)
+			})
+		})
+		Context("When an invocation claims more arguments than MaxArguments", func() {
+			It("should close the connection with an error", func(done Done) {
+				server, err := NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}), MaxArguments(10), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				conn := newTestingConnectionForServer()
+				go func() { _ = server.Serve(conn) }()
+				conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"many","target":"simple","arguments":[%v]}`,
+					strings.TrimSuffix(strings.Repeat("1,", 5000), ",")))
+				for message := range conn.received {
+					if closeMsg, ok := message.(closeMessage); ok {
+						Expect(closeMsg.Error).To(ContainSubstring("more than 10 arguments"))
+						break
+					}
+				}
+				server.cancel()
+				close(done)
+			}, 2.0)
+		})
+	})
+	Describe("MaxTargetLength option", func() {
+		Context("When an invocation has a target longer than MaxTargetLength", func() {
+			It("should close the connection with a protocol error", func(done Done) {
+				server, err := NewServer(context.TODO(), SimpleHubFactory(&invocationHub{}), MaxTargetLength(256),
+					MaxInvocationIDLength(64), testLoggerOption())
+				Expect(err).NotTo(HaveOccurred())
+				conn := newTestingConnectionForServer()
+				go func() { _ = server.Serve(conn) }()
+				conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"long","target":"%v"}`, strings.Repeat("x", 1<<20)))
+				for message := range conn.received {
+					if closeMsg, ok := message.(closeMessage); ok {
+						Expect(closeMsg.Error).To(ContainSubstring(ErrProtocolViolation.Error()))
+						Expect(closeMsg.Error).To(ContainSubstring("longer than 256 bytes"))
+						break
+					}
+				}
+				server.cancel()
+				close(done)
+			}, 2.0)
+		})
+	})
+	Describe("InvokeChanResults option", func() {
+		// receiveUntilCompletion returns the items of the stream items as text and the completion of the invocation
+		receiveUntilCompletion := func(conn *testingConnection) ([]string, completionMessage) {
+			items := make([]string, 0)
+			for message := range conn.received {
+				switch m := message.(type) {
+				case streamItemMessage:
+					items = append(items, strings.Trim(fmt.Sprintf("%s", m.Item), `"`))
+				case completionMessage:
+					return items, m
+				}
+			}
+			return items, completionMessage{}
+		}
+		serve := func(policy ChanResultPolicy) (Server, *testingConnection) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), InvokeChanResults(policy), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			return server, conn
+		}
+		Context("When the policy is ChanResultFirstItem", func() {
+			It("should complete an invocation of a method returning a chan with the first item", func(done Done) {
+				server, conn := serve(ChanResultFirstItem)
+				conn.ClientSend(`{"type":1,"invocationId":"first","target":"readstream","arguments":[1]}`)
+				items, completion := receiveUntilCompletion(conn)
+				Expect(items).To(BeEmpty())
+				Expect(completion.Error).To(BeEmpty())
+				Expect(completion.Result).To(Equal("A1"))
+				server.cancel()
+				close(done)
+			}, 2.0)
+			It("should stream all items to a stream invocation", func(done Done) {
+				server, conn := serve(ChanResultFirstItem)
+				conn.ClientSend(`{"type":4,"invocationId":"stream","target":"readstream","arguments":[1]}`)
+				items, completion := receiveUntilCompletion(conn)
+				Expect(items).To(Equal([]string{"A1", "B1", "C1", "D1"}))
+				Expect(completion.Error).To(BeEmpty())
+				server.cancel()
+				close(done)
+			}, 2.0)
+		})
+		Context("When the policy is ChanResultReject", func() {
+			It("should complete an invocation of a method returning a chan with an error", func(done Done) {
+				server, conn := serve(ChanResultReject)
+				conn.ClientSend(`{"type":1,"invocationId":"first","target":"readstream","arguments":[1]}`)
+				items, completion := receiveUntilCompletion(conn)
+				Expect(items).To(BeEmpty())
+				Expect(completion.Error).To(ContainSubstring("can only be invoked as stream invocation"))
+				Expect(completion.Result).To(BeNil())
+				server.cancel()
+				close(done)
+			}, 2.0)
+			It("should stream all items to a stream invocation", func(done Done) {
+				server, conn := serve(ChanResultReject)
+				conn.ClientSend(`{"type":4,"invocationId":"stream","target":"readstream","arguments":[1]}`)
+				items, completion := receiveUntilCompletion(conn)
+				Expect(items).To(Equal([]string{"A1", "B1", "C1", "D1"}))
+				Expect(completion.Error).To(BeEmpty())
+				server.cancel()
+				close(done)
+			}, 2.0)
+			It("should still complete an invocation of a method without chan result", func(done Done) {
+				server, conn := serve(ChanResultReject)
+				conn.ClientSend(`{"type":1,"invocationId":"plain","target":"invokeme","arguments":["A",1]}`)
+				_, completion := receiveUntilCompletion(conn)
+				Expect(completion.Error).To(BeEmpty())
+				Expect(completion.Result).To(Equal("A1"))
+				server.cancel()
+				close(done)
+			}, 2.0)
+		})
+		Context("When the policy is unknown", func() {
+			It("should return an error", func(done Done) {
+				_, err := NewServer(context.TODO(), SimpleHubFactory(&simpleHub{}), InvokeChanResults(ChanResultPolicy(7)), testLoggerOption())
+				Expect(err).To(HaveOccurred())
+				close(done)
+			})
+		})
+	})
 	Describe("HTTPTransports option", func() {
 		Context("When HTTPTransports is one of WebSockets, ServerSentEvents or both", func() {
 			It("should set these transports", func(done Done) {
@@ -382,6 +619,105 @@ var _ = Describe("Server options", func() {
 		})
 
 	})
+
+	Describe("HandshakeExtension and HandshakeFields options", func() {
+		startClient := func(ctx context.Context, fields map[string]interface{}) Client {
+			server, err := NewServer(ctx, SimpleHubFactory(&handshakeFieldsHub{}), HandshakeExtension(parseTokenHandshake), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			cliConn, srvConn := newClientServerConnections()
+			go func() { _ = server.Serve(srvConn) }()
+			client, err := NewClient(ctx, WithConnection(cliConn), HandshakeFields(fields), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			client.Start()
+			return client
+		}
+		Context("When the client sends a field which the server parses", func() {
+			It("should store the parsed field in the connection items", func(done Done) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				client := startClient(ctx, map[string]interface{}{"token": "secret"})
+				Expect(<-client.WaitForState(ctx, ClientConnected)).NotTo(HaveOccurred())
+				r := <-client.Invoke("Token")
+				Expect(r.Error).NotTo(HaveOccurred())
+				Expect(r.Value).To(Equal("secret"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When the server fails to parse the fields", func() {
+			It("should reject the handshake", func(done Done) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				client := startClient(ctx, map[string]interface{}{"token": ""})
+				Expect(<-client.WaitForState(ctx, ClientClosed)).NotTo(HaveOccurred())
+				Expect(client.Err()).To(MatchError("invalid handshake: missing token"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When HandshakeFields contains a reserved field", func() {
+			It("should return an error", func() {
+				_, err := NewClient(context.TODO(), WithConnection(newTestingConnection()),
+					HandshakeFields(map[string]interface{}{"protocol": "json"}), testLoggerOption())
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("When HandshakeExtension is used on a client", func() {
+			It("should return an error", func() {
+				_, err := NewClient(context.TODO(), WithConnection(newTestingConnection()),
+					HandshakeExtension(parseTokenHandshake), testLoggerOption())
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("ShutdownCloseMessage option", func() {
+		shutdown := func(options ...func(Party) error) closeMessage {
+			server, err := NewServer(context.TODO(), append(options, SimpleHubFactory(&simpleHub{}), testLoggerOption())...)
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			// Wait until the connection is served
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"invokeme","arguments":["A",1]}`)
+			Expect(<-conn.received).To(BeAssignableToTypeOf(completionMessage{}))
+			Expect(server.Shutdown(context.Background())).To(Succeed())
+			for message := range conn.received {
+				if closeMsg, ok := message.(closeMessage); ok {
+					return closeMsg
+				}
+			}
+			return closeMessage{}
+		}
+		Context("When the option is not set", func() {
+			It("should allow the clients to reconnect", func(done Done) {
+				message := shutdown()
+				Expect(message.AllowReconnect).To(BeTrue())
+				Expect(message.Error).NotTo(BeEmpty())
+				close(done)
+			}, 2.0)
+		})
+		Context("When the server is shut down for a rolling deploy", func() {
+			It("should send the configured error and allow the clients to reconnect", func(done Done) {
+				message := shutdown(ShutdownCloseMessage("restarting", true))
+				Expect(message.AllowReconnect).To(BeTrue())
+				Expect(message.Error).To(Equal("restarting"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When the server is shut down permanently", func() {
+			It("should send the configured error and not allow the clients to reconnect", func(done Done) {
+				message := shutdown(ShutdownCloseMessage("shut down", false))
+				Expect(message.AllowReconnect).To(BeFalse())
+				Expect(message.Error).To(Equal("shut down"))
+				close(done)
+			}, 2.0)
+		})
+		Context("When ShutdownCloseMessage is used on a client", func() {
+			It("should return an error", func() {
+				_, err := NewClient(context.TODO(), WithConnection(newTestingConnection()),
+					ShutdownCloseMessage("", false), testLoggerOption())
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
 })
 
 type channelWriter struct {
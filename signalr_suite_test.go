@@ -14,11 +14,14 @@ func TestSignalR(t *testing.T) {
 	RunSpecs(t, "SignalR Suite")
 }
 
-func connect(hubProto HubInterface) (Server, *testingConnection) {
-	server, err := NewServer(context.TODO(), SimpleHubFactory(hubProto),
+func connect(hubProto HubInterface, options ...func(Party) error) (Server, *testingConnection) {
+	options = append([]func(Party) error{
+		SimpleHubFactory(hubProto),
 		testLoggerOption(),
-		ChanReceiveTimeout(200*time.Millisecond),
-		StreamBufferCapacity(5))
+		ChanReceiveTimeout(200 * time.Millisecond),
+		StreamBufferCapacity(5),
+	}, options...)
+	server, err := NewServer(context.TODO(), options...)
 	if err != nil {
 		Fail(err.Error())
 		return nil, nil
@@ -0,0 +1,65 @@
+package signalr
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats describes the current state of a Server
+type ServerStats struct {
+	// Uptime is the time since the server has been created, in seconds
+	Uptime float64 `json:"uptime"`
+	// Connections is the number of connections which have completed the handshake
+	Connections int `json:"connections"`
+	// Hubs holds the number of connections under the type of the current hub. A server serves only one hub type,
+	// so the count is always the same as Connections. After ReplaceHub, the type is the one of the new hub,
+	// also for connections which were established with the previous hub.
+	Hubs map[string]int `json:"hubs"`
+	// BytesRead is the number of bytes read from all connections, including the closed ones
+	BytesRead uint64 `json:"bytesRead"`
+	// BytesWritten is the number of bytes written to all connections, including the closed ones
+	BytesWritten uint64 `json:"bytesWritten"`
+	// BytesReadPerSecond and BytesWrittenPerSecond are the average throughput since the server has been created
+	BytesReadPerSecond    float64 `json:"bytesReadPerSecond"`
+	BytesWrittenPerSecond float64 `json:"bytesWrittenPerSecond"`
+}
+
+// stats collects the ServerStats
+func (s *server) stats() ServerStats {
+	uptime := time.Since(s.startTime).Seconds()
+	stats := ServerStats{
+		Uptime:       uptime,
+		BytesRead:    atomic.LoadUint64(&s.closedBytesRead),
+		BytesWritten: atomic.LoadUint64(&s.closedBytesWritten),
+	}
+	for _, info := range s.lifetimeManager.ConnectionInfos() {
+		stats.Connections++
+		stats.BytesRead += info.BytesRead
+		stats.BytesWritten += info.BytesWritten
+	}
+	s.hubMx.RLock()
+	stats.Hubs = map[string]int{s.hubType: stats.Connections}
+	s.hubMx.RUnlock()
+	if uptime > 0 {
+		stats.BytesReadPerSecond = float64(stats.BytesRead) / uptime
+		stats.BytesWrittenPerSecond = float64(stats.BytesWritten) / uptime
+	}
+	return stats
+}
+
+// StatsHandler returns a http.Handler which serves the ServerStats as JSON.
+// The handler is not mapped by MapHTTP. To expose the stats, mount the handler on a path of your choice.
+// As the stats reveal the load of the server, you might want to protect the handler with the
+// authentication middleware of your application.
+func (s *server) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.stats())
+	})
+}
@@ -1,30 +1,38 @@
 package signalr
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sync"
 	"time"
 )
 
-func newStreamClient(protocol hubProtocol, chanReceiveTimeout time.Duration, streamBufferCapacity uint) *streamClient {
+// newStreamClient creates a streamClient. When ctx is done, sending to the upstream channels is given up.
+func newStreamClient(ctx context.Context, protocol hubProtocol, chanReceiveTimeout time.Duration, streamBufferCapacity uint, clock Clock) *streamClient {
 	return &streamClient{
+		ctx:                  ctx,
 		mx:                   sync.Mutex{},
 		upstreamChannels:     make(map[string]reflect.Value),
+		upstreamItemTypes:    make(map[string]reflect.Type),
 		runningStreams:       make(map[string]bool),
 		chanReceiveTimeout:   chanReceiveTimeout,
 		streamBufferCapacity: streamBufferCapacity,
 		protocol:             protocol,
+		clock:                clock,
 	}
 }
 
 type streamClient struct {
+	ctx                  context.Context
 	mx                   sync.Mutex
 	upstreamChannels     map[string]reflect.Value
+	upstreamItemTypes    map[string]reflect.Type
 	runningStreams       map[string]bool
 	chanReceiveTimeout   time.Duration
 	streamBufferCapacity uint
 	protocol             hubProtocol
+	clock                Clock
 }
 
 func (c *streamClient) buildChannelArgument(invocation invocationMessage, argType reflect.Type, chanCount int) (arg reflect.Value, canClientStreaming bool, err error) {
@@ -43,11 +51,15 @@ func (c *streamClient) buildChannelArgument(invocation invocationMessage, argTyp
 	}
 }
 
-func (c *streamClient) newUpstreamChannel(invocationID string) <-chan interface{} {
+// newUpstreamChannel creates the channel which receives the items of the stream with invocationID.
+// The items are unmarshaled into values of itemType, e.g. []byte items which are sent as base64 strings
+// by the JSON protocol are decoded.
+func (c *streamClient) newUpstreamChannel(invocationID string, itemType reflect.Type) <-chan interface{} {
 	c.mx.Lock()
 	defer c.mx.Unlock()
 	upChan := make(chan interface{}, c.streamBufferCapacity)
 	c.upstreamChannels[invocationID] = reflect.ValueOf(upChan)
+	c.upstreamItemTypes[invocationID] = itemType
 	return upChan
 }
 
@@ -56,6 +68,7 @@ func (c *streamClient) deleteUpstreamChannel(invocationID string) {
 	if upChan, ok := c.upstreamChannels[invocationID]; ok {
 		upChan.Close()
 		delete(c.upstreamChannels, invocationID)
+		delete(c.upstreamItemTypes, invocationID)
 	}
 	c.mx.Unlock()
 }
@@ -66,32 +79,53 @@ func (c *streamClient) receiveStreamItem(streamItem streamItemMessage) error {
 	if upChan, ok := c.upstreamChannels[streamItem.InvocationID]; ok {
 		// Mark the stream as running to detect illegal completion with result on this id
 		c.runningStreams[streamItem.InvocationID] = true
-		chanVal := reflect.New(upChan.Type().Elem())
-		err := c.protocol.UnmarshalArgument(streamItem.Item, chanVal.Interface())
+		itemType := upChan.Type().Elem()
+		if t, ok := c.upstreamItemTypes[streamItem.InvocationID]; ok {
+			itemType = t
+		}
+		chanVal, err := unmarshalValue(c.protocol, streamItem.Item, itemType)
 		if err != nil {
 			return err
 		}
-		return c.sendChanValSave(upChan, chanVal.Elem())
+		return c.sendChanValSave(upChan, chanVal)
 	}
 	return fmt.Errorf(`unknown stream id "%v"`, streamItem.InvocationID)
 }
 
-func (c *streamClient) sendChanValSave(upChan reflect.Value, chanVal reflect.Value) error {
-	done := make(chan error)
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				done <- fmt.Errorf("%v", r)
-			}
-		}()
-		upChan.Send(chanVal)
-		done <- nil
+// sendChanValSave sends chanVal to upChan. It gives up when the receiver does not receive within chanReceiveTimeout
+// or when the connection is closed, so a hub which does not read its stream can not block the teardown of the connection.
+func (c *streamClient) sendChanValSave(upChan reflect.Value, chanVal reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
 	}()
-	select {
-	case err := <-done:
-		return err
-	case <-time.After(c.chanReceiveTimeout):
+	timeout := c.clock.NewTimer(c.chanReceiveTimeout)
+	defer timeout.Stop()
+	chosen, _, _ := reflect.Select([]reflect.SelectCase{
+		{Dir: reflect.SelectSend, Chan: upChan, Send: chanVal},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timeout.C())},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ctx.Done())},
+	})
+	switch chosen {
+	case 1:
 		return &hubChanTimeoutError{fmt.Sprintf("timeout (%v) waiting for hub to receive client streamed value", c.chanReceiveTimeout)}
+	case 2:
+		return fmt.Errorf("connection closed while waiting for hub to receive client streamed value: %w", c.ctx.Err())
+	}
+	return nil
+}
+
+// abandonAll closes all upstream channels, so receivers which range over them are not left waiting
+// after the connection has been closed.
+func (c *streamClient) abandonAll() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for invocationID, upChan := range c.upstreamChannels {
+		upChan.Close()
+		delete(c.upstreamChannels, invocationID)
+		delete(c.upstreamItemTypes, invocationID)
+		delete(c.runningStreams, invocationID)
 	}
 }
 
@@ -141,6 +175,7 @@ func (c *streamClient) receiveCompletionItem(completion completionMessage, invok
 		invokeClient.deleteInvocation(completion.InvocationID)
 		c.mx.Lock()
 		delete(c.upstreamChannels, completion.InvocationID)
+		delete(c.upstreamItemTypes, completion.InvocationID)
 		delete(c.runningStreams, completion.InvocationID)
 		c.mx.Unlock()
 		return err
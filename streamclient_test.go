@@ -126,6 +126,18 @@ func (c *clientStreamHub) UploadErrorArray(u <-chan []error) {
 	}
 }
 
+func (c *clientStreamHub) UploadInt64(u <-chan int64) {
+	for r := range u {
+		c.SendResult(fmt.Sprintf("received %v", r))
+	}
+}
+
+func (c *clientStreamHub) UploadAny(u <-chan interface{}) {
+	for r := range u {
+		c.SendResult(fmt.Sprintf("received %T %v", r, r))
+	}
+}
+
 type resultReceiver struct {
 	ch chan string
 }
@@ -243,6 +255,39 @@ var _ = Describe("ClientStreaming", func() {
 		})
 	})
 
+	Describe("Stream invocation when the connection is closed", func() {
+		Context("When the connection is closed while the hub method does not read the stream", func() {
+			It("should end the connection without waiting for ChanReceiveTimeout", func(done Done) {
+				cliConn, srvConn := newClientServerConnections()
+				serverCtx, cancelServer := context.WithCancel(context.Background())
+				server, _ := NewServer(serverCtx, SimpleHubFactory(&clientStreamHub{}), testLoggerOption(),
+					ChanReceiveTimeout(time.Minute), StreamBufferCapacity(5))
+				served := make(chan struct{})
+				go func() {
+					_ = server.Serve(srvConn)
+					close(served)
+				}()
+				clientCtx, cancelClient := context.WithCancel(context.Background())
+				receiver := &resultReceiver{ch: make(chan string, 1)}
+				client, _ := NewClient(clientCtx, WithConnection(cliConn), WithReceiver(receiver), testLoggerOption())
+				client.Start()
+				<-client.WaitForState(context.Background(), ClientConnected)
+				ch := make(chan int, 1)
+				client.PushStreams("UploadHang", ch)
+				Expect(<-receiver.ch).To(Equal("UploadHang()"))
+				// StreamBufferCapacity is 5, so the 6th item blocks the server
+				for i := 0; i < 6; i++ {
+					ch <- i
+				}
+				<-time.After(100 * time.Millisecond)
+				cancelServer()
+				Eventually(served, time.Second).Should(BeClosed())
+				cancelClient()
+				close(done)
+			}, 2.0)
+		})
+	})
+
 	Describe("Stream invocation with wrong streamId", func() {
 		Context("When invoked by the client with streamIds", func() {
 			It("should be invoked on the server, and receive stream items until the caller sends a completion. Unknown streamIds should be ignored", func(done Done) {
@@ -355,6 +400,48 @@ var _ = Describe("ClientStreaming", func() {
 		})
 	})
 
+	Describe("Stream client with large integers", func() {
+		const large = int64(1<<62 + 1)
+		Context("When large integers are sent to a chan int64", func() {
+			It("should receive them without loss of precision", func(done Done) {
+				client, receiver, cancel := makeStreamingClientAndServer()
+				ch := make(chan int64)
+				client.PushStreams("UploadInt64", ch)
+				ch <- large
+				Expect(<-receiver.ch).To(Equal(fmt.Sprintf("received %v", large)))
+				ch <- -large
+				Expect(<-receiver.ch).To(Equal(fmt.Sprintf("received %v", -large)))
+				close(ch)
+				cancel()
+				close(done)
+			})
+		})
+		Context("When large integers are sent to a chan interface{} and UseJSONNumber is set", func() {
+			It("should receive them as json.Number without loss of precision", func(done Done) {
+				client, receiver, cancel := makeStreamingClientAndServer(UseJSONNumber(true))
+				ch := make(chan int64)
+				client.PushStreams("UploadAny", ch)
+				ch <- large
+				Expect(<-receiver.ch).To(Equal(fmt.Sprintf("received json.Number %v", large)))
+				close(ch)
+				cancel()
+				close(done)
+			})
+		})
+		Context("When large integers are sent to a chan interface{} without UseJSONNumber", func() {
+			It("should receive them as float64", func(done Done) {
+				client, receiver, cancel := makeStreamingClientAndServer()
+				ch := make(chan int64)
+				client.PushStreams("UploadAny", ch)
+				ch <- large
+				Expect(<-receiver.ch).To(Equal(fmt.Sprintf("received float64 %v", float64(large))))
+				close(ch)
+				cancel()
+				close(done)
+			})
+		})
+	})
+
 	Describe("Stream client with array channel", func() {
 		Context("When a func with an array channel is invoked by the client and stream items are send", func() {
 			It("should receive values and end after that", func(done Done) {
@@ -1,40 +1,214 @@
 package signalr
 
 import (
+	"errors"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type streamer struct {
-	cancels sync.Map
+	streams sync.Map
 	conn    hubConnection
+	// sequenced streams send the sequence number of each item, see StreamItemSequence
+	sequenced bool
+	clock     Clock
 }
 
-func (s *streamer) Start(invocationID string, reflectedChannel reflect.Value) {
+// activeStream is the state of a stream which has been started.
+// The mutex ensures that no item is sent after the stream has been completed by Stop.
+type activeStream struct {
+	mx    sync.Mutex
+	ended bool
+	onEnd func()
+	// flush receives the error text when the stream should be flushed, see Flush
+	flush chan string
+	// done is closed when the goroutine which pulls the stream has returned
+	done chan struct{}
+	// sequence is the sequence number of the last item sent
+	sequence uint64
+	// closedError returns the error text of the completion which is sent when the channel has been closed
+	closedError func() string
+}
+
+// closedErrorText returns the error text of the completion for a stream whose channel has been closed
+func (a *activeStream) closedErrorText() string {
+	if a.closedError == nil {
+		return ""
+	}
+	return a.closedError()
+}
+
+// end marks the stream as ended and calls onEnd. The mutex must be held.
+func (a *activeStream) end() {
+	a.ended = true
+	if a.onEnd != nil {
+		a.onEnd()
+	}
+}
+
+// Start starts streaming the items received from reflectedChannel.
+// If onEnd is not nil, it is called when the stream has ended, before the final completion is sent.
+// If closedError is not nil, the completion which is sent after the channel has been closed carries its result as error.
+// Each stream is pulled in its own goroutine, and the next item is only received after the previous one has been written.
+// So a slow stream neither blocks other messages on the connection nor piles up items faster than they can be sent.
+// When the connection ends, all its streams end at once, even if their channels do not deliver items anymore.
+// Iterators and producers are stopped by onEnd then. Hub methods which send to a channel should also select on
+// the context of the connection, so they are not blocked forever.
+func (s *streamer) Start(invocationID string, reflectedChannel reflect.Value, onEnd func(), closedError func() string) {
+	stream := &activeStream{onEnd: onEnd, flush: make(chan string, 1), done: make(chan struct{}), closedError: closedError}
+	s.streams.Store(invocationID, stream)
 	go func() {
-	loop:
+		defer close(stream.done)
+		defer s.streams.Delete(invocationID)
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflectedChannel},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stream.flush)},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.conn.Context().Done())},
+		}
 		for {
 			// Waits for channel, so might hang
-			if chanResult, ok := reflectedChannel.Recv(); ok {
-				if _, ok := s.cancels.Load(invocationID); ok {
-					s.cancels.Delete(invocationID)
-					_ = s.conn.Completion(invocationID, nil, "")
-					break loop
-				}
-				if s.conn.Context().Err() != nil {
-					break loop
-				}
-				_ = s.conn.StreamItem(invocationID, chanResult.Interface())
-			} else {
-				if s.conn.Context().Err() == nil {
-					_ = s.conn.Completion(invocationID, nil, "")
-				}
-				break loop
+			chosen, chanResult, ok := reflect.Select(cases)
+			switch chosen {
+			case 1:
+				s.flush(invocationID, stream, reflectedChannel, chanResult.String())
+				return
+			case 2:
+				s.abandon(stream)
+				return
+			}
+			if !s.send(invocationID, stream, chanResult, ok) {
+				return
 			}
 		}
 	}()
 }
 
+// send sends the item received from the stream. It returns false if the stream has ended.
+func (s *streamer) send(invocationID string, stream *activeStream, chanResult reflect.Value, ok bool) bool {
+	stream.mx.Lock()
+	defer stream.mx.Unlock()
+	switch {
+	case stream.ended:
+		// Stopped, the completion has already been sent
+		return false
+	case !ok:
+		stream.end()
+		if s.conn.Context().Err() == nil {
+			_ = s.conn.Completion(invocationID, nil, stream.closedErrorText())
+		}
+		return false
+	case s.conn.Context().Err() != nil:
+		stream.end()
+		return false
+	}
+	if errorText, ok := streamFailure(chanResult); ok {
+		stream.end()
+		_ = s.conn.Completion(invocationID, nil, errorText)
+		return false
+	}
+	if err := s.conn.StreamItem(invocationID, chanResult.Interface(), s.nextSequence(stream)); notSendable(err) {
+		// Complete the stream instead of sending a stream which misses an item
+		stream.end()
+		_ = s.conn.Completion(invocationID, nil, err.Error())
+		return false
+	} else if errors.Is(err, ErrConnectionClosed) {
+		// Nobody receives the following items
+		stream.end()
+		return false
+	} else if err == nil && streamFlush(chanResult) {
+		_ = s.conn.Flush()
+	}
+	return true
+}
+
+// abandon ends the stream without completion, because the connection has ended
+func (s *streamer) abandon(stream *activeStream) {
+	stream.mx.Lock()
+	defer stream.mx.Unlock()
+	if !stream.ended {
+		stream.end()
+	}
+}
+
+// nextSequence returns the sequence number of the next item of the stream, or 0 if the streamer is not sequenced.
+// The mutex of the stream must be held.
+func (s *streamer) nextSequence(stream *activeStream) uint64 {
+	if !s.sequenced {
+		return 0
+	}
+	stream.sequence++
+	return stream.sequence
+}
+
+// flush sends the items which are buffered in the channel of the stream and completes the stream with errorText.
+// A stream which has been closed by the hub is completed without error or with the error of closedError.
+func (s *streamer) flush(invocationID string, stream *activeStream, reflectedChannel reflect.Value, errorText string) {
+	stream.mx.Lock()
+	defer stream.mx.Unlock()
+	if stream.ended {
+		return
+	}
+	stream.end()
+	for {
+		chanResult, ok := reflectedChannel.TryRecv()
+		if !chanResult.IsValid() {
+			// No buffered item left
+			break
+		}
+		if !ok {
+			errorText = stream.closedErrorText()
+			break
+		}
+		if failureText, ok := streamFailure(chanResult); ok {
+			errorText = failureText
+			break
+		}
+		if err := s.conn.StreamItem(invocationID, chanResult.Interface(), s.nextSequence(stream)); err != nil {
+			if !notSendable(err) {
+				return
+			}
+			errorText = err.Error()
+			break
+		}
+	}
+	_ = s.conn.Completion(invocationID, nil, errorText)
+}
+
+// Flush lets all active streams send their buffered items and complete with errorText, see flush.
+// It waits until all streams are flushed or timeout has elapsed.
+func (s *streamer) Flush(errorText string, timeout time.Duration) {
+	streams := make([]*activeStream, 0)
+	s.streams.Range(func(_, value interface{}) bool {
+		stream := value.(*activeStream)
+		select {
+		case stream.flush <- errorText:
+		default:
+		}
+		streams = append(streams, stream)
+		return true
+	})
+	deadline := s.clock.NewTimer(timeout)
+	defer deadline.Stop()
+	for _, stream := range streams {
+		select {
+		case <-stream.done:
+		case <-deadline.C():
+			return
+		}
+	}
+}
+
+// Stop stops the stream and sends the final completion, so the other party does not wait for the next item
+// of a stream which might not send items for a long time.
 func (s *streamer) Stop(invocationID string) {
-	s.cancels.Store(invocationID, struct{}{})
+	if value, ok := s.streams.Load(invocationID); ok {
+		stream := value.(*activeStream)
+		stream.mx.Lock()
+		defer stream.mx.Unlock()
+		if !stream.ended {
+			stream.end()
+			_ = s.conn.Completion(invocationID, nil, "")
+		}
+	}
 }
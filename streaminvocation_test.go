@@ -1,6 +1,10 @@
 package signalr
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -52,6 +56,179 @@ func (s *streamHub) SliceStream() <-chan []int {
 	return r
 }
 
+var slowStreamRelease = make(chan struct{})
+
+func (s *streamHub) SlowStream() <-chan int {
+	r := make(chan int)
+	go func() {
+		defer close(r)
+		r <- 1
+		select {
+		case <-slowStreamRelease:
+			r <- 2
+		case <-s.Context().Done():
+		}
+	}()
+	streamInvocationQueue <- "SlowStream()"
+	return r
+}
+
+func (s *streamHub) BlockingStream() <-chan int {
+	r := make(chan int)
+	go func() {
+		defer close(r)
+		r <- 1
+		<-s.Context().Done()
+	}()
+	streamInvocationQueue <- "BlockingStream()"
+	return r
+}
+
+// heldStreams receives the channels of the streams started by HeldStream, so the test can push items to them
+var heldStreams = make(chan chan int, 2)
+
+func (s *streamHub) HeldStream() <-chan int {
+	r := make(chan int, 5)
+	heldStreams <- r
+	return r
+}
+
+// intSeq is the same type as iter.Seq[int]
+type intSeq func(yield func(int) bool)
+
+func (s *streamHub) SeqStream() intSeq {
+	streamInvocationQueue <- "SeqStream()"
+	return func(yield func(int) bool) {
+		for i := 1; i < 4; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+func (s *streamHub) EndlessSeqStream() intSeq {
+	streamInvocationQueue <- "EndlessSeqStream()"
+	return func(yield func(int) bool) {
+		for i := 1; yield(i); i++ {
+		}
+		streamInvocationQueue <- "EndlessSeqStream() stopped"
+	}
+}
+
+func (s *streamHub) ProducerStream(fail bool) func(ctx context.Context, send func(int) error) error {
+	streamInvocationQueue <- "ProducerStream()"
+	return func(ctx context.Context, send func(int) error) error {
+		for i := 1; i < 4; i++ {
+			if err := send(i); err != nil {
+				return err
+			}
+		}
+		if fail {
+			return errors.New("producer failed")
+		}
+		return nil
+	}
+}
+
+func (s *streamHub) EndlessProducerStream() func(ctx context.Context, send func(int) error) error {
+	streamInvocationQueue <- "EndlessProducerStream()"
+	return func(ctx context.Context, send func(int) error) error {
+		for i := 1; ; i++ {
+			if err := send(i); err != nil {
+				streamInvocationQueue <- fmt.Sprintf("EndlessProducerStream() stopped: %v", err)
+				return err
+			}
+		}
+	}
+}
+
+// streamsEnded receives the names of the streams of IdleStream and IdleUpload when they have ended
+var streamsEnded = make(chan string, 10)
+
+// IdleStream returns a stream which sends no items until it is ended
+func (s *streamHub) IdleStream(name string) func(ctx context.Context, send func(int) error) error {
+	return func(ctx context.Context, send func(int) error) error {
+		<-ctx.Done()
+		streamsEnded <- name
+		return ctx.Err()
+	}
+}
+
+func (s *streamHub) IdleUpload(name string, upload <-chan int) {
+	for range upload {
+	}
+	streamsEnded <- name
+}
+
+func (s *streamHub) CheckedStream(allowed bool) (<-chan int, error) {
+	streamInvocationQueue <- "CheckedStream()"
+	if !allowed {
+		return nil, errors.New("stream not allowed")
+	}
+	r := make(chan int)
+	go func() {
+		defer close(r)
+		for i := 1; i < 3; i++ {
+			r <- i
+		}
+	}()
+	return r, nil
+}
+
+// ProgressStream reports a recoverable error between its values. If fail is true, it fails after the second value
+func (s *streamHub) ProgressStream(fail bool) <-chan StreamResult {
+	r := make(chan StreamResult)
+	go func() {
+		defer close(r)
+		r <- StreamValue(1)
+		r <- StreamError(errors.New("step 2 skipped"))
+		r <- StreamValue(3)
+		if fail {
+			r <- StreamFailure(errors.New("step 4 failed"))
+		}
+		r <- StreamValue(5)
+	}()
+	streamInvocationQueue <- "ProgressStream()"
+	return r
+}
+
+func (s *streamHub) SparseStream() <-chan int {
+	r := make(chan int)
+	go func() {
+		defer close(r)
+		for i := 1; i < 3; i++ {
+			select {
+			case <-time.After(500 * time.Millisecond):
+				r <- i
+			case <-s.Context().Done():
+				return
+			}
+		}
+	}()
+	return r
+}
+
+func (s *streamHub) GrowingStream() <-chan string {
+	r := make(chan string)
+	go func() {
+		defer close(r)
+		for _, size := range []int{10, 1000, 10} {
+			select {
+			case r <- strings.Repeat("x", size):
+			case <-s.Context().Done():
+				return
+			}
+		}
+	}()
+	streamInvocationQueue <- "GrowingStream()"
+	return r
+}
+
+func (s *streamHub) LargeResult() string {
+	return strings.Repeat("x", 1000)
+}
+
 func (s *streamHub) SimpleInt() int {
 	streamInvocationQueue <- "SimpleInt()"
 	return -1
@@ -93,6 +270,151 @@ var _ = Describe("StreamInvocation", func() {
 		})
 	})
 
+	Describe("Stream invocation with StreamItemSequence", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, _ = NewServer(context.TODO(), SimpleHubFactory(&streamHub{}), StreamItemSequence(true), testLoggerOption())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When multiple streams are invoked", func() {
+			It("should send the items of each stream with an own increasing sequence", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "s1","target":"simplestream"}`)
+				conn.ClientSend(`{"type":4,"invocationId": "s2","target":"simplestream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SimpleStream()"))
+				Expect(<-streamInvocationQueue).To(Equal("SimpleStream()"))
+				sequences := map[string][]string{}
+				for completed := 0; completed < 2; {
+					switch recv := (<-conn.received).(type) {
+					case streamItemMessage:
+						sequences[recv.InvocationID] = append(sequences[recv.InvocationID], recv.Headers["sequence"])
+					case completionMessage:
+						completed++
+					}
+				}
+				Expect(sequences).To(Equal(map[string][]string{"s1": {"1", "2", "3"}, "s2": {"1", "2", "3"}}))
+				close(done)
+			})
+		})
+		Context("When a method without stream result is invoked as stream", func() {
+			It("should send the result as first item of the sequence", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "i1","target":"simpleint"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SimpleInt()"))
+				recv := (<-conn.received).(streamItemMessage)
+				Expect(recv.Headers).To(Equal(map[string]string{"sequence": "1"}))
+				close(done)
+			})
+		})
+	})
+
+	Describe("Stream invocation of a method returning a chan and an error", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&streamHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When the method returns an error", func() {
+			It("should send a completion with the error and no stream items", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "denied","target":"checkedstream","arguments":[false]}`)
+				Expect(<-streamInvocationQueue).To(Equal("CheckedStream()"))
+				recv := <-conn.received
+				Expect(recv).To(BeAssignableToTypeOf(completionMessage{}))
+				Expect(recv.(completionMessage).InvocationID).To(Equal("denied"))
+				Expect(recv.(completionMessage).Error).To(Equal("stream not allowed"))
+				close(done)
+			})
+		})
+		Context("When the method returns no error", func() {
+			It("should return stream items and a final completion without error", func(done Done) {
+				p := &jsonHubProtocol{dbg: testLogger()}
+				conn.ClientSend(`{"type":4,"invocationId": "allowed","target":"checkedstream","arguments":[true]}`)
+				Expect(<-streamInvocationQueue).To(Equal("CheckedStream()"))
+				for i := 1; i < 3; i++ {
+					recv := (<-conn.received).(streamItemMessage)
+					Expect(recv.InvocationID).To(Equal("allowed"))
+					var got int
+					Expect(p.UnmarshalArgument(recv.Item, &got)).NotTo(HaveOccurred())
+					Expect(got).To(Equal(i))
+				}
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("allowed"))
+				Expect(recv.Error).To(Equal(""))
+				close(done)
+			})
+		})
+		Context("When the method is invoked without streaming and returns no error", func() {
+			It("should return the first item as result", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "single","target":"checkedstream","arguments":[true]}`)
+				Expect(<-streamInvocationQueue).To(Equal("CheckedStream()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("single"))
+				Expect(recv.Error).To(Equal(""))
+				Expect(recv.Result).To(BeEquivalentTo(1))
+				close(done)
+			})
+		})
+	})
+
+	Describe("Stream invocation of a method returning StreamResult items", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&streamHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		receiveItems := func(invocationID string) ([]StreamResult, completionMessage) {
+			p := &jsonHubProtocol{dbg: testLogger()}
+			items := make([]StreamResult, 0)
+			for {
+				switch recv := (<-conn.received).(type) {
+				case streamItemMessage:
+					Expect(recv.InvocationID).To(Equal(invocationID))
+					var item StreamResult
+					Expect(p.UnmarshalArgument(recv.Item, &item)).NotTo(HaveOccurred())
+					items = append(items, item)
+				case completionMessage:
+					return items, recv
+				}
+			}
+		}
+		Context("When the stream sends a recoverable error", func() {
+			It("should send the error as stream item and continue the stream", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "progress","target":"progressstream","arguments":[false]}`)
+				Expect(<-streamInvocationQueue).To(Equal("ProgressStream()"))
+				items, completion := receiveItems("progress")
+				Expect(items).To(Equal([]StreamResult{{Value: 1.0}, {Error: "step 2 skipped"}, {Value: 3.0}, {Value: 5.0}}))
+				Expect(completion.Error).To(Equal(""))
+				close(done)
+			})
+		})
+		Context("When the stream sends a failure", func() {
+			It("should end the stream with a completion with the error", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "failing","target":"progressstream","arguments":[true]}`)
+				Expect(<-streamInvocationQueue).To(Equal("ProgressStream()"))
+				items, completion := receiveItems("failing")
+				Expect(items).To(Equal([]StreamResult{{Value: 1.0}, {Error: "step 2 skipped"}, {Value: 3.0}}))
+				Expect(completion.InvocationID).To(Equal("failing"))
+				Expect(completion.Error).To(Equal("step 4 failed"))
+				close(done)
+			})
+		})
+	})
+
 	Describe("Slice stream invocation", func() {
 		var server Server
 		var conn *testingConnection
@@ -171,6 +493,227 @@ var _ = Describe("StreamInvocation", func() {
 				close(done)
 			})
 		})
+		Context("When invoked by the client and stopped while the stream waits for its next item", func() {
+			It("should send the final completion without waiting for the next item", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "blocked","target":"blockingstream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("BlockingStream()"))
+				recv := (<-conn.received).(streamItemMessage)
+				Expect(recv.InvocationID).To(Equal("blocked"))
+				conn.ClientSend(`{"type":5,"invocationId": "blocked"}`)
+				select {
+				case message := <-conn.received:
+					completion := message.(completionMessage)
+					Expect(completion.InvocationID).To(Equal("blocked"))
+					Expect(completion.Error).To(Equal(""))
+				case <-time.After(time.Second):
+					Fail("no completion after cancel")
+				}
+				Consistently(conn.received, 100*time.Millisecond).ShouldNot(Receive())
+				close(done)
+			}, 3.0)
+		})
+	})
+
+	Describe("Iterator stream invocation from client", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&streamHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client", func() {
+			It("should send all values of the iterator as stream items and a final completion", func(done Done) {
+				protocol := jsonHubProtocol{dbg: testLogger()}
+				conn.ClientSend(`{"type":4,"invocationId": "seq","target":"seqstream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SeqStream()"))
+				for want := 1; want < 4; want++ {
+					recv := (<-conn.received).(streamItemMessage)
+					Expect(recv.InvocationID).To(Equal("seq"))
+					var got int
+					Expect(protocol.UnmarshalArgument(recv.Item, &got)).NotTo(HaveOccurred())
+					Expect(got).To(Equal(want))
+				}
+				completion := (<-conn.received).(completionMessage)
+				Expect(completion.InvocationID).To(Equal("seq"))
+				Expect(completion.Error).To(Equal(""))
+				close(done)
+			})
+		})
+		Context("When invoked by the client and stopped", func() {
+			It("should stop the iteration and send the final completion", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "endlessseq","target":"endlessseqstream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("EndlessSeqStream()"))
+				Expect((<-conn.received).(streamItemMessage).InvocationID).To(Equal("endlessseq"))
+				conn.ClientSend(`{"type":5,"invocationId": "endlessseq"}`)
+			loop:
+				for {
+					switch recv := (<-conn.received).(type) {
+					case streamItemMessage:
+						Expect(recv.InvocationID).To(Equal("endlessseq"))
+					case completionMessage:
+						Expect(recv.InvocationID).To(Equal("endlessseq"))
+						Expect(recv.Error).To(Equal(""))
+						break loop
+					}
+				}
+				Expect(<-streamInvocationQueue).To(Equal("EndlessSeqStream() stopped"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Producer stream invocation from client", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&streamHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When invoked by the client", func() {
+			It("should send all values passed to send as stream items and a final completion", func(done Done) {
+				protocol := jsonHubProtocol{dbg: testLogger()}
+				conn.ClientSend(`{"type":4,"invocationId": "prod","target":"producerstream","arguments":[false]}`)
+				Expect(<-streamInvocationQueue).To(Equal("ProducerStream()"))
+				for want := 1; want < 4; want++ {
+					recv := (<-conn.received).(streamItemMessage)
+					Expect(recv.InvocationID).To(Equal("prod"))
+					var got int
+					Expect(protocol.UnmarshalArgument(recv.Item, &got)).NotTo(HaveOccurred())
+					Expect(got).To(Equal(want))
+				}
+				completion := (<-conn.received).(completionMessage)
+				Expect(completion.InvocationID).To(Equal("prod"))
+				Expect(completion.Error).To(Equal(""))
+				close(done)
+			})
+		})
+		Context("When invoked by the client and the producer returns an error", func() {
+			It("should send the values and complete the stream with the error", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "prod","target":"producerstream","arguments":[true]}`)
+				Expect(<-streamInvocationQueue).To(Equal("ProducerStream()"))
+				for i := 0; i < 3; i++ {
+					Expect((<-conn.received).(streamItemMessage).InvocationID).To(Equal("prod"))
+				}
+				completion := (<-conn.received).(completionMessage)
+				Expect(completion.InvocationID).To(Equal("prod"))
+				Expect(completion.Error).To(Equal("producer failed"))
+				close(done)
+			})
+		})
+		Context("When invoked by the client and stopped", func() {
+			It("should end the context of the producer and send the final completion", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "endlessprod","target":"endlessproducerstream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("EndlessProducerStream()"))
+				Expect((<-conn.received).(streamItemMessage).InvocationID).To(Equal("endlessprod"))
+				conn.ClientSend(`{"type":5,"invocationId": "endlessprod"}`)
+			loop:
+				for {
+					switch recv := (<-conn.received).(type) {
+					case streamItemMessage:
+						Expect(recv.InvocationID).To(Equal("endlessprod"))
+					case completionMessage:
+						Expect(recv.InvocationID).To(Equal("endlessprod"))
+						Expect(recv.Error).To(Equal(""))
+						break loop
+					}
+				}
+				Expect(<-streamInvocationQueue).To(Equal("EndlessProducerStream() stopped: context canceled"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
+	Describe("Closing a connection with active streams", func() {
+		// startStreams starts two held streams and pushes two items to each of them
+		startStreams := func(options ...func(Party) error) (Server, *testingConnection) {
+			server, err := NewServer(context.TODO(), append([]func(Party) error{SimpleHubFactory(&streamHub{}), testLoggerOption()}, options...)...)
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			for _, id := range []string{"held1", "held2"} {
+				conn.ClientSend(fmt.Sprintf(`{"type":4,"invocationId":"%v","target":"heldstream"}`, id))
+				stream := <-heldStreams
+				stream <- 1
+				stream <- 2
+			}
+			// A completion for an unknown invocation closes the connection with an error
+			conn.ClientSend(`{"type":3,"invocationId":"unknown"}`)
+			return server, conn
+		}
+		Context("With FlushStreamsOnClose", func() {
+			It("should send the items and an error completion for each stream before the close message", func(done Done) {
+				server, conn := startStreams(FlushStreamsOnClose(time.Second))
+				items := map[string]int{}
+				completions := map[string]string{}
+			loop:
+				for {
+					switch message := (<-conn.received).(type) {
+					case streamItemMessage:
+						Expect(completions).NotTo(HaveKey(message.InvocationID))
+						items[message.InvocationID]++
+					case completionMessage:
+						completions[message.InvocationID] = message.Error
+					case closeMessage:
+						Expect(message.Error).To(ContainSubstring("unknown"))
+						break loop
+					}
+				}
+				Expect(items).To(Equal(map[string]int{"held1": 2, "held2": 2}))
+				Expect(completions).To(HaveLen(2))
+				Expect(completions["held1"]).To(ContainSubstring("unknown"))
+				Expect(completions["held2"]).To(ContainSubstring("unknown"))
+				server.cancel()
+				close(done)
+			}, 3.0)
+		})
+		Context("Without FlushStreamsOnClose", func() {
+			It("should send the close message without completing the streams", func(done Done) {
+				server, conn := startStreams()
+			loop:
+				for {
+					switch message := (<-conn.received).(type) {
+					case completionMessage:
+						Fail(fmt.Sprintf("unexpected completion %v", message))
+					case closeMessage:
+						break loop
+					}
+				}
+				server.cancel()
+				close(done)
+			}, 3.0)
+		})
+	})
+
+	Describe("Closing a connection with streams in both directions", func() {
+		It("should end all streams of the connection at once", func(done Done) {
+			server, err := NewServer(context.TODO(), SimpleHubFactory(&streamHub{}), testLoggerOption())
+			Expect(err).NotTo(HaveOccurred())
+			conn := newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			conn.ClientSend(`{"type":4,"invocationId":"down1","target":"idlestream","arguments":["down1"]}`)
+			conn.ClientSend(`{"type":4,"invocationId":"down2","target":"idlestream","arguments":["down2"]}`)
+			conn.ClientSend(`{"type":4,"invocationId":"held","target":"heldstream"}`)
+			conn.ClientSend(`{"type":1,"invocationId":"upload1","target":"idleupload","arguments":["up1"],"streamIds":["up1"]}`)
+			conn.ClientSend(`{"type":1,"invocationId":"upload2","target":"idleupload","arguments":["up2"],"streamIds":["up2"]}`)
+			conn.ClientSend(`{"type":2,"invocationId":"up1","item":1}`)
+			<-heldStreams
+			conn.ClientSend(`{"type":7}`)
+			ended := make([]string, 0, 4)
+			for i := 0; i < 4; i++ {
+				ended = append(ended, <-streamsEnded)
+			}
+			Expect(ended).To(ConsistOf("down1", "down2", "up1", "up2"))
+			server.cancel()
+			close(done)
+		}, 3.0)
 	})
 
 	Describe("Invalid CancelInvocation", func() {
@@ -244,6 +787,83 @@ var _ = Describe("StreamInvocation", func() {
 		})
 	})
 
+	Describe("Stream invocation with MaximumSendMessageSize", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			var err error
+			server, err = NewServer(context.TODO(), SimpleHubFactory(&streamHub{}),
+				testLoggerOption(),
+				MaximumSendMessageSize(200))
+			Expect(err).NotTo(HaveOccurred())
+			conn = newTestingConnectionForServer()
+			go func() { _ = server.Serve(conn) }()
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When a stream item is larger than the MaximumSendMessageSize", func() {
+			It("should complete the stream with an error and not send the item", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "growing","target":"growingstream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("GrowingStream()"))
+				sRecv := (<-conn.received).(streamItemMessage)
+				Expect(sRecv.InvocationID).To(Equal("growing"))
+				cRecv := (<-conn.received).(completionMessage)
+				Expect(cRecv.InvocationID).To(Equal("growing"))
+				Expect(cRecv.Error).To(ContainSubstring(ErrMessageTooLarge.Error()))
+				// The connection is still usable
+				conn.ClientSend(`{"type":1,"invocationId": "int","target":"simpleint"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SimpleInt()"))
+				cRecv = (<-conn.received).(completionMessage)
+				Expect(cRecv.InvocationID).To(Equal("int"))
+				Expect(cRecv.Result).To(BeEquivalentTo(-1))
+				close(done)
+			})
+		})
+		Context("When a result is larger than the MaximumSendMessageSize", func() {
+			It("should complete the invocation with an error", func(done Done) {
+				conn.ClientSend(`{"type":1,"invocationId": "large","target":"largeresult"}`)
+				cRecv := (<-conn.received).(completionMessage)
+				Expect(cRecv.InvocationID).To(Equal("large"))
+				Expect(cRecv.Result).To(BeNil())
+				Expect(cRecv.Error).To(ContainSubstring(ErrMessageTooLarge.Error()))
+				close(done)
+			})
+		})
+	})
+
+	Describe("Slow stream invocation", func() {
+		var server Server
+		var conn *testingConnection
+		BeforeEach(func(done Done) {
+			server, conn = connect(&streamHub{})
+			close(done)
+		})
+		AfterEach(func(done Done) {
+			server.cancel()
+			close(done)
+		})
+		Context("When the stream waits for its next item and the client invokes another method", func() {
+			It("should complete the invocation without waiting for the stream", func(done Done) {
+				conn.ClientSend(`{"type":4,"invocationId": "slow","target":"slowstream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SlowStream()"))
+				Expect((<-conn.received).(streamItemMessage).InvocationID).To(Equal("slow"))
+				conn.ClientSend(`{"type":1,"invocationId": "fast","target":"simpleint"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SimpleInt()"))
+				var recv interface{}
+				Eventually(conn.received, 100*time.Millisecond).Should(Receive(&recv))
+				Expect(recv).To(BeAssignableToTypeOf(completionMessage{}))
+				Expect(recv.(completionMessage).InvocationID).To(Equal("fast"))
+				slowStreamRelease <- struct{}{}
+				Expect((<-conn.received).(streamItemMessage).InvocationID).To(Equal("slow"))
+				Expect((<-conn.received).(completionMessage).InvocationID).To(Equal("slow"))
+				close(done)
+			}, 2.0)
+		})
+	})
+
 	Describe("invalid messages", func() {
 		var server Server
 		var conn *testingConnection
@@ -0,0 +1,68 @@
+package signalr
+
+import (
+	"reflect"
+)
+
+// StreamResult is the item type of streams which report errors while streaming, e.g. the progress of a long operation
+// which can continue after a step has failed. A hub method which returns a chan StreamResult or an iterator of StreamResult
+// streams
+//   - items created by StreamValue as stream items {"value": <value>}
+//   - items created by StreamError as stream items {"error": <error text>}. The stream continues after them
+//   - an item created by StreamFailure not as stream item, but ends the stream with a completion with the error.
+//     The items sent to the chan after it are not received.
+//
+// The error text is built like the error of a completion, see HubErrorDetailer.
+// Items marked with Flushed are written to the transport as soon as they have been sent, see Flushed.
+// Clients receive the items with PullStreamTyped(reflect.TypeOf(StreamResult{}), ...) and check the Error field of each item.
+type StreamResult struct {
+	Value   interface{} `json:"value,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	failure bool
+	flush   bool
+}
+
+// Flushed returns the StreamResult marked to be flushed after it has been sent, e.g. StreamValue(value).Flushed().
+// Messages which are collected by WriteCoalescing are written at once then, and if the Connection implements
+// http.Flusher, it is flushed, too. So the item is delivered without delay, even if no further items follow soon.
+func (r StreamResult) Flushed() StreamResult {
+	r.flush = true
+	return r
+}
+
+// StreamValue returns a StreamResult which carries value
+func StreamValue(value interface{}) StreamResult {
+	return StreamResult{Value: value}
+}
+
+// StreamError returns a StreamResult which carries a recoverable error. The stream continues after it.
+func StreamError(err error) StreamResult {
+	errorText, _ := hubErrorText(err)
+	return StreamResult{Error: errorText}
+}
+
+// StreamFailure returns a StreamResult which ends the stream with a completion with err
+func StreamFailure(err error) StreamResult {
+	errorText, _ := hubErrorText(err)
+	return StreamResult{Error: errorText, failure: true}
+}
+
+// streamFailure returns the error text if item is a StreamResult created by StreamFailure
+func streamFailure(item reflect.Value) (string, bool) {
+	if !item.IsValid() || !item.CanInterface() {
+		return "", false
+	}
+	if result, ok := item.Interface().(StreamResult); ok && result.failure {
+		return result.Error, true
+	}
+	return "", false
+}
+
+// streamFlush reports if item is a StreamResult marked with Flushed
+func streamFlush(item reflect.Value) bool {
+	if !item.IsValid() || !item.CanInterface() {
+		return false
+	}
+	result, ok := item.Interface().(StreamResult)
+	return ok && result.flush
+}
@@ -0,0 +1,96 @@
+package signalr
+
+import "sync"
+
+// tag is a key/value pair set on a connection
+type tag struct {
+	key   string
+	value string
+}
+
+// tagIndex holds the tags of the connections. It is indexed by tag, so the connections with a tag can be
+// selected without visiting all connections.
+type tagIndex struct {
+	mx sync.RWMutex
+	// byTag holds the connections per tag, keyed by connectionID
+	byTag map[tag]map[string]hubConnection
+	// byConnection holds the tag values per connectionID and tag key
+	byConnection map[string]map[string]string
+}
+
+func (t *tagIndex) set(conn hubConnection, key, value string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.byTag == nil {
+		t.byTag = make(map[tag]map[string]hubConnection)
+		t.byConnection = make(map[string]map[string]string)
+	}
+	connectionID := conn.ConnectionID()
+	t.removeLocked(connectionID, key)
+	conns, ok := t.byTag[tag{key, value}]
+	if !ok {
+		conns = make(map[string]hubConnection)
+		t.byTag[tag{key, value}] = conns
+	}
+	conns[connectionID] = conn
+	values, ok := t.byConnection[connectionID]
+	if !ok {
+		values = make(map[string]string)
+		t.byConnection[connectionID] = values
+	}
+	values[key] = value
+}
+
+func (t *tagIndex) remove(connectionID, key string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.removeLocked(connectionID, key)
+}
+
+func (t *tagIndex) removeLocked(connectionID, key string) {
+	values, ok := t.byConnection[connectionID]
+	if !ok {
+		return
+	}
+	value, ok := values[key]
+	if !ok {
+		return
+	}
+	delete(values, key)
+	if len(values) == 0 {
+		delete(t.byConnection, connectionID)
+	}
+	conns := t.byTag[tag{key, value}]
+	delete(conns, connectionID)
+	if len(conns) == 0 {
+		delete(t.byTag, tag{key, value})
+	}
+}
+
+// removeConnection removes all tags of the connection
+func (t *tagIndex) removeConnection(connectionID string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for key := range t.byConnection[connectionID] {
+		t.removeLocked(connectionID, key)
+	}
+}
+
+// value returns the value of the tag key of the connection
+func (t *tagIndex) value(connectionID, key string) (string, bool) {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	value, ok := t.byConnection[connectionID][key]
+	return value, ok
+}
+
+// connections returns the connections with the tag
+func (t *tagIndex) connections(key, value string) []hubConnection {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	conns := make([]hubConnection, 0, len(t.byTag[tag{key, value}]))
+	for _, conn := range t.byTag[tag{key, value}] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
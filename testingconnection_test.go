@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/onsi/ginkgo"
@@ -28,6 +29,7 @@ type testingConnection struct {
 	failRead     string
 	failWrite    string
 	failMx       sync.Mutex
+	closed       int32
 }
 
 func (t *testingConnection) Context() context.Context {
@@ -90,6 +92,15 @@ func (t *testingConnection) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
+func (t *testingConnection) Close() error {
+	atomic.StoreInt32(&t.closed, 1)
+	return nil
+}
+
+func (t *testingConnection) Closed() bool {
+	return atomic.LoadInt32(&t.closed) == 1
+}
+
 func (t *testingConnection) Connected() bool {
 	t.cnMutex.Lock()
 	defer t.cnMutex.Unlock()
@@ -220,6 +231,7 @@ func receiveLoop(conn clientReceiver) func() {
 
 							conn.ReceiveChan() <- streamItemMessage{
 								Type:         jsonStreamItemMessage.Type,
+								Headers:      jsonStreamItemMessage.Headers,
 								InvocationID: jsonStreamItemMessage.InvocationID,
 								Item:         jsonStreamItemMessage.Item,
 							}
@@ -233,6 +245,13 @@ func receiveLoop(conn clientReceiver) func() {
 						} else {
 							errorHandler(err)
 						}
+					case 8:
+						var ackMessage ackMessage
+						if err = json.Unmarshal([]byte(message), &ackMessage); err == nil {
+							conn.ReceiveChan() <- ackMessage
+						} else {
+							errorHandler(err)
+						}
 					case 7:
 						var closeMessage closeMessage
 						if err = json.Unmarshal([]byte(message), &closeMessage); err == nil {
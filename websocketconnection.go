@@ -23,6 +23,8 @@ func newWebSocketConnection(ctx context.Context, connectionID string, conn *webs
 }
 
 func (w *webSocketConnection) Write(p []byte) (n int, err error) {
+	// The transfer mode is set by the hubConnection according to the negotiated protocol.
+	// messagepack requires binary frames, json is sent in text frames
 	messageType := websocket.MessageText
 	if w.transferMode == BinaryTransferMode {
 		messageType = websocket.MessageBinary
@@ -58,6 +60,11 @@ func (w *webSocketConnection) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// Close closes the websocket
+func (w *webSocketConnection) Close() error {
+	return w.conn.Close(websocket.StatusNormalClosure, "")
+}
+
 func (w *webSocketConnection) TransferMode() TransferMode {
 	return w.transferMode
 }